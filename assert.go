@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AssertionConfig is a data quality check of an assert step.
+type AssertionConfig struct {
+	Name    string `yaml:"name,omitempty" json:"name,omitempty"`
+	Rule    string `yaml:"rule" json:"rule"`                           // jq predicate on the context, $ctx is available
+	Message string `yaml:"message,omitempty" json:"message,omitempty"` // reported when the rule is not satisfied
+}
+
+// AssertionFailure is an assertion of an assert step that was not satisfied.
+type AssertionFailure struct {
+	Name    string `json:"name"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// AssertionError is returned when assertions of an assert step are not satisfied, so that
+// schedulers can hold back the datasets failing their quality gates.
+type AssertionError struct {
+	Step     string
+	Failures []AssertionFailure
+}
+
+func (e *AssertionError) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		messages = append(messages, fmt.Sprintf("%s: %s", f.Name, f.Message))
+	}
+	return fmt.Sprintf("assert '%s' failed: %s", e.Step, strings.Join(messages, "; "))
+}
+
+// handleAssert checks the assertions of the step against the current context, the root
+// context for top level steps. All assertions are checked, the ones not satisfied are
+// reported together in an AssertionError. Nothing is merged into the context.
+func (c *ApiCrawler) handleAssert(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Assert] Checking %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	data := exec.currentContext.Data
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Assert '%s'", exec.step.Name), exec, data, nil, "assertions", len(exec.step.Assertions))
+
+	assertErr := &AssertionError{Step: exec.step.Name}
+	if assertErr.Step == "" {
+		assertErr.Step = exec.path
+	}
+	for i, assertion := range exec.step.Assertions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		failure := AssertionFailure{Name: assertion.Name, Rule: assertion.Rule, Message: assertion.Message}
+		if failure.Name == "" {
+			failure.Name = fmt.Sprintf("assertions[%d]", i)
+		}
+		if failure.Message == "" {
+			failure.Message = fmt.Sprintf("'%s' not satisfied", assertion.Rule)
+		}
+
+		code, err := c.getOrCompileJQRule(assertion.Rule, "$ctx")
+		if err != nil {
+			return &TransformError{Location: fmt.Sprintf("%s.assertions[%d]", exec.path, i), Rule: assertion.Rule, Err: err}
+		}
+		v, ok := c.runJQ(exec, code, data, templateCtx).Next()
+		if err, isErr := v.(error); isErr {
+			failure.Message = fmt.Sprintf("%s (%s)", failure.Message, err.Error())
+			assertErr.Failures = append(assertErr.Failures, failure)
+		} else if !ok || v == nil || v == false {
+			assertErr.Failures = append(assertErr.Failures, failure)
+		}
+	}
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_END, fmt.Sprintf("Assert '%s'", exec.step.Name), exec, assertErr.Failures, nil, "failed", len(assertErr.Failures))
+	if len(assertErr.Failures) > 0 {
+		return assertErr
+	}
+	c.logger.Info("[Assert] %s: %d assertions satisfied", exec.path, len(exec.step.Assertions))
+	return nil
+}
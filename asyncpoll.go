@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	asyncPollDefaultInterval = time.Second
+	asyncPollDefaultTimeout  = 5 * time.Minute
+)
+
+// AsyncPollConfig follows the "202 Accepted" pattern of asynchronous exports: the Location
+// of the 202 response is polled until the result is ready, the final response being the
+// result of the step.
+type AsyncPollConfig struct {
+	IntervalMs int `yaml:"intervalMs,omitempty" json:"intervalMs,omitempty"` // default 1000
+	TimeoutMs  int `yaml:"timeoutMs,omitempty" json:"timeoutMs,omitempty"`   // default 300000
+	// Until is a jq predicate on the polled body, with $response, telling the result is ready.
+	// Without it polling ends on the first response that is not a 202.
+	Until string `yaml:"until,omitempty" json:"until,omitempty"`
+}
+
+func (p *AsyncPollConfig) interval() time.Duration {
+	if p.IntervalMs > 0 {
+		return time.Duration(p.IntervalMs) * time.Millisecond
+	}
+	return asyncPollDefaultInterval
+}
+
+func (p *AsyncPollConfig) timeout() time.Duration {
+	if p.TimeoutMs > 0 {
+		return time.Duration(p.TimeoutMs) * time.Millisecond
+	}
+	return asyncPollDefaultTimeout
+}
+
+// pollAccepted polls the Location of a 202 response of a request step until the result is
+// ready and returns the final response in place of resp. The polls send the headers and the
+// authentication of the step; a 202 with a new Location moves the polling there.
+func (c *ApiCrawler) pollAccepted(ctx context.Context, exec *stepExecution, authenticator Authenticator, templateCtx map[string]any, req *http.Request, resp *http.Response) (*http.Response, error) {
+	cfg := exec.step.Request.AsyncPoll
+	location := resp.Header.Get("Location")
+	if location == "" {
+		c.logger.Warning("[Request] %s returned 202 without Location, nothing to poll", req.URL.String())
+		return resp, nil
+	}
+	resp.Body.Close()
+	target, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Location %s: %w", location, err)
+	}
+	deadline := time.Now().Add(cfg.timeout())
+
+	for attempt := 1; ; attempt++ {
+		if time.Now().Add(cfg.interval()).After(deadline) {
+			return nil, &HTTPError{Step: exec.path, URL: target.String(), Status: http.StatusAccepted, Err: fmt.Errorf("result not ready after %s", cfg.timeout())}
+		}
+		timer := time.NewTimer(cfg.interval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		pollReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating HTTP request: %w", err)
+		}
+		if err := c.applyHeaders(pollReq, exec.step.Request, templateCtx, nil); err != nil {
+			return nil, err
+		}
+		if err := c.authenticate(exec, authenticator, pollReq); err != nil {
+			return nil, err
+		}
+
+		c.logger.Info("[Request] polling %s (#%d)", target.String(), attempt)
+		resp, err := c.doRequest(exec, pollReq)
+		if err != nil {
+			return nil, err
+		}
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Async Poll #%d", attempt), exec, nil, nil, "url", target.String(), "status", resp.StatusCode)
+
+		switch {
+		case resp.StatusCode >= 400:
+			resp.Body.Close()
+			return nil, &HTTPError{Step: exec.path, URL: target.String(), Status: resp.StatusCode}
+		case resp.StatusCode == http.StatusAccepted:
+			resp.Body.Close()
+			if location := resp.Header.Get("Location"); location != "" {
+				if target, err = target.Parse(location); err != nil {
+					return nil, fmt.Errorf("invalid Location %s: %w", location, err)
+				}
+			}
+			continue
+		case cfg.Until == "":
+			return resp, nil
+		}
+
+		ready, err := c.asyncResultReady(exec, target.String(), resp)
+		if err != nil {
+			return nil, err
+		}
+		if ready {
+			return resp, nil
+		}
+	}
+}
+
+// asyncResultReady runs the until predicate on the body of a polled response, which is
+// buffered to be decoded again as the step result.
+func (c *ApiCrawler) asyncResultReady(exec *stepExecution, pollURL string, resp *http.Response) (bool, error) {
+	cfg := exec.step.Request.AsyncPoll
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, &HTTPError{Step: exec.path, URL: pollURL, Status: resp.StatusCode, Err: err}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	raw, err := c.decodeResponseBody(exec.step.Request, resp.Header, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	location := exec.path + ".request.asyncPoll.until"
+	code, err := c.getOrCompileJQRule(cfg.Until, "$response")
+	if err != nil {
+		return false, &TransformError{Location: location, Rule: cfg.Until, Err: err}
+	}
+	v, ok := c.runJQ(exec, code, raw, responseToJQ(resp)).Next()
+	if err, isErr := v.(error); isErr {
+		return false, &TransformError{Location: location, Rule: cfg.Until, Err: fmt.Errorf("jq error: %w", err)}
+	}
+	return ok && v != nil && v != false, nil
+}
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AuthCheckResult is the outcome of the pre-flight check of one configured authentication.
+type AuthCheckResult struct {
+	Location string        `json:"location"` // auth | steps[0].request.auth
+	Type     string        `json:"type"`     // basic | bearer | oauth
+	Method   string        `json:"method,omitempty"`
+	Verified bool          `json:"verified"` // false for static credentials that can't be verified without a request
+	Error    error         `json:"-"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (r AuthCheckResult) OK() bool {
+	return r.Error == nil
+}
+
+// CheckAuth exercises every configured authentication once (OAuth logins fetch a token)
+// without running the crawl, so schedulers can fail fast on expired credentials.
+// The returned error joins the failed checks.
+func (a *ApiCrawler) CheckAuth(ctx context.Context) ([]AuthCheckResult, error) {
+	var results []AuthCheckResult
+	var errs []error
+
+	for _, location := range a.authLocations() {
+		cfg := location.cfg
+		if cfg.Type == "" {
+			continue
+		}
+		result := AuthCheckResult{Location: location.path, Type: cfg.Type, Method: cfg.Method}
+		start := time.Now()
+		switch auth := a.newAuthenticator(cfg).(type) {
+		case *AuthenticatorImpl:
+			result.Verified, result.Error = auth.Check(ctx)
+		case failingAuthenticator:
+			result.Error = auth.err
+		}
+		result.Duration = time.Since(start)
+
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", location.path, result.Error))
+			a.logger.Warning("[Auth] check failed for %s: %s", location.path, result.Error.Error())
+		}
+		results = append(results, result)
+	}
+
+	return results, errors.Join(errs...)
+}
+
+type authLocation struct {
+	path string
+	cfg  AuthenticatorConfig
+}
+
+// authLocations lists the configured authentications: the global one, then the request
+// level ones in step order.
+func (a *ApiCrawler) authLocations() []authLocation {
+	var locations []authLocation
+	if a.Config.Authentication != nil {
+		locations = append(locations, authLocation{"auth", *a.Config.Authentication})
+	}
+
+	var walk func(steps []Step, location string)
+	walk = func(steps []Step, location string) {
+		for i, step := range steps {
+			stepLocation := fmt.Sprintf("%s[%d]", location, i)
+			if step.Request != nil && step.Request.Authentication != nil {
+				locations = append(locations, authLocation{stepLocation + ".request.auth", *step.Request.Authentication})
+			}
+			walk(step.Steps, stepLocation+".steps")
+		}
+	}
+	walk(a.Config.Steps, "steps")
+	return locations
+}
@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+const OAUTH_METHOD_AUTH_CODE = "authorization_code"
+
+// authCodeCallback is what the redirect of an authorization code login delivered.
+type authCodeCallback struct {
+	code string
+	err  error
+}
+
+// authCodeLogin runs the authorization code grant with PKCE of provider: prompt shows the
+// authorization url to open in a browser, the login redirects the browser to the loopback
+// redirectUrl the crawler listens on, which delivers the code to exchange for a token.
+func (a *ApiCrawler) authCodeLogin(ctx context.Context, location string, provider *OAuthProvider, prompt func(DeviceAuthorization)) (*oauth2.Token, error) {
+	redirect, err := url.Parse(provider.cfg.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirectUrl: %w", err)
+	}
+	// without a port the redirect goes to a free one, loopback redirects may use any port
+	addr := redirect.Host
+	if redirect.Port() == "" {
+		addr = net.JoinHostPort(redirect.Hostname(), "0")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen for the login redirect: %w", err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	redirect.Host = net.JoinHostPort(redirect.Hostname(), port)
+	path := redirect.Path
+	if path == "" {
+		path = "/"
+	}
+
+	conf := *provider.conf
+	conf.RedirectURL = redirect.String()
+	verifier := oauth2.GenerateVerifier()
+	state := oauth2.GenerateVerifier()
+
+	callbacks := make(chan authCodeCallback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		// requests not carrying the state are not the redirect of this login
+		if query.Get("state") != state {
+			http.Error(w, "Unknown login state", http.StatusBadRequest)
+			return
+		}
+		var callback authCodeCallback
+		switch {
+		case query.Get("error") != "":
+			callback.err = fmt.Errorf("authorization denied: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			callback.err = fmt.Errorf("login redirect without code")
+		default:
+			callback.code = query.Get("code")
+		}
+		if callback.err != nil {
+			http.Error(w, "Login failed: "+callback.err.Error(), http.StatusBadRequest)
+		} else {
+			io.WriteString(w, "Login completed, you can close this window.")
+		}
+		select {
+		case callbacks <- callback:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	prompt(DeviceAuthorization{
+		Location:        location,
+		Method:          OAUTH_METHOD_AUTH_CODE,
+		VerificationURI: conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)),
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case callback := <-callbacks:
+		if callback.err != nil {
+			return nil, callback.err
+		}
+		return conf.Exchange(ctx, callback.code, oauth2.VerifierOption(verifier))
+	}
+}
+
+// validateRedirectURL checks the redirectUrl of an authorization code login, a loopback url
+// the crawler can listen on.
+func validateRedirectURL(raw string, location string) []ValidationError {
+	if raw == "" {
+		return []ValidationError{{"auth.redirectUrl is required when method is authorization_code", location}}
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" {
+		return []ValidationError{{"auth.redirectUrl must be an http loopback url, e.g. http://127.0.0.1:8765/callback", location}}
+	}
+	if host := u.Hostname(); host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return []ValidationError{{"auth.redirectUrl must be an http loopback url, e.g. http://127.0.0.1:8765/callback", location}}
+		}
+	}
+	return nil
+}
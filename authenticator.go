@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"net/http"
 	"sync"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
@@ -28,23 +29,32 @@ func (np NoopAuthenticator) PrepareRequest(req *http.Request) error {
 
 type AuthenticatorConfig struct {
 	OAuthConfig `yaml:",inline" json:",inline"`
-	Type        string `yaml:"type,omitempty" json:"type,omitempty"` // basic | bearer | oauth
+	Type        string `yaml:"type,omitempty" json:"type,omitempty"` // basic | bearer | oauth | injected | aws_sigv4
 	Token       string `yaml:"token,omitempty" json:"token,omitempty"`
+	Name        string `yaml:"name,omitempty" json:"name,omitempty"` // credentials injected with SetCredential
+	// aws_sigv4 signs the requests, the credentials default to AWS_ACCESS_KEY_ID and related env variables
+	AccessKey    string `yaml:"accessKey,omitempty" json:"accessKey,omitempty"`
+	SecretKey    string `yaml:"secretKey,omitempty" json:"secretKey,omitempty"`
+	SessionToken string `yaml:"sessionToken,omitempty" json:"sessionToken,omitempty"`
+	Region       string `yaml:"region,omitempty" json:"region,omitempty"`
+	Service      string `yaml:"service,omitempty" json:"service,omitempty"` // e.g. execute-api, es
 }
 
 type AuthenticatorImpl struct {
 	enabled       bool
 	oauthProvider *OAuthProvider
 	cfg           AuthenticatorConfig
+	credentials   *credentialStore // see SetCredential
+	now           func() time.Time // signing time of aws_sigv4
 }
 
 func NewAuthenticator(config AuthenticatorConfig) Authenticator {
 	enabled := false
 	if len(config.Type) != 0 {
 		enabled = true
-		if config.Type != "basic" && config.Type != "bearer" && config.Type != "oauth" {
-			slog.Error(fmt.Sprintf("Unsupported authentication type. Use 'basic' or 'bearer' or oauth. Got: %s", config.Type))
-			panic(fmt.Sprintf("Unsupported authentication type. Use 'basic' or 'bearer' or oauth. Got: %s", config.Type))
+		if config.Type != "basic" && config.Type != "bearer" && config.Type != "oauth" && config.Type != AUTH_TYPE_INJECTED && config.Type != AUTH_TYPE_AWS_SIGV4 {
+			slog.Error(fmt.Sprintf("Unsupported authentication type. Use 'basic', 'bearer', 'oauth', 'injected' or 'aws_sigv4'. Got: %s", config.Type))
+			panic(fmt.Sprintf("Unsupported authentication type. Use 'basic', 'bearer', 'oauth', 'injected' or 'aws_sigv4'. Got: %s", config.Type))
 		}
 	}
 
@@ -57,6 +67,7 @@ func NewAuthenticator(config AuthenticatorConfig) Authenticator {
 		enabled:       enabled,
 		oauthProvider: oauthProvider,
 		cfg:           config,
+		now:           time.Now,
 	}
 	return a
 }
@@ -66,29 +77,82 @@ func (a AuthenticatorImpl) PrepareRequest(req *http.Request) error {
 		return nil
 	}
 
+	if credential, ok := a.injectedCredential(); ok {
+		credential.apply(req)
+		return nil
+	}
+	if a.cfg.Type == AUTH_TYPE_INJECTED {
+		return fmt.Errorf("no credential injected for auth '%s'", a.cfg.Name)
+	}
+
 	// Inject authentication headers if needed.
 	if a.cfg.Type == "oauth" {
 		token, err := a.oauthProvider.GetToken()
 		if err != nil {
-			return fmt.Errorf("could not get oauth token: %s", err.Error())
+			return fmt.Errorf("could not get oauth token: %w", err)
 		}
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	} else if a.cfg.Type == "basic" {
 		req.SetBasicAuth(a.cfg.Username, a.cfg.Password)
 	} else if a.cfg.Type == "bearer" {
 		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", a.cfg.Token))
+	} else if a.cfg.Type == AUTH_TYPE_AWS_SIGV4 {
+		return a.signAWSRequest(req)
 	}
 	return nil
 }
 
+// Check exercises the authenticator once: OAuth logins fetch a token, static
+// credentials (basic, bearer) are only checked for presence. It reports whether the
+// credentials were verified against the server.
+func (a AuthenticatorImpl) Check(ctx context.Context) (bool, error) {
+	if _, ok := a.injectedCredential(); ok {
+		return false, nil
+	}
+	switch a.cfg.Type {
+	case AUTH_TYPE_INJECTED:
+		return false, fmt.Errorf("no credential injected for auth '%s'", a.cfg.Name)
+	case "oauth":
+		if _, err := a.oauthProvider.GetTokenContext(ctx); err != nil {
+			return true, fmt.Errorf("could not get oauth token: %w", err)
+		}
+		return true, nil
+	case "basic":
+		if a.cfg.Username == "" {
+			return false, fmt.Errorf("basic auth without username")
+		}
+	case "bearer":
+		if a.cfg.Token == "" {
+			return false, fmt.Errorf("bearer auth without token")
+		}
+	case AUTH_TYPE_AWS_SIGV4:
+		if creds := a.awsCredentials(); creds.AccessKey == "" || creds.SecretKey == "" {
+			return false, fmt.Errorf("aws_sigv4 auth without credentials")
+		}
+	}
+	return false, nil
+}
+
+// injectedCredential returns the credential injected for the authentication name, if any.
+func (a AuthenticatorImpl) injectedCredential() (Credential, bool) {
+	if a.cfg.Name == "" || a.credentials == nil {
+		return Credential{}, false
+	}
+	return a.credentials.get(a.cfg.Name)
+}
+
 type OAuthConfig struct {
-	Method       string   `yaml:"method,omitempty" json:"method,omitempty"` // password | client_credentials
-	TokenURL     string   `yaml:"tokenUrl,omitempty" json:"tokenUrl,omitempty"`
-	ClientID     string   `yaml:"clientId,omitempty" json:"clientId,omitempty"`
-	ClientSecret string   `yaml:"clientSecret,omitempty" json:"clientSecret,omitempty"`
-	Username     string   `yaml:"username,omitempty" json:"username,omitempty"`
-	Password     string   `yaml:"password,omitempty" json:"password,omitempty"`
-	Scopes       []string `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+	Method        string   `yaml:"method,omitempty" json:"method,omitempty"` // password | client_credentials | device_code | authorization_code | refresh_token
+	TokenURL      string   `yaml:"tokenUrl,omitempty" json:"tokenUrl,omitempty"`
+	ClientID      string   `yaml:"clientId,omitempty" json:"clientId,omitempty"`
+	ClientSecret  string   `yaml:"clientSecret,omitempty" json:"clientSecret,omitempty"`
+	Username      string   `yaml:"username,omitempty" json:"username,omitempty"`
+	Password      string   `yaml:"password,omitempty" json:"password,omitempty"`
+	Scopes        []string `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+	DeviceAuthURL string   `yaml:"deviceAuthUrl,omitempty" json:"deviceAuthUrl,omitempty"` // device authorization endpoint of device_code
+	RefreshToken  string   `yaml:"refreshToken,omitempty" json:"refreshToken,omitempty"`   // initial refresh token of refresh_token
+	AuthURL       string   `yaml:"authUrl,omitempty" json:"authUrl,omitempty"`             // authorization endpoint of authorization_code
+	RedirectURL   string   `yaml:"redirectUrl,omitempty" json:"redirectUrl,omitempty"`     // loopback redirect of authorization_code
 }
 
 // OAuthProvider struct
@@ -99,6 +163,12 @@ type OAuthProvider struct {
 	mu          sync.Mutex
 	username    string
 	password    string
+	cfg         OAuthConfig
+	// deviceTokens is the session store of the device_code and authorization_code methods,
+	// see DeviceLogin
+	deviceTokens *deviceTokenStore
+	// refreshTokens holds the tokens of the refresh_token method
+	refreshTokens *refreshTokenSession
 }
 
 func NewOAuthProvider(cfg OAuthConfig) *OAuthProvider {
@@ -110,6 +180,7 @@ func NewOAuthProvider(cfg OAuthConfig) *OAuthProvider {
 	wrapper := &OAuthProvider{
 		username: cfg.Username,
 		password: cfg.Password,
+		cfg:      cfg,
 	}
 
 	switch authMethod {
@@ -122,6 +193,36 @@ func NewOAuthProvider(cfg OAuthConfig) *OAuthProvider {
 			},
 			Scopes: cfg.Scopes,
 		}
+	case OAUTH_METHOD_REFRESH_TOKEN:
+		wrapper.conf = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				TokenURL: tokenURL,
+			},
+			Scopes: cfg.Scopes,
+		}
+		wrapper.refreshTokens = newRefreshTokenSession(nil)
+	case OAUTH_METHOD_DEVICE_CODE:
+		wrapper.conf = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				TokenURL:      tokenURL,
+				DeviceAuthURL: cfg.DeviceAuthURL,
+			},
+			Scopes: cfg.Scopes,
+		}
+	case OAUTH_METHOD_AUTH_CODE:
+		wrapper.conf = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  cfg.AuthURL,
+				TokenURL: tokenURL,
+			},
+			Scopes: cfg.Scopes,
+		}
 	case "client_credentials":
 		wrapper.clientCreds = &clientcredentials.Config{
 			ClientID:     clientID,
@@ -130,8 +231,8 @@ func NewOAuthProvider(cfg OAuthConfig) *OAuthProvider {
 			Scopes:       cfg.Scopes,
 		}
 	default:
-		slog.Error("Unsupported OAUTH_METHOD. Use 'password' or 'client_credentials'")
-		panic("Unsupported OAUTH_METHOD. Use 'password' or 'client_credentials'")
+		slog.Error("Unsupported OAUTH_METHOD. Use 'password', 'client_credentials', 'device_code', 'authorization_code' or 'refresh_token'")
+		panic("Unsupported OAUTH_METHOD. Use 'password', 'client_credentials', 'device_code', 'authorization_code' or 'refresh_token'")
 	}
 
 	return wrapper
@@ -139,10 +240,28 @@ func NewOAuthProvider(cfg OAuthConfig) *OAuthProvider {
 
 // GetToken retrieves a valid access token (refreshing if necessary)
 func (w *OAuthProvider) GetToken() (string, error) {
+	return w.GetTokenContext(context.Background())
+}
+
+// GetTokenContext is GetToken with a context bounding the token request.
+func (w *OAuthProvider) GetTokenContext(ctx context.Context) (string, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	ctx := context.Background()
+	if w.cfg.Method == OAUTH_METHOD_DEVICE_CODE || w.cfg.Method == OAUTH_METHOD_AUTH_CODE {
+		token, err := w.deviceToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	}
+	if w.cfg.Method == OAUTH_METHOD_REFRESH_TOKEN {
+		token, err := w.refreshTokens.token(ctx, w.conf, w.cfg)
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	}
 
 	// If token exists and is still valid, return it
 	if w.token != nil && w.token.Valid() {
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned by Run when maxRequestsPerRun or maxBytesPerRun is reached.
+// The data merged until then is still available with GetData.
+var ErrBudgetExceeded = errors.New("run budget exceeded")
+
+// runBudget counts the requests and response bytes of a run, globally and per step.
+type runBudget struct {
+	mu           sync.Mutex
+	requests     int
+	bytes        int64
+	stepRequests map[string]int
+	stepBytes    map[string]int64
+}
+
+func newRunBudget() *runBudget {
+	return &runBudget{stepRequests: map[string]int{}, stepBytes: map[string]int64{}}
+}
+
+// chargeRequest accounts for one request (HTTP, gRPC, FTP, ...) of a step, failing when
+// it would exceed the run or step limit.
+func (c *ApiCrawler) chargeRequest(exec *stepExecution) error {
+	b := c.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limit := c.Config.MaxRequestsPerRun; limit > 0 && b.requests >= limit {
+		return fmt.Errorf("%w: maxRequestsPerRun %d reached at step '%s'", ErrBudgetExceeded, limit, exec.path)
+	}
+	if limit := exec.step.MaxRequestsPerRun; limit > 0 && b.stepRequests[exec.path] >= limit {
+		return fmt.Errorf("%w: step maxRequestsPerRun %d reached at step '%s'", ErrBudgetExceeded, limit, exec.path)
+	}
+	b.requests++
+	b.stepRequests[exec.path]++
+	c.updateStatsTree(exec, func(s *StepStats) { s.Requests++ })
+	return nil
+}
+
+// chargeBytes accounts for n bytes of response body read by a step.
+func (c *ApiCrawler) chargeBytes(exec *stepExecution, n int) error {
+	b := c.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bytes += int64(n)
+	b.stepBytes[exec.path] += int64(n)
+	c.updateStatsTree(exec, func(s *StepStats) { s.Bytes += int64(n) })
+
+	if limit := c.Config.MaxBytesPerRun; limit > 0 && b.bytes > limit {
+		return fmt.Errorf("%w: maxBytesPerRun %d exceeded at step '%s'", ErrBudgetExceeded, limit, exec.path)
+	}
+	if limit := exec.step.MaxBytesPerRun; limit > 0 && b.stepBytes[exec.path] > limit {
+		return fmt.Errorf("%w: step maxBytesPerRun %d exceeded at step '%s'", ErrBudgetExceeded, limit, exec.path)
+	}
+	return nil
+}
+
+// doRequest performs the HTTP request of a step within the run budget.
+// The response body is metered, reading past the byte limit fails.
+// Inside an adaptive forEach step, responses are reported to its limiter and
+// throttled requests are retried. The hosts politeness settings and the timeout are applied to
+// every attempt, after the interceptors, which see the correlation header.
+func (c *ApiCrawler) doRequest(exec *stepExecution, req *http.Request) (*http.Response, error) {
+	c.setCorrelationHeader(req)
+	interceptors, err := c.interceptRequest(req)
+	if err != nil {
+		return nil, &HTTPError{Step: exec.path, URL: req.URL.String(), Err: err}
+	}
+	limiter := exec.adaptiveLimiter()
+	policy := c.hostPolicy(req.URL)
+	client, err := c.clientFor(c.clientSettings(exec.step.Request, req.URL))
+	if err != nil {
+		return nil, &HTTPError{Step: exec.path, URL: req.URL.String(), Err: err}
+	}
+	timeout := c.bodyTimeout(exec)
+	for attempt := 0; ; attempt++ {
+		if err := c.chargeRequest(exec); err != nil {
+			return nil, err
+		}
+		release := func() {}
+		if policy != nil {
+			var err error
+			if release, err = policy.wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		start := time.Now()
+		attemptReq, cancel := withTimeout(req, timeout)
+		resp, err := client.Do(attemptReq)
+		release()
+		if err != nil {
+			cancel()
+			return nil, &HTTPError{Step: exec.path, URL: req.URL.String(), Err: err}
+		}
+		c.observeServerTime(req, resp, start)
+		if policy != nil {
+			if wait := policy.pace(resp, time.Now()); wait > time.Second {
+				c.logger.Info("[Hosts] %s rate limit quota low, next request in %s", req.URL.Hostname(), wait.Round(time.Second))
+			}
+		}
+		c.interceptResponse(interceptors, req, resp)
+
+		rewindable := req.Body == nil || req.GetBody != nil
+		if limiter != nil && limiter.observe(resp.StatusCode, time.Since(start)) && attempt < limiter.maxRetries() && rewindable {
+			delay := retryDelay(resp, attempt)
+			resp.Body.Close()
+			cancel()
+			c.logger.Warning("[Request] %s returned %s, retrying in %s", req.URL.String(), resp.Status, delay)
+			c.updateStats(exec, func(s *StepStats) { s.Retries++ })
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			case <-timer.C:
+			}
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, &HTTPError{Step: exec.path, URL: req.URL.String(), Err: err}
+				}
+			}
+			continue
+		}
+
+		if timeout > 0 {
+			// the upgraded connections of the websocket subscriptions, without timeout, keep their body
+			resp.Body = &timedBody{ReadCloser: resp.Body, cancel: cancel}
+		}
+		resp.Body = c.meterBody(exec, resp.Body)
+		return resp, nil
+	}
+}
+
+func (c *ApiCrawler) meterBody(exec *stepExecution, body io.ReadCloser) io.ReadCloser {
+	return &meteredBody{ReadCloser: body, charge: func(n int) error { return c.chargeBytes(exec, n) }}
+}
+
+type meteredBody struct {
+	io.ReadCloser
+	charge func(n int) error
+}
+
+func (m *meteredBody) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	if n > 0 {
+		// the data past the limit is dropped, decoders would otherwise accept
+		// a complete value read together with the error
+		if budgetErr := m.charge(n); budgetErr != nil {
+			return 0, budgetErr
+		}
+	}
+	return n, err
+}
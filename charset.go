@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsetTables map the bytes 0x80-0xFF of the supported single byte charsets to runes.
+var charsetTables = map[string]*[128]rune{}
+
+func init() {
+	latin1 := new([128]rune)
+	for i := range latin1 {
+		latin1[i] = rune(0x80 + i)
+	}
+
+	latin9 := *latin1
+	for b, r := range map[byte]rune{0xA4: '€', 0xA6: 'Š', 0xA8: 'š', 0xB4: 'Ž', 0xB8: 'ž', 0xBC: 'Œ', 0xBD: 'œ', 0xBE: 'Ÿ'} {
+		latin9[b-0x80] = r
+	}
+
+	cp1252 := *latin1
+	for i, r := range []rune{
+		'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+		0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+	} {
+		cp1252[i] = r
+	}
+
+	for _, name := range []string{"iso-8859-1", "iso8859-1", "latin1", "l1"} {
+		charsetTables[name] = latin1
+	}
+	for _, name := range []string{"iso-8859-15", "iso8859-15", "latin9", "l9"} {
+		charsetTables[name] = &latin9
+	}
+	for _, name := range []string{"windows-1252", "cp1252", "x-cp1252"} {
+		charsetTables[name] = &cp1252
+	}
+}
+
+// responseCharset returns the charset of a response: request.responseCharset when set,
+// otherwise the charset parameter of the Content-Type header.
+func responseCharset(reqConfig *RequestConfig, header http.Header) string {
+	if reqConfig != nil && reqConfig.ResponseCharset != "" {
+		return reqConfig.ResponseCharset
+	}
+	if header == nil {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["charset"]
+}
+
+func isSupportedCharset(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return true
+	}
+	_, ok := charsetTables[strings.ToLower(charset)]
+	return ok
+}
+
+// transcodeToUTF8 wraps body so that it yields UTF-8.
+// UTF-8 and ASCII bodies are returned unchanged.
+func transcodeToUTF8(charset string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return body, nil
+	}
+	table, ok := charsetTables[strings.ToLower(charset)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported response charset: %s", charset)
+	}
+	return &singleByteDecoder{src: bufio.NewReader(body), table: table}, nil
+}
+
+type singleByteDecoder struct {
+	src     *bufio.Reader
+	table   *[128]rune
+	pending []byte
+}
+
+func (d *singleByteDecoder) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.pending) > 0 {
+			c := copy(p[n:], d.pending)
+			d.pending = d.pending[c:]
+			n += c
+			continue
+		}
+		b, err := d.src.ReadByte()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if b < 0x80 {
+			p[n] = b
+			n++
+			continue
+		}
+		d.pending = utf8.AppendRune(nil, d.table[b-0x80])
+	}
+	return n, nil
+}
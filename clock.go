@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock provides the time of profiler events, step durations and run start.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator provides the ids of runs and profiler events.
+type IDGenerator interface {
+	NewID() string
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type randomIDGenerator struct{}
+
+// NewID returns a random 128 bit hex identifier.
+func (randomIDGenerator) NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TickingClock is a deterministic Clock for golden tests: every call to Now
+// returns the previous time advanced by Step, starting at Start.
+type TickingClock struct {
+	mu    sync.Mutex
+	Start time.Time
+	Step  time.Duration
+	ticks int64
+}
+
+func NewTickingClock(start time.Time, step time.Duration) *TickingClock {
+	return &TickingClock{Start: start, Step: step}
+}
+
+func (c *TickingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.Start.Add(time.Duration(c.ticks) * c.Step)
+	c.ticks++
+	return now
+}
+
+// SequentialIDGenerator is a deterministic IDGenerator for golden tests,
+// returning Prefix-1, Prefix-2, ...
+type SequentialIDGenerator struct {
+	mu     sync.Mutex
+	Prefix string
+	next   int
+}
+
+func NewSequentialIDGenerator(prefix string) *SequentialIDGenerator {
+	return &SequentialIDGenerator{Prefix: prefix}
+}
+
+func (g *SequentialIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.next++
+	return fmt.Sprintf("%s-%d", g.Prefix, g.next)
+}
+
+func (a *ApiCrawler) SetClock(clock Clock) {
+	a.clock = clock
+}
+
+func (a *ApiCrawler) SetIDGenerator(generator IDGenerator) {
+	a.idGenerator = generator
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// configdoc renders the documentation of crawler configurations:
+//
+//	configdoc [-format markdown|html] [-out dir] config.yaml...
+//
+// Without -out the documentation is written to stdout, otherwise one file per configuration.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	apigorowler "github.com/noi-techpark/go-apigorowler"
+)
+
+func main() {
+	format := flag.String("format", apigorowler.DOC_FORMAT_MARKDOWN, "output format: markdown or html")
+	out := flag.String("out", "", "directory receiving one file per configuration, default stdout")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: configdoc [-format markdown|html] [-out dir] config.yaml...")
+		os.Exit(2)
+	}
+
+	for _, path := range flag.Args() {
+		if err := document(path, *format, *out); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
+func document(path string, format string, out string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := apigorowler.ParseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	doc, err := apigorowler.DescribeConfig(cfg, name, format)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = fmt.Println(doc)
+		return err
+	}
+	ext := ".md"
+	if format == apigorowler.DOC_FORMAT_HTML {
+		ext = ".html"
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(out, name+ext), []byte(doc), 0644)
+}
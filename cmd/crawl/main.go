@@ -0,0 +1,399 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// crawl runs a crawler configuration headless:
+//
+//	crawl [-out data.json] [-report report.json] [-events events.jsonl] config.yaml
+//	crawl -check [-timeout 10s] config.yaml
+//	crawl -diff before.json after.json
+//	crawl -record dir config.yaml
+//	crawl -test dir...
+//	crawl -event-schema
+//
+// The data is written as JSON to -out, default stdout; stream configurations write one entity
+// per line. The exit code tells the outcome of the run: 0 success, 2 validation error,
+// 3 partial failure, 4 auth failure, 5 budget exceeded, 6 run locked, 7 canceled, 8 timeout,
+// 1 when the command itself failed.
+//
+// -events writes the profiler events of the run to a JSONL file, one apigorowler.ProfilerEvent
+// per line; -event-schema prints the JSON Schema of these lines.
+//
+// -check validates the configuration and probes its hosts and authentications instead of
+// running it, printing one line per step; unreachable hosts exit with 3, failed
+// authentications with 4.
+//
+// -diff prints the structural changes between two JSON files, e.g. the data of two runs, one
+// change per line.
+//
+// -record runs the configuration and turns the run into a Go test in dir: the responses, the
+// configuration, the golden output and a test replaying them and checking the profiler events,
+// to lock in the behaviour of a configuration before refactoring it.
+//
+// -test runs the config tests declared in the *.test.yaml files of the directories, printing
+// one line per test and its failures; a failed test exits with 1.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apigorowler "github.com/noi-techpark/go-apigorowler"
+	crawler_testing "github.com/noi-techpark/go-apigorowler/testing"
+)
+
+func main() {
+	out := flag.String("out", "", "file receiving the data, default stdout")
+	report := flag.String("report", "", "file receiving the JSON run report")
+	check := flag.Bool("check", false, "probe the hosts and authentications instead of running")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of every -check probe")
+	diff := flag.Bool("diff", false, "print the changes between two JSON files instead of running")
+	record := flag.String("record", "", "directory receiving the recorded run as a Go test")
+	test := flag.Bool("test", false, "run the *.test.yaml config tests of the directories instead of running")
+	events := flag.String("events", "", "JSONL file receiving the profiler events of the run")
+	eventSchema := flag.Bool("event-schema", false, "print the JSON Schema of the profiler events instead of running")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *eventSchema && flag.NArg() == 0 {
+		os.Exit(printEventSchema())
+	}
+	if *test && flag.NArg() > 0 {
+		os.Exit(runConfigTests(flag.Args()))
+	}
+
+	if *diff && flag.NArg() == 2 {
+		os.Exit(diffFiles(flag.Arg(0), flag.Arg(1)))
+	}
+	if *diff || *test || *eventSchema || flag.NArg() != 1 {
+		usage()
+		os.Exit(apigorowler.EXIT_FAILURE)
+	}
+	if *check {
+		os.Exit(checkConnectivity(flag.Arg(0), *timeout))
+	}
+	if *record != "" {
+		os.Exit(recordFixture(flag.Arg(0), *record))
+	}
+	os.Exit(crawl(flag.Arg(0), *out, *report, *events))
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "usage: crawl [-out data.json] [-report report.json] [-events events.jsonl] config.yaml")
+	fmt.Fprintln(w, "       crawl -check [-timeout 10s] config.yaml")
+	fmt.Fprintln(w, "       crawl -diff before.json after.json")
+	fmt.Fprintln(w, "       crawl -record dir config.yaml")
+	fmt.Fprintln(w, "       crawl -test dir...")
+	fmt.Fprintln(w, "       crawl -event-schema")
+	flag.PrintDefaults()
+	fmt.Fprint(w, `
+exit codes:
+  0  success
+  1  the command failed, e.g. bad arguments or an unreadable file
+  2  validation error
+  3  partial failure, the report lists the failed steps
+  4  auth failure
+  5  run budget exceeded
+  6  another run holds the run lock
+  7  canceled, e.g. by Ctrl-C
+  8  the run deadline or a request timeout expired
+`)
+}
+
+func diffFiles(before, after string) int {
+	values := make([]any, 2)
+	for i, path := range []string{before, after} {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &values[i])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			return apigorowler.EXIT_FAILURE
+		}
+	}
+	for _, op := range apigorowler.DiffJSON(values[0], values[1]) {
+		fmt.Println(op.String())
+	}
+	return apigorowler.EXIT_SUCCESS
+}
+
+// recordFixture runs the configuration at path recording its responses into dir, then writes
+// the configuration, the golden output and the test replaying the run next to them.
+func recordFixture(path string, dir string) int {
+	fail := func(name string, err error) int {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+		return apigorowler.EXIT_FAILURE
+	}
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return fail(path, err)
+	}
+	craw, _, err := apigorowler.NewApiCrawler(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+		if code := apigorowler.ExitCode(err); code == apigorowler.EXIT_VALIDATION_ERROR {
+			return code
+		}
+		return apigorowler.EXIT_FAILURE
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fail(dir, err)
+	}
+	recorder := crawler_testing.NewRecorder(dir, nil)
+	craw.SetClient(&http.Client{Transport: recorder})
+
+	profiler := craw.EnableProfiler()
+	events := map[string]int{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for d := range profiler {
+			if d.Name != "" {
+				events[d.Name]++
+			}
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var output []byte
+	stream := craw.GetDataStream()
+	if stream != nil {
+		entities := []any{}
+		streamed := make(chan struct{})
+		go func() {
+			defer close(streamed)
+			for entity := range stream {
+				entities = append(entities, entity)
+			}
+		}()
+		err = craw.Run(ctx)
+		close(stream)
+		<-streamed
+		if err == nil {
+			output, err = json.MarshalIndent(entities, "", "  ")
+		}
+	} else {
+		err = craw.Run(ctx)
+		if err == nil {
+			output, err = craw.MarshalOutput(craw.GetData())
+		}
+	}
+	close(profiler)
+	<-done
+	if err != nil {
+		// a failed run is no behaviour worth locking in
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+		return apigorowler.ExitCode(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), config, 0644); err != nil {
+		return fail(dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "output.json"), output, 0644); err != nil {
+		return fail(dir, err)
+	}
+	fixture := crawler_testing.Fixture{
+		Name:   "recorded " + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Config: "config.yaml",
+		Mocks:  recorder.MockMap(),
+		Output: "output.json",
+		Stream: stream != nil,
+		Events: events,
+	}
+	testPath := filepath.Join(dir, "recorded_test.go")
+	if err := fixture.WriteTest(testPath); err != nil {
+		return fail(testPath, err)
+	}
+	fmt.Fprintf(os.Stderr, "recorded %d responses into %s\n", len(fixture.Mocks), dir)
+	return apigorowler.EXIT_SUCCESS
+}
+
+// runConfigTests runs the config tests of the directories, see apigorowler.ConfigTest.
+func runConfigTests(dirs []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	code := apigorowler.EXIT_SUCCESS
+	for _, dir := range dirs {
+		results, err := apigorowler.RunConfigTests(ctx, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", dir, err.Error())
+			return apigorowler.EXIT_FAILURE
+		}
+		if len(results) == 0 {
+			fmt.Printf("?\t%s\tno config tests\n", dir)
+		}
+		for _, r := range results {
+			if r.Passed() {
+				fmt.Printf("ok\t%s\n", r.Path)
+				continue
+			}
+			code = apigorowler.EXIT_FAILURE
+			fmt.Printf("FAIL\t%s\n", r.Path)
+			for _, failure := range r.Failures {
+				fmt.Printf("\t%s\n", failure)
+			}
+		}
+	}
+	return code
+}
+
+func checkConnectivity(path string, timeout time.Duration) int {
+	craw, _, err := apigorowler.NewApiCrawler(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+		if code := apigorowler.ExitCode(err); code == apigorowler.EXIT_VALIDATION_ERROR {
+			return code
+		}
+		return apigorowler.EXIT_FAILURE
+	}
+
+	results, err := craw.CheckConnectivity(context.Background(), timeout)
+	for _, r := range results {
+		switch {
+		case !r.OK():
+			fmt.Printf("%s\t%s\t%s: %s\n", r.Step, r.Host, r.Problem, r.Error.Error())
+		case r.Host == "":
+			fmt.Printf("%s\t-\tauth ok\n", r.Step)
+		default:
+			fmt.Printf("%s\t%s\tok %d (%s)\n", r.Step, r.Host, r.Status, r.Duration.Round(time.Millisecond))
+		}
+	}
+	return apigorowler.ExitCode(err)
+}
+
+func printEventSchema() int {
+	schema, err := apigorowler.ProfilerEventSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return apigorowler.EXIT_FAILURE
+	}
+	fmt.Println(string(schema))
+	return apigorowler.EXIT_SUCCESS
+}
+
+// exportEvents writes the profiler events of craw as JSONL to path until the returned
+// function is called.
+func exportEvents(craw *apigorowler.ApiCrawler, path string) (func() error, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	profiler := craw.EnableProfiler()
+	done := make(chan error, 1)
+	go func() {
+		enc := json.NewEncoder(f)
+		var err error
+		for d := range profiler {
+			if err == nil {
+				err = enc.Encode(apigorowler.NewProfilerEvent(d))
+			}
+		}
+		done <- err
+	}()
+	return func() error {
+		close(profiler)
+		err := <-done
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+func crawl(path string, out string, report string, events string) int {
+	craw, _, err := apigorowler.NewApiCrawler(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+		if code := apigorowler.ExitCode(err); code == apigorowler.EXIT_VALIDATION_ERROR {
+			return code
+		}
+		return apigorowler.EXIT_FAILURE
+	}
+
+	w := io.Writer(os.Stdout)
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", out, err.Error())
+			return apigorowler.EXIT_FAILURE
+		}
+		defer f.Close()
+		w = f
+	}
+	write := func(data []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+
+	if events != "" {
+		closeEvents, err := exportEvents(craw, events)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", events, err.Error())
+			return apigorowler.EXIT_FAILURE
+		}
+		defer func() {
+			if err := closeEvents(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", events, err.Error())
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var runErr error
+	if stream := craw.GetDataStream(); stream != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for entity := range stream {
+				if err := write(craw.MarshalOutputLine(entity)); err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				}
+			}
+		}()
+		runErr = craw.Run(ctx)
+		close(stream)
+		<-done
+	} else {
+		runErr = craw.Run(ctx)
+		if runErr == nil {
+			if err := write(craw.MarshalOutput(craw.GetData())); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				return apigorowler.EXIT_FAILURE
+			}
+		}
+	}
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, runErr.Error())
+	}
+
+	if report != "" {
+		data, err := json.MarshalIndent(craw.LastRunReport(runErr), "", "  ")
+		if err == nil {
+			err = os.WriteFile(report, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", report, err.Error())
+			return apigorowler.EXIT_FAILURE
+		}
+	}
+	return apigorowler.ExitCode(runErr)
+}
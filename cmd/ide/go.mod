@@ -10,10 +10,13 @@ require (
 	github.com/noi-techpark/go-apigorowler v0.0.7
 	github.com/rivo/tview v0.0.0-20250501113434-0c592cd31026
 	github.com/sergi/go-diff v1.4.0
+	golang.org/x/oauth2 v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/PuerkitoBio/goquery v1.10.3 // indirect
+	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/expr-lang/expr v1.17.6 // indirect
 	github.com/gdamore/encoding v1.0.1 // indirect
 	github.com/itchyny/gojq v0.12.17 // indirect
@@ -21,8 +24,8 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/oauth2 v0.30.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
-	golang.org/x/term v0.28.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/net v0.39.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/term v0.31.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
 )
@@ -9,10 +9,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +24,7 @@ import (
 	"github.com/noi-techpark/go-apigorowler"
 	"github.com/rivo/tview"
 	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/oauth2"
 	"gopkg.in/yaml.v3"
 )
 
@@ -62,6 +65,26 @@ func getColoredDiff(before, after string) string {
 	return result.String()
 }
 
+// Render the structural diff of an event, one colored line per change
+func getColoredOps(ops []apigorowler.DiffOp) string {
+	if len(ops) == 0 {
+		return "no changes"
+	}
+	var result strings.Builder
+	for _, op := range ops {
+		switch op.Op {
+		case apigorowler.DIFF_OP_ADD:
+			result.WriteString(`[green]` + escapeBrackets(op.String()) + `[-:-:-]`)
+		case apigorowler.DIFF_OP_REMOVE:
+			result.WriteString(`[red]` + escapeBrackets(op.String()) + `[-:-:-]`)
+		default:
+			result.WriteString(`[yellow]` + escapeBrackets(op.String()) + `[-:-:-]`)
+		}
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
 type ConsoleLogger struct {
 	LogFunc func(msg string)
 }
@@ -98,6 +121,7 @@ type ConsoleApp struct {
 	configFilePath string
 	profilerData   []apigorowler.StepProfilerData
 	stopFn         context.CancelFunc
+	deviceTokens   map[string]*oauth2.Token // device logins of the session, kept across config reloads
 }
 
 func recoverAndLog(logger ConsoleLogger) {
@@ -316,6 +340,12 @@ func (c *ConsoleApp) updateOnChangeStepNode(node *tview.TreeNode) {
 	// Build description text
 	descriptionText := ""
 	descriptionText += fmt.Sprintf("[green]Step Name:[-:-:-:-]%s\n", data.Name)
+	if data.Config.Description != "" {
+		descriptionText += fmt.Sprintf("[green]Step Description:[-:-:-:-]%s\n", escapeBrackets(data.Config.Description))
+	}
+	for _, k := range slices.Sorted(maps.Keys(data.Config.Meta)) {
+		descriptionText += fmt.Sprintf("[green]%s:[-:-:-:-]%s\n", escapeBrackets(k), escapeBrackets(fmt.Sprint(data.Config.Meta[k])))
+	}
 	descriptionText += fmt.Sprintf("[green]Step Configuration:\n[-:-:-:-]%s\n", escapeBrackets(string(conf)))
 	descriptionText += "\n"
 	for k, v := range data.Extra {
@@ -379,6 +409,9 @@ func (c *ConsoleApp) setupCrawlJob() {
 					dataStringBefore, _ := json.MarshalIndent(d.DataBefore, "", "  ")
 					d.Extra["Step Diff with prev"] = (getColoredDiff(string(dataStringBefore), string(dataString)))
 				}
+				if ops, ok := d.Extra["diff"].([]apigorowler.DiffOp); ok {
+					d.Extra["diff"] = getColoredOps(ops)
+				}
 				d.DataString = escapeBrackets(string(dataString))
 
 				c.profilerData = append(c.profilerData, d)
@@ -413,6 +446,24 @@ func (c *ConsoleApp) setupCrawlJob() {
 		defer cancel()
 		c.stopFn = cancel
 
+		craw.SetDeviceTokens(c.deviceTokens)
+		loginErr := craw.DeviceLogin(ctx, func(d apigorowler.DeviceAuthorization) {
+			uri := d.VerificationURI
+			if d.VerificationURIComplete != "" {
+				uri = d.VerificationURIComplete
+			}
+			if d.UserCode == "" {
+				c.appendLog(fmt.Sprintf("[yellow]Login for %s: open %s", d.Location, escapeBrackets(uri)))
+				return
+			}
+			c.appendLog(fmt.Sprintf("[yellow]Device login for %s: open %s and enter the code %s", d.Location, escapeBrackets(uri), d.UserCode))
+		})
+		c.deviceTokens = craw.DeviceTokens()
+		if loginErr != nil {
+			c.appendLog("[red]" + escapeBrackets(loginErr.Error()))
+			return
+		}
+
 		// handle stream
 		if craw.Config.Stream {
 			stream := craw.GetDataStream()
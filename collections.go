@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"sync"
+)
+
+// outputCollections holds the results of the steps with collectInto, by collection name,
+// apart from the context tree.
+type outputCollections struct {
+	mu    sync.Mutex
+	items map[string][]any
+}
+
+func newOutputCollections() *outputCollections {
+	return &outputCollections{items: map[string][]any{}}
+}
+
+// add appends a step result to a collection, the elements of array results one by one.
+func (o *outputCollections) add(name string, result any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if items, ok := result.([]any); ok {
+		o.items[name] = append(o.items[name], items...)
+		return
+	}
+	o.items[name] = append(o.items[name], result)
+}
+
+// GetCollections returns the named output collections filled by the steps with collectInto
+// in the last run.
+func (a *ApiCrawler) GetCollections() map[string][]any {
+	a.collections.mu.Lock()
+	defer a.collections.mu.Unlock()
+
+	collections := make(map[string][]any, len(a.collections.items))
+	for name, items := range a.collections.items {
+		collections[name] = append([]any{}, items...)
+	}
+	return collections
+}
+
+// collectItem appends the result of a forEach iteration to the collection of the step,
+// reporting whether it was collected and is therefore not kept in the context.
+func (c *ApiCrawler) collectItem(exec *stepExecution, i int, result any) bool {
+	if exec.step.CollectInto == "" {
+		return false
+	}
+	c.collections.add(exec.step.CollectInto, result)
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Collect result #%d", i), exec, result, nil, "collection", exec.step.CollectInto)
+	return true
+}
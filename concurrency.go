@@ -0,0 +1,499 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveDefaultMaxRetries = 3
+	adaptiveBaseBackoff       = 250 * time.Millisecond
+	adaptiveMaxBackoff        = 10 * time.Second
+
+	defaultOccupancySampleMs = 1000
+)
+
+// AdaptiveConcurrencyConfig lets a parallel forEach step back off when the upstream throttles:
+// a throttled response (408, 429, 5xx) halves the concurrency and is retried, a response slower
+// than latencyThresholdMs reduces it by one. After as many fast, successful responses in a row
+// as the current concurrency, it grows by one again, up to maxConcurrency.
+type AdaptiveConcurrencyConfig struct {
+	MinConcurrency     int `yaml:"minConcurrency,omitempty" json:"minConcurrency,omitempty"`         // default 1
+	LatencyThresholdMs int `yaml:"latencyThresholdMs,omitempty" json:"latencyThresholdMs,omitempty"` // 0 ignores latency
+	MaxRetries         int `yaml:"maxRetries,omitempty" json:"maxRetries,omitempty"`                 // retries of a throttled request, default 3
+}
+
+// concurrencyLimiter bounds the iterations of a forEach step running at the same time.
+// When adaptive, the bound follows the responses observed by the nested requests.
+type concurrencyLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	inFlight  int
+	min       int
+	max       int
+	adaptive  *AdaptiveConcurrencyConfig
+	successes int
+	onChange  func(limit int)
+	pressure  func() bool // memory pressure, holding the concurrency at min
+}
+
+func newConcurrencyLimiter(max int, adaptive *AdaptiveConcurrencyConfig) *concurrencyLimiter {
+	l := &concurrencyLimiter{limit: max, min: 1, max: max, adaptive: adaptive}
+	if adaptive != nil && adaptive.MinConcurrency > 0 {
+		l.min = adaptive.MinConcurrency
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a free slot.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= l.effectiveLimit() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.inFlight++
+	return nil
+}
+
+// effectiveLimit is the limit, or min under memory pressure. Iterations waiting for a slot
+// see the pressure end as soon as a running one releases its slot.
+func (l *concurrencyLimiter) effectiveLimit() int {
+	if l.pressure != nil && l.pressure() {
+		return min(l.min, l.limit)
+	}
+	return l.limit
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.cond.Broadcast()
+}
+
+// observe adapts the limit to a response, reporting whether it was throttled.
+func (l *concurrencyLimiter) observe(status int, latency time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous := l.limit
+	throttled := (&HTTPError{Status: status}).Temporary()
+	switch {
+	case throttled:
+		l.limit = max(l.min, l.limit/2)
+		l.successes = 0
+	case l.adaptive.LatencyThresholdMs > 0 && latency > time.Duration(l.adaptive.LatencyThresholdMs)*time.Millisecond:
+		l.limit = max(l.min, l.limit-1)
+		l.successes = 0
+	default:
+		l.successes++
+		if l.successes >= l.limit && l.limit < l.max {
+			l.limit++
+			l.successes = 0
+		}
+	}
+
+	if l.limit != previous {
+		l.cond.Broadcast()
+		if l.onChange != nil {
+			l.onChange(l.limit)
+		}
+	}
+	return throttled
+}
+
+func (l *concurrencyLimiter) maxRetries() int {
+	if l.adaptive.MaxRetries > 0 {
+		return l.adaptive.MaxRetries
+	}
+	return adaptiveDefaultMaxRetries
+}
+
+// adaptiveLimiter returns the limiter of the closest adaptive forEach step exec is nested in.
+func (exec *stepExecution) adaptiveLimiter() *concurrencyLimiter {
+	for e := exec; e != nil; e = e.parent {
+		if e.limiter != nil && e.limiter.adaptive != nil {
+			return e.limiter
+		}
+	}
+	return nil
+}
+
+// workerSlot identifies the worker slot of a parallel forEach step an iteration runs in.
+type workerSlot struct {
+	pool string // location of the forEach step
+	id   int
+}
+
+// workerSlot returns the slot of the closest parallel forEach iteration exec runs in.
+func (exec *stepExecution) workerSlot() *workerSlot {
+	for e := exec; e != nil; e = e.parent {
+		if e.worker != nil {
+			return e.worker
+		}
+	}
+	return nil
+}
+
+// parallel reports whether exec runs inside an iteration of a parallel forEach step.
+func (exec *stepExecution) parallel() bool {
+	for e := exec; e != nil; e = e.parent {
+		if e.limiter != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// templateContext returns the contexts of exec as template data. Parallel iterations share
+// the contexts above their item with the merges of the other iterations, and gojq rewrites
+// the numbers of its inputs in place: they get a copy taken under the merge lock.
+func (c *ApiCrawler) templateContext(exec *stepExecution) map[string]interface{} {
+	if !exec.parallel() {
+		return contextMapToTemplate(exec.contextMap)
+	}
+	c.mergeMu.RLock()
+	defer c.mergeMu.RUnlock()
+	return cloneJSON(contextMapToTemplate(exec.contextMap)).(map[string]interface{})
+}
+
+// cloneJSON copies the maps and slices of a decoded JSON value.
+func cloneJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = cloneJSON(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = cloneJSON(e)
+		}
+		return out
+	}
+	return v
+}
+
+// retryDelay honours Retry-After (in seconds), falling back to an exponential backoff.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, adaptiveMaxBackoff)
+	}
+	return min(adaptiveBaseBackoff<<attempt, adaptiveMaxBackoff)
+}
+
+// Names of the profiler events of parallel forEach steps: PARALLELISM_SETUP once when the
+// iterations start, then PARALLELISM_SAMPLE as its follow-ups.
+const (
+	PARALLELISM_SETUP  = "Parallelism Setup"
+	PARALLELISM_SAMPLE = "Parallelism Sample"
+)
+
+// OccupancySample is the Data of the PARALLELISM_SAMPLE profiler events, taken periodically
+// while a parallel forEach step runs: whether concurrency or the upstream is the bottleneck.
+type OccupancySample struct {
+	Limit   int           `json:"limit"` // current concurrency, below maxConcurrency when adaptive or under memory pressure
+	Busy    int           `json:"busy"`
+	Idle    int           `json:"idle"`
+	Queued  int           `json:"queued"` // items not started yet
+	Done    int           `json:"done"`
+	Workers []WorkerState `json:"workers"`
+}
+
+// WorkerState is the state of a worker slot of a parallel forEach step.
+type WorkerState struct {
+	Worker int  `json:"worker"`
+	Busy   bool `json:"busy"`
+	Item   int  `json:"item"` // index of the item being iterated, -1 when idle
+}
+
+// workerOccupancy tracks the items the worker slots of a parallel forEach step iterate.
+type workerOccupancy struct {
+	mu      sync.Mutex
+	items   []int
+	total   int
+	started int
+	done    int
+}
+
+func newWorkerOccupancy(workers int, total int) *workerOccupancy {
+	o := &workerOccupancy{items: make([]int, workers), total: total}
+	for i := range o.items {
+		o.items[i] = -1
+	}
+	return o
+}
+
+// start assigns item to an idle worker slot, returning the slot.
+func (o *workerOccupancy) start(item int) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.started++
+	for worker, current := range o.items {
+		if current < 0 {
+			o.items[worker] = item
+			return worker
+		}
+	}
+	// unreachable while iterations hold a limiter slot
+	o.items = append(o.items, item)
+	return len(o.items) - 1
+}
+
+func (o *workerOccupancy) finish(worker int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.items[worker] = -1
+	o.done++
+}
+
+func (o *workerOccupancy) sample(limit int) OccupancySample {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sample := OccupancySample{Limit: limit, Queued: o.total - o.started, Done: o.done, Workers: make([]WorkerState, len(o.items))}
+	for worker, item := range o.items {
+		sample.Workers[worker] = WorkerState{Worker: worker, Busy: item >= 0, Item: item}
+		if item >= 0 {
+			sample.Busy++
+		} else {
+			sample.Idle++
+		}
+	}
+	return sample
+}
+
+func (l *concurrencyLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.effectiveLimit()
+}
+
+// sampleOccupancy pushes the PARALLELISM_SETUP profiler event of a parallel forEach step,
+// then an OccupancySample every occupancySampleMs until the returned function is called,
+// which pushes the last one. Nothing is sampled without the profiler.
+func (c *ApiCrawler) sampleOccupancy(exec *stepExecution, limiter *concurrencyLimiter, occupancy *workerOccupancy) func() {
+	if c.profiler == nil {
+		return func() {}
+	}
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, PARALLELISM_SETUP, exec, nil, nil,
+		"maxConcurrency", exec.step.MaxConcurrency, "items", occupancy.total, "adaptive", exec.step.AdaptiveConcurrency != nil)
+
+	interval := exec.step.OccupancySampleMs
+	if interval <= 0 {
+		interval = defaultOccupancySampleMs
+	}
+	push := func() {
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, PARALLELISM_SAMPLE, exec, occupancy.sample(limiter.currentLimit()), nil)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				push()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+		push()
+	}
+}
+
+// forEachParallel runs the iterations of a forEach step with up to maxConcurrency at the
+// same time. The first failing iteration cancels the others, a reached time budget stops
+// starting new ones.
+func (c *ApiCrawler) forEachParallel(ctx context.Context, exec *stepExecution, items []interface{}) ([]interface{}, error) {
+	limiter := newConcurrencyLimiter(exec.step.MaxConcurrency, exec.step.AdaptiveConcurrency)
+	limiter.onChange = func(limit int) {
+		c.logger.Info("[ForEach] %s concurrency set to %d", exec.path, limit)
+	}
+	if c.memory != nil {
+		limiter.pressure = c.underMemoryPressure
+	}
+	exec.limiter = limiter
+	if exec.step.OrderedMerges {
+		exec.merges = newMergeSequencer()
+	}
+	occupancy := newWorkerOccupancy(exec.step.MaxConcurrency, len(items))
+	stopSampling := c.sampleOccupancy(exec, limiter, occupancy)
+	defer stopSampling()
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// items not iterated because of the time budget are kept as they are
+	results := append([]interface{}(nil), items...)
+	diverted := make([]bool, len(items))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for i, item := range items {
+		if err := limiter.acquire(workCtx); err != nil {
+			break
+		}
+		if c.timeBudgetReached(exec) {
+			limiter.release()
+			c.logger.Info("[ForEach] %s time budget reached, %d of %d items iterated", exec.path, i, len(items))
+			break
+		}
+		if err := c.relieveMemoryPressure(workCtx, exec); err != nil {
+			limiter.release()
+			once.Do(func() {
+				firstErr = err
+				cancel()
+			})
+			break
+		}
+		// items may be part of a context the running iterations merge into
+		c.mergeMu.RLock()
+		item := cloneJSON(item)
+		c.mergeMu.RUnlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer limiter.release()
+			worker := occupancy.start(i)
+			defer occupancy.finish(worker)
+
+			// the events of the iteration and of its nested steps carry the worker slot
+			iteration := *exec
+			iteration.worker = &workerSlot{pool: exec.path, id: worker}
+			result, err := c.forEachIteration(workCtx, &iteration, i, len(items), item)
+			if err == nil {
+				diverted[i], err = c.divertItem(workCtx, exec, i, result)
+			}
+			if exec.merges != nil {
+				if mergeErr := exec.merges.finish(i); err == nil {
+					err = mergeErr
+				}
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	kept := results[:0]
+	for i, result := range results {
+		if !diverted[i] {
+			kept = append(kept, result)
+		}
+	}
+	return kept, nil
+}
+
+// mergeSequencer applies the merges of parallel forEach iterations into shared contexts in item
+// order (orderedMerges): the merges of an iteration are held until it and all the previous
+// ones are done.
+type mergeSequencer struct {
+	mu      sync.Mutex
+	pending map[int][]func() error
+	done    map[int]bool
+	next    int // first iteration whose merges are not applied yet
+}
+
+func newMergeSequencer() *mergeSequencer {
+	return &mergeSequencer{pending: map[int][]func() error{}, done: map[int]bool{}}
+}
+
+func (s *mergeSequencer) add(item int, merge func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[item] = append(s.pending[item], merge)
+}
+
+// finish marks an iteration done, applying the merges of the done iterations next in order.
+func (s *mergeSequencer) finish(item int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done[item] = true
+	for s.done[s.next] {
+		merges := s.pending[s.next]
+		delete(s.pending, s.next)
+		delete(s.done, s.next)
+		s.next++
+		for _, merge := range merges {
+			if err := merge(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// orderedMerges returns the sequencer of the closest orderedMerges forEach step exec merges
+// into a shared context of, with the iteration exec belongs to. Contexts deeper than the one of
+// the forEach step belong to a single iteration and are merged into right away.
+func (exec *stepExecution) orderedMerges() (*mergeSequencer, int) {
+	target := exec.mergeTarget()
+	for e := exec; e.parent != nil; e = e.parent {
+		owner := e.parent
+		if owner.merges != nil && (target == nil || target.depth <= owner.currentContext.depth) {
+			return owner.merges, e.item
+		}
+	}
+	return nil, 0
+}
+
+// mergeTarget returns the context a step result is merged into, nil for output collections.
+func (exec *stepExecution) mergeTarget() *Context {
+	switch {
+	case exec.step.CollectInto != "":
+		return nil
+	case exec.step.MergeOn != "":
+		return exec.currentContext
+	case exec.step.MergeWithParentOn != "":
+		return exec.contextMap[exec.currentContext.ParentContext]
+	case exec.step.MergeWithContext != nil:
+		return exec.contextMap[exec.step.MergeWithContext.Name]
+	default:
+		return exec.currentContext
+	}
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter(8, &AdaptiveConcurrencyConfig{MinConcurrency: 2, LatencyThresholdMs: 100})
+
+	assert.True(t, l.observe(http.StatusTooManyRequests, time.Millisecond))
+	assert.Equal(t, 4, l.limit)
+	assert.True(t, l.observe(http.StatusServiceUnavailable, time.Millisecond))
+	assert.Equal(t, 2, l.limit)
+	assert.True(t, l.observe(http.StatusBadGateway, time.Millisecond))
+	assert.Equal(t, 2, l.limit, "never below minConcurrency")
+
+	// ramps up by one after as many fast responses as the current limit
+	assert.False(t, l.observe(http.StatusOK, time.Millisecond))
+	assert.Equal(t, 2, l.limit)
+	assert.False(t, l.observe(http.StatusOK, time.Millisecond))
+	assert.Equal(t, 3, l.limit)
+
+	// slow responses reduce it by one
+	assert.False(t, l.observe(http.StatusOK, time.Second))
+	assert.Equal(t, 2, l.limit)
+
+	// not found is not throttling
+	assert.False(t, l.observe(http.StatusNotFound, time.Millisecond))
+
+	for range 100 {
+		l.observe(http.StatusOK, time.Millisecond)
+	}
+	assert.Equal(t, 8, l.limit, "never above maxConcurrency")
+}
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	l := newConcurrencyLimiter(2, nil)
+	require.NoError(t, l.acquire(context.Background()))
+	require.NoError(t, l.acquire(context.Background()))
+	assert.Equal(t, 2, l.inFlight)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+
+	acquired := make(chan error)
+	go func() { acquired <- l.acquire(context.Background()) }()
+	l.release()
+	require.NoError(t, <-acquired)
+}
+
+func TestWorkerOccupancy(t *testing.T) {
+	o := newWorkerOccupancy(2, 3)
+	first := o.start(0)
+	second := o.start(1)
+	assert.NotEqual(t, first, second)
+
+	sample := o.sample(2)
+	assert.Equal(t, 2, sample.Busy)
+	assert.Equal(t, 0, sample.Idle)
+	assert.Equal(t, 1, sample.Queued)
+
+	o.finish(first)
+	assert.Equal(t, first, o.start(2), "the freed slot is reused")
+	o.finish(first)
+	o.finish(second)
+
+	sample = o.sample(1)
+	assert.Equal(t, OccupancySample{Limit: 1, Idle: 2, Done: 3, Workers: []WorkerState{{0, false, -1}, {1, false, -1}}}, sample)
+}
+
+func TestConcurrencyLimiterMemoryPressure(t *testing.T) {
+	pressure := true
+	l := newConcurrencyLimiter(4, &AdaptiveConcurrencyConfig{MinConcurrency: 2})
+	l.pressure = func() bool { return pressure }
+	assert.Equal(t, 2, l.currentLimit(), "minConcurrency under pressure")
+	pressure = false
+	assert.Equal(t, 4, l.currentLimit())
+
+	l = newConcurrencyLimiter(3, nil)
+	l.pressure = func() bool { return true }
+	require.NoError(t, l.acquire(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded, "a single iteration at a time")
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	crawler_testing "github.com/noi-techpark/go-apigorowler/testing"
+	"gopkg.in/yaml.v3"
+)
+
+// CONFIG_TEST_SUFFIX names the sidecar declaring the regression test of a configuration,
+// stations.test.yaml tests stations.yaml.
+const CONFIG_TEST_SUFFIX = ".test.yaml"
+
+// ConfigTest is a regression test of a configuration declared in YAML, for the maintainers
+// of configurations not writing Go: the run replays the fixtures instead of calling the
+// upstream, its data is compared to the expected output and the requests of its steps to the
+// expected ones. Paths are relative to the directory of the sidecar.
+type ConfigTest struct {
+	Config     string                     `yaml:"config,omitempty"` // default the sidecar name without .test
+	Params     map[string]any             `yaml:"params,omitempty"` // run params, see RunWithParams
+	Fixtures   map[string]string          `yaml:"fixtures"`         // url => response file
+	Output     any                        `yaml:"output,omitempty"` // expected data, the array of the streamed entities in stream mode
+	OutputFile string                     `yaml:"outputFile,omitempty"`
+	Steps      map[string]StepExpectation `yaml:"steps,omitempty"` // by step name
+}
+
+// StepExpectation is what a ConfigTest expects of the request steps of a name.
+type StepExpectation struct {
+	Pages *int     `yaml:"pages,omitempty"` // responses received by the steps
+	URLs  []string `yaml:"urls,omitempty"`  // urls requested by the steps, in order
+}
+
+// ConfigTestResult is the outcome of a ConfigTest, passed without failures.
+type ConfigTestResult struct {
+	Path     string
+	Failures []string
+}
+
+func (r ConfigTestResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// RunConfigTests runs the config tests found in dir and its subdirectories, in path order.
+func RunConfigTests(ctx context.Context, dir string) ([]ConfigTestResult, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), CONFIG_TEST_SUFFIX) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]ConfigTestResult, 0, len(paths))
+	for _, path := range paths {
+		results = append(results, RunConfigTest(ctx, path))
+	}
+	return results, nil
+}
+
+// RunConfigTest runs the config test declared at path.
+func RunConfigTest(ctx context.Context, path string) ConfigTestResult {
+	result := ConfigTestResult{Path: path}
+	fail := func(format string, args ...any) ConfigTestResult {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, args...))
+		return result
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fail("%s", err.Error())
+	}
+	var test ConfigTest
+	if err := yaml.Unmarshal(data, &test); err != nil {
+		return fail("invalid test: %s", err.Error())
+	}
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+	if test.Config == "" {
+		test.Config = strings.TrimSuffix(filepath.Base(path), CONFIG_TEST_SUFFIX) + ".yaml"
+	}
+	if test.Output != nil && test.OutputFile != "" {
+		return fail("invalid test: output and outputFile can not both be set")
+	}
+	var expected any
+	if test.OutputFile != "" {
+		if err := crawler_testing.LoadOutput(&expected, resolve(test.OutputFile)); err != nil {
+			return fail("outputFile: %s", err.Error())
+		}
+	} else if test.Output != nil {
+		if expected, err = jsonValue(test.Output); err != nil {
+			return fail("invalid output: %s", err.Error())
+		}
+	}
+
+	craw, _, err := NewApiCrawler(resolve(test.Config))
+	if err != nil {
+		return fail("configuration: %s", err.Error())
+	}
+	craw.SetLogger(silentConfigTestLogger{})
+	mocks := map[string]string{}
+	for url, file := range test.Fixtures {
+		mocks[url] = resolve(file)
+	}
+	craw.SetClient(&http.Client{Transport: crawler_testing.NewMockRoundTripper(mocks)})
+
+	// the urls of the request steps, by step name
+	requested := map[string][]string{}
+	profiler := craw.EnableProfiler()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for d := range profiler {
+			if url, ok := d.Extra["url"].(string); ok && d.Type == STEP_PROFILER_TYPE_START {
+				requested[d.Config.Name] = append(requested[d.Config.Name], url)
+			}
+		}
+	}()
+
+	var output any
+	var runErr error
+	if stream := craw.GetDataStream(); stream != nil {
+		entities := []any{}
+		streamed := make(chan struct{})
+		go func() {
+			defer close(streamed)
+			for entity := range stream {
+				entities = append(entities, entity)
+			}
+		}()
+		runErr = craw.RunWithParams(ctx, test.Params)
+		close(stream)
+		<-streamed
+		output = entities
+	} else {
+		runErr = craw.RunWithParams(ctx, test.Params)
+		output = craw.GetData()
+	}
+	close(profiler)
+	<-done
+	if runErr != nil {
+		return fail("run: %s", runErr.Error())
+	}
+
+	if test.Output != nil || test.OutputFile != "" {
+		actual, err := jsonValue(output)
+		if err != nil {
+			return fail("output: %s", err.Error())
+		}
+		for _, op := range DiffJSON(expected, actual) {
+			result.Failures = append(result.Failures, "output: "+op.String())
+		}
+	}
+
+	names := make([]string, 0, len(test.Steps))
+	for name := range test.Steps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		expect, urls := test.Steps[name], requested[name]
+		if expect.Pages != nil && *expect.Pages != len(urls) {
+			result.Failures = append(result.Failures, fmt.Sprintf("step '%s': %d pages, expected %d", name, len(urls), *expect.Pages))
+		}
+		if expect.URLs != nil && !slices.Equal(expect.URLs, urls) {
+			result.Failures = append(result.Failures, fmt.Sprintf("step '%s': requested %v, expected %v", name, urls, expect.URLs))
+		}
+	}
+	return result
+}
+
+// jsonValue returns v as plain JSON values, numbers decoded as float64 like the data of a run.
+func jsonValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
+
+// silentConfigTestLogger keeps the logs of the runs out of the test report.
+type silentConfigTestLogger struct{}
+
+func (silentConfigTestLogger) Debug(msg string, args ...any)   {}
+func (silentConfigTestLogger) Info(msg string, args ...any)    {}
+func (silentConfigTestLogger) Warning(msg string, args ...any) {}
+func (silentConfigTestLogger) Error(msg string, args ...any)   {}
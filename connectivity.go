@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	CONNECTIVITY_DNS     = "dns"
+	CONNECTIVITY_CONNECT = "connect"
+	CONNECTIVITY_TLS     = "tls"
+	CONNECTIVITY_TIMEOUT = "timeout"
+	CONNECTIVITY_AUTH    = "auth"
+
+	connectivityDefaultTimeout = 10 * time.Second
+)
+
+// ConnectivityResult is the outcome of the connectivity check of a step: whether its host
+// answers and whether the authentication of the step works.
+type ConnectivityResult struct {
+	Step     string        `json:"step"` // steps[0].steps[1]
+	Host     string        `json:"host"` // https://api.example.com
+	Status   int           `json:"status,omitempty"`
+	Problem  string        `json:"problem,omitempty"` // dns | connect | tls | timeout | auth
+	Error    error         `json:"-"`
+	Duration time.Duration `json:"duration"` // of the host probe
+}
+
+func (r ConnectivityResult) OK() bool {
+	return r.Error == nil
+}
+
+// CheckConnectivity is the deep validation of a configuration, run before scheduling it:
+// every distinct host of the steps is probed once with HEAD (GET when HEAD is not allowed) and
+// every authentication is checked as by CheckAuth, each within timeout (default 10s). Any
+// response of the host counts as reachable. The returned error joins the problems; failed
+// authentications are *AuthError.
+func (a *ApiCrawler) CheckConnectivity(ctx context.Context, timeout time.Duration) ([]ConnectivityResult, error) {
+	if timeout <= 0 {
+		timeout = connectivityDefaultTimeout
+	}
+	authCtx, cancel := context.WithTimeout(ctx, timeout)
+	authResults, _ := a.CheckAuth(authCtx)
+	cancel()
+	authErrors := map[string]*AuthError{}
+	for _, r := range authResults {
+		if r.Error != nil {
+			authErrors[r.Location] = &AuthError{Location: r.Location, Type: r.Type, Err: r.Error}
+		}
+	}
+
+	var results []ConnectivityResult
+	var errs []error
+	probes := map[string]ConnectivityResult{}
+	for _, target := range a.connectivityTargets() {
+		result := ConnectivityResult{Step: target.step, Host: target.host}
+		if target.host != "" {
+			u, _ := url.Parse(target.host + "/")
+			key := target.host + "\x00" + a.clientSettings(target.req, u).key()
+			probe, ok := probes[key]
+			if !ok {
+				probe = a.probeHost(ctx, target.host, target.req, timeout)
+				probes[key] = probe
+			}
+			result.Status, result.Problem, result.Error, result.Duration = probe.Status, probe.Problem, probe.Error, probe.Duration
+		}
+		if authErr, ok := authErrors[target.auth]; ok && result.Error == nil {
+			result.Problem, result.Error = CONNECTIVITY_AUTH, authErr
+		}
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", target.step, result.Error))
+			a.logger.Warning("[Connectivity] %s %s: %s", target.step, target.host, result.Error.Error())
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
+
+type connectivityTarget struct {
+	step string
+	host string // scheme and host of the url, empty when templated
+	auth string // location of the authentication of the step, empty without
+	req  *RequestConfig
+}
+
+// connectivityTargets lists the steps sending HTTP requests, in step order.
+func (a *ApiCrawler) connectivityTargets() []connectivityTarget {
+	var targets []connectivityTarget
+	var walk func(steps []Step, location string)
+	walk = func(steps []Step, location string) {
+		for i, step := range steps {
+			stepLocation := fmt.Sprintf("%s[%d]", location, i)
+			if req := step.Request; req != nil {
+				target := connectivityTarget{step: stepLocation, host: literalHost(req.URL), req: req}
+				if req.Authentication != nil {
+					target.auth = stepLocation + ".request.auth"
+				} else if a.Config.Authentication != nil {
+					target.auth = "auth"
+				}
+				if target.host != "" || target.auth != "" {
+					targets = append(targets, target)
+				}
+			}
+			walk(step.Steps, stepLocation+".steps")
+		}
+	}
+	walk(a.Config.Steps, "steps")
+	return targets
+}
+
+// literalHost returns the scheme and host of an http url template, empty when the host is
+// templated, e.g. https://{{ .region }}.example.com.
+func literalHost(rawURL string) string {
+	literal := rawURL
+	if i := strings.Index(rawURL, "{{"); i >= 0 {
+		literal = rawURL[:i]
+	}
+	u, err := url.Parse(literal)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	host := u.Scheme + "://" + u.Host
+	if rest := literal[len(host):]; len(literal) < len(rawURL) && !strings.ContainsAny(rest, "/?#") {
+		return ""
+	}
+	return host
+}
+
+// probeHost sends HEAD to the root of host, then GET if the host does not allow HEAD.
+// The proxy, TLS and transport settings of the request of the step apply.
+func (a *ApiCrawler) probeHost(ctx context.Context, host string, reqConfig *RequestConfig, timeout time.Duration) ConnectivityResult {
+	result := ConnectivityResult{Host: host}
+	u, _ := url.Parse(host + "/")
+	client, err := a.clientFor(a.clientSettings(reqConfig, u))
+	if err != nil {
+		result.Problem, result.Error = CONNECTIVITY_CONNECT, err
+		if errors.As(err, new(*tlsSetupError)) {
+			result.Problem = CONNECTIVITY_TLS
+		}
+		return result
+	}
+	start := time.Now()
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		req, err := http.NewRequestWithContext(probeCtx, method, u.String(), nil)
+		if err != nil {
+			cancel()
+			result.Problem, result.Error = CONNECTIVITY_CONNECT, err
+			break
+		}
+		a.setUserAgent(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			result.Problem, result.Error = connectivityProblem(err), err
+			break
+		}
+		resp.Body.Close()
+		cancel()
+		result.Status = resp.StatusCode
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+// connectivityProblem classifies the error of a probe.
+func connectivityProblem(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return CONNECTIVITY_DNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return CONNECTIVITY_TLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return CONNECTIVITY_TIMEOUT
+	default:
+		return CONNECTIVITY_CONNECT
+	}
+}
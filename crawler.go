@@ -7,18 +7,26 @@ package apigorowler
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	texttemplate "text/template"
+	"time"
 
 	"github.com/itchyny/gojq"
-	"gopkg.in/yaml.v3"
 )
 
 type StepProfileType int
@@ -31,6 +39,8 @@ const (
 )
 
 type StepProfilerData struct {
+	ID         string
+	Timestamp  time.Time
 	Type       StepProfileType
 	Name       string
 	Config     Step
@@ -39,6 +49,7 @@ type StepProfilerData struct {
 	DataString string
 	Context    Context
 	Extra      map[string]any
+	RunID      string
 }
 
 type HTTPClient interface {
@@ -80,35 +91,165 @@ func (l *stdLogger) Error(msg string, args ...any) {
 
 const RES_KEY = "$res"
 
+// Reserved context keys holding the iteration metadata of forEach steps:
+// <as>_index (0 based) and <as>_total, is_first and is_last of the innermost forEach.
+const (
+	ITERATION_INDEX_SUFFIX = "_index"
+	ITERATION_TOTAL_SUFFIX = "_total"
+	ITERATION_FIRST_KEY    = "is_first"
+	ITERATION_LAST_KEY     = "is_last"
+)
+
+// Item shapes of the forEach values: wrapped ({"value": v}, the default) or raw (v).
+const (
+	ITEM_SHAPE_WRAPPED = "wrapped"
+	ITEM_SHAPE_RAW     = "raw"
+)
+
 type Config struct {
 	Steps          []Step               `yaml:"steps" json:"steps"`
 	RootContext    interface{}          `yaml:"rootContext" json:"rootContext"`
 	Authentication *AuthenticatorConfig `yaml:"auth,omitempty" json:"auth,omitempty"`
 	Headers        map[string]string    `yaml:"headers,omitempty" json:"headers,omitempty"`
 	Stream         bool                 `yaml:"stream,omitempty" json:"stream,omitempty"`
+	StreamKey      string               `yaml:"streamKey,omitempty" json:"streamKey,omitempty"` // array of an object rootContext streamed, the other keys accumulate
+	Encrypted      string               `yaml:"encrypted,omitempty" json:"-"`
+	Sinks          []SinkConfig         `yaml:"sinks,omitempty" json:"sinks,omitempty"`
+	// MaxRequestsPerRun and MaxBytesPerRun stop the run with ErrBudgetExceeded, 0 means unlimited
+	MaxRequestsPerRun int                `yaml:"maxRequestsPerRun,omitempty" json:"maxRequestsPerRun,omitempty"`
+	MaxBytesPerRun    int64              `yaml:"maxBytesPerRun,omitempty" json:"maxBytesPerRun,omitempty"`
+	SchemaDrift       *SchemaDriftConfig `yaml:"schemaDrift,omitempty" json:"schemaDrift,omitempty"`
+	// StrictTemplates makes templates fail on missing context keys instead of rendering "<no value>"
+	StrictTemplates bool `yaml:"strictTemplates,omitempty" json:"strictTemplates,omitempty"`
+	// Hosts maps host patterns (api.example.com, *.example.com) to politeness settings
+	Hosts      map[string]HostConfig `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	ServerTime *ServerTimeConfig     `yaml:"serverTime,omitempty" json:"serverTime,omitempty"`
+	// EntitySchema diverts the emitted entities not matching a JSON schema to a quarantine
+	EntitySchema *EntitySchemaConfig `yaml:"entitySchema,omitempty" json:"entitySchema,omitempty"`
+	// Dedup suppresses the emitted entities whose identity was already emitted with the same content
+	Dedup *DedupConfig `yaml:"dedup,omitempty" json:"dedup,omitempty"`
+	// Lock prevents two runs of the configuration from interleaving
+	Lock *LockConfig `yaml:"lock,omitempty" json:"lock,omitempty"`
+	// RunEvents posts the run report to webhooks when a run starts, succeeds or fails
+	RunEvents *RunEventsConfig `yaml:"runEvents,omitempty" json:"runEvents,omitempty"`
+	// Interceptors patch the requests of the steps and their responses, in order
+	Interceptors []InterceptorConfig `yaml:"interceptors,omitempty" json:"interceptors,omitempty"`
+	// URLSigners hold the bucket credentials of the presign helpers, by name
+	URLSigners map[string]URLSignerConfig `yaml:"urlSigners,omitempty" json:"urlSigners,omitempty"`
+	// PruneContexts releases the contexts the nested steps do not reference by name
+	PruneContexts bool `yaml:"pruneContexts,omitempty" json:"pruneContexts,omitempty"`
+	// UserAgent identifies the crawler, DefaultUserAgent when not set
+	UserAgent *UserAgentConfig `yaml:"userAgent,omitempty" json:"userAgent,omitempty"`
+	// Output controls the JSON formatting of the sinks and MarshalOutput
+	Output *OutputConfig `yaml:"output,omitempty" json:"output,omitempty"`
+	// StateStore keeps the state between runs, local files when not set (see SetStateStore)
+	StateStore *StateStoreConfig `yaml:"stateStore,omitempty" json:"stateStore,omitempty"`
+	// CorrelationHeader sends the run id with every request of the steps, e.g. X-Correlation-ID
+	CorrelationHeader string `yaml:"correlationHeader,omitempty" json:"correlationHeader,omitempty"`
+	// MemoryPressure throttles the run while its heap is above a limit
+	MemoryPressure *MemoryPressureConfig `yaml:"memoryPressure,omitempty" json:"memoryPressure,omitempty"`
+	// TLS sets the client certificate, CAs and server name of the requests, e.g. for mutual TLS
+	TLS *TLSConfig `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// Proxy routes the requests through a proxy, overridden by the hosts and request ones
+	Proxy *ProxyConfig `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// TimeoutSeconds limits every request, response body included, 0 means unlimited
+	TimeoutSeconds float64 `yaml:"timeoutSeconds,omitempty" json:"timeoutSeconds,omitempty"`
+	// Transport tunes the connection pool, keep-alives and connection timeouts
+	Transport *TransportConfig `yaml:"transport,omitempty" json:"transport,omitempty"`
 }
 
 type Step struct {
-	Type              string                `yaml:"type" json:"type"`
-	Name              string                `yaml:"name,omitempty" json:"name,omitempty"`
-	Path              string                `yaml:"path,omitempty" json:"path,omitempty"`
-	As                string                `yaml:"as,omitempty" json:"as,omitempty"`
-	Values            []interface{}         `yaml:"values,omitempty" json:"values,omitempty"`
-	Steps             []Step                `yaml:"steps,omitempty" json:"steps,omitempty"`
-	Request           *RequestConfig        `yaml:"request,omitempty" json:"request,omitempty"`
-	ResultTransformer string                `yaml:"resultTransformer,omitempty" json:"resultTransformer,omitempty"`
-	MergeWithParentOn string                `yaml:"mergeWithParentOn,omitempty" json:"mergeWithParentOn,omitempty"`
-	MergeOn           string                `yaml:"mergeOn,omitempty" json:"mergeOn,omitempty"`
-	MergeWithContext  *MergeWithContextRule `yaml:"mergeWithContext,omitempty" json:"mergeWithContext,omitempty"`
+	Type              string                  `yaml:"type" json:"type"`
+	Name              string                  `yaml:"name,omitempty" json:"name,omitempty"`
+	Description       string                  `yaml:"description,omitempty" json:"description,omitempty"` // what the step is for, shown by the tooling
+	Meta              map[string]any          `yaml:"meta,omitempty" json:"meta,omitempty"`               // free form annotations, e.g. owner or ticket
+	Path              string                  `yaml:"path,omitempty" json:"path,omitempty"`
+	As                string                  `yaml:"as,omitempty" json:"as,omitempty"`
+	Values            []interface{}           `yaml:"values,omitempty" json:"values,omitempty"`
+	ItemShape         string                  `yaml:"itemShape,omitempty" json:"itemShape,omitempty"` // wrapped (default) | raw, item context of the values
+	Steps             []Step                  `yaml:"steps,omitempty" json:"steps,omitempty"`
+	Request           *RequestConfig          `yaml:"request,omitempty" json:"request,omitempty"`
+	ResultTransformer string                  `yaml:"resultTransformer,omitempty" json:"resultTransformer,omitempty"`
+	Mapping           map[string]MappingField `yaml:"mapping,omitempty" json:"mapping,omitempty"` // applied after resultTransformer
+	MergeWithParentOn string                  `yaml:"mergeWithParentOn,omitempty" json:"mergeWithParentOn,omitempty"`
+	MergeOn           string                  `yaml:"mergeOn,omitempty" json:"mergeOn,omitempty"`
+	MergeWithContext  *MergeWithContextRule   `yaml:"mergeWithContext,omitempty" json:"mergeWithContext,omitempty"`
+	Download          *DownloadConfig         `yaml:"download,omitempty" json:"download,omitempty"`
+	Subscribe         *SubscribeConfig        `yaml:"subscribe,omitempty" json:"subscribe,omitempty"`
+	GRPC              *GRPCConfig             `yaml:"grpc,omitempty" json:"grpc,omitempty"`
+	Fetch             *FetchConfig            `yaml:"fetch,omitempty" json:"fetch,omitempty"`
+	Poll              *PollConfig             `yaml:"poll,omitempty" json:"poll,omitempty"`
+	Sitemap           *SitemapConfig          `yaml:"sitemap,omitempty" json:"sitemap,omitempty"`
+	Probe             *ProbeConfig            `yaml:"probe,omitempty" json:"probe,omitempty"`
+	Assertions        []AssertionConfig       `yaml:"assertions,omitempty" json:"assertions,omitempty"`
+	Transform         string                  `yaml:"transform,omitempty" json:"transform,omitempty"`                 // jq rule of a transform step
+	MaxRequestsPerRun int                     `yaml:"maxRequestsPerRun,omitempty" json:"maxRequestsPerRun,omitempty"` // requests of this step in a run
+	MaxBytesPerRun    int64                   `yaml:"maxBytesPerRun,omitempty" json:"maxBytesPerRun,omitempty"`       // response bytes of this step in a run
+
+	// Locals are named jq rules evaluated once against the current context before the step
+	// runs, available as $locals in the templates and jq rules of the step
+	Locals map[string]string `yaml:"locals,omitempty" json:"locals,omitempty"`
+
+	// When is a jq rule evaluated against the current context before the step runs: the step,
+	// nested steps included, is skipped when it yields false or null
+	When string `yaml:"when,omitempty" json:"when,omitempty"`
+
+	// DependsOn names sibling steps that must run before this one, otherwise steps run in
+	// the declared order
+	DependsOn []string `yaml:"dependsOn,omitempty" json:"dependsOn,omitempty"`
+
+	MaxConcurrency      int                        `yaml:"maxConcurrency,omitempty" json:"maxConcurrency,omitempty"` // forEach iterations running in parallel
+	AdaptiveConcurrency *AdaptiveConcurrencyConfig `yaml:"adaptiveConcurrency,omitempty" json:"adaptiveConcurrency,omitempty"`
+	OccupancySampleMs   int                        `yaml:"occupancySampleMs,omitempty" json:"occupancySampleMs,omitempty"` // profiler samples of the parallel iterations, default 1000
+	// DelayMs and JitterMs pace sequential forEach iterations: every iteration after the first
+	// waits DelayMs plus a random time up to JitterMs
+	DelayMs  int `yaml:"delayMs,omitempty" json:"delayMs,omitempty"`
+	JitterMs int `yaml:"jitterMs,omitempty" json:"jitterMs,omitempty"`
+	// OrderedMerges applies the merges of parallel iterations into shared contexts in item order
+	OrderedMerges bool `yaml:"orderedMerges,omitempty" json:"orderedMerges,omitempty"`
+	// StopOn ends a forEach step before all items are iterated, only timeBudget is supported
+	StopOn []StopCondition `yaml:"stopOn,omitempty" json:"stopOn,omitempty"`
+	// EmitPerItem sends every forEach result to the stream and the sinks as soon as it is
+	// complete, instead of keeping it in the context until the end of the run
+	EmitPerItem bool `yaml:"emitPerItem,omitempty" json:"emitPerItem,omitempty"`
+	// CollectInto appends the step results to a named output collection (see GetCollections)
+	// instead of merging them into the context
+	CollectInto string `yaml:"collectInto,omitempty" json:"collectInto,omitempty"`
 }
 
 type RequestConfig struct {
-	URL            string               `yaml:"url" json:"url"`
-	Method         string               `yaml:"method" json:"method"`
-	Headers        map[string]string    `yaml:"headers,omitempty" json:"headers,omitempty"`
-	Body           string               `yaml:"body,omitempty" json:"body,omitempty"`
-	Pagination     Pagination           `yaml:"pagination,omitempty" json:"pagination,omitempty"`
-	Authentication *AuthenticatorConfig `yaml:"auth,omitempty" json:"auth,omitempty"`
+	URL             string                 `yaml:"url" json:"url"`
+	Method          string                 `yaml:"method" json:"method"`
+	Headers         map[string]HeaderValue `yaml:"headers,omitempty" json:"headers,omitempty"`     // a list of values repeats the header
+	UserAgent       string                 `yaml:"userAgent,omitempty" json:"userAgent,omitempty"` // go-template, overrides the configuration one
+	Body            string                 `yaml:"body,omitempty" json:"body,omitempty"`
+	BodyBase64      string                 `yaml:"bodyBase64,omitempty" json:"bodyBase64,omitempty"`           // go-template, binary body sent decoded
+	BodyFile        string                 `yaml:"bodyFile,omitempty" json:"bodyFile,omitempty"`               // go-template, path of a file sent as body
+	GraphQL         *GraphQLConfig         `yaml:"graphql,omitempty" json:"graphql,omitempty"`                 // body built as a GraphQL payload
+	ResponseFrom    string                 `yaml:"responseFrom,omitempty" json:"responseFrom,omitempty"`       // body | headers
+	ResponseFormat  string                 `yaml:"responseFormat,omitempty" json:"responseFormat,omitempty"`   // json | text | csv | html
+	CSV             *CSVConfig             `yaml:"csv,omitempty" json:"csv,omitempty"`                         // responseFormat csv options
+	HTML            *HTMLConfig            `yaml:"html,omitempty" json:"html,omitempty"`                       // responseFormat html selectors
+	ResponseCharset string                 `yaml:"responseCharset,omitempty" json:"responseCharset,omitempty"` // overrides the Content-Type charset
+	EmptyBody       string                 `yaml:"emptyBody,omitempty" json:"emptyBody,omitempty"`             // null (default) | error, json responses without a body
+	TolerantJSON    bool                   `yaml:"tolerantJson,omitempty" json:"tolerantJson,omitempty"`       // accept comments, trailing commas, NaN and Infinity
+	PreciseNumbers  bool                   `yaml:"preciseNumbers,omitempty" json:"preciseNumbers,omitempty"`   // decode numbers without the float64 precision loss
+	ErrorWhen       string                 `yaml:"errorWhen,omitempty" json:"errorWhen,omitempty"`             // jq predicate failing the step on a response reporting an error
+	ErrorMessage    string                 `yaml:"errorMessage,omitempty" json:"errorMessage,omitempty"`       // jq expression of the message of errorWhen
+	Pagination      Pagination             `yaml:"pagination,omitempty" json:"pagination,omitempty"`
+	Authentication  *AuthenticatorConfig   `yaml:"auth,omitempty" json:"auth,omitempty"`
+	OpenAPI         *OpenAPIConfig         `yaml:"openapi,omitempty" json:"openapi,omitempty"`     // validate responses against the declared schema
+	AsyncPoll       *AsyncPollConfig       `yaml:"asyncPoll,omitempty" json:"asyncPoll,omitempty"` // poll the Location of 202 responses
+	IdempotencyKey  *IdempotencyKeyConfig  `yaml:"idempotencyKey,omitempty" json:"idempotencyKey,omitempty"`
+	TLS             *TLSConfig             `yaml:"tls,omitempty" json:"tls,omitempty"`                       // overrides the fields set in the configuration tls
+	Proxy           *ProxyConfig           `yaml:"proxy,omitempty" json:"proxy,omitempty"`                   // overrides the hosts and configuration proxies
+	TimeoutSeconds  float64                `yaml:"timeoutSeconds,omitempty" json:"timeoutSeconds,omitempty"` // overrides the configuration timeoutSeconds
+	Transport       *TransportConfig       `yaml:"transport,omitempty" json:"transport,omitempty"`           // overrides the fields set in the configuration transport
+	Query           map[string]string      `yaml:"query,omitempty" json:"query,omitempty"`                   // go-templates, JSON arrays are encoded following ArrayFormat
+	ArrayFormat     string                 `yaml:"arrayFormat,omitempty" json:"arrayFormat,omitempty"`       // repeat (default) | comma | brackets
+	// Languages repeats every request per language, the result is keyed by language
+	Languages     []string `yaml:"languages,omitempty" json:"languages,omitempty"`
+	LanguageParam string   `yaml:"languageParam,omitempty" json:"languageParam,omitempty"` // query parameter of the language, default the Accept-Language header
 }
 
 type MergeWithContextRule struct {
@@ -125,9 +266,18 @@ type Context struct {
 
 type stepExecution struct {
 	step              Step
+	path              string // location in the config, e.g. steps[0].steps[1]
 	currentContextKey string
 	currentContext    *Context
 	contextMap        map[string]*Context
+	parent            *stepExecution
+	stats             *StepStats
+	start             time.Time
+	limiter           *concurrencyLimiter // parallel forEach steps
+	merges            *mergeSequencer     // parallel forEach steps with orderedMerges
+	item              int                 // index of the iteration of the parent forEach step
+	locals            map[string]any      // $locals, see evalLocals
+	worker            *workerSlot         // parallel forEach iterations, see workerSlot
 }
 
 type ApiCrawler struct {
@@ -140,7 +290,52 @@ type ApiCrawler struct {
 	profiler            chan StepProfilerData
 	enableProfilation   bool
 	templateCache       map[string]*template.Template
+	textTemplateCache   map[string]*texttemplate.Template
 	jqCache             map[string]*gojq.Code
+	openAPICache        map[string]*openAPIDoc
+	artifactStore       ArtifactStore
+	configName          string
+	runID               string
+	sinks               []OutputSink
+	extraSinks          []OutputSink
+	grpcInvoker         GRPCInvoker
+	fileFetchers        map[string]FileFetcher
+	opcuaReader         OPCUAReader
+	schemas             *schemaTracker
+	schemaDrift         []SchemaDrift
+	budget              *runBudget
+	failures            *runFailures
+	statsMu             sync.Mutex
+	cacheMu             sync.Mutex   // template and jq caches
+	mergeMu             sync.RWMutex // merges into contexts shared by parallel forEach iterations
+	contextRefCache     sync.Map     // context names referenced by the nested steps, by step location
+	clock               Clock
+	idGenerator         IDGenerator
+	memory              *memoryMonitor // see MemoryPressureConfig
+	secrets             SecretsProvider
+	heapInUse           func() uint64
+	contextDumpStep     string
+	contextDumpPath     string
+	contextDumped       atomic.Bool
+	hostPolicies        []*hostPolicy
+	proxyMu             sync.Mutex
+	proxyClients        map[string]HTTPClient
+	emitMu              sync.Mutex  // sinks and stream receive one entity at a time
+	itemsEmitted        atomic.Bool // forEach results were emitted per item
+	collections         *outputCollections
+	serverClock         *serverClock   // set when serverTime.sync is enabled
+	params              map[string]any // $params of the current run
+	deviceTokens        *deviceTokenStore
+	refreshTokens       *refreshTokenSession
+	credentials         *credentialStore
+	quarantine          *entityQuarantine // set when entitySchema is configured
+	quarantineStream    chan QuarantinedEntity
+	stateStore          StateStore
+	dedup               *entityDedup // set when dedup is configured
+	runLock             RunLock
+	runStart            time.Time
+	runHooks            RunHooks
+	complete            bool
 }
 
 func NewApiCrawler(configPath string) (*ApiCrawler, []ValidationError, error) {
@@ -149,25 +344,50 @@ func NewApiCrawler(configPath string) (*ApiCrawler, []ValidationError, error) {
 		return nil, nil, err
 	}
 
-	var cfg Config
-	err = yaml.Unmarshal(data, &cfg)
+	cfg, err := ParseConfig(data)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &ConfigError{Err: err}
 	}
 
 	errors := ValidateConfig(cfg)
 	if len(errors) != 0 {
-		return nil, errors, fmt.Errorf("validation failed")
+		return nil, errors, &ConfigError{Errors: errors}
 	}
 
 	c := &ApiCrawler{
-		httpClient:    http.DefaultClient,
-		Config:        cfg,
-		ContextMap:    map[string]*Context{},
-		logger:        NewDefaultLogger(),
-		profiler:      nil,
-		templateCache: make(map[string]*template.Template),
-		jqCache:       make(map[string]*gojq.Code),
+		httpClient:        http.DefaultClient,
+		Config:            cfg,
+		ContextMap:        map[string]*Context{},
+		profiler:          nil,
+		templateCache:     make(map[string]*template.Template),
+		textTemplateCache: make(map[string]*texttemplate.Template),
+		jqCache:           make(map[string]*gojq.Code),
+		openAPICache:      make(map[string]*openAPIDoc),
+		artifactStore:     FileArtifactStore{},
+		stateStore:        FileStateStore{},
+		fileFetchers:      map[string]FileFetcher{"ftp": FTPFetcher{}},
+		budget:            newRunBudget(),
+		failures:          newRunFailures(),
+		collections:       newOutputCollections(),
+		clock:             systemClock{},
+		idGenerator:       randomIDGenerator{},
+		secrets:           EnvSecrets{},
+		heapInUse:         heapObjectsBytes,
+		hostPolicies:      newHostPolicies(cfg.Hosts),
+		proxyClients:      map[string]HTTPClient{},
+		deviceTokens:      newDeviceTokenStore(),
+		credentials:       newCredentialStore(),
+		configName:        strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath)),
+	}
+	c.refreshTokens = newRefreshTokenSession(func() StateStore { return c.stateStore })
+	c.SetLogger(NewDefaultLogger())
+
+	if cfg.StateStore != nil {
+		store, err := newStateStore(*cfg.StateStore)
+		if err != nil {
+			return nil, nil, &ConfigError{Err: err}
+		}
+		c.stateStore = store
 	}
 
 	// handle stream channel
@@ -177,7 +397,7 @@ func NewApiCrawler(configPath string) (*ApiCrawler, []ValidationError, error) {
 
 	// instantiate global authenticator
 	if cfg.Authentication != nil {
-		c.globalAuthenticator = NewAuthenticator(*cfg.Authentication)
+		c.globalAuthenticator = c.newAuthenticator(*cfg.Authentication)
 	} else {
 		c.globalAuthenticator = NoopAuthenticator{}
 	}
@@ -193,11 +413,14 @@ func (a *ApiCrawler) GetData() interface{} {
 }
 
 func (a *ApiCrawler) SetLogger(logger Logger) {
-	a.logger = logger
+	a.logger = &runLogger{Logger: logger, crawler: a}
 }
 
 func (a *ApiCrawler) SetClient(client HTTPClient) {
+	a.proxyMu.Lock()
+	defer a.proxyMu.Unlock()
 	a.httpClient = client
+	a.proxyClients = map[string]HTTPClient{}
 }
 
 func (a *ApiCrawler) EnableProfiler() chan StepProfilerData {
@@ -209,11 +432,13 @@ func (a *ApiCrawler) EnableProfiler() chan StepProfilerData {
 // getOrCompileTemplate retrieves a pre-compiled template from the cache,
 // or compiles, caches, and returns it if not found.
 func (a *ApiCrawler) getOrCompileTemplate(tmplString string) (*template.Template, error) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
 	if tmpl, ok := a.templateCache[tmplString]; ok {
 		return tmpl, nil
 	}
 
-	tmpl, err := template.New("dynamic").Parse(tmplString)
+	tmpl, err := template.New("dynamic").Option(a.Config.templateMissingKey()).Funcs(templateFuncs).Funcs(template.FuncMap{"params": a.runParams, "presign": a.presignURL}).Parse(templatePrefix + tmplString)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing template: %w", err)
 	}
@@ -222,9 +447,29 @@ func (a *ApiCrawler) getOrCompileTemplate(tmplString string) (*template.Template
 	return tmpl, nil
 }
 
+// getOrCompileTextTemplate is the text/template counterpart of getOrCompileTemplate,
+// used for request bodies where HTML escaping would corrupt the payload.
+func (a *ApiCrawler) getOrCompileTextTemplate(tmplString string) (*texttemplate.Template, error) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	if tmpl, ok := a.textTemplateCache[tmplString]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := texttemplate.New("dynamic").Option(a.Config.templateMissingKey()).Funcs(templateFuncs).Funcs(texttemplate.FuncMap{"params": a.runParams, "presign": a.presignURL}).Parse(templatePrefix + tmplString)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %w", err)
+	}
+
+	a.textTemplateCache[tmplString] = tmpl
+	return tmpl, nil
+}
+
 // getOrCompileJQRule retrieves a pre-compiled JQ rule from the cache,
 // or compiles, caches, and returns it if not found.
 func (a *ApiCrawler) getOrCompileJQRule(ruleString string, variables ...string) (*gojq.Code, error) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
 	cacheKey := ruleString
 	if len(variables) > 0 {
 		// Use a unique key for rules with variables
@@ -241,7 +486,11 @@ func (a *ApiCrawler) getOrCompileJQRule(ruleString string, variables ...string)
 		return nil, fmt.Errorf("invalid jq rule '%s': %w", ruleString, err)
 	}
 
-	code, err := gojq.Compile(query, gojq.WithVariables(variables))
+	// $now, $params, $stats and $locals are bound in every rule, see runJQ
+	options := append([]gojq.CompilerOption{gojq.WithVariables(append(variables, "$now", "$params", "$stats", "$locals"))}, jqTimeFunctions...)
+	options = append(options, jqDomainFunctions...)
+	options = append(options, a.jqPresign())
+	code, err := gojq.Compile(query, options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to compile jq rule: %w", err)
 	}
@@ -250,6 +499,18 @@ func (a *ApiCrawler) getOrCompileJQRule(ruleString string, variables ...string)
 	return code, nil
 }
 
+// runJQ runs a rule compiled with getOrCompileJQRule, values are bound to its variables in order.
+// exec is the step running the rule, nil outside steps.
+func (c *ApiCrawler) runJQ(exec *stepExecution, code *gojq.Code, input any, values ...any) gojq.Iter {
+	return code.Run(input, append(values, jqNow(), c.params, c.runStats(exec), stepLocals(exec))...)
+}
+
+func init() {
+	// interface values of the steps copied by deepCopy, e.g. forEach values and step meta
+	gob.Register([]any{})
+	gob.Register(map[string]any{})
+}
+
 func deepCopy[T any](src T) (T, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -292,8 +553,14 @@ func (a *ApiCrawler) pushProfilerData(dataType StepProfileType, name string, exe
 		}
 		extraMap[key] = extra[i+1]
 	}
+	if worker := exec.workerSlot(); worker != nil {
+		extraMap["worker"] = worker.id
+		extraMap["workerPool"] = worker.pool
+	}
 
 	d := StepProfilerData{
+		ID:         a.idGenerator.NewID(),
+		Timestamp:  a.clock.Now(),
 		Type:       dataType,
 		Name:       name,
 		Context:    context,
@@ -301,21 +568,60 @@ func (a *ApiCrawler) pushProfilerData(dataType StepProfileType, name string, exe
 		DataBefore: dataBefore,
 		Config:     cleanConfig,
 		Extra:      extraMap,
+		RunID:      a.runID,
 	}
 
 	a.profiler <- d
 }
 
-func newStepExecution(step Step, currentContextKey string, contextMap map[string]*Context) *stepExecution {
+// pushProfilerDiff pushes an event changing dataBefore into data, with the changes in Extra["diff"].
+func (a *ApiCrawler) pushProfilerDiff(name string, exec *stepExecution, data any, dataBefore any, extra ...any) {
+	if a.profiler == nil {
+		return
+	}
+	a.pushProfilerData(STEP_PROFILER_TYPE_NONE, name, exec, data, dataBefore, append(slices.Clip(extra), "diff", DiffJSON(dataBefore, data))...)
+}
+
+func newStepExecution(step Step, path string, currentContextKey string, contextMap map[string]*Context) *stepExecution {
 	return &stepExecution{
 		step:              step,
+		path:              path,
 		currentContextKey: currentContextKey,
 		contextMap:        contextMap,
 		currentContext:    contextMap[currentContextKey],
+		stats:             &StepStats{},
 	}
 }
 
 func (c *ApiCrawler) Run(ctx context.Context) error {
+	return c.runLocked(ctx, map[string]any{})
+}
+
+// runLocked runs the crawler holding the run lock, see RunWithParams for params.
+func (c *ApiCrawler) runLocked(ctx context.Context, params map[string]any) error {
+	unlock, err := c.acquireRunLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			c.logger.Warning("[Lock] error releasing the run lock: %s", err.Error())
+		}
+	}()
+
+	if errs := c.validateTransports(); len(errs) > 0 {
+		return &ConfigError{Errors: errs}
+	}
+	c.params = params
+	err = c.run(ctx)
+	if err != nil {
+		c.abortSinks(ctx)
+	}
+	c.notifyRunEnd(ctx, err)
+	return err
+}
+
+func (c *ApiCrawler) run(ctx context.Context) error {
 	rootCtx := &Context{
 		Data:          c.Config.RootContext,
 		ParentContext: "",
@@ -326,57 +632,249 @@ func (c *ApiCrawler) Run(ctx context.Context) error {
 	c.ContextMap["root"] = rootCtx
 	currentContext := "root"
 
-	for _, step := range c.Config.Steps {
-		ecxec := newStepExecution(step, currentContext, c.ContextMap)
+	c.runID = c.idGenerator.NewID()
+	c.budget = newRunBudget()
+	c.failures = newRunFailures()
+	c.complete = false
+	c.contextDumped.Store(false)
+	c.itemsEmitted.Store(false)
+	c.collections = newOutputCollections()
+	// the start report must not carry the counts of the previous run
+	c.schemaDrift = nil
+	c.quarantine = nil
+	c.dedup = nil
+	c.serverClock = nil
+	if c.Config.ServerTime != nil && c.Config.ServerTime.Sync {
+		c.serverClock = &serverClock{}
+		if c.Config.ServerTime.URL != "" {
+			c.syncServerTime(ctx)
+		}
+	}
+	c.runStart = c.clock.Now()
+	c.notifyRunStart(ctx)
+	runInfo := sinkRunInfo{ConfigName: c.configName, RunID: c.runID, Start: c.runStart.UTC()}
+	c.sinks = append([]OutputSink{}, c.extraSinks...)
+	for _, sinkCfg := range c.Config.Sinks {
+		sinkCfg, err := c.resolveSinkSecrets(sinkCfg)
+		if err != nil {
+			c.recordFailure(nil, err)
+			return err
+		}
+		sink, err := newSink(sinkCfg, runInfo, c.httpClient, c.serverNow, c.outputConfig())
+		if err != nil {
+			c.recordFailure(nil, err)
+			return err
+		}
+		c.sinks = append(c.sinks, sink)
+	}
+	if c.Config.SchemaDrift != nil {
+		c.schemas = newSchemaTracker()
+	}
+	if c.Config.EntitySchema != nil {
+		quarantine, err := c.openQuarantine(ctx)
+		if err != nil {
+			c.recordFailure(nil, err)
+			return err
+		}
+		c.quarantine = quarantine
+		defer c.closeQuarantine()
+	}
+	if c.Config.Dedup != nil {
+		dedup, err := c.loadDedup(ctx)
+		if err != nil {
+			c.recordFailure(nil, err)
+			return err
+		}
+		c.dedup = dedup
+	}
+	stopMemoryMonitor := c.startMemoryMonitor()
+	defer stopMemoryMonitor()
+
+	for _, i := range stepOrder(c.Config.Steps) {
+		step := c.Config.Steps[i]
+		ecxec := newStepExecution(step, fmt.Sprintf("steps[%d]", i), currentContext, c.ContextMap)
 		if err := c.ExecuteStep(ctx, ecxec); err != nil {
 			return err
 		}
 	}
 
+	if err := c.checkSchemaDrift(); err != nil {
+		c.recordFailure(nil, err)
+		return err
+	}
+
+	if err := c.finishSinks(ctx); err != nil {
+		c.recordFailure(nil, err)
+		return err
+	}
+
+	if err := c.saveDedup(ctx); err != nil {
+		c.recordFailure(nil, err)
+		return err
+	}
+
+	c.complete = true
 	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Result", nil, c.GetData(), c.Config.RootContext)
 	return nil
 }
 
+// ExecuteStep runs a step, recording it in the run failures (and dumping its context
+// when configured) when it fails.
 func (c *ApiCrawler) ExecuteStep(ctx context.Context, exec *stepExecution) error {
+	exec.start = c.clock.Now()
+	c.dumpContext(exec, nil)
+	err := c.executeStep(ctx, exec)
+	if err != nil && c.recordFailure(exec, err) {
+		c.dumpContext(exec, err)
+	}
+	return err
+}
+
+func (c *ApiCrawler) executeStep(ctx context.Context, exec *stepExecution) error {
+	if err := c.evalLocals(exec); err != nil {
+		return err
+	}
+	if run, err := c.evalWhen(exec); err != nil || !run {
+		return err
+	}
 	switch exec.step.Type {
 	case "request":
 		return c.handleRequest(ctx, exec)
 	case "forEach":
 		return c.handleForEach(ctx, exec)
+	case "split":
+		return c.handleSplit(ctx, exec)
+	case "download":
+		return c.handleDownload(ctx, exec)
+	case "subscribe":
+		return c.handleSubscribe(ctx, exec)
+	case "grpc":
+		return c.handleGRPC(ctx, exec)
+	case "fetch":
+		return c.handleFetch(ctx, exec)
+	case "poll":
+		return c.handlePoll(ctx, exec)
+	case "sitemap":
+		return c.handleSitemap(ctx, exec)
+	case "probe":
+		return c.handleProbe(ctx, exec)
+	case "assert":
+		return c.handleAssert(ctx, exec)
+	case "transform":
+		return c.handleTransform(ctx, exec)
 	default:
 		return fmt.Errorf("unknown step type: %s", exec.step.Type)
 	}
 }
 
+// pageSender sends the request of a page of a request step, page 0 being the first one.
+type pageSender func(ctx context.Context, page int, next *RequestParts) (*http.Request, *url.URL, *http.Response, error)
+
 func (c *ApiCrawler) handleRequest(ctx context.Context, exec *stepExecution) error {
 	c.logger.Info("[Request] Preparing %s", exec.step.Name)
 
 	// 1. Expand URL using Go template
-	tmpl, err := c.getOrCompileTemplate(exec.step.Request.URL)
+	templateCtx := c.templateContext(exec)
+	_url, err := c.renderURL(exec.step.Request.URL, c.templateData(exec, templateCtx))
 	if err != nil {
-		return fmt.Errorf("error getting/compiling URL template: %w", err)
-	}
-
-	var urlBuf bytes.Buffer
-	templateCtx := contextMapToTemplate(exec.contextMap)
-	if err := tmpl.Execute(&urlBuf, templateCtx); err != nil {
-		return fmt.Errorf("error executing URL template: %w", err)
+		return err
 	}
-	_url := urlBuf.String()
 
 	// instantiate authenticator
-	authenticator := c.globalAuthenticator
-	if exec.step.Request.Authentication != nil {
-		authenticator = NewAuthenticator(*exec.step.Request.Authentication)
-	}
+	authenticator := c.requestAuthenticator(exec.step.Request)
 
 	// instantiate paginator
-	paginator, err := NewPaginator(ConfigP{exec.step.Request.Pagination})
+	paginator, err := newPaginator(ConfigP{exec.step.Request.pagination()}, c.serverNow)
 	if err != nil {
-		return fmt.Errorf("error creating request paginator: %w", err)
+		return &PaginationError{Step: exec.path, Err: err}
 	}
+	paginator.setClock(c.clock.Now)
+	paginator.setDecoder(exec.step.Request.decodeJSON)
 	stop := false
 	next := paginator.NextFromCtx()
+	var sessionCookies []*http.Cookie
+
+	send := func(ctx context.Context, page int, next *RequestParts) (*http.Request, *url.URL, *http.Response, error) {
+		var err error
+		// $stats counts the pages fetched so far
+		pageData := c.templateData(exec, templateCtx)
+		pageReq := exec.step.Request.pageRequest(page)
+
+		var urlObj *url.URL
+		if len(next.NextPageUrl) == 0 {
+			pageURL := _url
+			if pageReq.URL != exec.step.Request.URL {
+				if pageURL, err = c.renderURL(pageReq.URL, pageData); err != nil {
+					return nil, nil, nil, err
+				}
+			}
+			urlObj, err = url.Parse(pageURL)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid URL %s: %w", pageURL, err)
+			}
+		} else {
+			urlObj, err = url.Parse(next.NextPageUrl)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid next.NextPageUrl URL %s: %w", next.NextPageUrl, err)
+			}
+		}
+
+		// 1. Inject query params
+		if err := c.applyQuery(urlObj, pageReq, pageData, next); err != nil {
+			return nil, nil, nil, err
+		}
+
+		// 2. Encode body if needed
+		reqBody, err := c.buildRequestBody(pageReq, pageData, next)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		// 2. Create and send HTTP request
+		req, err := http.NewRequestWithContext(ctx, strings.ToUpper(pageReq.Method), urlObj.String(), reqBody)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error creating HTTP request: %w", err)
+		}
+		if err := c.applyHeaders(req, exec.step.Request, pageData, next.Headers); err != nil {
+			return nil, nil, nil, err
+		}
+		if exec.step.Request.IdempotencyKey != nil {
+			if err := c.setIdempotencyKey(exec, req, pageData, page); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if langs := exec.step.Request.Languages; len(langs) > 0 {
+			exec.step.Request.setLanguage(req, langs[0])
+			urlObj = req.URL
+		}
+		for _, cookie := range sessionCookies {
+			req.AddCookie(cookie)
+		}
+
+		// apply authentication
+		if err := c.authenticate(exec, authenticator, req); err != nil {
+			return nil, nil, nil, err
+		}
+
+		c.logger.Info("[Request] %s", urlObj.String())
+
+		resp, err := c.doRequest(exec, req)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if exec.step.Request.AsyncPoll != nil && resp.StatusCode == http.StatusAccepted {
+			if resp, err = c.pollAccepted(ctx, exec, authenticator, pageData, req, resp); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		return req, urlObj, resp, nil
+	}
+	// pages of a total count requested in parallel, see TotalCount
+	var prefetched []prefetchedPage
+	// next page of a pipelined pagination, requested while the current one is processed
+	var ahead <-chan prefetchedPage
+	aheadCtx, cancelAhead := context.WithCancel(ctx)
+	defer cancelAhead()
 
 	for !stop {
 		// context cancelation handling
@@ -384,224 +882,648 @@ func (c *ApiCrawler) handleRequest(ctx context.Context, exec *stepExecution) err
 		case <-ctx.Done():
 			return ctx.Err() // Context cancelled
 		default:
+			var req *http.Request
 			var urlObj *url.URL
-			if len(next.NextPageUrl) == 0 {
-				urlObj, err = url.Parse(_url)
-				if err != nil {
-					return fmt.Errorf("invalid URL %s: %w", _url, err)
-				}
+			var resp *http.Response
+			if len(prefetched) > 0 {
+				page := prefetched[0]
+				prefetched = prefetched[1:]
+				req, urlObj, resp, err = page.req, page.url, page.resp, page.err
+			} else if ahead != nil {
+				page := <-ahead
+				ahead = nil
+				req, urlObj, resp, err = page.req, page.url, page.resp, page.err
 			} else {
-				urlObj, err = url.Parse(next.NextPageUrl)
-				if err != nil {
-					return fmt.Errorf("invalid next.NextPageUrl URL %s: %w", next.NextPageUrl, err)
-				}
+				req, urlObj, resp, err = send(ctx, paginator.PageNum(), next)
 			}
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			paginationHeaders := next.Headers
 
-			// 1. Inject query params
-			query := urlObj.Query()
-			for k, v := range next.QueryParams {
-				query.Set(k, v)
+			if resp.StatusCode >= 400 {
+				return &HTTPError{Step: exec.path, URL: urlObj.String(), Status: resp.StatusCode}
+			}
+			c.updateStats(exec, func(s *StepStats) { s.Pages++ })
+			if exec.step.Request.Pagination.SessionAffinity {
+				sessionCookies = mergeCookies(sessionCookies, resp.Cookies())
 			}
-			urlObj.RawQuery = query.Encode()
 
-			// 2. Encode body if needed
-			var reqBody io.Reader
-			if len(next.BodyParams) > 0 {
-				bodyJSON, err := json.Marshal(next.BodyParams)
+			// run next
+			next, stop, err = paginator.Next(resp)
+			if err != nil {
+				return &PaginationError{Step: exec.path, Page: paginator.PageNum(), Err: err}
+			}
+			if paginator.TimeBudgetReached() {
+				c.logger.Info("[Request] %s time budget reached after page %d", exec.path, paginator.PageNum())
+			}
+			if total := exec.step.Request.Pagination.Total; total != nil && total.Concurrency > 1 && paginator.PageNum() == 1 && !stop {
+				parts, err := paginator.remainingPages()
 				if err != nil {
-					return fmt.Errorf("error encoding body params: %w", err)
+					return &PaginationError{Step: exec.path, Page: paginator.PageNum(), Err: err}
 				}
-				reqBody = bytes.NewReader(bodyJSON)
+				c.logger.Info("[Request] %s fetching the %d remaining pages, %d at a time", exec.path, len(parts), total.Concurrency)
+				prefetched = c.prefetchPages(ctx, exec, send, parts, paginator.PageNum())
+			}
+			if exec.step.Request.Pagination.Pipeline && !stop {
+				ahead = fetchAhead(aheadCtx, send, paginator.PageNum(), next)
 			}
 
-			// 2. Create and send HTTP request
-			req, err := http.NewRequest(exec.step.Request.Method, urlObj.String(), reqBody)
-			if err != nil {
-				return fmt.Errorf("error creating HTTP request: %w", err)
+			// 3. Decode response into interface{}
+			var raw interface{}
+			if exec.step.Request.responseFromHeaders() {
+				raw = headersToMap(resp.Header)
+			} else if raw, err = c.decodeResponseBody(exec.step.Request, resp.Header, resp.Body); err != nil {
+				return err
+			} else if exec.step.Request.OpenAPI != nil {
+				if err := c.checkOpenAPI(ctx, exec, req.Method, urlObj, resp.StatusCode, raw); err != nil {
+					return err
+				}
 			}
-			// Apply headers from both config and paginator
-			// priority is (ascending order)
-			// 1. Global
-			// 2. Request
-			// 3. Pagination
-			for k, v := range c.Config.Headers {
-				req.Header.Set(k, v)
+			if exec.step.Request.GraphQL != nil {
+				if err := c.checkGraphQLErrors(exec, urlObj.String(), raw); err != nil {
+					return err
+				}
 			}
-			for k, v := range exec.step.Request.Headers {
-				req.Header.Set(k, v)
+			if err := c.checkResponseError(exec, urlObj.String(), resp, raw); err != nil {
+				return err
 			}
-			for k, v := range next.Headers {
-				req.Header.Set(k, v)
+			if len(exec.step.Request.Languages) > 0 {
+				if raw, err = c.fetchLanguages(ctx, exec, authenticator, req, raw); err != nil {
+					return err
+				}
 			}
 
-			// apply authentication
-			authenticator.PrepareRequest(req)
+			// status and headers are exposed to transformer and merge rules as $response
+			responseInfo := responseToJQ(resp)
 
-			c.logger.Info("[Request] %s", urlObj.String())
+			profileStepName := fmt.Sprintf("Request '%s' | page#%d", exec.step.Name, paginator.PageNum())
+			profileExtra := []any{"url", urlObj.String()}
+			if len(paginationHeaders) > 0 {
+				profileExtra = append(profileExtra, "paginationHeaders", paginationHeaders)
+			}
+			if c.profiler != nil {
+				profileExtra = append(profileExtra,
+					"requestHeaders", c.profiledHeaders(req.Header, exec.step.Request),
+					"responseHeaders", c.profiledHeaders(resp.Header, exec.step.Request))
+			}
+			c.pushProfilerData(STEP_PROFILER_TYPE_START, profileStepName, exec, raw, nil, profileExtra...)
 
-			resp, err := c.httpClient.Do(req)
+			// 4. Apply JQ transformer
+			c.logger.Debug("[Request] Got response: status %s", resp.Status)
+			transformed, err := c.transformResult(exec, raw, templateCtx, responseInfo)
 			if err != nil {
-				return fmt.Errorf("error performing HTTP request: %w", err)
+				return err
 			}
-			defer resp.Body.Close()
 
-			// run next
-			next, stop, err = paginator.Next(resp)
-			if err != nil {
-				return fmt.Errorf("paginator update error: %w", err)
+			c.pushProfilerDiff("Response Transformation", exec, transformed, raw, "url", urlObj.String())
+
+			if err := c.mergeStepResult(ctx, exec, transformed, responseInfo, "url", urlObj.String()); err != nil {
+				return err
 			}
+		}
+	}
 
-			// 3. Decode JSON response into interface{}
-			var raw interface{}
-			if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
-				return fmt.Errorf("error decoding JSON: %w", err)
+	return nil
+}
+
+// transformResult applies the step resultTransformer, if any, to a raw step result.
+func (c *ApiCrawler) transformResult(exec *stepExecution, raw any, templateCtx map[string]any, responseInfo map[string]any) (any, error) {
+	transformed := raw
+
+	if exec.step.ResultTransformer != "" {
+		c.logger.Debug("[Request] transforming with expression: %s", exec.step.ResultTransformer)
+
+		// Create the evaluation context with $res variable bound
+		location := exec.path + ".resultTransformer"
+		code, err := c.getOrCompileJQRule(exec.step.ResultTransformer, "$ctx", "$response")
+		if err != nil {
+			return nil, &TransformError{Location: location, Rule: exec.step.ResultTransformer, Err: err}
+		}
+
+		iter := c.runJQ(exec, code, raw, templateCtx, responseInfo)
+		var singleResult interface{}
+		count := 0
+
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, isErr := v.(error); isErr {
+				return nil, &TransformError{Location: location, Rule: exec.step.ResultTransformer, Err: fmt.Errorf("jq error: %w", err)}
 			}
 
-			profileStepName := fmt.Sprintf("Request '%s' | page#%d", exec.step.Name, paginator.PageNum())
-			c.pushProfilerData(STEP_PROFILER_TYPE_START, profileStepName, exec, raw, nil, "url", urlObj.String())
+			count++
+			if count > 1 {
+				return nil, &TransformError{Location: location, Rule: exec.step.ResultTransformer, Err: fmt.Errorf("resultTransformer yielded more than one value")}
+			}
 
-			// 4. Apply JQ transformer
-			transformed := raw
-			c.logger.Debug("[Request] Got response: status %s", resp.Status)
+			singleResult = v
+		}
+		transformed = singleResult
+	}
 
-			if exec.step.ResultTransformer != "" {
-				c.logger.Debug("[Request] transforming with expression: %s", exec.step.ResultTransformer)
+	if len(exec.step.Mapping) > 0 {
+		location := exec.path + ".mapping"
+		rule, err := mappingToJQ(exec.step.Mapping)
+		if err != nil {
+			return nil, &TransformError{Location: location, Err: err}
+		}
+		code, err := c.getOrCompileJQRule(rule, "$ctx", "$response")
+		if err != nil {
+			return nil, &TransformError{Location: location, Rule: rule, Err: err}
+		}
+		mapped, ok := c.runJQ(exec, code, transformed, templateCtx, responseInfo).Next()
+		if err, isErr := mapped.(error); isErr || !ok {
+			if !isErr {
+				err = fmt.Errorf("mapping yielded nothing")
+			}
+			return nil, &TransformError{Location: location, Rule: rule, Err: fmt.Errorf("jq error: %w", err)}
+		}
+		transformed = mapped
+	}
 
-				// Create the evaluation context with $res variable bound
-				code, err := c.getOrCompileJQRule(exec.step.ResultTransformer, "$ctx")
-				if err != nil {
-					return fmt.Errorf("failed to get/compile transform rule: %w", err)
-				}
+	if c.schemas != nil {
+		c.schemas.observe(exec, transformed)
+	}
+
+	return transformed, nil
+}
 
-				iter := code.Run(raw, templateCtx)
-				var singleResult interface{}
-				count := 0
-
-				for {
-					v, ok := iter.Next()
-					if !ok {
-						break
-					}
-					if err, isErr := v.(error); isErr {
-						return fmt.Errorf("jq error: %w", err)
-					}
-
-					count++
-					if count > 1 {
-						return fmt.Errorf("resultTransformer yielded more than one value")
-					}
-
-					singleResult = v
+// mergeStepResult runs the nested steps on a (transformed) step result and merges it into the target context,
+// following the mergeOn / mergeWithParentOn / mergeWithContext rules or the default shallow merge.
+// extra is forwarded to the profiler events.
+func (c *ApiCrawler) mergeStepResult(ctx context.Context, exec *stepExecution, transformed any, responseInfo map[string]any, extra ...any) error {
+	thisContextKey := exec.currentContextKey
+	if exec.step.As != "" {
+		thisContextKey = exec.step.As
+	}
+	// ------------
+	// Nested foreach must happen on the "temporary" transform result, not the actual context because the results
+	// accumulated over calls and the foreach would end iterating the whole result each time
+
+	// create a new child context overriding current key
+	childContextMap := childMapWith(exec.contextMap, exec.currentContext, thisContextKey, transformed)
+	c.pruneContexts(exec, childContextMap, thisContextKey)
+
+	for _, i := range stepOrder(exec.step.Steps) {
+		newExec := newStepExecution(exec.step.Steps[i], fmt.Sprintf("%s.steps[%d]", exec.path, i), thisContextKey, childContextMap)
+		newExec.parent = exec
+		// newExec := newStepExecution(step, exec.currentContextKey, c.ContextMap)
+		if err := c.ExecuteStep(ctx, newExec); err != nil {
+			return err
+		}
+	}
+
+	// use the nested result as transformed to perform merging
+	transformed = childContextMap[thisContextKey].Data
+
+	merge := func() error { return c.mergeResult(exec, transformed, responseInfo, extra...) }
+	if merges, item := exec.orderedMerges(); merges != nil {
+		merges.add(item, merge)
+	} else if err := merge(); err != nil {
+		return err
+	}
+
+	// at this point all inner steps have been executed for all entries in this call
+	// the tree has been completely retrieved and we can check the stream
+	if exec.currentContext.depth == 0 && c.Config.Stream {
+		for i, d := range c.takeStreamEntities(exec.currentContext) {
+			if err := c.emitEntity(ctx, exec, d); err != nil {
+				return err
+			}
+			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Stream result #%d", i), exec, d, nil, extra...)
+		}
+	}
+	return nil
+}
+
+// mergeResult merges a step result as configured by its merge rules.
+func (c *ApiCrawler) mergeResult(exec *stepExecution, transformed any, responseInfo map[string]any, extra ...any) error {
+	// parallel forEach iterations may merge into the same contexts
+	c.mergeMu.Lock()
+	defer c.mergeMu.Unlock()
+
+	// 0. Named output collection, out of the context tree
+	if exec.step.CollectInto != "" {
+		c.collections.add(exec.step.CollectInto, transformed)
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Response Collect", exec, transformed, nil, append(extra, "collection", exec.step.CollectInto)...)
+	} else if exec.step.MergeOn != "" {
+		// 1. Explicit merge rule (advanced use)
+		c.logger.Debug("[Request] merging-on with expression: %s", exec.step.MergeOn)
+		templateCtx := contextMapToTemplate(exec.contextMap)
+
+		// Simple jq merge on current context
+		updated, err := applyMergeRule(c, exec, exec.currentContext.Data, exec.step.MergeOn, transformed, templateCtx, responseInfo)
+		if err != nil {
+			return &TransformError{Location: exec.path + ".mergeOn", Rule: exec.step.MergeOn, Err: err}
+		}
+		c.pushProfilerDiff("Response Merge-On", exec, updated, exec.currentContext.Data, extra...)
+		exec.currentContext.Data = updated
+	} else if exec.step.MergeWithParentOn != "" {
+		c.logger.Debug("[Request] merging-with-parent with expression: %s", exec.step.MergeWithParentOn)
+		templateCtx := contextMapToTemplate(exec.contextMap)
+
+		parentCtx := exec.contextMap[exec.currentContext.ParentContext]
+		// Simple jq merge on current context
+		updated, err := applyMergeRule(c, exec, parentCtx.Data, exec.step.MergeWithParentOn, transformed, templateCtx, responseInfo)
+		if err != nil {
+			return &TransformError{Location: exec.path + ".mergeWithParentOn", Rule: exec.step.MergeWithParentOn, Err: err}
+		}
+		c.pushProfilerDiff("Response Merge-Parent", exec, updated, parentCtx.Data, extra...)
+		parentCtx.Data = updated
+	} else if exec.step.MergeWithContext != nil {
+		c.logger.Debug("[Request] merging-with-context with expression: %s:%s",
+			exec.step.MergeWithContext.Name, exec.step.MergeWithContext.Rule)
+
+		templateCtx := contextMapToTemplate(exec.contextMap)
+		// 2. Named context merge (cross-scope update)
+		targetCtx, ok := exec.contextMap[exec.step.MergeWithContext.Name]
+		if !ok {
+			return fmt.Errorf("context '%s' not found", exec.step.MergeWithContext.Name)
+		}
+		updated, err := applyMergeRule(c, exec, targetCtx.Data, exec.step.MergeWithContext.Rule, transformed, templateCtx, responseInfo)
+		if err != nil {
+			return &TransformError{Location: exec.path + ".mergeWithContext", Rule: exec.step.MergeWithContext.Rule, Err: err}
+		}
+		c.pushProfilerDiff("Response Merge-Context", exec, updated, targetCtx.Data, extra...)
+		targetCtx.Data = updated
+	} else if transformed == nil {
+		// empty responses of requests made for their side effect only
+		c.logger.Debug("[Request] null result, nothing to merge")
+	} else {
+		c.logger.Debug("[Request] default merge")
+
+		// 3. Simple assignment (shallow)
+		switch data := exec.currentContext.Data.(type) {
+		case []interface{}:
+			if transformedArray, ok := transformed.([]interface{}); ok {
+				exec.currentContext.Data = append(data, transformedArray...) // Reassigns to field of original struct
+			} else {
+				exec.currentContext.Data = append(data, transformed)
+			}
+		case map[string]interface{}:
+			if transformedMap, ok := transformed.(map[string]interface{}); ok {
+				for k, v := range transformedMap {
+					data[k] = v // Modifies in-place
 				}
-				transformed = singleResult
 			}
+		default:
+			exec.currentContext.Data = transformed
+		}
+	}
+
+	endExtra := []any{"stats", c.statsSnapshot(exec)}
+	if worker := exec.workerSlot(); worker != nil {
+		endExtra = append(endExtra, "worker", worker.id, "workerPool", worker.pool)
+	}
+	c.pushProfilerData(STEP_PROFILER_TYPE_END_SILENT, "", nil, nil, nil, endExtra...)
+	return nil
+}
 
-			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Response Transformation", exec, transformed, raw, "url", urlObj.String())
+// renderURL expands a URL template against the template context.
+func (c *ApiCrawler) renderURL(urlTemplate string, templateCtx map[string]any) (string, error) {
+	tmpl, err := c.getOrCompileTemplate(urlTemplate)
+	if err != nil {
+		return "", fmt.Errorf("error getting/compiling URL template: %w", err)
+	}
+
+	var urlBuf bytes.Buffer
+	if err := tmpl.Execute(&urlBuf, templateCtx); err != nil {
+		return "", fmt.Errorf("error executing URL template: %w", err)
+	}
+	return urlBuf.String(), nil
+}
 
-			thisContextKey := exec.currentContextKey
-			if exec.step.As != "" {
-				thisContextKey = exec.step.As
+// requestAuthenticator returns the request level authenticator, falling back to the global one.
+func (c *ApiCrawler) requestAuthenticator(reqConfig *RequestConfig) Authenticator {
+	if reqConfig.Authentication != nil {
+		return c.newAuthenticator(*reqConfig.Authentication)
+	}
+	return c.globalAuthenticator
+}
+
+// newAuthenticator creates an authenticator sharing the injected credentials and the session
+// tokens of the device logins and refresh tokens.
+func (c *ApiCrawler) newAuthenticator(cfg AuthenticatorConfig) Authenticator {
+	cfg, err := c.resolveAuthSecrets(cfg)
+	if err != nil {
+		return failingAuthenticator{err: err}
+	}
+	auth := NewAuthenticator(cfg)
+	if impl, ok := auth.(*AuthenticatorImpl); ok {
+		impl.credentials = c.credentials
+		impl.now = c.clock.Now
+		if impl.oauthProvider != nil {
+			impl.oauthProvider.deviceTokens = c.deviceTokens
+			if impl.oauthProvider.refreshTokens != nil {
+				impl.oauthProvider.refreshTokens = c.refreshTokens
 			}
-			// ------------
-			// Nested foreach must happen on the "temporary" transform result, not the actual context because the results
-			// accumulated over calls and the foreach would end iterating the whole result each time
+		}
+	}
+	return auth
+}
 
-			// create a new child context overriding current key
-			childContextMap := childMapWith(exec.contextMap, exec.currentContext, thisContextKey, transformed)
+// authenticate applies authenticator to the request of a step, wrapping failures in an AuthError.
+func (c *ApiCrawler) authenticate(exec *stepExecution, authenticator Authenticator, req *http.Request) error {
+	if err := authenticator.PrepareRequest(req); err != nil {
+		authErr := &AuthError{Location: "authentication", Err: err}
+		if auth := exec.step.Request.Authentication; auth != nil {
+			authErr.Location = exec.path + ".request.auth"
+			authErr.Type = auth.Type
+		} else if c.Config.Authentication != nil {
+			authErr.Type = c.Config.Authentication.Type
+		}
+		return authErr
+	}
+	return nil
+}
 
-			for _, step := range exec.step.Steps {
-				newExec := newStepExecution(step, thisContextKey, childContextMap)
-				// newExec := newStepExecution(step, exec.currentContextKey, c.ContextMap)
-				if err := c.ExecuteStep(ctx, newExec); err != nil {
-					return err
-				}
+// applyHeaders sets the configured headers on req.
+// priority is (ascending order)
+// 1. User-Agent of the configuration, Content-Type of binary and GraphQL bodies
+// 2. Global
+// 3. Hosts
+// 4. User-Agent of the request
+// 5. Request
+// 6. Pagination
+// Global and request header values are go-templates rendered against templateCtx;
+// a template rendering empty omits the header.
+func (c *ApiCrawler) applyHeaders(req *http.Request, reqConfig *RequestConfig, templateCtx map[string]any, paginationHeaders map[string]string) error {
+	c.setUserAgent(req)
+	if reqConfig.binaryBody() && req.Body != nil && req.Body != http.NoBody {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if reqConfig.GraphQL != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := c.setHeaderTemplates(req, c.Config.Headers, templateCtx); err != nil {
+		return err
+	}
+	if policy := c.hostPolicy(req.URL); policy != nil {
+		for k, v := range policy.cfg.Headers {
+			if err := c.setHeader(req, k, v); err != nil {
+				return err
 			}
+		}
+	}
+	if reqConfig.UserAgent != "" {
+		if err := c.setHeaderTemplates(req, map[string]string{"User-Agent": reqConfig.UserAgent}, templateCtx); err != nil {
+			return err
+		}
+	}
+	for k, values := range reqConfig.Headers {
+		if err := c.setHeaderValues(req, k, values, templateCtx); err != nil {
+			return err
+		}
+	}
+	for k, v := range paginationHeaders {
+		req.Header.Set(k, v)
+	}
+	return nil
+}
 
-			// use the nested result as transformed to perform merging
-			transformed = childContextMap[thisContextKey].Data
+func (c *ApiCrawler) setHeaderTemplates(req *http.Request, headers map[string]string, templateCtx map[string]any) error {
+	for k, v := range headers {
+		if err := c.setHeaderValues(req, k, HeaderValue{v}, templateCtx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// 1. Explicit merge rule (advanced use)
-			if exec.step.MergeOn != "" {
-				c.logger.Debug("[Request] merging-on with expression: %s", exec.step.MergeOn)
-				templateCtx := contextMapToTemplate(exec.contextMap)
+// buildRequestBody renders the body template and injects the paginator body params into it.
+// The body is sent regardless of the method, since some search APIs expect a payload on GET.
+func (c *ApiCrawler) buildRequestBody(reqConfig *RequestConfig, templateCtx map[string]any, next *RequestParts) (io.Reader, error) {
+	if reqConfig.GraphQL != nil {
+		return c.buildGraphQLBody(reqConfig.GraphQL, templateCtx, next)
+	}
+	if reqConfig.binaryBody() {
+		if len(next.BodyParams) > 0 {
+			return nil, fmt.Errorf("body pagination params require a JSON body, not bodyBase64 or bodyFile")
+		}
+		return c.buildBinaryBody(reqConfig, templateCtx)
+	}
 
-				// Simple jq merge on current context
-				updated, err := applyMergeRule(c, exec.currentContext.Data, exec.step.MergeOn, transformed, templateCtx)
-				if err != nil {
-					return fmt.Errorf("mergeOn failed: %w", err)
-				}
-				c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Response Merge-On", exec, updated, exec.currentContext.Data, "url", urlObj.String())
-				exec.currentContext.Data = updated
-			} else if exec.step.MergeWithParentOn != "" {
-				c.logger.Debug("[Request] merging-with-parent with expression: %s", exec.step.MergeWithParentOn)
-				templateCtx := contextMapToTemplate(exec.contextMap)
-
-				parentCtx := exec.contextMap[exec.currentContext.ParentContext]
-				// Simple jq merge on current context
-				updated, err := applyMergeRule(c, parentCtx.Data, exec.step.MergeWithParentOn, transformed, templateCtx)
-				if err != nil {
-					return fmt.Errorf("mergeWithParentOn failed: %w", err)
-				}
-				c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Response Merge-Parent", exec, updated, parentCtx.Data, "url", urlObj.String())
-				parentCtx.Data = updated
-			} else if exec.step.MergeWithContext != nil {
-				c.logger.Debug("[Request] merging-with-context with expression: %s:%s",
-					exec.step.MergeWithContext.Name, exec.step.MergeWithContext.Rule)
-
-				templateCtx := contextMapToTemplate(exec.contextMap)
-				// 2. Named context merge (cross-scope update)
-				targetCtx, ok := exec.contextMap[exec.step.MergeWithContext.Name]
-				if !ok {
-					return fmt.Errorf("context '%s' not found", exec.step.MergeWithContext.Name)
+	var rendered []byte
+	if strings.TrimSpace(reqConfig.Body) != "" {
+		tmpl, err := c.getOrCompileTextTemplate(reqConfig.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error getting/compiling body template: %w", err)
+		}
+		var bodyBuf bytes.Buffer
+		if err := tmpl.Execute(&bodyBuf, templateCtx); err != nil {
+			return nil, fmt.Errorf("error executing body template: %w", err)
+		}
+		rendered = bodyBuf.Bytes()
+	}
+
+	if len(next.BodyParams) == 0 {
+		if len(rendered) == 0 {
+			return nil, nil
+		}
+		return bytes.NewReader(rendered), nil
+	}
+
+	body := map[string]interface{}{}
+	if len(rendered) != 0 {
+		if err := json.Unmarshal(rendered, &body); err != nil {
+			return nil, fmt.Errorf("request body must be a JSON object when using body pagination params: %w", err)
+		}
+	}
+	for k, v := range next.BodyParams {
+		body[k] = v
+	}
+
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding body params: %w", err)
+	}
+	return bytes.NewReader(bodyJSON), nil
+}
+
+// binaryBody reports whether the body is sent as is, from bodyBase64 or bodyFile.
+func (r *RequestConfig) binaryBody() bool {
+	return r.BodyBase64 != "" || r.BodyFile != ""
+}
+
+// buildBinaryBody returns the decoded bodyBase64 or the content of bodyFile, bypassing the
+// JSON body builder.
+func (c *ApiCrawler) buildBinaryBody(reqConfig *RequestConfig, templateCtx map[string]any) (io.Reader, error) {
+	source := reqConfig.BodyBase64
+	if reqConfig.BodyFile != "" {
+		source = reqConfig.BodyFile
+	}
+	tmpl, err := c.getOrCompileTextTemplate(source)
+	if err != nil {
+		return nil, fmt.Errorf("error getting/compiling body template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, templateCtx); err != nil {
+		return nil, fmt.Errorf("error executing body template: %w", err)
+	}
+
+	if reqConfig.BodyFile != "" {
+		data, err := os.ReadFile(strings.TrimSpace(buf.String()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading bodyFile: %w", err)
+		}
+		return bytes.NewReader(data), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(buf.String()), ""))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding bodyBase64: %w", err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// pageRequest returns the request of a page, counted from 0: from the second page on the
+// pagination nextRequest replaces its url, method and body.
+func (r *RequestConfig) pageRequest(page int) *RequestConfig {
+	next := r.Pagination.NextRequest
+	if page == 0 || next == nil {
+		return r
+	}
+	pageReq := *r
+	if next.URL != "" {
+		pageReq.URL = next.URL
+	}
+	if next.Method != "" {
+		pageReq.Method = next.Method
+	}
+	if next.Body != "" {
+		pageReq.Body = next.Body
+	}
+	return &pageReq
+}
+
+// mergeCookies returns the session cookies updated with the ones a response set.
+func mergeCookies(session []*http.Cookie, set []*http.Cookie) []*http.Cookie {
+	for _, cookie := range set {
+		session = slices.DeleteFunc(session, func(c *http.Cookie) bool { return c.Name == cookie.Name })
+		if cookie.MaxAge >= 0 {
+			session = append(session, &http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+	return session
+}
+
+// responseFromHeaders reports whether the step result is built from the response headers.
+// HEAD responses never carry a body, therefore they always use headers.
+func (r *RequestConfig) responseFromHeaders() bool {
+	return r.ResponseFrom == "headers" || strings.ToUpper(r.Method) == http.MethodHead
+}
+
+// responseToJQ builds the $response variable available to transformer and merge rules.
+func responseToJQ(resp *http.Response) map[string]interface{} {
+	return map[string]interface{}{
+		"status":  resp.StatusCode,
+		"headers": headersToMap(resp.Header),
+	}
+}
+
+// headersToMap converts response headers into a jq friendly object.
+// Single valued headers are exposed as strings, repeated ones as arrays.
+func headersToMap(h http.Header) map[string]interface{} {
+	result := make(map[string]interface{}, len(h))
+	for k, values := range h {
+		if len(values) == 1 {
+			result[k] = values[0]
+			continue
+		}
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		result[k] = list
+	}
+	return result
+}
+
+func (c *ApiCrawler) handleForEach(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Foreach] Preparing %s", exec.step.Name)
+
+	results, err := c.forEachItems(exec)
+	if err != nil {
+		return err
+	}
+
+	profileStepName := fmt.Sprintf("Foreach Extract '%s'", exec.step.Name)
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, profileStepName, exec, results, nil)
+
+	executionResults := make([]interface{}, 0)
+	if exec.step.MaxConcurrency > 1 {
+		var err error
+		if executionResults, err = c.forEachParallel(ctx, exec, results); err != nil {
+			return err
+		}
+	} else {
+		for i, item := range results {
+			if i > 0 {
+				if err := c.iterationDelay(ctx, exec); err != nil {
+					return err
 				}
-				updated, err := applyMergeRule(c, targetCtx.Data, exec.step.MergeWithContext.Rule, transformed, templateCtx)
+			}
+			if c.timeBudgetReached(exec) {
+				c.logger.Info("[Foreach] %s time budget reached, %d of %d items iterated", exec.path, i, len(results))
+				// the remaining items are kept as they are
+				executionResults = append(executionResults, results[i:]...)
+				break
+			}
+			if err := c.relieveMemoryPressure(ctx, exec); err != nil {
+				return err
+			}
+			// context cancelation handling
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				result, err := c.forEachIteration(ctx, exec, i, len(results), item)
 				if err != nil {
-					return fmt.Errorf("mergeWithContext failed: %w", err)
+					return err
 				}
-				c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Response Merge-Context", exec, updated, targetCtx.Data, "url", urlObj.String())
-				targetCtx.Data = updated
-			} else {
-				c.logger.Debug("[Request] default merge")
-
-				// 3. Simple assignment (shallow)
-				switch data := exec.currentContext.Data.(type) {
-				case []interface{}:
-					exec.currentContext.Data = append(data, transformed.([]interface{})...) // Reassigns to field of original struct
-				case map[string]interface{}:
-					if transformedMap, ok := transformed.(map[string]interface{}); ok {
-						for k, v := range transformedMap {
-							data[k] = v // Modifies in-place
-						}
-					}
-				default:
-					exec.currentContext.Data = transformed
+				if diverted, err := c.divertItem(ctx, exec, i, result); err != nil {
+					return err
+				} else if diverted {
+					continue
 				}
+				executionResults = append(executionResults, result)
 			}
+		}
+	}
 
-			c.pushProfilerData(STEP_PROFILER_TYPE_END_SILENT, "", nil, nil, nil)
+	// We need to path the context with the result of the nested data.
+	// This has to be done only if we are using path selector, foreach with hadcoded values already merge with some othe context
+	v, err := c.patchPath(exec, executionResults)
+	if err != nil {
+		return err
+	}
 
-			// at this point all inner steps have been executed for all entries in this call
-			// the tree has been completely retrieved and we can check the stream
-			if exec.currentContext.depth == 0 && c.Config.Stream {
-				// No need to check conversion since rootContext is enforced to be an array
-				array_data := exec.currentContext.Data.([]interface{})
-				for i, d := range array_data {
-					c.DataStream <- d
-					c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Stream result #%d", i), exec, d, nil, "url", urlObj.String())
-				}
+	profileStepName = fmt.Sprintf("Foreach Merge '%s'", exec.step.Name)
+	c.pushProfilerData(STEP_PROFILER_TYPE_END, profileStepName, exec, v, exec.currentContext.Data, "stats", c.statsSnapshot(exec))
 
-				// reset data
-				exec.currentContext.Data = []interface{}{}
+	// Assign new patched data
+	exec.currentContext.Data = v
+
+	// at this point all inner steps have been executed for all entries in this call
+	// the tree has been completely retrieved and we can check the stream
+	if exec.currentContext.depth <= 1 && c.Config.Stream {
+		for i, d := range c.takeStreamEntities(exec.currentContext) {
+			if err := c.emitEntity(ctx, exec, d); err != nil {
+				return err
 			}
+			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Stream result #%d", i), exec, d, nil)
 		}
 	}
 
 	return nil
 }
 
-func (c *ApiCrawler) handleForEach(ctx context.Context, exec *stepExecution) error {
-	c.logger.Info("[Foreach] Preparing %s", exec.step.Name)
-
+// forEachItems returns the items a forEach step iterates: the values, or the elements
+// selected by path in the current context.
+func (c *ApiCrawler) forEachItems(exec *stepExecution) ([]interface{}, error) {
 	results := []interface{}{}
 
 	if len(exec.step.Path) != 0 && exec.step.Values == nil {
@@ -609,17 +1531,17 @@ func (c *ApiCrawler) handleForEach(ctx context.Context, exec *stepExecution) err
 
 		code, err := c.getOrCompileJQRule(exec.step.Path)
 		if err != nil {
-			return fmt.Errorf("failed to get/compile jq path: %w", err)
+			return nil, &TransformError{Location: exec.path + ".path", Rule: exec.step.Path, Err: err}
 		}
 
-		iter := code.Run(exec.currentContext.Data)
+		iter := c.runJQ(exec, code, exec.currentContext.Data)
 		for {
 			v, ok := iter.Next()
 			if !ok {
 				break
 			}
 			if err, isErr := v.(error); isErr {
-				return fmt.Errorf("jq error: %w", err)
+				return nil, &TransformError{Location: exec.path + ".path", Rule: exec.step.Path, Err: fmt.Errorf("jq error: %w", err)}
 			}
 			results = append(results, v)
 		}
@@ -634,88 +1556,158 @@ func (c *ApiCrawler) handleForEach(ctx context.Context, exec *stepExecution) err
 		c.logger.Debug("[Foreach] using values over path: %s, values %+v", exec.step.Path, exec.step.Values)
 
 		for _, v := range exec.step.Values {
-			results = append(results, map[string]interface{}{"value": v})
-		}
-	}
-
-	profileStepName := fmt.Sprintf("Foreach Extract '%s'", exec.step.Name)
-	c.pushProfilerData(STEP_PROFILER_TYPE_START, profileStepName, exec, results, nil)
-
-	executionResults := make([]interface{}, 0)
-	for i, item := range results {
-		// context cancelation handling
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			c.logger.Info("[ForEach] Iteration %d as '%s'", i, exec.step.As, "item", item)
-
-			childContextMap := childMapWith(exec.contextMap, exec.currentContext, exec.step.As, item)
-
-			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Selection #%d", i), exec, item, nil)
-
-			for _, nested := range exec.step.Steps {
-				newExec := newStepExecution(nested, exec.step.As, childContextMap)
-				if err := c.ExecuteStep(ctx, newExec); err != nil {
-					return err
-				}
+			if exec.step.ItemShape == ITEM_SHAPE_RAW {
+				results = append(results, v)
+			} else {
+				results = append(results, map[string]interface{}{"value": v})
 			}
-
-			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Result #%d", i), exec, childContextMap[exec.step.As].Data, nil)
-			executionResults = append(executionResults, childContextMap[exec.step.As].Data)
 		}
 	}
+	return results, nil
+}
 
-	// We need to path the context with the result of the nested data.
-	// This has to be done only if we are using path selector, foreach with hadcoded values already merge with some othe context
+// patchPath replaces the elements selected by the path of a forEach step with results.
+func (c *ApiCrawler) patchPath(exec *stepExecution, results []interface{}) (interface{}, error) {
 	code, err := c.getOrCompileJQRule(exec.step.Path+" = $new", "$new")
 	if err != nil {
-		return fmt.Errorf("failed to get/compile merge rule: %w", err)
+		return nil, &TransformError{Location: exec.path + ".path", Rule: exec.step.Path, Err: err}
 	}
 
 	// Run the query against contextData, passing $new as a variable
-	iter := code.Run(exec.currentContext.Data, executionResults)
+	iter := c.runJQ(exec, code, exec.currentContext.Data, results)
 
 	v, ok := iter.Next()
 	if !ok {
-		return fmt.Errorf("patch yielded nothing")
+		return nil, &TransformError{Location: exec.path + ".path", Rule: exec.step.Path, Err: fmt.Errorf("patch yielded nothing")}
 	}
 	if err, isErr := v.(error); isErr {
-		return err
+		return nil, &TransformError{Location: exec.path + ".path", Rule: exec.step.Path, Err: err}
 	}
+	return v, nil
+}
 
-	profileStepName = fmt.Sprintf("Foreach Merge '%s'", exec.step.Name)
-	c.pushProfilerData(STEP_PROFILER_TYPE_END, profileStepName, exec, v, exec.currentContext.Data)
+// isIterationStep reports whether a step type iterates its nested steps over items.
+func isIterationStep(stepType string) bool {
+	return strings.EqualFold(stepType, "foreach") || strings.EqualFold(stepType, "split")
+}
 
-	// Assign new patched data
-	exec.currentContext.Data = v
+// takeStreamEntities removes the entities to stream from a context: its array, enforced
+// for the root context, or with streamKey the array under that key of the root context.
+func (c *ApiCrawler) takeStreamEntities(context *Context) []any {
+	if c.Config.StreamKey == "" {
+		entities, ok := context.Data.([]any)
+		if ok {
+			context.Data = []any{}
+		}
+		return entities
+	}
+	root, ok := context.Data.(map[string]any)
+	if !ok || context.depth > 0 {
+		return nil
+	}
+	entities, _ := root[c.Config.StreamKey].([]any)
+	root[c.Config.StreamKey] = []any{}
+	return entities
+}
 
-	// at this point all inner steps have been executed for all entries in this call
-	// the tree has been completely retrieved and we can check the stream
-	if exec.currentContext.depth <= 1 && c.Config.Stream {
-		// No need to check conversion since rootContext is enforced to be an array
-		array_data := exec.currentContext.Data.([]interface{})
-		for i, d := range array_data {
-			c.DataStream <- d
-			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Stream result #%d", i), exec, d, nil)
+// divertItem sends the result of a forEach iteration to its collection (collectInto) or
+// emits it (emitPerItem), reporting whether it left the context.
+func (c *ApiCrawler) divertItem(ctx context.Context, exec *stepExecution, i int, result any) (bool, error) {
+	if c.collectItem(exec, i, result) {
+		return true, nil
+	}
+	if !exec.step.EmitPerItem {
+		return false, nil
+	}
+	if !c.Config.Stream && len(c.sinks) == 0 {
+		c.logger.Warning("[ForEach] %s emitPerItem ignored, there is neither a stream nor a sink", exec.path)
+		return false, nil
+	}
+	if err := c.emitEntity(ctx, exec, result); err != nil {
+		return false, err
+	}
+	c.itemsEmitted.Store(true)
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Stream result #%d", i), exec, result, nil)
+	return true, nil
+}
+
+// timeBudgetReached reports whether a forEach step ran out of its timeBudget.
+func (c *ApiCrawler) timeBudgetReached(exec *stepExecution) bool {
+	for _, cond := range exec.step.StopOn {
+		if cond.Type != "timeBudget" {
+			continue
+		}
+		budget, err := timeBudgetDuration(cond.Value)
+		if err == nil && c.clock.Now().Sub(exec.start) >= budget {
+			return true
 		}
+	}
+	return false
+}
 
-		// reset data
-		exec.currentContext.Data = []interface{}{}
+// iterationDelay waits between two sequential iterations of a forEach step, as configured
+// by its delayMs and jitterMs.
+func (c *ApiCrawler) iterationDelay(ctx context.Context, exec *stepExecution) error {
+	delay := time.Duration(exec.step.DelayMs) * time.Millisecond
+	if exec.step.JitterMs > 0 {
+		delay += time.Duration(rand.Int64N(int64(exec.step.JitterMs)+1)) * time.Millisecond
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
+}
 
-	return nil
+// forEachIteration runs the nested steps of a forEach step on item, returning the resulting item.
+func (c *ApiCrawler) forEachIteration(ctx context.Context, exec *stepExecution, i int, total int, item interface{}) (interface{}, error) {
+	c.logger.Info("[ForEach] Iteration %d as '%s'", i, exec.step.As, "item", item)
+	c.updateStats(exec, func(s *StepStats) { s.Items++ })
+
+	childContextMap := childMapWith(exec.contextMap, exec.currentContext, exec.step.As, item)
+	addIterationMetadata(childContextMap, exec.step.As, i, total)
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Selection #%d", i), exec, item, nil)
+
+	result, err := c.runNestedSteps(ctx, exec, i, childContextMap)
+	if err != nil {
+		return nil, err
+	}
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Result #%d", i), exec, result, nil)
+	return result, nil
 }
 
-func applyMergeRule(c *ApiCrawler, contextData any, rule string, result any, templateCtx map[string]any) (interface{}, error) {
+// runNestedSteps runs the nested steps of an iteration on its item, returning the item.
+func (c *ApiCrawler) runNestedSteps(ctx context.Context, exec *stepExecution, i int, childContextMap map[string]*Context) (interface{}, error) {
+	c.pruneContexts(exec, childContextMap, exec.step.As)
+	for _, j := range stepOrder(exec.step.Steps) {
+		newExec := newStepExecution(exec.step.Steps[j], fmt.Sprintf("%s.steps[%d]", exec.path, j), exec.step.As, childContextMap)
+		newExec.parent = exec
+		newExec.item = i
+		if err := c.ExecuteStep(ctx, newExec); err != nil {
+			c.recordFailedItem(err, i)
+			return nil, err
+		}
+	}
+	return childContextMap[exec.step.As].Data, nil
+}
+
+func applyMergeRule(c *ApiCrawler, exec *stepExecution, contextData any, rule string, result any, templateCtx map[string]any, responseInfo map[string]any) (interface{}, error) {
 	// Parse the JQ expression
-	code, err := c.getOrCompileJQRule(rule, "$res", "$ctx")
+	code, err := c.getOrCompileJQRule(rule, "$res", "$ctx", "$response")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get/compile merge rule: %w", err)
 	}
 
 	// Run the query against contextData, passing $res as a variable
-	iter := code.Run(contextData, result, templateCtx)
+	iter := c.runJQ(exec, code, contextData, result, templateCtx, responseInfo)
 
 	// Collect the results, expecting exactly one
 	var values []interface{}
@@ -752,6 +1744,20 @@ func childMapWith(base map[string]*Context, currentCotnext *Context, key string,
 	return newMap
 }
 
+// addIterationMetadata adds the reserved iteration keys next to the item context key.
+func addIterationMetadata(contextMap map[string]*Context, key string, i int, total int) {
+	item := contextMap[key]
+	metadata := map[string]any{
+		key + ITERATION_INDEX_SUFFIX: i,
+		key + ITERATION_TOTAL_SUFFIX: total,
+		ITERATION_FIRST_KEY:          i == 0,
+		ITERATION_LAST_KEY:           i == total-1,
+	}
+	for k, v := range metadata {
+		contextMap[k] = &Context{Data: v, ParentContext: key, key: k, depth: item.depth}
+	}
+}
+
 func contextMapToTemplate(base map[string]*Context) map[string]interface{} {
 	result := make(map[string]interface{})
 	// root special case
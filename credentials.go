@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// AUTH_TYPE_INJECTED authenticates only with the credentials injected by SetCredential.
+const AUTH_TYPE_INJECTED = "injected"
+
+// Credential is a live credential managed by the host application: Token is sent as a
+// bearer token, Cookies are added to the requests.
+type Credential struct {
+	Token   string
+	Cookies []*http.Cookie
+}
+
+// credentialStore holds the injected credentials, keyed by authentication name.
+type credentialStore struct {
+	mu          sync.RWMutex
+	credentials map[string]Credential
+}
+
+func newCredentialStore() *credentialStore {
+	return &credentialStore{credentials: map[string]Credential{}}
+}
+
+func (s *credentialStore) get(name string) (Credential, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	credential, ok := s.credentials[name]
+	return credential, ok
+}
+
+// SetCredential injects the credential of the authentications named authName, replacing the
+// previous one. Requests use it instead of the configured login from then on, also during
+// a run, so services managing tokens themselves (e.g. a shared Keycloak client) can keep
+// them fresh. An empty Credential removes the injection.
+func (a *ApiCrawler) SetCredential(authName string, credential Credential) {
+	a.credentials.mu.Lock()
+	defer a.credentials.mu.Unlock()
+	if credential.Token == "" && len(credential.Cookies) == 0 {
+		delete(a.credentials.credentials, authName)
+		return
+	}
+	a.credentials.credentials[authName] = credential
+}
+
+// apply sets an injected credential on req.
+func (c Credential) apply(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	}
+	for _, cookie := range c.Cookies {
+		req.AddCookie(cookie)
+	}
+}
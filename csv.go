@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVConfig tells how responseFormat csv bodies are parsed.
+type CSVConfig struct {
+	Delimiter  string   `yaml:"delimiter,omitempty" json:"delimiter,omitempty"`   // single character, default ,
+	Columns    []string `yaml:"columns,omitempty" json:"columns,omitempty"`       // column names of bodies without a header row
+	InferTypes bool     `yaml:"inferTypes,omitempty" json:"inferTypes,omitempty"` // numbers, booleans and empty cells as null instead of strings
+}
+
+// jsonNumberPattern matches the JSON number grammar: cells like 007 or +1 stay strings.
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// decodeCSV decodes a CSV body into an array of objects keyed by the column names, the first
+// row being the header unless csv.columns is set.
+func (r *RequestConfig) decodeCSV(body io.Reader) (any, error) {
+	cfg := CSVConfig{}
+	if r.CSV != nil {
+		cfg = *r.CSV
+	}
+	reader := csv.NewReader(body)
+	if cfg.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(cfg.Delimiter)
+	}
+	reader.ReuseRecord = true
+
+	columns := cfg.Columns
+	if len(columns) == 0 {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return []any{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding CSV: %w", err)
+		}
+		columns = make([]string, len(header))
+		for i, name := range header {
+			columns[i] = strings.TrimSpace(name)
+		}
+		// a byte order mark survives the transcoding to UTF-8
+		columns[0] = strings.TrimPrefix(columns[0], "\ufeff")
+	} else {
+		reader.FieldsPerRecord = len(columns)
+	}
+
+	rows := []any{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding CSV: %w", err)
+		}
+		row := make(map[string]any, len(columns))
+		for i, name := range columns {
+			row[name] = r.csvValue(record[i], cfg.InferTypes)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (r *RequestConfig) csvValue(cell string, infer bool) any {
+	if !infer {
+		return cell
+	}
+	switch {
+	case cell == "":
+		return nil
+	case cell == "true", cell == "false":
+		return cell == "true"
+	case jsonNumberPattern.MatchString(cell):
+		if r.PreciseNumbers {
+			return json.Number(cell)
+		}
+		if f, err := strconv.ParseFloat(cell, 64); err == nil {
+			return f
+		}
+	}
+	return cell
+}
+
+func validateCSV(cfg *CSVConfig, location string) []ValidationError {
+	var errs []ValidationError
+	if cfg.Delimiter != "" {
+		delimiter, size := utf8.DecodeRuneInString(cfg.Delimiter)
+		if size != len(cfg.Delimiter) || delimiter == '"' || delimiter == '\r' || delimiter == '\n' || delimiter == utf8.RuneError {
+			errs = append(errs, ValidationError{"csv.delimiter must be a single character other than a quote or a line break", location + ".delimiter"})
+		}
+	}
+	seen := map[string]bool{}
+	for i, name := range cfg.Columns {
+		if name == "" || seen[name] {
+			errs = append(errs, ValidationError{"csv.columns must be unique non empty names", fmt.Sprintf("%s.columns[%d]", location, i)})
+		}
+		seen[name] = true
+	}
+	return errs
+}
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type DedupConfig struct {
+	Identity    string `yaml:"identity" json:"identity"`                           // jq expression identifying an entity, e.g. .id
+	OnlyChanged bool   `yaml:"onlyChanged,omitempty" json:"onlyChanged,omitempty"` // skip the entities emitted unchanged by a previous run
+	StateKey    string `yaml:"stateKey,omitempty" json:"stateKey,omitempty"`       // key in the state store, default dedup/<config name>.json
+}
+
+// StateStore persists the state kept by the crawler between runs.
+type StateStore interface {
+	// Load returns the value stored under key, nil when there is none.
+	Load(ctx context.Context, key string) ([]byte, error)
+	Save(ctx context.Context, key string, value []byte) error
+}
+
+// FileStateStore keeps the state on the local filesystem, relative to BaseDir.
+type FileStateStore struct {
+	BaseDir string
+}
+
+func (s FileStateStore) Load(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.BaseDir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s FileStateStore) Save(ctx context.Context, key string, value []byte) error {
+	path := filepath.Join(s.BaseDir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, value, 0644)
+}
+
+func (a *ApiCrawler) SetStateStore(store StateStore) {
+	a.stateStore = store
+}
+
+// entityDedup tracks the content hash of the emitted entities by identity.
+type entityDedup struct {
+	mu         sync.Mutex
+	previous   map[string]string // persisted by the previous runs
+	seen       map[string]string // emitted in this run
+	suppressed int
+}
+
+func (c *ApiCrawler) dedupStateKey() string {
+	if c.Config.Dedup.StateKey != "" {
+		return c.Config.Dedup.StateKey
+	}
+	return "dedup/" + c.configName + ".json"
+}
+
+// loadDedup reads the hashes persisted by the previous runs.
+func (c *ApiCrawler) loadDedup(ctx context.Context) (*entityDedup, error) {
+	d := &entityDedup{previous: map[string]string{}, seen: map[string]string{}}
+	if !c.Config.Dedup.OnlyChanged {
+		return d, nil
+	}
+	data, err := c.stateStore.Load(ctx, c.dedupStateKey())
+	if err != nil {
+		return nil, fmt.Errorf("error loading dedup state: %w", err)
+	}
+	if data != nil {
+		if err := json.Unmarshal(data, &d.previous); err != nil {
+			return nil, fmt.Errorf("error decoding dedup state %s: %w", c.dedupStateKey(), err)
+		}
+	}
+	return d, nil
+}
+
+// saveDedup persists the hashes of the entities emitted so far, after a successful run,
+// so that the entities of a failed run are emitted again by the next one.
+func (c *ApiCrawler) saveDedup(ctx context.Context) error {
+	if c.dedup == nil || !c.Config.Dedup.OnlyChanged {
+		return nil
+	}
+	state := make(map[string]string, len(c.dedup.previous)+len(c.dedup.seen))
+	for k, v := range c.dedup.previous {
+		state[k] = v
+	}
+	for k, v := range c.dedup.seen {
+		state[k] = v
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := c.stateStore.Save(ctx, c.dedupStateKey(), data); err != nil {
+		return fmt.Errorf("error saving dedup state: %w", err)
+	}
+	return nil
+}
+
+// SuppressedCount returns the number of duplicate entities not emitted by the last run.
+func (a *ApiCrawler) SuppressedCount() int {
+	if a.dedup == nil {
+		return 0
+	}
+	a.dedup.mu.Lock()
+	defer a.dedup.mu.Unlock()
+	return a.dedup.suppressed
+}
+
+// duplicateEntity reports whether an entity was already emitted with the same content in
+// this run or, with onlyChanged, in a previous one; other entities are recorded as seen.
+func (c *ApiCrawler) duplicateEntity(entity any) (bool, error) {
+	if c.dedup == nil {
+		return false, nil
+	}
+	code, err := c.getOrCompileJQRule(c.Config.Dedup.Identity)
+	if err != nil {
+		return false, &TransformError{Location: "dedup.identity", Rule: c.Config.Dedup.Identity, Err: err}
+	}
+	id, ok := c.runJQ(nil, code, entity).Next()
+	if err, isErr := id.(error); isErr || !ok {
+		if !isErr {
+			err = fmt.Errorf("identity yielded nothing")
+		}
+		return false, &TransformError{Location: "dedup.identity", Rule: c.Config.Dedup.Identity, Err: fmt.Errorf("jq error: %w", err)}
+	}
+
+	identity, err := json.Marshal(id)
+	if err != nil {
+		return false, err
+	}
+	content, err := json.Marshal(entity)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	c.dedup.mu.Lock()
+	defer c.dedup.mu.Unlock()
+	key := string(identity)
+	if c.dedup.seen[key] == hash || c.dedup.previous[key] == hash {
+		c.dedup.suppressed++
+		return true, nil
+	}
+	c.dedup.seen[key] = hash
+	return false, nil
+}
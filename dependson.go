@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// stepOrder returns the indexes of sibling steps in execution order: the declared order,
+// except that a step waits for the steps named in its dependsOn. The dependencies are
+// validated, unknown names are ignored and a cycle keeps the declared order of its steps.
+func stepOrder(steps []Step) []int {
+	order := make([]int, 0, len(steps))
+	done := make([]bool, len(steps))
+	ready := func(i int) bool {
+		for _, name := range steps[i].DependsOn {
+			if j := siblingIndex(steps, name); j >= 0 && j != i && !done[j] {
+				return false
+			}
+		}
+		return true
+	}
+	for len(order) < len(steps) {
+		next := -1
+		for i := range steps {
+			if !done[i] && ready(i) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// cycle, run the first pending step
+			next = slices.Index(done, false)
+		}
+		done[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+// siblingIndex returns the index of the sibling step named name, -1 when there is none.
+func siblingIndex(steps []Step, name string) int {
+	return slices.IndexFunc(steps, func(s Step) bool { return s.Name == name })
+}
+
+// validateDependsOn checks the dependsOn of sibling steps, location is the one of the
+// steps array.
+func validateDependsOn(steps []Step, location string) []ValidationError {
+	var errs []ValidationError
+
+	for i, step := range steps {
+		loc := fmt.Sprintf("%s[%d].dependsOn", location, i)
+		for _, name := range step.DependsOn {
+			matches := 0
+			for _, s := range steps {
+				if s.Name == name {
+					matches++
+				}
+			}
+			switch {
+			case name == "":
+				errs = append(errs, ValidationError{"dependsOn names must not be empty", loc})
+			case name == step.Name:
+				errs = append(errs, ValidationError{fmt.Sprintf("step '%s' can not depend on itself", name), loc})
+			case matches == 0:
+				errs = append(errs, ValidationError{fmt.Sprintf("dependsOn references unknown sibling step '%s'", name), loc})
+			case matches > 1:
+				errs = append(errs, ValidationError{fmt.Sprintf("dependsOn '%s' is ambiguous, several sibling steps have that name", name), loc})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// every step must reach the end of the order with its dependencies before it
+	done := map[int]bool{}
+	for _, i := range stepOrder(steps) {
+		for _, name := range steps[i].DependsOn {
+			if !done[siblingIndex(steps, name)] {
+				errs = append(errs, ValidationError{fmt.Sprintf("dependsOn cycle between steps %s", strings.Join(dependencyCycle(steps, i, done), ", ")), fmt.Sprintf("%s[%d].dependsOn", location, i)})
+				return errs
+			}
+		}
+		done[i] = true
+	}
+	return errs
+}
+
+// dependencyCycle returns the names of the steps on the dependency cycle reached from step i,
+// following the dependencies not done. When the order gets stuck every pending step has one.
+func dependencyCycle(steps []Step, i int, done map[int]bool) []string {
+	var path []int
+	for !slices.Contains(path, i) {
+		path = append(path, i)
+		for _, name := range steps[i].DependsOn {
+			if j := siblingIndex(steps, name); !done[j] {
+				i = j
+				break
+			}
+		}
+	}
+	path = path[slices.Index(path, i):]
+	names := make([]string, 0, len(path))
+	for _, j := range path {
+		names = append(names, steps[j].Name)
+	}
+	return names
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const OAUTH_METHOD_DEVICE_CODE = "device_code"
+
+// DeviceAuthorization is what the user needs to complete an interactive OAuth login:
+// open VerificationURI and enter UserCode before ExpiresAt. The logins of authorization_code
+// have no UserCode, VerificationURI is the authorization url to log in at.
+type DeviceAuthorization struct {
+	Location                string // auth | steps[0].request.auth
+	Method                  string // device_code | authorization_code
+	VerificationURI         string
+	VerificationURIComplete string // verification uri with the user code, when the server provides it
+	UserCode                string
+	ExpiresAt               time.Time // zero for authorization_code, the login waits until ctx is done
+}
+
+// deviceTokenStore holds the tokens of the interactive authenticators for the session,
+// keyed by token url and client id.
+type deviceTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+func newDeviceTokenStore() *deviceTokenStore {
+	return &deviceTokenStore{tokens: map[string]*oauth2.Token{}}
+}
+
+func deviceTokenKey(cfg OAuthConfig) string {
+	return cfg.TokenURL + " " + cfg.ClientID
+}
+
+func (s *deviceTokenStore) get(key string) *oauth2.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[key]
+}
+
+func (s *deviceTokenStore) set(key string, token *oauth2.Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = token
+}
+
+// deviceToken returns the session token of a device_code provider, refreshing it if needed.
+// Device logins are interactive, the crawler never starts one on its own.
+func (w *OAuthProvider) deviceToken(ctx context.Context) (*oauth2.Token, error) {
+	if w.deviceTokens == nil {
+		return nil, fmt.Errorf("device login required")
+	}
+	key := deviceTokenKey(w.cfg)
+	token := w.deviceTokens.get(key)
+	if token == nil {
+		return nil, fmt.Errorf("device login required for %s", w.cfg.TokenURL)
+	}
+	if token.Valid() {
+		return token, nil
+	}
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("device token for %s expired, a new device login is required", w.cfg.TokenURL)
+	}
+	refreshed, err := w.conf.TokenSource(ctx, token).Token()
+	if err != nil {
+		return nil, fmt.Errorf("could not refresh the device token: %w", err)
+	}
+	w.deviceTokens.set(key, refreshed)
+	return refreshed, nil
+}
+
+// DeviceLogin runs the interactive logins of the oauth authenticators that have no usable
+// token. For method device_code, the device authorization grant: prompt shows the verification
+// uri and user code, then the token endpoint is polled until the user completed the login or
+// the code expired. For method authorization_code, the authorization code grant with PKCE:
+// prompt shows the authorization url, the login then redirects the browser to the crawler.
+// The tokens are kept for the crawler session, see DeviceTokens.
+func (a *ApiCrawler) DeviceLogin(ctx context.Context, prompt func(DeviceAuthorization)) error {
+	for _, location := range a.authLocations() {
+		cfg := location.cfg
+		if cfg.Type != "oauth" || (cfg.Method != OAUTH_METHOD_DEVICE_CODE && cfg.Method != OAUTH_METHOD_AUTH_CODE) {
+			continue
+		}
+		cfg, err := a.resolveAuthSecrets(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", location.path, err)
+		}
+		provider := NewOAuthProvider(cfg.OAuthConfig)
+		provider.deviceTokens = a.deviceTokens
+		if _, err := provider.deviceToken(ctx); err == nil {
+			continue
+		}
+		if cfg.Method == OAUTH_METHOD_AUTH_CODE {
+			token, err := a.authCodeLogin(ctx, location.path, provider, prompt)
+			if err != nil {
+				return fmt.Errorf("%s: login failed: %w", location.path, err)
+			}
+			a.deviceTokens.set(deviceTokenKey(cfg.OAuthConfig), token)
+			a.logger.Info("[Auth] login completed for %s", location.path)
+			continue
+		}
+
+		resp, err := provider.conf.DeviceAuth(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: device authorization failed: %w", location.path, err)
+		}
+		prompt(DeviceAuthorization{
+			Location:                location.path,
+			Method:                  OAUTH_METHOD_DEVICE_CODE,
+			VerificationURI:         resp.VerificationURI,
+			VerificationURIComplete: resp.VerificationURIComplete,
+			UserCode:                resp.UserCode,
+			ExpiresAt:               resp.Expiry,
+		})
+		token, err := provider.conf.DeviceAccessToken(ctx, resp)
+		if err != nil {
+			return fmt.Errorf("%s: device login failed: %w", location.path, err)
+		}
+		a.deviceTokens.set(deviceTokenKey(cfg.OAuthConfig), token)
+		a.logger.Info("[Auth] device login completed for %s", location.path)
+	}
+	return nil
+}
+
+// DeviceTokens returns the tokens obtained with DeviceLogin, so that the crawler created
+// for the next version of a configuration can reuse them with SetDeviceTokens.
+func (a *ApiCrawler) DeviceTokens() map[string]*oauth2.Token {
+	a.deviceTokens.mu.Lock()
+	defer a.deviceTokens.mu.Unlock()
+	tokens := make(map[string]*oauth2.Token, len(a.deviceTokens.tokens))
+	for k, v := range a.deviceTokens.tokens {
+		tokens[k] = v
+	}
+	return tokens
+}
+
+// SetDeviceTokens hands the tokens of a previous device login to the crawler.
+func (a *ApiCrawler) SetDeviceTokens(tokens map[string]*oauth2.Token) {
+	for k, v := range tokens {
+		a.deviceTokens.set(k, v)
+	}
+}
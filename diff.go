@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	DIFF_OP_ADD     = "add"
+	DIFF_OP_REMOVE  = "remove"
+	DIFF_OP_REPLACE = "replace"
+)
+
+// DiffOp is a single change between two JSON values.
+type DiffOp struct {
+	Op       string `json:"op"`                 // add | remove | replace
+	Path     string `json:"path"`               // jq path of the changed value, e.g. .stations[0].name
+	Value    any    `json:"value,omitempty"`    // the new value, add and replace only
+	OldValue any    `json:"oldValue,omitempty"` // the previous value, remove and replace only
+}
+
+func (d DiffOp) String() string {
+	value := func(v any) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	switch d.Op {
+	case DIFF_OP_ADD:
+		return fmt.Sprintf("+ %s: %s", d.Path, value(d.Value))
+	case DIFF_OP_REMOVE:
+		return fmt.Sprintf("- %s: %s", d.Path, value(d.OldValue))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", d.Path, value(d.OldValue), value(d.Value))
+	}
+}
+
+// DiffJSON returns the changes turning a into b, ordered by path. Objects are compared key by
+// key, arrays index by index: an item inserted at the front of an array replaces every item
+// after it. Numbers compare by value, whatever their Go type.
+func DiffJSON(a, b any) []DiffOp {
+	ops := []DiffOp{}
+	diffJSON(".", a, b, &ops)
+	return ops
+}
+
+func diffJSON(path string, a, b any, ops *[]DiffOp) {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			old, inA := av[k]
+			value, inB := bv[k]
+			switch {
+			case !inA:
+				*ops = append(*ops, DiffOp{Op: DIFF_OP_ADD, Path: diffPathKey(path, k), Value: value})
+			case !inB:
+				*ops = append(*ops, DiffOp{Op: DIFF_OP_REMOVE, Path: diffPathKey(path, k), OldValue: old})
+			default:
+				diffJSON(diffPathKey(path, k), old, value, ops)
+			}
+		}
+		return
+	case []any:
+		bv, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			switch {
+			case i >= len(av):
+				*ops = append(*ops, DiffOp{Op: DIFF_OP_ADD, Path: diffPathIndex(path, i), Value: bv[i]})
+			case i >= len(bv):
+				*ops = append(*ops, DiffOp{Op: DIFF_OP_REMOVE, Path: diffPathIndex(path, i), OldValue: av[i]})
+			default:
+				diffJSON(diffPathIndex(path, i), av[i], bv[i], ops)
+			}
+		}
+		return
+	}
+	if !diffEqual(a, b) {
+		*ops = append(*ops, DiffOp{Op: DIFF_OP_REPLACE, Path: path, Value: b, OldValue: a})
+	}
+}
+
+var diffIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func diffPathKey(path, key string) string {
+	if !diffIdentifier.MatchString(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "." {
+		return "." + key
+	}
+	return path + "." + key
+}
+
+func diffPathIndex(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// diffEqual compares two scalars, numbers by value.
+func diffEqual(a, b any) bool {
+	if x, ok := jsonNumberValue(a); ok {
+		y, ok := jsonNumberValue(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
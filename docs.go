@@ -0,0 +1,466 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	DOC_FORMAT_MARKDOWN = "markdown"
+	DOC_FORMAT_HTML     = "html"
+)
+
+// configDoc is the description of a configuration, rendered as Markdown or HTML.
+type configDoc struct {
+	Title    string
+	Overview [][2]string // label, value
+	Steps    []stepDoc
+	Hosts    []string
+	Auth     [][2]string // location, type
+	Contexts [][2]string // name, producing step
+}
+
+type stepDoc struct {
+	Depth       int
+	Path        string
+	Type        string
+	Name        string
+	Description string
+	Meta        [][2]string // key, value
+	Target      string      // url or grpc method
+	Details     []string
+}
+
+var templateActions = regexp.MustCompile(`{{[^}]*}}`)
+
+// DescribeConfig renders a human readable description of a configuration: the step tree,
+// the hosts and urls it touches, authentication, pagination, rate limits and the contexts
+// it produces. format is markdown or html.
+func DescribeConfig(cfg Config, name string, format string) (string, error) {
+	doc := describeConfig(cfg, name)
+	switch format {
+	case "", DOC_FORMAT_MARKDOWN:
+		return doc.markdown(), nil
+	case DOC_FORMAT_HTML:
+		var buf bytes.Buffer
+		if err := configDocHTML.Execute(&buf, doc); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	default:
+		return "", fmt.Errorf("unknown documentation format: %s", format)
+	}
+}
+
+func describeConfig(cfg Config, name string) configDoc {
+	doc := configDoc{Title: name}
+
+	rootType := "object"
+	if _, ok := cfg.RootContext.([]any); ok {
+		rootType = "array"
+	}
+	doc.Overview = append(doc.Overview, [2]string{"Root context", rootType})
+	if cfg.Stream && cfg.StreamKey != "" {
+		doc.Overview = append(doc.Overview, [2]string{"Output", fmt.Sprintf("streamed %s entities, the other keys of the root context at the end of the run", cfg.StreamKey)})
+	} else if cfg.Stream {
+		doc.Overview = append(doc.Overview, [2]string{"Output", "streamed entities"})
+	} else {
+		doc.Overview = append(doc.Overview, [2]string{"Output", "root context at the end of the run"})
+	}
+	for _, sink := range cfg.Sinks {
+		target := sink.Type
+		if sink.S3 != nil {
+			target = fmt.Sprintf("s3://%s/%s", sink.S3.Bucket, sink.S3.Key)
+		}
+		doc.Overview = append(doc.Overview, [2]string{"Sink", target})
+	}
+	if cfg.MaxRequestsPerRun > 0 {
+		doc.Overview = append(doc.Overview, [2]string{"Max requests per run", fmt.Sprint(cfg.MaxRequestsPerRun)})
+	}
+	if cfg.MaxBytesPerRun > 0 {
+		doc.Overview = append(doc.Overview, [2]string{"Max bytes per run", fmt.Sprint(cfg.MaxBytesPerRun)})
+	}
+	if mp := cfg.MemoryPressure; mp != nil {
+		limit := "80% of GOMEMLIMIT"
+		if mp.HeapLimitMb > 0 {
+			limit = fmt.Sprintf("%d MB", mp.HeapLimitMb)
+		}
+		doc.Overview = append(doc.Overview, [2]string{"Memory pressure", "throttled above a heap of " + limit})
+	}
+	if cfg.UserAgent != nil {
+		doc.Overview = append(doc.Overview, [2]string{"User-Agent", cfg.UserAgent.String()})
+	}
+	if out := cfg.Output; out != nil {
+		doc.Overview = append(doc.Overview, [2]string{"Output", fmt.Sprintf("numbers %s, indent %d", cmp.Or(out.Numbers, OUTPUT_NUMBERS_DEFAULT), out.Indent)})
+	}
+	if cfg.StateStore != nil {
+		doc.Overview = append(doc.Overview, [2]string{"State store", cfg.StateStore.Type})
+	}
+	if cfg.PruneContexts {
+		doc.Overview = append(doc.Overview, [2]string{"Context pruning", "enabled"})
+	}
+	for _, pattern := range sortedKeys(cfg.Hosts) {
+		doc.Overview = append(doc.Overview, [2]string{"Host " + pattern, describeHost(cfg.Hosts[pattern])})
+	}
+	if cfg.EntitySchema != nil {
+		doc.Overview = append(doc.Overview, [2]string{"Entity schema", fmt.Sprint(cfg.EntitySchema.Schema)})
+	}
+	if cfg.Dedup != nil {
+		doc.Overview = append(doc.Overview, [2]string{"Deduplication", "by " + cfg.Dedup.Identity})
+	}
+	for _, interceptor := range cfg.Interceptors {
+		doc.Overview = append(doc.Overview, [2]string{"Interceptor", describeInterceptor(interceptor)})
+	}
+	if cfg.Authentication != nil {
+		doc.Auth = append(doc.Auth, [2]string{"auth", describeAuth(*cfg.Authentication)})
+	}
+
+	contexts := map[string]string{"root": "rootContext"}
+	for i, step := range cfg.Steps {
+		doc.describeStep(step, fmt.Sprintf("steps[%d]", i), 0, contexts)
+	}
+	for _, name := range sortedKeys(contexts) {
+		doc.Contexts = append(doc.Contexts, [2]string{name, contexts[name]})
+	}
+	return doc
+}
+
+func (d *configDoc) describeStep(step Step, path string, depth int, contexts map[string]string) {
+	s := stepDoc{Depth: depth, Path: path, Type: step.Type, Name: step.Name}
+	for _, key := range sortedKeys(step.Meta) {
+		s.Meta = append(s.Meta, [2]string{key, fmt.Sprint(step.Meta[key])})
+	}
+	s.Description = step.Description
+
+	if req := step.Request; req != nil {
+		method := strings.ToUpper(req.Method)
+		if method == "" {
+			method = "GET"
+		}
+		s.Target = method + " " + req.URL
+		d.addHost(req.URL)
+		if req.Authentication != nil {
+			d.Auth = append(d.Auth, [2]string{path + ".request.auth", describeAuth(*req.Authentication)})
+		}
+		if req.GraphQL != nil {
+			operation := "graphql query"
+			if req.GraphQL.OperationName != "" {
+				operation += " " + req.GraphQL.OperationName
+			}
+			if req.GraphQL.PageInfo != "" {
+				operation += ", pages by " + req.GraphQL.PageInfo
+			}
+			s.Details = append(s.Details, operation)
+		}
+		if p := describePagination(req.Pagination); p != "" {
+			s.Details = append(s.Details, "pagination: "+p)
+		}
+		if req.ResponseFormat != "" {
+			s.Details = append(s.Details, "response format: "+req.ResponseFormat)
+		}
+		if req.OpenAPI != nil {
+			s.Details = append(s.Details, "validated against "+req.OpenAPI.Spec)
+		}
+		if req.ArrayFormat != "" && req.ArrayFormat != ARRAY_FORMAT_REPEAT {
+			s.Details = append(s.Details, "query arrays as "+req.ArrayFormat)
+		}
+		if len(req.Languages) > 0 {
+			s.Details = append(s.Details, "in "+strings.Join(req.Languages, ", "))
+		}
+		if req.IdempotencyKey != nil {
+			s.Details = append(s.Details, "sends "+req.IdempotencyKey.header())
+		}
+		if req.AsyncPoll != nil {
+			s.Details = append(s.Details, "polls the Location of 202 responses")
+		}
+		if req.TLS != nil && req.TLS.CertFile != "" {
+			s.Details = append(s.Details, "client certificate "+req.TLS.CertFile)
+		}
+		if req.Proxy != nil {
+			s.Details = append(s.Details, "via proxy")
+		}
+		if req.TimeoutSeconds > 0 {
+			s.Details = append(s.Details, fmt.Sprintf("timeout %gs", req.TimeoutSeconds))
+		}
+	}
+	if step.GRPC != nil {
+		s.Target = fmt.Sprintf("%s %s/%s", step.GRPC.Target, step.GRPC.Service, step.GRPC.Method)
+		d.addHost(step.GRPC.Target)
+	}
+
+	if step.Type == "forEach" {
+		if step.Values != nil {
+			s.Details = append(s.Details, fmt.Sprintf("over %d values as %s", len(step.Values), step.As))
+		} else {
+			s.Details = append(s.Details, fmt.Sprintf("over %s as %s", step.Path, step.As))
+		}
+		if step.MaxConcurrency > 1 {
+			s.Details = append(s.Details, fmt.Sprintf("%d iterations in parallel", step.MaxConcurrency))
+		}
+		if step.DelayMs > 0 || step.JitterMs > 0 {
+			s.Details = append(s.Details, fmt.Sprintf("%d-%dms between iterations", step.DelayMs, step.DelayMs+step.JitterMs))
+		}
+		if step.OrderedMerges {
+			s.Details = append(s.Details, "merges in item order")
+		}
+		if step.EmitPerItem {
+			s.Details = append(s.Details, "emits every item")
+		}
+	}
+	if step.Type == "assert" {
+		names := make([]string, 0, len(step.Assertions))
+		for _, assertion := range step.Assertions {
+			if assertion.Name != "" {
+				names = append(names, assertion.Name)
+			} else {
+				names = append(names, assertion.Rule)
+			}
+		}
+		s.Details = append(s.Details, "asserts "+strings.Join(names, ", "))
+	}
+	if step.Type == "transform" {
+		s.Details = append(s.Details, "transforms with "+step.Transform)
+	}
+	if len(step.Locals) > 0 {
+		s.Details = append(s.Details, "locals "+strings.Join(sortedKeys(step.Locals), ", "))
+	}
+	if step.Type == "split" {
+		s.Details = append(s.Details, fmt.Sprintf("splits %s as %s into entities", step.Path, step.As))
+	}
+	if step.As != "" {
+		contexts[step.As] = path
+	}
+	switch {
+	case step.CollectInto != "":
+		s.Details = append(s.Details, "collected into "+step.CollectInto)
+		contexts["collection "+step.CollectInto] = path
+	case step.MergeOn != "":
+		s.Details = append(s.Details, "merged on "+step.MergeOn)
+	case step.MergeWithParentOn != "":
+		s.Details = append(s.Details, "merged with parent on "+step.MergeWithParentOn)
+	case step.MergeWithContext != nil:
+		s.Details = append(s.Details, fmt.Sprintf("merged into %s with %s", step.MergeWithContext.Name, step.MergeWithContext.Rule))
+	}
+	if step.When != "" {
+		s.Details = append(s.Details, "only when "+step.When)
+	}
+	if len(step.DependsOn) > 0 {
+		s.Details = append(s.Details, "after "+strings.Join(step.DependsOn, ", "))
+	}
+	if step.MaxRequestsPerRun > 0 {
+		s.Details = append(s.Details, fmt.Sprintf("max %d requests per run", step.MaxRequestsPerRun))
+	}
+
+	d.Steps = append(d.Steps, s)
+	for i, nested := range step.Steps {
+		d.describeStep(nested, fmt.Sprintf("%s.steps[%d]", path, i), depth+1, contexts)
+	}
+}
+
+// addHost records the host of a url template, the templated parts are left out.
+func (d *configDoc) addHost(rawURL string) {
+	host := templateActions.ReplaceAllString(rawURL, "")
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Scheme + "://" + u.Host
+	}
+	if host != "" && !slices.Contains(d.Hosts, host) {
+		d.Hosts = append(d.Hosts, host)
+	}
+}
+
+func describeAuth(auth AuthenticatorConfig) string {
+	if auth.Type == AUTH_TYPE_INJECTED {
+		return fmt.Sprintf("injected (%s)", auth.Name)
+	}
+	if auth.Type == "oauth" && auth.Method != "" {
+		return fmt.Sprintf("oauth (%s, %s)", auth.Method, auth.TokenURL)
+	}
+	return auth.Type
+}
+
+func describePagination(p Pagination) string {
+	var parts []string
+	if p.NextPageUrlSelector != "" {
+		parts = append(parts, "next page url from "+p.NextPageUrlSelector)
+	}
+	for _, param := range p.Params {
+		parts = append(parts, fmt.Sprintf("%s %s %s", param.Location, param.Type, param.Name))
+	}
+	if total := p.Total; total != nil {
+		desc := fmt.Sprintf("pages of %d up to the total count at %s", total.PageSize, total.Source)
+		if total.Concurrency > 1 {
+			desc += fmt.Sprintf(", %d at a time", total.Concurrency)
+		}
+		parts = append(parts, desc)
+	}
+	if next := p.NextRequest; next != nil {
+		target := strings.TrimSpace(strings.ToUpper(next.Method) + " " + next.URL)
+		if target == "" {
+			target = "with another body"
+		}
+		parts = append(parts, "next pages "+target)
+	}
+	if p.SessionAffinity {
+		parts = append(parts, "session affinity")
+	}
+	if p.Pipeline {
+		parts = append(parts, "next page requested during the nested steps")
+	}
+	for _, stop := range p.StopOn {
+		switch stop.Type {
+		case "responseBody":
+			parts = append(parts, "stop on "+stop.Expression)
+		case "requestParam":
+			parts = append(parts, fmt.Sprintf("stop when %s %s %v", stop.Param, stop.Compare, stop.Value))
+		default:
+			parts = append(parts, fmt.Sprintf("stop on %s %v", stop.Type, stop.Value))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeInterceptor(interceptor InterceptorConfig) string {
+	var parts []string
+	if interceptor.RewriteURLPrefix != "" {
+		parts = append(parts, fmt.Sprintf("rewrites %s to %s", interceptor.URLPrefix, interceptor.RewriteURLPrefix))
+	} else if interceptor.URLPrefix != "" {
+		parts = append(parts, "on "+interceptor.URLPrefix)
+	}
+	if len(interceptor.SetHeaders) > 0 || len(interceptor.RemoveHeaders) > 0 {
+		parts = append(parts, "patches headers")
+	}
+	for _, from := range slices.Sorted(maps.Keys(interceptor.MapStatus)) {
+		parts = append(parts, fmt.Sprintf("maps status %d to %d", from, interceptor.MapStatus[from]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeHost(host HostConfig) string {
+	var parts []string
+	if host.RateLimit > 0 {
+		parts = append(parts, fmt.Sprintf("%v requests/s", host.RateLimit))
+	}
+	if host.DelayMs > 0 {
+		parts = append(parts, fmt.Sprintf("%d ms between requests", host.DelayMs))
+	}
+	if host.MaxConcurrency > 0 {
+		parts = append(parts, fmt.Sprintf("max %d concurrent requests", host.MaxConcurrency))
+	}
+	if host.Proxy != "" {
+		parts = append(parts, "via proxy")
+	}
+	if host.RateLimitHeaders != nil {
+		parts = append(parts, "paced by rate limit headers")
+	}
+	if len(parts) == 0 {
+		return "custom headers"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (d configDoc) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", d.Title)
+
+	b.WriteString("| Setting | Value |\n| --- | --- |\n")
+	for _, row := range d.Overview {
+		fmt.Fprintf(&b, "| %s | %s |\n", row[0], markdownCell(row[1]))
+	}
+
+	b.WriteString("\n## Steps\n\n")
+	for _, s := range d.Steps {
+		fmt.Fprintf(&b, "%s- **%s** `%s`", strings.Repeat("  ", s.Depth), s.Type, s.Path)
+		if s.Name != "" {
+			fmt.Fprintf(&b, " %s", s.Name)
+		}
+		if s.Target != "" {
+			fmt.Fprintf(&b, ": `%s`", s.Target)
+		}
+		b.WriteString("\n")
+		if s.Description != "" {
+			fmt.Fprintf(&b, "%s  _%s_\n", strings.Repeat("  ", s.Depth), strings.Join(strings.Fields(s.Description), " "))
+		}
+		for _, meta := range s.Meta {
+			fmt.Fprintf(&b, "%s  - %s: %s\n", strings.Repeat("  ", s.Depth), meta[0], meta[1])
+		}
+		for _, detail := range s.Details {
+			fmt.Fprintf(&b, "%s  - %s\n", strings.Repeat("  ", s.Depth), detail)
+		}
+	}
+
+	if len(d.Hosts) > 0 {
+		b.WriteString("\n## Hosts\n\n")
+		for _, host := range d.Hosts {
+			fmt.Fprintf(&b, "- %s\n", host)
+		}
+	}
+
+	if len(d.Auth) > 0 {
+		b.WriteString("\n## Authentication\n\n| Location | Type |\n| --- | --- |\n")
+		for _, row := range d.Auth {
+			fmt.Fprintf(&b, "| `%s` | %s |\n", row[0], markdownCell(row[1]))
+		}
+	}
+
+	b.WriteString("\n## Contexts\n\n| Name | Produced by |\n| --- | --- |\n")
+	for _, row := range d.Contexts {
+		fmt.Fprintf(&b, "| `%s` | `%s` |\n", row[0], row[1])
+	}
+	return b.String()
+}
+
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+var configDocHTML = template.Must(template.New("doc").Funcs(template.FuncMap{
+	"indent": func(depth int) int { return depth * 24 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{ .Title }}</title></head>
+<body>
+<h1>{{ .Title }}</h1>
+<table>
+{{- range .Overview }}
+<tr><th>{{ index . 0 }}</th><td>{{ index . 1 }}</td></tr>
+{{- end }}
+</table>
+<h2>Steps</h2>
+{{- range .Steps }}
+<div style="margin-left: {{ indent .Depth }}px">
+<p><strong>{{ .Type }}</strong> <code>{{ .Path }}</code>{{ if .Name }} {{ .Name }}{{ end }}{{ if .Target }}: <code>{{ .Target }}</code>{{ end }}</p>
+{{- if .Description }}
+<p><em>{{ .Description }}</em></p>
+{{- end }}
+{{- if .Meta }}
+<dl>{{ range .Meta }}<dt>{{ index . 0 }}</dt><dd>{{ index . 1 }}</dd>{{ end }}</dl>
+{{- end }}
+{{- if .Details }}
+<ul>{{ range .Details }}<li>{{ . }}</li>{{ end }}</ul>
+{{- end }}
+</div>
+{{- end }}
+{{- if .Hosts }}
+<h2>Hosts</h2>
+<ul>{{ range .Hosts }}<li>{{ . }}</li>{{ end }}</ul>
+{{- end }}
+{{- if .Auth }}
+<h2>Authentication</h2>
+<table>{{ range .Auth }}<tr><td><code>{{ index . 0 }}</code></td><td>{{ index . 1 }}</td></tr>{{ end }}</table>
+{{- end }}
+<h2>Contexts</h2>
+<table>{{ range .Contexts }}<tr><td><code>{{ index . 0 }}</code></td><td><code>{{ index . 1 }}</code></td></tr>{{ end }}</table>
+</body>
+</html>
+`))
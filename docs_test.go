@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeConfig(t *testing.T) {
+	data, err := os.ReadFile("examples/list-and-details-paginated-stopped-streamed.yaml")
+	require.NoError(t, err)
+	cfg, err := ParseConfig(data)
+	require.NoError(t, err)
+
+	doc, err := DescribeConfig(cfg, "dogs", DOC_FORMAT_MARKDOWN)
+	require.NoError(t, err)
+	assert.Contains(t, doc, "# dogs\n")
+	assert.Contains(t, doc, "| Output | streamed entities |")
+	assert.Contains(t, doc, "- **request** `steps[0]` first-request: `GET https://dogapi.dog/api/v2/breeds`\n"+
+		"  - pagination: query int page[number], stop when .query.page[number] eq 3\n"+
+		"  - **forEach** `steps[0].steps[0]` get details\n")
+	assert.Contains(t, doc, "## Hosts\n\n- https://dogapi.dog\n")
+	assert.Contains(t, doc, "| `dog` | `steps[0].steps[0]` |")
+
+	html, err := DescribeConfig(cfg, "dogs", DOC_FORMAT_HTML)
+	require.NoError(t, err)
+	assert.Contains(t, html, "<h1>dogs</h1>")
+	assert.Contains(t, html, "<code>GET https://dogapi.dog/api/v2/breeds/{{ .dog.id }}</code>")
+
+	_, err = DescribeConfig(cfg, "dogs", "pdf")
+	assert.Error(t, err)
+}
+
+func TestDescribeStepMetadata(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+rootContext: []
+steps:
+  - type: request
+    name: stations
+    description: |
+      Lists the stations,
+      the details come from the nested step.
+    meta:
+      owner: mobility-team
+      sla: 1h
+    request:
+      url: https://example.com/stations
+      method: GET
+`))
+	require.NoError(t, err)
+	assert.Empty(t, ValidateConfig(cfg))
+
+	doc, err := DescribeConfig(cfg, "stations", DOC_FORMAT_MARKDOWN)
+	require.NoError(t, err)
+	assert.Contains(t, doc, "- **request** `steps[0]` stations: `GET https://example.com/stations`\n"+
+		"  _Lists the stations, the details come from the nested step._\n"+
+		"  - owner: mobility-team\n"+
+		"  - sla: 1h\n")
+
+	html, err := DescribeConfig(cfg, "stations", DOC_FORMAT_HTML)
+	require.NoError(t, err)
+	assert.Contains(t, html, "<dt>owner</dt><dd>mobility-team</dd>")
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type DownloadConfig struct {
+	Path string `yaml:"path" json:"path"` // go template, destination key in the artifact store
+}
+
+// ArtifactStore persists the binary payloads fetched by download steps.
+type ArtifactStore interface {
+	Store(ctx context.Context, key string, contentType string, body io.Reader) error
+}
+
+// FileArtifactStore writes artifacts on the local filesystem, relative to BaseDir.
+type FileArtifactStore struct {
+	BaseDir string
+}
+
+func (s FileArtifactStore) Store(ctx context.Context, key string, contentType string, body io.Reader) error {
+	path := filepath.Join(s.BaseDir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, body)
+	return err
+}
+
+func (a *ApiCrawler) SetArtifactStore(store ArtifactStore) {
+	a.artifactStore = store
+}
+
+// handleDownload streams a binary response into the artifact store.
+// The artifact metadata (path, size, sha256, contentType, url, status) is the step result
+// and goes through resultTransformer and merge rules like a JSON response.
+func (c *ApiCrawler) handleDownload(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Download] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	data := c.templateData(exec, templateCtx)
+	_url, err := c.renderURL(exec.step.Request.URL, data)
+	if err != nil {
+		return err
+	}
+
+	pathTmpl, err := c.getOrCompileTextTemplate(exec.step.Download.Path)
+	if err != nil {
+		return fmt.Errorf("error getting/compiling download path template: %w", err)
+	}
+	var pathBuf bytes.Buffer
+	if err := pathTmpl.Execute(&pathBuf, data); err != nil {
+		return fmt.Errorf("error executing download path template: %w", err)
+	}
+	path := strings.TrimSpace(pathBuf.String())
+
+	reqBody, err := c.buildRequestBody(exec.step.Request, data, &RequestParts{})
+	if err != nil {
+		return err
+	}
+
+	method := http.MethodGet
+	if exec.step.Request.Method != "" {
+		method = strings.ToUpper(exec.step.Request.Method)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, _url, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	if err := c.applyHeaders(req, exec.step.Request, data, nil); err != nil {
+		return err
+	}
+	if err := c.authenticate(exec, c.requestAuthenticator(exec.step.Request), req); err != nil {
+		return err
+	}
+
+	c.logger.Info("[Download] %s -> %s", _url, path)
+
+	resp, err := c.doRequest(exec, req)
+	if err != nil {
+		return fmt.Errorf("error performing HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// error pages must not be stored as artifacts
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPError{Step: exec.path, URL: _url, Status: resp.StatusCode}
+	}
+
+	hasher := sha256.New()
+	counter := &countingReader{reader: io.TeeReader(resp.Body, hasher)}
+	contentType := resp.Header.Get("Content-Type")
+
+	if err := c.artifactStore.Store(ctx, path, contentType, counter); err != nil {
+		return fmt.Errorf("error storing artifact '%s': %w", path, err)
+	}
+
+	metadata := map[string]interface{}{
+		"path":        path,
+		"size":        int(counter.count),
+		"sha256":      hex.EncodeToString(hasher.Sum(nil)),
+		"contentType": contentType,
+		"url":         _url,
+		"status":      resp.StatusCode,
+	}
+
+	responseInfo := responseToJQ(resp)
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Download '%s'", exec.step.Name), exec, metadata, nil, "url", _url)
+
+	transformed, err := c.transformResult(exec, metadata, templateCtx, responseInfo)
+	if err != nil {
+		return err
+	}
+
+	c.pushProfilerDiff("Download Transformation", exec, transformed, metadata, "url", _url)
+
+	return c.mergeStepResult(ctx, exec, transformed, responseInfo, "url", _url)
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const CONFIG_KEY_ENV = "APIGOROWLER_CONFIG_KEY"
+
+// ConfigKeyProvider returns the AES key used to decrypt the `encrypted` config blob.
+// The default reads a base64 encoded 32 bytes key from APIGOROWLER_CONFIG_KEY;
+// override it to fetch the key from a KMS or any other secret store.
+var ConfigKeyProvider = func() ([]byte, error) {
+	encoded, ok := os.LookupEnv(CONFIG_KEY_ENV)
+	if !ok || len(encoded) == 0 {
+		return nil, fmt.Errorf("config contains an encrypted section but %s is not set", CONFIG_KEY_ENV)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", CONFIG_KEY_ENV, err)
+	}
+	return key, nil
+}
+
+// ParseConfig unmarshals a YAML configuration and expands its `encrypted` section, if any.
+// The decrypted content is a YAML document merged over the plain fields, so credentials
+// (auth, headers, ...) can be kept encrypted while the rest of the file stays readable.
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	if len(cfg.Encrypted) == 0 {
+		return cfg, nil
+	}
+
+	key, err := ConfigKeyProvider()
+	if err != nil {
+		return cfg, err
+	}
+
+	plain, err := DecryptConfigSection(cfg.Encrypted, key)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := yaml.Unmarshal(plain, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid encrypted section: %w", err)
+	}
+	cfg.Encrypted = ""
+
+	return cfg, nil
+}
+
+// EncryptConfigSection encrypts a YAML fragment with AES-GCM, returning the base64
+// blob to be placed in the `encrypted` field of a configuration.
+func EncryptConfigSection(plain []byte, key []byte) (string, error) {
+	gcm, err := newConfigCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptConfigSection reverses EncryptConfigSection.
+func DecryptConfigSection(blob string, key []byte) ([]byte, error) {
+	gcm, err := newConfigCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(blob), ""))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted section is not valid base64: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted section is too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt config section: %w", err)
+	}
+	return plain, nil
+}
+
+func newConfigCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("config key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The error types below are returned (wrapped) by NewApiCrawler, Run, CheckAuth and
+// CheckConnectivity, so that embedders can tell failures apart with errors.As, e.g. to retry
+// on a 503 but alert on a broken transformer. ProbeError, AssertionError, ErrBudgetExceeded
+// and ErrRunLocked complete the set.
+
+// ConfigError is returned when the configuration can not be read, decrypted or validated.
+type ConfigError struct {
+	Errors []ValidationError // empty when the configuration could not be parsed
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("invalid configuration: %s", e.Err.Error())
+	}
+	messages := make([]string, 0, len(e.Errors))
+	for _, v := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", v.Location, v.Message))
+	}
+	return fmt.Sprintf("validation failed: %s", strings.Join(messages, "; "))
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when a request could not be authenticated, e.g. because the
+// OAuth token endpoint refused the credentials.
+type AuthError struct {
+	Location string // e.g. steps[0].request.auth, "authentication" for the global one
+	Type     string
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Location, e.Err.Error())
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPError is returned when a request failed at the transport level (Status 0)
+// or was answered with an error status.
+type HTTPError struct {
+	Step   string // step path, e.g. steps[0].steps[1]
+	URL    string
+	Status int
+	Err    error // transport error, nil for error statuses
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("step '%s': request to %s failed: %s", e.Step, e.URL, e.Err.Error())
+	}
+	return fmt.Sprintf("step '%s': %s returned status %d %s", e.Step, e.URL, e.Status, http.StatusText(e.Status))
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether retrying the request later may succeed:
+// transport errors, 408, 429 and 5xx statuses.
+func (e *HTTPError) Temporary() bool {
+	return e.Status == 0 || e.Status == http.StatusRequestTimeout ||
+		e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// TransformError is returned when a jq rule (resultTransformer, path, merge rules) fails.
+type TransformError struct {
+	Location string // e.g. steps[0].resultTransformer
+	Rule     string
+	Err      error
+}
+
+func (e *TransformError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Location, e.Err.Error())
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// ContractError is returned when a response does not match the schema declared in the
+// OpenAPI document of the step (request.openapi with mode error).
+type ContractError struct {
+	Step       string
+	URL        string
+	Mismatches []string // e.g. $.items[0].id: expected integer, got string
+}
+
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("step '%s': response of %s does not match the openapi schema: %s", e.Step, e.URL, strings.Join(e.Mismatches, "; "))
+}
+
+// ResponseError is returned when a response with a success status reports an error in its
+// body, as told by the errorWhen predicate of the request.
+type ResponseError struct {
+	Step    string
+	URL     string
+	Status  int
+	Message string // errorMessage evaluated on the response
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("step '%s': %s reported an error: %s", e.Step, e.URL, e.Message)
+}
+
+// GraphQLError is returned when a GraphQL response carries errors and no data. The errors of
+// partial responses, with data, are logged.
+type GraphQLError struct {
+	Step     string
+	URL      string
+	Messages []string // message of each entry of errors, the entry as JSON without one
+}
+
+func (e *GraphQLError) Error() string {
+	return fmt.Sprintf("step '%s': %s returned graphql errors: %s", e.Step, e.URL, strings.Join(e.Messages, "; "))
+}
+
+// PaginationError is returned when the pagination of a request step can not be set up
+// or advanced, e.g. because the next page parameter could not be extracted.
+type PaginationError struct {
+	Step string
+	Page int
+	Err  error
+}
+
+func (e *PaginationError) Error() string {
+	return fmt.Sprintf("step '%s': pagination failed at page %d: %s", e.Step, e.Page, e.Err.Error())
+}
+
+func (e *PaginationError) Unwrap() error {
+	return e.Err
+}
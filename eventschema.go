@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PROFILER_SCHEMA_VERSION is the version of the ProfilerEvent format and of its JSON Schema.
+// New kinds, fields and extra keys keep the version, consumers ignore the ones they do not
+// know; renaming or removing any of them or changing its type bumps it.
+const PROFILER_SCHEMA_VERSION = 1
+
+// PROFILER_SCHEMA_ID identifies the JSON Schema of the profiler events of a version.
+const PROFILER_SCHEMA_ID = "https://github.com/noi-techpark/go-apigorowler/schemas/profiler-event.v1.json"
+
+// profiler event types as exported in ProfilerEvent.Type
+const (
+	PROFILER_EVENT_START      = "start"
+	PROFILER_EVENT_NONE       = "event"
+	PROFILER_EVENT_END        = "end"
+	PROFILER_EVENT_END_SILENT = "endSilent"
+)
+
+// ProfilerEvent is the machine-readable form of a StepProfilerData, one line of a profiler
+// JSONL export. Its fields and the Data and Extra of every kind are described by
+// ProfilerEventSchema.
+type ProfilerEvent struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	ID            string         `json:"id"`
+	RunID         string         `json:"runId,omitempty"`
+	Timestamp     time.Time      `json:"timestamp"`
+	Type          string         `json:"type"`
+	Kind          string         `json:"kind"` // see ProfilerEventKinds, "unknown" for the events of newer crawlers
+	Name          string         `json:"name"`
+	Step          string         `json:"step,omitempty"` // name of the step pushing the event
+	StepType      string         `json:"stepType,omitempty"`
+	Data          any            `json:"data,omitempty"`
+	DataBefore    any            `json:"dataBefore,omitempty"`
+	Extra         map[string]any `json:"extra,omitempty"`
+}
+
+// NewProfilerEvent returns the machine-readable form of a profiler event.
+func NewProfilerEvent(d StepProfilerData) ProfilerEvent {
+	return ProfilerEvent{
+		SchemaVersion: PROFILER_SCHEMA_VERSION,
+		ID:            d.ID,
+		RunID:         d.RunID,
+		Timestamp:     d.Timestamp,
+		Type:          profilerEventType(d.Type),
+		Kind:          ProfilerEventKindOf(d.Name),
+		Name:          d.Name,
+		Step:          d.Config.Name,
+		StepType:      d.Config.Type,
+		Data:          d.Data,
+		DataBefore:    d.DataBefore,
+		Extra:         d.Extra,
+	}
+}
+
+func profilerEventType(t StepProfileType) string {
+	switch t {
+	case STEP_PROFILER_TYPE_START:
+		return PROFILER_EVENT_START
+	case STEP_PROFILER_TYPE_END:
+		return PROFILER_EVENT_END
+	case STEP_PROFILER_TYPE_END_SILENT:
+		return PROFILER_EVENT_END_SILENT
+	default:
+		return PROFILER_EVENT_NONE
+	}
+}
+
+// ProfilerEventKind describes the events of a kind: how they are named, what their Data and
+// DataBefore hold and the JSON types of their Extra keys.
+type ProfilerEventKind struct {
+	Kind       string
+	Name       *regexp.Regexp
+	Data       string
+	DataBefore string            // empty when the events have none
+	Extra      map[string]string // key => JSON Schema type
+}
+
+// profilerCommonExtra are the Extra keys any event may carry.
+var profilerCommonExtra = map[string]string{
+	"worker":     "integer", // slot of the closest parallel forEach iteration
+	"workerPool": "string",  // location of that forEach step
+}
+
+// ProfilerEventKinds are the kinds of the profiler events, in the order the schema lists them.
+var ProfilerEventKinds = []ProfilerEventKind{
+	{Kind: "request", Name: regexp.MustCompile(`^Request '.*' \| page#\d+$`), Data: "decoded response of the page",
+		Extra: map[string]string{"url": "string", "paginationHeaders": "object", "requestHeaders": "object", "responseHeaders": "object"}},
+	{Kind: "fetch", Name: regexp.MustCompile(`^Fetch '.*'$`), Data: "decoded response",
+		Extra: map[string]string{"url": "string"}},
+	{Kind: "poll", Name: regexp.MustCompile(`^Poll '.*'$`), Data: "decoded response of the last poll",
+		Extra: map[string]string{"url": "string"}},
+	{Kind: "asyncPoll", Name: regexp.MustCompile(`^Async Poll #\d+$`), Data: "null",
+		Extra: map[string]string{"url": "string", "status": "integer"}},
+	{Kind: "probe", Name: regexp.MustCompile(`^Probe '.*'$`), Data: "response body",
+		Extra: map[string]string{"url": "string", "status": "integer", "latencyMs": "integer"}},
+	{Kind: "download", Name: regexp.MustCompile(`^Download '.*'$`), Data: "metadata of the downloaded file",
+		Extra: map[string]string{"url": "string"}},
+	{Kind: "grpc", Name: regexp.MustCompile(`^gRPC '.*'$`), Data: "decoded response",
+		Extra: map[string]string{"target": "string", "method": "string"}},
+	{Kind: "subscribe", Name: regexp.MustCompile(`^Subscribe '.*' \| message#\d+$`), Data: "decoded message",
+		Extra: map[string]string{"url": "string"}},
+	{Kind: "sitemap", Name: regexp.MustCompile(`^Sitemap '.*'$`), Data: "urls of the sitemaps",
+		Extra: map[string]string{"url": "string", "sitemaps": "integer"}},
+	{Kind: "language", Name: regexp.MustCompile(`^Language '.*'$`), Data: "decoded response in the language",
+		Extra: map[string]string{"url": "string"}},
+	{Kind: "openAPIValidation", Name: regexp.MustCompile(`^OpenAPI Validation$`), Data: "mismatches with the OpenAPI document",
+		Extra: map[string]string{"url": "string"}},
+	{Kind: "transformation", Name: regexp.MustCompile(`^(Response|Message|Download|Context) Transformation$`), Data: "transformed value", DataBefore: "value before the transformation",
+		Extra: map[string]string{"diff": "array", "url": "string", "target": "string", "method": "string"}},
+	{Kind: "merge", Name: regexp.MustCompile(`^Response Merge-(On|Parent|Context)$`), Data: "merged context", DataBefore: "context before the merge",
+		Extra: map[string]string{"diff": "array", "url": "string", "target": "string", "method": "string"}},
+	{Kind: "collect", Name: regexp.MustCompile(`^(Response Collect|Collect result #\d+)$`), Data: "value added to the collection",
+		Extra: map[string]string{"collection": "string", "url": "string", "target": "string", "method": "string"}},
+	{Kind: "stream", Name: regexp.MustCompile(`^Stream result #\d+$`), Data: "streamed entity",
+		Extra: map[string]string{"url": "string", "target": "string", "method": "string"}},
+	{Kind: "forEach", Name: regexp.MustCompile(`^Foreach Extract '.*'$`), Data: "items to iterate"},
+	{Kind: "forEachMerge", Name: regexp.MustCompile(`^Foreach Merge '.*'$`), Data: "result merged", DataBefore: "context before the merge",
+		Extra: map[string]string{"stats": "object"}},
+	{Kind: "selection", Name: regexp.MustCompile(`^Selection #\d+$`), Data: "item of the iteration"},
+	{Kind: "iterationResult", Name: regexp.MustCompile(`^Result #\d+$`), Data: "item after the nested steps"},
+	{Kind: "result", Name: regexp.MustCompile(`^Result$`), Data: "data of the run", DataBefore: "root context before the run"},
+	{Kind: "transform", Name: regexp.MustCompile(`^Transform '.*'$`), Data: "context to transform"},
+	{Kind: "split", Name: regexp.MustCompile(`^Split '.*'$`), Data: "entities to split",
+		Extra: map[string]string{"entities": "integer"}},
+	{Kind: "entity", Name: regexp.MustCompile(`^Entity (Split|Failed|Enriched|Emitted) #\d+$`), Data: "entity", DataBefore: "entity before the nested steps, Enriched only",
+		Extra: map[string]string{"diff": "array", "error": "string"}},
+	{Kind: "splitMerge", Name: regexp.MustCompile(`^Split Merge '.*'$`), Data: "result merged", DataBefore: "context before the merge",
+		Extra: map[string]string{"stats": "object"}},
+	{Kind: "assert", Name: regexp.MustCompile(`^Assert '.*'$`), Data: "asserted value on start, failures on end",
+		Extra: map[string]string{"assertions": "integer", "failed": "integer"}},
+	{Kind: "skipped", Name: regexp.MustCompile(`^Skipped '.*'$`), Data: "value of the when rule",
+		Extra: map[string]string{"when": "string"}},
+	{Kind: "quarantine", Name: regexp.MustCompile(`^Entity Quarantine$`), Data: "quarantined entity",
+		Extra: map[string]string{"errors": "array"}},
+	{Kind: "schemaDrift", Name: regexp.MustCompile(`^Schema Drift$`), Data: "drifts of the run",
+		Extra: map[string]string{"drifts": "integer"}},
+	{Kind: "parallelismSetup", Name: regexp.MustCompile(`^` + PARALLELISM_SETUP + `$`), Data: "null",
+		Extra: map[string]string{"maxConcurrency": "integer", "items": "integer", "adaptive": "boolean"}},
+	{Kind: "parallelismSample", Name: regexp.MustCompile(`^` + PARALLELISM_SAMPLE + `$`), Data: "OccupancySample of the workers"},
+	{Kind: "memoryPressure", Name: regexp.MustCompile(`^` + MEMORY_PRESSURE + `$`), Data: "null",
+		Extra: map[string]string{"heapBefore": "integer", "heapAfter": "integer", "limit": "integer", "flushed": "integer"}},
+	{Kind: "stepEnd", Name: regexp.MustCompile(`^$`), Data: "null",
+		Extra: map[string]string{"stats": "object"}},
+}
+
+// ProfilerEventKindOf returns the kind of the profiler events named name, "unknown" when
+// none matches.
+func ProfilerEventKindOf(name string) string {
+	for _, k := range ProfilerEventKinds {
+		if k.Name.MatchString(name) {
+			return k.Kind
+		}
+	}
+	return "unknown"
+}
+
+// ProfilerEventSchema returns the JSON Schema of the ProfilerEvent lines of a profiler export.
+func ProfilerEventSchema() ([]byte, error) {
+	kinds := []string{}
+	cases := []any{}
+	for _, k := range ProfilerEventKinds {
+		kinds = append(kinds, k.Kind)
+		extra := map[string]any{}
+		for key, t := range profilerCommonExtra {
+			extra[key] = map[string]any{"type": t}
+		}
+		for key, t := range k.Extra {
+			extra[key] = map[string]any{"type": t}
+		}
+		then := map[string]any{
+			"name":  map[string]any{"pattern": k.Name.String()},
+			"data":  map[string]any{"description": k.Data},
+			"extra": map[string]any{"type": "object", "properties": extra},
+		}
+		if k.DataBefore != "" {
+			then["dataBefore"] = map[string]any{"description": k.DataBefore}
+		}
+		cases = append(cases, map[string]any{
+			"if":   map[string]any{"properties": map[string]any{"kind": map[string]any{"const": k.Kind}}},
+			"then": map[string]any{"properties": then},
+		})
+	}
+	// the kinds are listed, not enumerated: the events of newer crawlers with new kinds stay valid
+	kinds = append(kinds, "unknown")
+
+	schema := map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         PROFILER_SCHEMA_ID,
+		"title":       "go-apigorowler profiler event",
+		"description": "One line of a profiler JSONL export. Consumers ignore unknown kinds, fields and extra keys, which newer crawlers may add without a new schemaVersion.",
+		"type":        "object",
+		"required":    []string{"schemaVersion", "id", "timestamp", "type", "kind", "name"},
+		"properties": map[string]any{
+			"schemaVersion": map[string]any{"const": PROFILER_SCHEMA_VERSION},
+			"id":            map[string]any{"type": "string"},
+			"runId":         map[string]any{"type": "string"},
+			"timestamp":     map[string]any{"type": "string", "format": "date-time"},
+			"type":          map[string]any{"enum": []string{PROFILER_EVENT_START, PROFILER_EVENT_NONE, PROFILER_EVENT_END, PROFILER_EVENT_END_SILENT}},
+			"kind":          map[string]any{"type": "string", "description": "known kinds: " + strings.Join(kinds, ", ")},
+			"name":          map[string]any{"type": "string"},
+			"step":          map[string]any{"type": "string", "description": "name of the step pushing the event"},
+			"stepType":      map[string]any{"type": "string"},
+			"data":          map[string]any{},
+			"dataBefore":    map[string]any{},
+			"extra":         map[string]any{"type": "object"},
+		},
+		"allOf": cases,
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Exit codes of the crawl command, so that CI and cron wrappers can branch on the outcome
+// of a run.
+const (
+	EXIT_SUCCESS          = 0
+	EXIT_FAILURE          = 1 // the command itself failed, e.g. bad arguments or unreadable files
+	EXIT_VALIDATION_ERROR = 2
+	EXIT_PARTIAL_FAILURE  = 3 // the run failed, the report lists the failed steps
+	EXIT_AUTH_FAILURE     = 4
+	EXIT_BUDGET_EXCEEDED  = 5
+	EXIT_RUN_LOCKED       = 6 // another run of the configuration holds the lock, nothing was crawled
+	EXIT_CANCELED         = 7 // the run was interrupted, e.g. by SIGINT
+	EXIT_TIMEOUT          = 8 // the run deadline or a request timeout expired
+)
+
+// ExitCode maps an error returned by NewApiCrawler or Run to its exit code.
+func ExitCode(err error) int {
+	var configErr *ConfigError
+	var authErr *AuthError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return EXIT_SUCCESS
+	case errors.As(err, &configErr):
+		return EXIT_VALIDATION_ERROR
+	case errors.As(err, &authErr):
+		return EXIT_AUTH_FAILURE
+	case errors.Is(err, ErrBudgetExceeded):
+		return EXIT_BUDGET_EXCEEDED
+	case errors.Is(err, ErrRunLocked):
+		return EXIT_RUN_LOCKED
+	case errors.Is(err, context.Canceled):
+		return EXIT_CANCELED
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return EXIT_TIMEOUT
+	default:
+		return EXIT_PARTIAL_FAILURE
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FetchConfig struct {
+	Username string `yaml:"username,omitempty" json:"username,omitempty"` // defaults to the url user, then "anonymous" for ftp
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+}
+
+// FileFetcher retrieves a remote file for fetch steps.
+// Fetchers are registered per url scheme; ftp is built in, sftp has to be provided
+// by the embedding application with SetFileFetcher (e.g. using github.com/pkg/sftp),
+// which also owns the key and host key settings. Runs with sftp fetch steps and no
+// sftp fetcher fail before the first step.
+type FileFetcher interface {
+	Fetch(ctx context.Context, u *url.URL, auth FetchConfig) (io.ReadCloser, error)
+}
+
+func (a *ApiCrawler) SetFileFetcher(scheme string, fetcher FileFetcher) {
+	a.fileFetchers[scheme] = fetcher
+}
+
+// handleFetch downloads a file over ftp/sftp and decodes it with request.responseFormat.
+func (c *ApiCrawler) handleFetch(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Fetch] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	_url, err := c.renderURL(exec.step.Request.URL, c.templateData(exec, templateCtx))
+	if err != nil {
+		return err
+	}
+	urlObj, err := url.Parse(_url)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %w", _url, err)
+	}
+
+	fetcher, ok := c.fileFetchers[urlObj.Scheme]
+	if !ok {
+		return fmt.Errorf("no fetcher for scheme '%s', set one with SetFileFetcher", urlObj.Scheme)
+	}
+
+	auth := FetchConfig{}
+	if exec.step.Fetch != nil {
+		auth = *exec.step.Fetch
+	}
+	if auth.Username == "" && urlObj.User != nil {
+		auth.Username = urlObj.User.Username()
+		if auth.Password == "" {
+			auth.Password, _ = urlObj.User.Password()
+		}
+	}
+	// never log credentials
+	logURL := *urlObj
+	logURL.User = nil
+
+	c.logger.Info("[Fetch] %s", logURL.String())
+
+	if err := c.chargeRequest(exec); err != nil {
+		return err
+	}
+	body, err := fetcher.Fetch(ctx, urlObj, auth)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %w", logURL.String(), err)
+	}
+	body = c.meterBody(exec, body)
+	defer body.Close()
+
+	raw, err := c.decodeResponseBody(exec.step.Request, nil, body)
+	if err != nil {
+		return err
+	}
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Fetch '%s'", exec.step.Name), exec, raw, nil, "url", logURL.String())
+
+	transformed, err := c.transformResult(exec, raw, templateCtx, nil)
+	if err != nil {
+		return err
+	}
+	c.pushProfilerDiff("Response Transformation", exec, transformed, raw, "url", logURL.String())
+
+	return c.mergeStepResult(ctx, exec, transformed, nil, "url", logURL.String())
+}
+
+// FTPFetcher is a minimal passive mode FTP client (RFC 959, RFC 2428) retrieving a single file.
+type FTPFetcher struct {
+	Timeout time.Duration
+}
+
+func (f FTPFetcher) Fetch(ctx context.Context, u *url.URL, auth FetchConfig) (io.ReadCloser, error) {
+	timeout := f.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	tp := textproto.NewConn(conn)
+	fail := func(err error) (io.ReadCloser, error) {
+		tp.Close()
+		return nil, err
+	}
+
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fail(fmt.Errorf("ftp greeting: %w", err))
+	}
+
+	user, pass := auth.Username, auth.Password
+	if user == "" {
+		user, pass = "anonymous", "anonymous@"
+	}
+	code, _, err := ftpCmd(tp, 0, "USER %s", user)
+	if err != nil {
+		return fail(err)
+	}
+	if code == 331 {
+		if _, _, err := ftpCmd(tp, 230, "PASS %s", pass); err != nil {
+			return fail(fmt.Errorf("ftp login: %w", err))
+		}
+	} else if code != 230 {
+		return fail(fmt.Errorf("ftp login: unexpected reply %d", code))
+	}
+
+	if _, _, err := ftpCmd(tp, 200, "TYPE I"); err != nil {
+		return fail(err)
+	}
+
+	dataAddr, err := ftpPassive(tp, u.Hostname())
+	if err != nil {
+		return fail(err)
+	}
+	data, err := dialer.DialContext(ctx, "tcp", dataAddr)
+	if err != nil {
+		return fail(fmt.Errorf("ftp data connection: %w", err))
+	}
+
+	path, err := url.PathUnescape(u.EscapedPath())
+	if err != nil {
+		data.Close()
+		return fail(err)
+	}
+	if code, msg, err := ftpCmd(tp, 0, "RETR %s", strings.TrimPrefix(path, "/")); err != nil {
+		data.Close()
+		return fail(err)
+	} else if code != 125 && code != 150 {
+		data.Close()
+		return fail(fmt.Errorf("ftp RETR %s: %d %s", path, code, msg))
+	}
+
+	return &ftpReader{data: data, ctrl: tp}, nil
+}
+
+// ftpCmd sends a command and reads the reply; expectCode 0 accepts any code.
+func ftpCmd(tp *textproto.Conn, expectCode int, format string, args ...any) (int, string, error) {
+	if _, err := tp.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return tp.ReadResponse(expectCode)
+}
+
+// ftpPassive opens a passive data port, trying EPSV first and falling back to PASV.
+func ftpPassive(tp *textproto.Conn, host string) (string, error) {
+	if code, msg, err := ftpCmd(tp, 0, "EPSV"); err == nil && code == 229 {
+		// 229 Entering Extended Passive Mode (|||6446|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start >= 0 && end > start+4 {
+			return net.JoinHostPort(host, msg[start+4:end]), nil
+		}
+	}
+
+	_, msg, err := ftpCmd(tp, 227, "PASV")
+	if err != nil {
+		return "", fmt.Errorf("ftp passive mode: %w", err)
+	}
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("invalid PASV reply: %s", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("invalid PASV reply: %s", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("invalid PASV reply: %s", msg)
+	}
+	// the advertised address is ignored, servers behind NAT often report a private one
+	return net.JoinHostPort(host, strconv.Itoa(p1*256+p2)), nil
+}
+
+// ftpReader streams the data connection and finishes the transfer on Close.
+type ftpReader struct {
+	data net.Conn
+	ctrl *textproto.Conn
+}
+
+func (r *ftpReader) Read(p []byte) (int, error) {
+	return r.data.Read(p)
+}
+
+func (r *ftpReader) Close() error {
+	r.data.Close()
+	defer r.ctrl.Close()
+	if _, _, err := r.ctrl.ReadResponse(226); err != nil {
+		return err
+	}
+	ftpCmd(r.ctrl, 0, "QUIT")
+	return nil
+}
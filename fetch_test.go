@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveFTP runs a single session of a scripted FTP server serving one file.
+func serveFTP(t *testing.T, ln net.Listener, file string, commands chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer data.Close()
+	dataPort := data.Addr().(*net.TCPAddr).Port
+
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220-welcome\r\n220 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		commands <- cmd
+		switch {
+		case strings.HasPrefix(cmd, "USER"):
+			fmt.Fprint(conn, "331 password required\r\n")
+		case strings.HasPrefix(cmd, "PASS"):
+			fmt.Fprint(conn, "230 logged in\r\n")
+		case cmd == "TYPE I":
+			fmt.Fprint(conn, "200 binary\r\n")
+		case cmd == "EPSV":
+			fmt.Fprint(conn, "500 not supported\r\n")
+		case cmd == "PASV":
+			fmt.Fprintf(conn, "227 Entering Passive Mode (10,0,0,1,%d,%d)\r\n", dataPort/256, dataPort%256)
+		case strings.HasPrefix(cmd, "RETR"):
+			dc, err := data.Accept()
+			require.NoError(t, err)
+			fmt.Fprint(conn, "150 opening data connection\r\n")
+			io.WriteString(dc, file)
+			dc.Close()
+			fmt.Fprint(conn, "226 transfer complete\r\n")
+		case cmd == "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			close(commands)
+			return
+		}
+	}
+}
+
+func TestFTPFetcher(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	commands := make(chan string, 16)
+	go serveFTP(t, ln, `{"stations": [1, 2]}`, commands)
+
+	u, _ := url.Parse(fmt.Sprintf("ftp://%s/pub/stations%%20list.json", ln.Addr().String()))
+	body, err := FTPFetcher{}.Fetch(context.TODO(), u, FetchConfig{Username: "noi", Password: "secret"})
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	assert.Equal(t, `{"stations": [1, 2]}`, string(content))
+
+	var sent []string
+	for cmd := range commands {
+		sent = append(sent, cmd)
+	}
+	assert.Equal(t, []string{"USER noi", "PASS secret", "TYPE I", "EPSV", "PASV", "RETR pub/stations list.json", "QUIT"}, sent)
+}
@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	RESPONSE_FORMAT_JSON = "json"
+	RESPONSE_FORMAT_TEXT = "text"
+	RESPONSE_FORMAT_CSV  = "csv"
+	RESPONSE_FORMAT_HTML = "html"
+)
+
+var responseFormats = []string{RESPONSE_FORMAT_JSON, RESPONSE_FORMAT_TEXT, RESPONSE_FORMAT_CSV, RESPONSE_FORMAT_HTML}
+
+const (
+	EMPTY_BODY_NULL  = "null"
+	EMPTY_BODY_ERROR = "error"
+)
+
+// decodeResponseBody transcodes a response payload to UTF-8 and decodes it according to
+// request.responseFormat. Every step reading a body (request, fetch, ...) goes through it;
+// header is nil for non HTTP sources. A Content-Type charset the crawler does not know is
+// logged and the body decoded as it is: only request.responseCharset is validated.
+func (c *ApiCrawler) decodeResponseBody(reqConfig *RequestConfig, header http.Header, body io.Reader) (any, error) {
+	charset := responseCharset(reqConfig, header)
+	if reqConfig.ResponseCharset == "" && !isSupportedCharset(charset) {
+		c.logger.Warning("[Response] unsupported charset %s in Content-Type, body decoded as it is", charset)
+		charset = ""
+	}
+	body, err := transcodeToUTF8(charset, body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reqConfig.ResponseFormat {
+	case "", RESPONSE_FORMAT_JSON:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		raw, err := reqConfig.decodeJSON(data)
+		if err != nil {
+			// 204 and empty 200 responses, e.g. of trigger endpoints
+			if errors.Is(err, io.EOF) && reqConfig.EmptyBody != EMPTY_BODY_ERROR {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("error decoding JSON: %w", err)
+		}
+		return raw, nil
+	case RESPONSE_FORMAT_TEXT:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		return string(data), nil
+	case RESPONSE_FORMAT_CSV:
+		return reqConfig.decodeCSV(body)
+	case RESPONSE_FORMAT_HTML:
+		return reqConfig.decodeHTML(body)
+	default:
+		return nil, fmt.Errorf("unknown response format: %s", reqConfig.ResponseFormat)
+	}
+}
+
+// decodeJSON decodes a JSON payload with the tolerantJson and preciseNumbers options of
+// the request. Precise numbers are decoded as json.Number, which jq turns into integers
+// (big ones included) instead of float64.
+func (r *RequestConfig) decodeJSON(data []byte) (any, error) {
+	if r != nil && r.TolerantJSON {
+		data = sanitizeJSON(data)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if r != nil && r.PreciseNumbers {
+		decoder.UseNumber()
+	}
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// sanitizeJSON turns the JSON dialect of sloppy upstreams into valid JSON: comments and
+// trailing commas are removed, NaN and Infinity become null. Strings are left untouched.
+func sanitizeJSON(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		switch {
+		case ch == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end, len(data)-1)
+			out = append(out, data[i:end+1]...)
+			i = end
+		case ch == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case ch == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		case ch == ',' && closesAfterComma(data[i+1:]):
+		case bytes.HasPrefix(data[i:], []byte("NaN")):
+			out = append(out, "null"...)
+			i += len("NaN") - 1
+		case bytes.HasPrefix(data[i:], []byte("Infinity")):
+			out = append(out, "null"...)
+			i += len("Infinity") - 1
+		case (ch == '-' || ch == '+') && bytes.HasPrefix(data[i+1:], []byte("Infinity")):
+			out = append(out, "null"...)
+			i += len("Infinity")
+		default:
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// closesAfterComma reports whether an object or array closes after a comma, skipping
+// whitespace and comments.
+func closesAfterComma(rest []byte) bool {
+	for i := 0; i < len(rest); i++ {
+		switch {
+		case rest[i] == ' ' || rest[i] == '\t' || rest[i] == '\n' || rest[i] == '\r':
+		case rest[i] == '/' && i+1 < len(rest) && rest[i+1] == '/':
+			for i < len(rest) && rest[i] != '\n' {
+				i++
+			}
+		case rest[i] == '/' && i+1 < len(rest) && rest[i+1] == '*':
+			end := bytes.Index(rest[i+2:], []byte("*/"))
+			if end < 0 {
+				return false
+			}
+			i += end + 3
+		default:
+			return rest[i] == '}' || rest[i] == ']'
+		}
+	}
+	return false
+}
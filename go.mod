@@ -3,9 +3,12 @@ module github.com/noi-techpark/go-apigorowler
 go 1.24.4
 
 require (
+	github.com/PuerkitoBio/goquery v1.10.3
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/expr-lang/expr v1.17.5
 	github.com/itchyny/gojq v0.12.17
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/net v0.39.0
 	golang.org/x/oauth2 v0.30.0
 	gopkg.in/yaml.v3 v3.0.1
 )
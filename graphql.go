@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// GraphQLConfig sends the request as a GraphQL operation: the body is the JSON payload
+// {"query", "variables", "operationName"}.
+type GraphQLConfig struct {
+	Query         string `yaml:"query" json:"query"`
+	Variables     string `yaml:"variables,omitempty" json:"variables,omitempty"` // go template rendering a JSON object
+	OperationName string `yaml:"operationName,omitempty" json:"operationName,omitempty"`
+	// PageInfo is the jq path of a relay pageInfo object in the responses, e.g.
+	// .data.stations.pageInfo: its endCursor is sent as the CursorVariable of the next page
+	// until hasNextPage is false
+	PageInfo       string `yaml:"pageInfo,omitempty" json:"pageInfo,omitempty"`
+	CursorVariable string `yaml:"cursorVariable,omitempty" json:"cursorVariable,omitempty"` // default after
+}
+
+func (g *GraphQLConfig) cursorVariable() string {
+	if g.CursorVariable == "" {
+		return "after"
+	}
+	return g.CursorVariable
+}
+
+// pagination returns the pagination of the request, completed with the cursor param and the
+// stop condition of a GraphQL pageInfo.
+func (r *RequestConfig) pagination() Pagination {
+	p := r.Pagination
+	if r.GraphQL == nil || r.GraphQL.PageInfo == "" {
+		return p
+	}
+	p.Params = append(append([]Param{}, p.Params...), Param{
+		Name:     r.GraphQL.cursorVariable(),
+		Location: "body",
+		Type:     "dynamic",
+		Source:   "body:" + r.GraphQL.PageInfo + ".endCursor",
+	})
+	p.StopOn = append(append([]StopCondition{}, p.StopOn...), StopCondition{
+		Type:       "responseBody",
+		Expression: "(" + r.GraphQL.PageInfo + ".hasNextPage | not)",
+	})
+	return p
+}
+
+// buildGraphQLBody renders the GraphQL payload, the body pagination params being variables.
+func (c *ApiCrawler) buildGraphQLBody(cfg *GraphQLConfig, templateCtx map[string]any, next *RequestParts) (io.Reader, error) {
+	variables := map[string]any{}
+	if strings.TrimSpace(cfg.Variables) != "" {
+		tmpl, err := c.getOrCompileTextTemplate(cfg.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("error getting/compiling graphql variables template: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, templateCtx); err != nil {
+			return nil, fmt.Errorf("error executing graphql variables template: %w", err)
+		}
+		if err := json.Unmarshal(buf.Bytes(), &variables); err != nil {
+			return nil, fmt.Errorf("graphql variables must render a JSON object: %w", err)
+		}
+	}
+	for k, v := range next.BodyParams {
+		variables[k] = v
+	}
+
+	payload := map[string]any{"query": cfg.Query, "variables": variables}
+	if cfg.OperationName != "" {
+		payload["operationName"] = cfg.OperationName
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding graphql payload: %w", err)
+	}
+	return bytes.NewReader(body), nil
+}
+
+// checkGraphQLErrors fails on a response carrying errors and no data, the errors of a partial
+// response are logged.
+func (c *ApiCrawler) checkGraphQLErrors(exec *stepExecution, _url string, raw any) error {
+	body, ok := raw.(map[string]any)
+	if !ok {
+		return nil
+	}
+	errs, _ := body["errors"].([]any)
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(errs))
+	for _, e := range errs {
+		if m, ok := e.(map[string]any); ok && m["message"] != nil {
+			messages = append(messages, fmt.Sprint(m["message"]))
+		} else {
+			data, _ := json.Marshal(e)
+			messages = append(messages, string(data))
+		}
+	}
+	if body["data"] == nil {
+		return &GraphQLError{Step: exec.path, URL: _url, Messages: messages}
+	}
+	c.logger.Warning("[Request] %s partial graphql response: %s", exec.path, strings.Join(messages, "; "))
+	return nil
+}
+
+func validateGraphQL(req RequestConfig, location string) []ValidationError {
+	var errs []ValidationError
+	cfg := req.GraphQL
+	if strings.TrimSpace(cfg.Query) == "" {
+		errs = append(errs, ValidationError{"graphql.query is required", location + ".query"})
+	}
+	if req.Body != "" || req.BodyBase64 != "" || req.BodyFile != "" {
+		errs = append(errs, ValidationError{"graphql builds the body, request.body, bodyBase64 and bodyFile can not be set", location})
+	}
+	if cfg.PageInfo != "" {
+		if _, err := gojq.Parse(cfg.PageInfo); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid graphql.pageInfo path: %v", err), location + ".pageInfo"})
+		}
+	} else if cfg.CursorVariable != "" {
+		errs = append(errs, ValidationError{"graphql.cursorVariable requires graphql.pageInfo", location + ".cursorVariable"})
+	}
+	return errs
+}
@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type GRPCConfig struct {
+	Target    string            `yaml:"target" json:"target"` // host:port
+	Service   string            `yaml:"service" json:"service"`
+	Method    string            `yaml:"method" json:"method"`
+	Plaintext bool              `yaml:"plaintext,omitempty" json:"plaintext,omitempty"`
+	Request   string            `yaml:"request,omitempty" json:"request,omitempty"` // go template rendering the JSON request message
+	Metadata  map[string]string `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+}
+
+// GRPCCall is a unary call with a JSON mapped request message.
+type GRPCCall struct {
+	Target    string
+	Service   string
+	Method    string
+	Plaintext bool
+	Metadata  map[string]string
+	Request   []byte
+}
+
+// GRPCInvoker performs unary gRPC calls, returning the JSON mapped response message.
+// The module does not ship a gRPC transport to keep its dependencies small;
+// embedders provide one (e.g. built on grpcurl/grpcdynamic) with SetGRPCInvoker,
+// resolving the methods with the descriptors or the server reflection they choose.
+// Runs with grpc steps and no invoker fail before the first step.
+type GRPCInvoker interface {
+	Invoke(ctx context.Context, call GRPCCall) ([]byte, error)
+}
+
+func (a *ApiCrawler) SetGRPCInvoker(invoker GRPCInvoker) {
+	a.grpcInvoker = invoker
+}
+
+func (c *ApiCrawler) handleGRPC(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[gRPC] Preparing %s", exec.step.Name)
+
+	if c.grpcInvoker == nil {
+		return fmt.Errorf("step '%s' requires a gRPC invoker, set one with SetGRPCInvoker", exec.step.Name)
+	}
+
+	cfg := exec.step.GRPC
+	templateCtx := c.templateContext(exec)
+
+	request := []byte("{}")
+	if cfg.Request != "" {
+		tmpl, err := c.getOrCompileTextTemplate(cfg.Request)
+		if err != nil {
+			return fmt.Errorf("error getting/compiling grpc request template: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, c.templateData(exec, templateCtx)); err != nil {
+			return fmt.Errorf("error executing grpc request template: %w", err)
+		}
+		request = buf.Bytes()
+	}
+	if !json.Valid(request) {
+		return fmt.Errorf("grpc request of step '%s' is not valid JSON: %s", exec.step.Name, string(request))
+	}
+
+	fullMethod := fmt.Sprintf("%s/%s", cfg.Service, cfg.Method)
+	c.logger.Info("[gRPC] %s %s", cfg.Target, fullMethod)
+
+	if err := c.chargeRequest(exec); err != nil {
+		return err
+	}
+	response, err := c.grpcInvoker.Invoke(ctx, GRPCCall{
+		Target:    cfg.Target,
+		Service:   cfg.Service,
+		Method:    cfg.Method,
+		Plaintext: cfg.Plaintext,
+		Metadata:  cfg.Metadata,
+		Request:   request,
+	})
+	if err != nil {
+		return fmt.Errorf("grpc call %s failed: %w", fullMethod, err)
+	}
+	if err := c.chargeBytes(exec, len(response)); err != nil {
+		return err
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(response, &raw); err != nil {
+		return fmt.Errorf("error decoding grpc response: %w", err)
+	}
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("gRPC '%s'", exec.step.Name), exec, raw, nil, "target", cfg.Target, "method", fullMethod)
+
+	transformed, err := c.transformResult(exec, raw, templateCtx, nil)
+	if err != nil {
+		return err
+	}
+	c.pushProfilerDiff("Response Transformation", exec, transformed, raw, "target", cfg.Target, "method", fullMethod)
+
+	return c.mergeStepResult(ctx, exec, transformed, nil, "target", cfg.Target, "method", fullMethod)
+}
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HeaderValue is the value of a request header, a string or a list of strings sending the
+// header once per value:
+//
+//	headers:
+//	  Accept: application/json
+//	  X-Tag: [bikes, parking]
+type HeaderValue []string
+
+func (h *HeaderValue) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var value string
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		*h = HeaderValue{value}
+		return nil
+	}
+	return node.Decode((*[]string)(h))
+}
+
+func (h HeaderValue) MarshalYAML() (any, error) {
+	if len(h) == 1 {
+		return h[0], nil
+	}
+	return []string(h), nil
+}
+
+func (h *HeaderValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*h = HeaderValue{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(h))
+}
+
+func (h HeaderValue) MarshalJSON() ([]byte, error) {
+	if len(h) == 1 {
+		return json.Marshal(h[0])
+	}
+	return json.Marshal([]string(h))
+}
+
+// setHeaderValues sets the header name to its rendered values. Values may be go-templates
+// rendered against templateCtx, the ones rendering empty are left out; without any value left
+// the header is not sent.
+func (c *ApiCrawler) setHeaderValues(req *http.Request, name string, values HeaderValue, templateCtx map[string]any) error {
+	req.Header.Del(name)
+	for _, v := range values {
+		if !isHeaderTemplate(v) {
+			value, err := c.secretValue(v)
+			if err != nil {
+				return fmt.Errorf("header %s: %w", name, err)
+			}
+			req.Header.Add(name, value)
+			continue
+		}
+		tmpl, err := c.getOrCompileTextTemplate(v)
+		if err != nil {
+			return fmt.Errorf("error getting/compiling header %s template: %w", name, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, templateCtx); err != nil {
+			return fmt.Errorf("error executing header %s template: %w", name, err)
+		}
+		if value := strings.TrimSpace(buf.String()); value != "" {
+			req.Header.Add(name, value)
+		}
+	}
+	return nil
+}
+
+// sensitiveHeaders are left out of the profiler events, with the headers set from secrets.
+// X-Amz-Security-Token carries the AWS session token of the aws_sigv4 authentication.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Amz-Security-Token"}
+
+// profiledHeaders returns a copy of headers for the profiler events, every value of a repeated
+// header kept. The credentials are redacted.
+func (c *ApiCrawler) profiledHeaders(headers http.Header, reqConfig *RequestConfig) map[string][]string {
+	secret := map[string]bool{}
+	for _, name := range sensitiveHeaders {
+		secret[name] = true
+	}
+	for name, value := range c.Config.Headers {
+		if strings.HasPrefix(value, SECRET_REF_PREFIX) {
+			secret[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	for _, host := range c.Config.Hosts {
+		for name, value := range host.Headers {
+			if strings.HasPrefix(value, SECRET_REF_PREFIX) {
+				secret[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	for name, values := range reqConfig.Headers {
+		for _, value := range values {
+			if strings.HasPrefix(value, SECRET_REF_PREFIX) {
+				secret[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+
+	profiled := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		if secret[http.CanonicalHeaderKey(name)] {
+			profiled[name] = []string{"[redacted]"}
+			continue
+		}
+		profiled[name] = append([]string{}, values...)
+	}
+	return profiled
+}
+
+func validateHeaderValues(headers map[string]HeaderValue, location string) []ValidationError {
+	var errs []ValidationError
+	for _, name := range sortedKeys(headers) {
+		values := headers[name]
+		if len(values) == 0 {
+			errs = append(errs, ValidationError{"header requires a value", location + "." + name})
+		}
+		for i, value := range values {
+			valueLocation := location + "." + name
+			if len(values) > 1 {
+				valueLocation = fmt.Sprintf("%s[%d]", valueLocation, i)
+			}
+			if !isHeaderTemplate(value) {
+				continue
+			}
+			if _, err := templateRootNames(value); err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid header template: %v", err), valueLocation})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostConfig holds the politeness settings applied to every request to the matching hosts,
+// whichever step issues it.
+type HostConfig struct {
+	RateLimit      float64           `yaml:"rateLimit,omitempty" json:"rateLimit,omitempty"`           // requests per second
+	DelayMs        int               `yaml:"delayMs,omitempty" json:"delayMs,omitempty"`               // minimum time between the start of two requests
+	MaxConcurrency int               `yaml:"maxConcurrency,omitempty" json:"maxConcurrency,omitempty"` // requests in flight
+	Headers        map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Proxy          string            `yaml:"proxy,omitempty" json:"proxy,omitempty"` // e.g. http://proxy.local:3128, overrides the configuration proxy
+	// RateLimitHeaders paces the requests by the quota the host announces in its responses
+	RateLimitHeaders *RateLimitHeadersConfig `yaml:"rateLimitHeaders,omitempty" json:"rateLimitHeaders,omitempty"`
+}
+
+const (
+	RATE_LIMIT_RESET_AUTO    = "auto"
+	RATE_LIMIT_RESET_SECONDS = "seconds"
+	RATE_LIMIT_RESET_UNIX    = "unix"
+)
+
+// RateLimitHeadersConfig names the response headers holding the remaining quota of a host
+// and the time it resets.
+type RateLimitHeadersConfig struct {
+	Remaining    string `yaml:"remaining,omitempty" json:"remaining,omitempty"`       // default X-RateLimit-Remaining
+	Reset        string `yaml:"reset,omitempty" json:"reset,omitempty"`               // default X-RateLimit-Reset
+	ResetFormat  string `yaml:"resetFormat,omitempty" json:"resetFormat,omitempty"`   // auto (default) | seconds | unix
+	MinRemaining int    `yaml:"minRemaining,omitempty" json:"minRemaining,omitempty"` // quota kept for other clients, default 0
+}
+
+// rateLimitResetUnixThreshold tells delta seconds from unix timestamps in the auto format.
+const rateLimitResetUnixThreshold = 1_000_000_000
+
+// pace spreads the remaining quota announced by resp until its reset, returning how long the
+// next request has to wait; with the quota at minRemaining the next request waits for the reset.
+func (p *hostPolicy) pace(resp *http.Response, now time.Time) time.Duration {
+	cfg := p.cfg.RateLimitHeaders
+	if cfg == nil {
+		return 0
+	}
+	remainingHeader, resetHeader := cfg.Remaining, cfg.Reset
+	if remainingHeader == "" {
+		remainingHeader = "X-RateLimit-Remaining"
+	}
+	if resetHeader == "" {
+		resetHeader = "X-RateLimit-Reset"
+	}
+	remaining, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get(remainingHeader)))
+	if err != nil {
+		return 0
+	}
+	reset, err := strconv.ParseFloat(strings.TrimSpace(resp.Header.Get(resetHeader)), 64)
+	if err != nil || reset < 0 {
+		return 0
+	}
+	var resetAt time.Time
+	switch {
+	case cfg.ResetFormat == RATE_LIMIT_RESET_UNIX,
+		cfg.ResetFormat != RATE_LIMIT_RESET_SECONDS && reset >= rateLimitResetUnixThreshold:
+		resetAt = time.Unix(0, int64(reset*float64(time.Second)))
+	default:
+		resetAt = now.Add(time.Duration(reset * float64(time.Second)))
+	}
+	window := resetAt.Sub(now)
+	if window <= 0 {
+		return 0
+	}
+
+	next := resetAt
+	if available := remaining - cfg.MinRemaining; available > 0 {
+		next = now.Add(window / time.Duration(available))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if next.After(p.nextAllowed) {
+		p.nextAllowed = next
+	}
+	return p.nextAllowed.Sub(now)
+}
+
+// hostPolicy is the runtime state of a hosts entry.
+type hostPolicy struct {
+	pattern  string
+	cfg      HostConfig
+	interval time.Duration
+	slots    chan struct{}
+
+	mu          sync.Mutex
+	nextAllowed time.Time
+}
+
+// newHostPolicies orders the hosts entries from the most specific pattern:
+// exact hosts first, then wildcards by decreasing length.
+func newHostPolicies(hosts map[string]HostConfig) []*hostPolicy {
+	policies := make([]*hostPolicy, 0, len(hosts))
+	for pattern, cfg := range hosts {
+		p := &hostPolicy{pattern: strings.ToLower(pattern), cfg: cfg}
+		if cfg.RateLimit > 0 {
+			p.interval = time.Duration(float64(time.Second) / cfg.RateLimit)
+		}
+		p.interval = max(p.interval, time.Duration(cfg.DelayMs)*time.Millisecond)
+		if cfg.MaxConcurrency > 0 {
+			p.slots = make(chan struct{}, cfg.MaxConcurrency)
+		}
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		wi, wj := strings.Contains(policies[i].pattern, "*"), strings.Contains(policies[j].pattern, "*")
+		if wi != wj {
+			return !wi
+		}
+		if len(policies[i].pattern) != len(policies[j].pattern) {
+			return len(policies[i].pattern) > len(policies[j].pattern)
+		}
+		return policies[i].pattern < policies[j].pattern
+	})
+	return policies
+}
+
+// hostPolicy returns the hosts entry matching u, if any.
+func (c *ApiCrawler) hostPolicy(u *url.URL) *hostPolicy {
+	host := strings.ToLower(u.Hostname())
+	for _, p := range c.hostPolicies {
+		if ok, _ := path.Match(p.pattern, host); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// wait blocks until a request may be sent to the host, returning the function releasing
+// the concurrency slot. Slots are released once the response headers arrived: steps keep
+// bodies open while running their nested steps and following pages.
+func (p *hostPolicy) wait(ctx context.Context) (func(), error) {
+	release := func() {}
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-p.slots }
+	}
+
+	if p.interval > 0 || p.cfg.RateLimitHeaders != nil {
+		p.mu.Lock()
+		now := time.Now()
+		start := now
+		if p.nextAllowed.After(now) {
+			start = p.nextAllowed
+		}
+		p.nextAllowed = start.Add(p.interval)
+		p.mu.Unlock()
+
+		if delay := start.Sub(now); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				release()
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostPolicyMatching(t *testing.T) {
+	c := &ApiCrawler{hostPolicies: newHostPolicies(map[string]HostConfig{
+		"*":               {DelayMs: 1},
+		"*.example.com":   {DelayMs: 2},
+		"api.example.com": {DelayMs: 3},
+	})}
+
+	match := func(rawURL string) int {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		p := c.hostPolicy(u)
+		require.NotNil(t, p)
+		return p.cfg.DelayMs
+	}
+
+	assert.Equal(t, 3, match("https://API.example.com:8443/v1"))
+	assert.Equal(t, 2, match("https://www.example.com/"))
+	assert.Equal(t, 2, match("https://a.b.example.com/"))
+	assert.Equal(t, 1, match("https://example.org/"))
+
+	c = &ApiCrawler{hostPolicies: newHostPolicies(map[string]HostConfig{"api.example.com": {}})}
+	u, _ := url.Parse("https://example.com/")
+	assert.Nil(t, c.hostPolicy(u))
+}
+
+func TestHostPolicyPacing(t *testing.T) {
+	now := time.Now()
+	response := func(remaining, reset string) *http.Response {
+		return &http.Response{Header: http.Header{"X-Ratelimit-Remaining": {remaining}, "X-Ratelimit-Reset": {reset}}}
+	}
+	policy := func(cfg RateLimitHeadersConfig) *hostPolicy {
+		return newHostPolicies(map[string]HostConfig{"api.example.com": {RateLimitHeaders: &cfg}})[0]
+	}
+
+	// the remaining quota is spread until the reset
+	assert.Equal(t, 2*time.Second, policy(RateLimitHeadersConfig{}).pace(response("10", "20"), now))
+	// exhausted quota, the reset being a unix timestamp
+	reset := strconv.FormatInt(now.Add(30*time.Second).Unix(), 10)
+	wait := policy(RateLimitHeadersConfig{}).pace(response("0", reset), now)
+	assert.InDelta(t, 30*time.Second, wait, float64(time.Second))
+	// the quota kept for other clients
+	assert.Equal(t, 20*time.Second, policy(RateLimitHeadersConfig{MinRemaining: 5}).pace(response("5", "20"), now))
+	assert.Equal(t, 10*time.Second, policy(RateLimitHeadersConfig{MinRemaining: 5}).pace(response("7", "20"), now))
+	// custom headers and formats
+	custom := policy(RateLimitHeadersConfig{Remaining: "RateLimit-Remaining", Reset: "RateLimit-Reset", ResetFormat: RATE_LIMIT_RESET_SECONDS})
+	assert.Equal(t, 4*time.Second, custom.pace(&http.Response{Header: http.Header{"Ratelimit-Remaining": {"1"}, "Ratelimit-Reset": {"4"}}}, now))
+	// a later, shorter pace keeps the longer wait
+	assert.Equal(t, 4*time.Second, custom.pace(&http.Response{Header: http.Header{"Ratelimit-Remaining": {"100"}, "Ratelimit-Reset": {"4"}}}, now))
+	// no or malformed headers, no pacing
+	assert.Zero(t, policy(RateLimitHeadersConfig{}).pace(&http.Response{Header: http.Header{}}, now))
+	assert.Zero(t, policy(RateLimitHeadersConfig{}).pace(response("x", "20"), now))
+	assert.Zero(t, newHostPolicies(map[string]HostConfig{"*": {}})[0].pace(response("0", "20"), now))
+
+	// the next request waits
+	p := policy(RateLimitHeadersConfig{})
+	p.pace(response("1", "0.2"), time.Now())
+	start := time.Now()
+	release, err := p.wait(context.Background())
+	require.NoError(t, err)
+	release()
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// HTMLConfig maps the CSS selectors of a responseFormat html page to fields.
+// A field is a selector whose first match yields its text, or an attribute with
+// "selector @attr"; "@attr" alone reads the attribute of the item itself.
+type HTMLConfig struct {
+	Items  string            `yaml:"items,omitempty" json:"items,omitempty"`   // selector of the items, the result is an array with one object per match
+	Fields map[string]string `yaml:"fields,omitempty" json:"fields,omitempty"` // first match of the selector, null without match
+	Lists  map[string]string `yaml:"lists,omitempty" json:"lists,omitempty"`   // every match of the selector, as an array
+}
+
+// decodeHTML parses an HTML page and extracts the html.fields and html.lists, for each
+// html.items match when set. The page is parsed like browsers do (goquery, on the HTML5
+// parser of golang.org/x/net/html), e.g. the rows of a table land in its implied <tbody>.
+func (r *RequestConfig) decodeHTML(body io.Reader) (any, error) {
+	cfg := r.HTML
+	if cfg == nil {
+		return nil, errors.New("responseFormat html requires request.html")
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing html: %w", err)
+	}
+
+	if cfg.Items == "" {
+		return cfg.extract(doc.Selection)
+	}
+	items, err := cascadia.Compile(cfg.Items)
+	if err != nil {
+		return nil, err
+	}
+	results := []any{}
+	for _, item := range doc.FindMatcher(items).EachIter() {
+		result, err := cfg.extract(item)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (cfg *HTMLConfig) extract(scope *goquery.Selection) (map[string]any, error) {
+	result := map[string]any{}
+	for name, field := range cfg.Fields {
+		sel, attr, err := parseHTMLField(field)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = nil
+		if sel == nil {
+			result[name] = htmlAttr(scope, attr)
+		} else if matches := scope.FindMatcher(sel); matches.Length() > 0 {
+			result[name] = htmlValue(matches.First(), attr)
+		}
+	}
+	for name, field := range cfg.Lists {
+		sel, attr, err := parseHTMLField(field)
+		if err != nil {
+			return nil, err
+		}
+		values := []any{}
+		for _, match := range scope.FindMatcher(sel).EachIter() {
+			values = append(values, htmlValue(match, attr))
+		}
+		result[name] = values
+	}
+	return result, nil
+}
+
+func htmlAttr(s *goquery.Selection, attr string) any {
+	if v, ok := s.Attr(attr); ok {
+		return v
+	}
+	return nil
+}
+
+// htmlValue is the text of the element, whitespace collapsed, or the attribute when given.
+// Unlike Selection.Text, the text nodes are separated and scripts and styles left out.
+func htmlValue(s *goquery.Selection, attr string) any {
+	if attr != "" {
+		return htmlAttr(s, attr)
+	}
+	var text strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+			text.WriteString(" ")
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style"):
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, n := range s.Nodes {
+		walk(n)
+	}
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// parseHTMLField splits "selector @attr" into its selector, nil when empty, and attribute.
+func parseHTMLField(field string) (goquery.Matcher, string, error) {
+	field = strings.TrimSpace(field)
+	attr := ""
+	if i := strings.LastIndex(field, "@"); i >= 0 && !strings.ContainsAny(field[i:], "]\"'") {
+		field, attr = strings.TrimSpace(field[:i]), strings.TrimSpace(field[i+1:])
+		if attr == "" {
+			return nil, "", errors.New("missing attribute name after @")
+		}
+	}
+	if field == "" {
+		if attr == "" {
+			return nil, "", errors.New("empty selector")
+		}
+		return nil, attr, nil
+	}
+	sel, err := cascadia.Compile(field)
+	if err != nil {
+		return nil, "", err
+	}
+	return sel, attr, nil
+}
+
+// validateHTMLFormat checks the html selectors of a request, location being the request one.
+func validateHTMLFormat(req *RequestConfig, location string) []ValidationError {
+	switch {
+	case req.HTML != nil:
+		return validateHTML(req.HTML, location+".html")
+	case req.ResponseFormat == RESPONSE_FORMAT_HTML:
+		return []ValidationError{{"responseFormat html requires request.html", location + ".html"}}
+	}
+	return nil
+}
+
+func validateHTML(cfg *HTMLConfig, location string) []ValidationError {
+	var errs []ValidationError
+	if cfg.Items != "" {
+		if _, err := cascadia.Compile(cfg.Items); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid html.items selector: %s", err.Error()), location + ".items"})
+		}
+	}
+	if len(cfg.Fields) == 0 && len(cfg.Lists) == 0 {
+		errs = append(errs, ValidationError{"html requires fields or lists", location})
+	}
+	for _, group := range []struct {
+		name   string
+		fields map[string]string
+	}{{"fields", cfg.Fields}, {"lists", cfg.Lists}} {
+		names := make([]string, 0, len(group.fields))
+		for name := range group.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sel, attr, err := parseHTMLField(group.fields[name])
+			switch {
+			case err != nil:
+			case sel == nil && group.name == "lists":
+				err = errors.New("lists require a selector")
+			case sel == nil && cfg.Items == "":
+				err = fmt.Errorf("@%s without selector requires html.items", attr)
+			}
+			if err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid html.%s selector: %s", group.name, err.Error()), location + "." + group.name + "." + name})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+
+// IdempotencyKeyConfig attaches a key to the requests of a step, for APIs deduplicating
+// retried requests by key. The key is stable for the same item and page within a run, so the
+// retries of a request, e.g. on 429 or 503 with adaptive concurrency, send the same key.
+type IdempotencyKeyConfig struct {
+	Header string `yaml:"header,omitempty" json:"header,omitempty"` // default Idempotency-Key
+	// Key is a go-template identifying the item, e.g. {{ .station.id }}; by default the item is
+	// identified by its position in the enclosing forEach steps
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+}
+
+func (k *IdempotencyKeyConfig) header() string {
+	if k.Header != "" {
+		return k.Header
+	}
+	return IDEMPOTENCY_KEY_HEADER
+}
+
+// setIdempotencyKey sets the idempotency key header of a page request: a UUID formatted hash
+// of the run id, the step, the item and the page.
+func (c *ApiCrawler) setIdempotencyKey(exec *stepExecution, req *http.Request, templateData map[string]any, page int) error {
+	cfg := exec.step.Request.IdempotencyKey
+	item := idempotencyItem(exec)
+	if cfg.Key != "" {
+		tmpl, err := c.getOrCompileTextTemplate(cfg.Key)
+		if err != nil {
+			return fmt.Errorf("error compiling idempotencyKey.key template: %w", err)
+		}
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, templateData); err != nil {
+			return fmt.Errorf("error executing idempotencyKey.key template: %w", err)
+		}
+		item = buf.String()
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{c.runID, exec.path, item, fmt.Sprint(page)}, "\x00")))
+	h := hex.EncodeToString(sum[:16])
+	req.Header.Set(cfg.header(), fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32]))
+	return nil
+}
+
+// idempotencyItem identifies the item of exec by the iteration indexes of the enclosing
+// forEach steps, e.g. "3/0".
+func idempotencyItem(exec *stepExecution) string {
+	var items []string
+	for e := exec; e != nil && e.parent != nil; e = e.parent {
+		items = append([]string{fmt.Sprint(e.item)}, items...)
+	}
+	return strings.Join(items, "/")
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// InterceptorConfig patches the requests sent by steps and their responses, to work around
+// upstream quirks per deployment. An interceptor applies to the requests whose URL starts
+// with URLPrefix and whose method is Method; empty conditions match every request.
+type InterceptorConfig struct {
+	URLPrefix string `yaml:"urlPrefix,omitempty" json:"urlPrefix,omitempty"`
+	Method    string `yaml:"method,omitempty" json:"method,omitempty"`
+	// RewriteURLPrefix replaces URLPrefix in the request URL, e.g. to move to a new base url
+	RewriteURLPrefix string            `yaml:"rewriteUrlPrefix,omitempty" json:"rewriteUrlPrefix,omitempty"`
+	SetHeaders       map[string]string `yaml:"setHeaders,omitempty" json:"setHeaders,omitempty"`
+	RemoveHeaders    []string          `yaml:"removeHeaders,omitempty" json:"removeHeaders,omitempty"`
+	// MapStatus replaces response statuses, e.g. {418: 200}
+	MapStatus map[int]int `yaml:"mapStatus,omitempty" json:"mapStatus,omitempty"`
+}
+
+func (i InterceptorConfig) matches(req *http.Request) bool {
+	if i.Method != "" && !strings.EqualFold(i.Method, req.Method) {
+		return false
+	}
+	return strings.HasPrefix(req.URL.String(), i.URLPrefix)
+}
+
+// interceptRequest applies the request side of the interceptors, in order: every
+// interceptor sees the request as left by the previous ones. It returns the
+// interceptors matching the request, whose response side applies to its response.
+func (c *ApiCrawler) interceptRequest(req *http.Request) ([]InterceptorConfig, error) {
+	var matched []InterceptorConfig
+	for _, interceptor := range c.Config.Interceptors {
+		if !interceptor.matches(req) {
+			continue
+		}
+		matched = append(matched, interceptor)
+
+		if interceptor.RewriteURLPrefix != "" {
+			rewritten := interceptor.RewriteURLPrefix + strings.TrimPrefix(req.URL.String(), interceptor.URLPrefix)
+			u, err := url.Parse(rewritten)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rewritten URL %s: %w", rewritten, err)
+			}
+			c.logger.Debug("[Interceptor] %s rewritten to %s", req.URL.String(), rewritten)
+			req.URL = u
+			req.Host = u.Host
+		}
+		for k, v := range interceptor.SetHeaders {
+			req.Header.Set(k, v)
+		}
+		for _, k := range interceptor.RemoveHeaders {
+			req.Header.Del(k)
+		}
+	}
+	return matched, nil
+}
+
+// interceptResponse applies the response side of the matched interceptors.
+func (c *ApiCrawler) interceptResponse(matched []InterceptorConfig, req *http.Request, resp *http.Response) {
+	for _, interceptor := range matched {
+		if status, ok := interceptor.MapStatus[resp.StatusCode]; ok {
+			c.logger.Debug("[Interceptor] status %d of %s mapped to %d", resp.StatusCode, req.URL.String(), status)
+			resp.StatusCode = status
+			resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		}
+	}
+}
+
+func validateInterceptor(interceptor InterceptorConfig, location string) []ValidationError {
+	var errs []ValidationError
+	if interceptor.RewriteURLPrefix == "" && len(interceptor.SetHeaders) == 0 && len(interceptor.RemoveHeaders) == 0 && len(interceptor.MapStatus) == 0 {
+		errs = append(errs, ValidationError{"interceptor requires one of rewriteUrlPrefix, setHeaders, removeHeaders, mapStatus", location})
+	}
+	if interceptor.Method != "" && !isValidMethod(interceptor.Method) {
+		errs = append(errs, ValidationError{fmt.Sprintf("interceptor method '%s' is not a valid HTTP method token", interceptor.Method), location + ".method"})
+	}
+	if interceptor.RewriteURLPrefix != "" && interceptor.URLPrefix == "" {
+		errs = append(errs, ValidationError{"rewriteUrlPrefix requires urlPrefix", location + ".rewriteUrlPrefix"})
+	}
+	for _, name := range sortedKeys(interceptor.SetHeaders) {
+		if !isToken(name) {
+			errs = append(errs, ValidationError{fmt.Sprintf("'%s' is not a valid header name", name), location + ".setHeaders"})
+		}
+	}
+	for from, to := range interceptor.MapStatus {
+		if from < 100 || from > 599 || to < 100 || to > 599 {
+			errs = append(errs, ValidationError{fmt.Sprintf("mapStatus %d: %d is not a valid HTTP status mapping", from, to), location + ".mapStatus"})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// jqDomainFunctions are the conversions mobility and tourism harvests need over and over,
+// available in every jq rule:
+//
+//	toWGS84(.x; .y; 25832)          -> {"lon": 11.35, "lat": 46.49}
+//	.date | parseItalianDate        -> "2024-03-12T14:30:00+01:00"
+//	.name | normalizeWhitespace     -> "Piazza Walther"
+//	.name | slugify                 -> "piazza-walther"
+var jqDomainFunctions = []gojq.CompilerOption{
+	gojq.WithFunction("toWGS84", 3, 3, func(_ any, args []any) any {
+		x, okX := jqFloat(args[0])
+		y, okY := jqFloat(args[1])
+		epsg, okEPSG := jqFloat(args[2])
+		if !okX || !okY || !okEPSG {
+			return fmt.Errorf("toWGS84: x, y and epsg must be numbers, got %v, %v, %v", args[0], args[1], args[2])
+		}
+		lon, lat, err := toWGS84(x, y, int(epsg))
+		if err != nil {
+			return err
+		}
+		return map[string]any{"lon": lon, "lat": lat}
+	}),
+	gojq.WithFunction("parseItalianDate", 0, 0, func(v any, _ []any) any {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("parseItalianDate: expected a string, got %v", v)
+		}
+		t, err := parseItalianDate(s)
+		if err != nil {
+			return err
+		}
+		return t.Format(time.RFC3339)
+	}),
+	gojq.WithFunction("normalizeWhitespace", 0, 0, func(v any, _ []any) any {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("normalizeWhitespace: expected a string, got %v", v)
+		}
+		return strings.Join(strings.Fields(s), " ")
+	}),
+	gojq.WithFunction("slugify", 0, 0, func(v any, _ []any) any {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("slugify: expected a string, got %v", v)
+		}
+		return slugify(s)
+	}),
+}
+
+func jqFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+)
+
+// toWGS84 converts projected coordinates to WGS84 longitude and latitude. Supported are
+// EPSG:4326 (returned as is), EPSG:3857 (web mercator) and the UTM zones on WGS84
+// (EPSG:326xx north, 327xx south) and ETRS89 (EPSG:258xx, e.g. 25832 for South Tyrol),
+// ETRS89 and WGS84 being within a meter of each other.
+func toWGS84(x, y float64, epsg int) (lon float64, lat float64, err error) {
+	switch {
+	case epsg == 4326:
+		return x, y, nil
+	case epsg == 3857 || epsg == 900913:
+		lon = x / wgs84A * 180 / math.Pi
+		lat = (2*math.Atan(math.Exp(y/wgs84A)) - math.Pi/2) * 180 / math.Pi
+		return lon, lat, nil
+	case epsg >= 32601 && epsg <= 32660:
+		lon, lat = inverseUTM(x, y, epsg-32600, false)
+		return lon, lat, nil
+	case epsg >= 32701 && epsg <= 32760:
+		lon, lat = inverseUTM(x, y, epsg-32700, true)
+		return lon, lat, nil
+	case epsg >= 25828 && epsg <= 25838:
+		lon, lat = inverseUTM(x, y, epsg-25800, false)
+		return lon, lat, nil
+	}
+	return 0, 0, fmt.Errorf("toWGS84: unsupported EPSG code %d", epsg)
+}
+
+// inverseUTM is the inverse transverse mercator projection of a UTM zone (Snyder, USGS 1395).
+func inverseUTM(easting, northing float64, zone int, south bool) (float64, float64) {
+	const k0 = 0.9996
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	x := easting - 500000
+	y := northing
+	if south {
+		y -= 10000000
+	}
+	lon0 := float64((zone-1)*6-180+3) * math.Pi / 180
+
+	m := y / k0
+	mu := m / (wgs84A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin1, cos1, tan1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ep2 * cos1 * cos1
+	t1 := tan1 * tan1
+	n1 := wgs84A / math.Sqrt(1-e2*sin1*sin1)
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*sin1*sin1, 1.5)
+	d := x / (n1 * k0)
+
+	lat := phi1 - (n1*tan1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lon := lon0 + (d-
+		(1+2*t1+c1)*math.Pow(d, 3)/6+
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120)/cos1
+
+	return lon * 180 / math.Pi, lat * 180 / math.Pi
+}
+
+var italianMonths = map[string]time.Month{
+	"gennaio": time.January, "febbraio": time.February, "marzo": time.March, "aprile": time.April,
+	"maggio": time.May, "giugno": time.June, "luglio": time.July, "agosto": time.August,
+	"settembre": time.September, "ottobre": time.October, "novembre": time.November, "dicembre": time.December,
+	"gen": time.January, "feb": time.February, "mar": time.March, "apr": time.April,
+	"mag": time.May, "giu": time.June, "lug": time.July, "ago": time.August,
+	"set": time.September, "ott": time.October, "nov": time.November, "dic": time.December,
+}
+
+var (
+	italianWeekday     = regexp.MustCompile(`^(luned[iì]|marted[iì]|mercoled[iì]|gioved[iì]|venerd[iì]|sabato|domenica|lun|mar|mer|gio|ven|sab|dom)\.?,?\s+`)
+	italianNumericDate = regexp.MustCompile(`^(\d{1,2})[/.-](\d{1,2})[/.-](\d{4}|\d{2})(.*)$`)
+	italianTextualDate = regexp.MustCompile(`^(\d{1,2})[°º]?\s+([a-z]+)\.?\s+(\d{4})(.*)$`)
+	italianTime        = regexp.MustCompile(`^[\s,T]*(?:ore\s+)?(\d{1,2})[:.](\d{2})(?:[:.](\d{2}))?$`)
+)
+
+// parseItalianDate parses the dates of Italian sources: 12/03/2024, 12.03.24 14:30,
+// 1° marzo 2024, lunedì 12 mar 2024 ore 14.30, in the Europe/Rome time zone.
+func parseItalianDate(s string) (time.Time, error) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parseItalianDate: %w", err)
+	}
+
+	value := italianWeekday.ReplaceAllString(strings.ToLower(strings.Join(strings.Fields(s), " ")), "")
+	var day, year int
+	var month time.Month
+	var rest string
+	if m := italianNumericDate.FindStringSubmatch(value); m != nil {
+		day, _ = strconv.Atoi(m[1])
+		monthNum, _ := strconv.Atoi(m[2])
+		month = time.Month(monthNum)
+		year, _ = strconv.Atoi(m[3])
+		if len(m[3]) == 2 {
+			year += 2000
+		}
+		rest = m[4]
+	} else if m := italianTextualDate.FindStringSubmatch(value); m != nil {
+		var ok bool
+		if month, ok = italianMonths[m[2]]; !ok {
+			return time.Time{}, fmt.Errorf("parseItalianDate: unknown month '%s' in '%s'", m[2], s)
+		}
+		day, _ = strconv.Atoi(m[1])
+		year, _ = strconv.Atoi(m[3])
+		rest = m[4]
+	} else {
+		return time.Time{}, fmt.Errorf("parseItalianDate: unsupported date '%s'", s)
+	}
+
+	var hour, minute, second int
+	if strings.TrimSpace(rest) != "" {
+		m := italianTime.FindStringSubmatch(rest)
+		if m == nil {
+			return time.Time{}, fmt.Errorf("parseItalianDate: unsupported time in '%s'", s)
+		}
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		second, _ = strconv.Atoi(m[3])
+	}
+
+	t := time.Date(year, month, day, hour, minute, second, 0, loc)
+	// time.Date normalizes 31/02 into March
+	if t.Day() != day || t.Month() != month || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("parseItalianDate: invalid date '%s'", s)
+	}
+	return t, nil
+}
+
+var slugTransliterations = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "å", "a", "ä", "ae", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ø", "o", "ö", "oe", "œ", "oe",
+	"ù", "u", "ú", "u", "û", "u", "ü", "ue", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// slugify lowercases s, transliterates the Italian and German accented letters and
+// joins the remaining ASCII letters and digits with dashes.
+func slugify(s string) string {
+	s = slugTransliterations.Replace(strings.ToLower(s))
+	var b strings.Builder
+	dash := false
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// setLanguage asks for the response in lang, with the languageParam query parameter when set,
+// otherwise with the Accept-Language header.
+func (r *RequestConfig) setLanguage(req *http.Request, lang string) {
+	if r.LanguageParam == "" {
+		req.Header.Set("Accept-Language", lang)
+		return
+	}
+	// the other params keep their encoding, e.g. comma arrays
+	key := url.QueryEscape(r.LanguageParam)
+	params := []string{}
+	for _, param := range strings.Split(req.URL.RawQuery, "&") {
+		if param != "" && param != key && !strings.HasPrefix(param, key+"=") {
+			params = append(params, param)
+		}
+	}
+	req.URL.RawQuery = strings.Join(append(params, key+"="+url.QueryEscape(lang)), "&")
+}
+
+// fetchLanguages repeats req, sent in the first language, in the other languages of the step
+// and returns the decoded bodies keyed by language, first being the body in the first one.
+// Pagination follows the responses in the first language.
+func (c *ApiCrawler) fetchLanguages(ctx context.Context, exec *stepExecution, authenticator Authenticator, req *http.Request, first any) (map[string]any, error) {
+	reqConfig := exec.step.Request
+	result := map[string]any{reqConfig.Languages[0]: first}
+	for _, lang := range reqConfig.Languages[1:] {
+		langReq := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error creating HTTP request: %w", err)
+			}
+			langReq.Body = body
+		}
+		reqConfig.setLanguage(langReq, lang)
+		if err := c.authenticate(exec, authenticator, langReq); err != nil {
+			return nil, err
+		}
+
+		c.logger.Info("[Request] %s (%s)", langReq.URL.String(), lang)
+		resp, err := c.doRequest(exec, langReq)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := c.decodeLanguage(exec, langReq, resp)
+		if err != nil {
+			return nil, err
+		}
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Language '%s'", lang), exec, raw, nil, "url", langReq.URL.String())
+		result[lang] = raw
+	}
+	return result, nil
+}
+
+func (c *ApiCrawler) decodeLanguage(exec *stepExecution, req *http.Request, resp *http.Response) (any, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, &HTTPError{Step: exec.path, URL: req.URL.String(), Status: resp.StatusCode}
+	}
+	if exec.step.Request.responseFromHeaders() {
+		return headersToMap(resp.Header), nil
+	}
+	return c.decodeResponseBody(exec.step.Request, resp.Header, resp.Body)
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// localsTemplateKey holds $locals in the data of the request templates, see templateData.
+const localsTemplateKey = "$locals"
+
+// templateLocalsPrefix declares $locals in every go-template of the configuration.
+const templateLocalsPrefix = "{{ $locals := locals . }}"
+
+// localNamePattern keeps local names usable as $locals.name in templates and jq.
+var localNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateLocals is the locals template function, returning the $locals of the template data.
+func templateLocals(data any) any {
+	if m, ok := data.(map[string]any); ok {
+		if locals, ok := m[localsTemplateKey]; ok {
+			return locals
+		}
+	}
+	return map[string]any{}
+}
+
+// stepLocals returns the $locals of exec, empty outside steps and for steps without locals.
+func stepLocals(exec *stepExecution) map[string]any {
+	if exec == nil || exec.locals == nil {
+		return map[string]any{}
+	}
+	return exec.locals
+}
+
+// evalLocals evaluates the locals of the step once, before it runs, against the current
+// context with $ctx. They are bound as $locals in the templates and jq rules of the step only.
+func (c *ApiCrawler) evalLocals(exec *stepExecution) error {
+	if len(exec.step.Locals) == 0 {
+		return nil
+	}
+	templateCtx := c.templateContext(exec)
+	locals := make(map[string]any, len(exec.step.Locals))
+	for _, name := range sortedKeys(exec.step.Locals) {
+		rule := exec.step.Locals[name]
+		location := fmt.Sprintf("%s.locals.%s", exec.path, name)
+		code, err := c.getOrCompileJQRule(rule, "$ctx")
+		if err != nil {
+			return &TransformError{Location: location, Rule: rule, Err: err}
+		}
+		v, ok := c.runJQ(exec, code, exec.currentContext.Data, templateCtx).Next()
+		if err, isErr := v.(error); isErr {
+			return &TransformError{Location: location, Rule: rule, Err: fmt.Errorf("jq error: %w", err)}
+		}
+		if ok {
+			locals[name] = v
+		} else {
+			locals[name] = nil
+		}
+	}
+	exec.locals = locals
+	c.logger.Debug("[Locals] %s: %v", exec.path, locals)
+	return nil
+}
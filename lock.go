@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrRunLocked is returned by Run when another run of the same configuration holds the lock.
+var ErrRunLocked = errors.New("another run holds the lock")
+
+type LockConfig struct {
+	Path              string `yaml:"path,omitempty" json:"path,omitempty"`                           // lock file, default <config name>.lock in the working directory
+	StaleAfterSeconds int    `yaml:"staleAfterSeconds,omitempty" json:"staleAfterSeconds,omitempty"` // take over locks older than this, e.g. left by a killed process
+}
+
+// RunLock guards a configuration against concurrent runs, e.g. across the replicas of a
+// scheduled job. Lock fails with ErrRunLocked when the lock is held; the returned function
+// releases it.
+type RunLock interface {
+	Lock(ctx context.Context, name string) (unlock func() error, err error)
+}
+
+// FileRunLock is a RunLock on the local filesystem: the lock is held as long as the lock
+// file exists.
+type FileRunLock struct {
+	Path       string
+	StaleAfter time.Duration // 0 never takes over a lock
+}
+
+func (l FileRunLock) Lock(ctx context.Context, name string) (func() error, error) {
+	path := l.Path
+	if path == "" {
+		path = name + ".lock"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			if err := f.Close(); err != nil {
+				return nil, err
+			}
+			return func() error { return os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		info, statErr := os.Stat(path)
+		if statErr != nil || l.StaleAfter <= 0 || time.Since(info.ModTime()) < l.StaleAfter {
+			return nil, fmt.Errorf("%w: %s", ErrRunLocked, path)
+		}
+		// stale lock, e.g. of a killed process
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrRunLocked, path)
+}
+
+// SetRunLock guards the runs of the crawler with lock, e.g. a distributed lock shared by the
+// replicas of a job. It takes precedence over the lock section of the configuration.
+func (a *ApiCrawler) SetRunLock(lock RunLock) {
+	a.runLock = lock
+}
+
+// acquireRunLock takes the lock of the run, if any is configured. With a Redis state store
+// the lock section locks in Redis, the locks expiring after staleAfterSeconds.
+func (c *ApiCrawler) acquireRunLock(ctx context.Context) (func() error, error) {
+	lock := c.runLock
+	if lock == nil && c.Config.Lock != nil {
+		staleAfter := time.Duration(c.Config.Lock.StaleAfterSeconds) * time.Second
+		if redis, ok := c.stateStore.(*RedisStore); ok {
+			// the replicas sharing the state share the lock
+			shared := *redis
+			shared.LockTTL = staleAfter
+			lock = &shared
+		} else {
+			lock = FileRunLock{Path: c.Config.Lock.Path, StaleAfter: staleAfter}
+		}
+	}
+	if lock == nil {
+		return func() error { return nil }, nil
+	}
+	return lock.Lock(ctx, c.configName)
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MAPPING_TYPE_STRING = "string"
+	MAPPING_TYPE_INT    = "int"
+	MAPPING_TYPE_FLOAT  = "float"
+	MAPPING_TYPE_BOOL   = "bool"
+)
+
+// MappingField maps a target field to a jq expression evaluated on the source record.
+// In YAML the expression alone is a shorthand for a field without coercion and default:
+//
+//	mapping:
+//	  name: .title
+//	  capacity: { source: .places.total, type: int, default: 0 }
+type MappingField struct {
+	Source  string `yaml:"source" json:"source"`
+	Type    string `yaml:"type,omitempty" json:"type,omitempty"` // string | int | float | bool, default: as is
+	Default any    `yaml:"default,omitempty" json:"default,omitempty"`
+}
+
+func (f *MappingField) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&f.Source)
+	}
+	type plain MappingField
+	return node.Decode((*plain)(f))
+}
+
+func (f *MappingField) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &f.Source)
+	}
+	type plain MappingField
+	return json.Unmarshal(data, (*plain)(f))
+}
+
+// mappingCoercions converts the mapped value, null stays null.
+var mappingCoercions = map[string]string{
+	MAPPING_TYPE_STRING: `if . == null then null else tostring end`,
+	MAPPING_TYPE_INT:    `if . == null then null elif type == "boolean" then (if . then 1 else 0 end) else (tonumber | trunc) end`,
+	MAPPING_TYPE_FLOAT:  `if . == null then null elif type == "boolean" then (if . then 1 else 0 end) else tonumber end`,
+	MAPPING_TYPE_BOOL:   `if . == null or type == "boolean" then . else (tostring | ascii_downcase | IN("true", "1", "yes", "y", "on")) end`,
+}
+
+// mappingToJQ compiles a mapping block into a jq rule building one record per source record:
+// an array result is mapped item by item.
+func mappingToJQ(mapping map[string]MappingField) (string, error) {
+	fields := make([]string, 0, len(mapping))
+	for _, target := range sortedKeys(mapping) {
+		field := mapping[target]
+		key, _ := json.Marshal(target)
+
+		expr := fmt.Sprintf("(%s)", field.Source)
+		if field.Default != nil {
+			def, err := json.Marshal(field.Default)
+			if err != nil {
+				return "", fmt.Errorf("invalid default of '%s': %w", target, err)
+			}
+			expr = fmt.Sprintf("(%s | if . == null then %s else . end)", expr, def)
+		}
+		if field.Type != "" {
+			coercion, ok := mappingCoercions[field.Type]
+			if !ok {
+				return "", fmt.Errorf("unsupported type '%s' of '%s'", field.Type, target)
+			}
+			expr = fmt.Sprintf("(%s | %s)", expr, coercion)
+		}
+		fields = append(fields, fmt.Sprintf("%s: %s", key, expr))
+	}
+	record := "{" + strings.Join(fields, ", ") + "}"
+	return fmt.Sprintf(`def _record: %s; if type == "array" then map(_record) else _record end`, record), nil
+}
+
+// validateMapping checks the types and the jq syntax of the sources of a mapping block.
+func validateMapping(mapping map[string]MappingField, location string) []ValidationError {
+	var errs []ValidationError
+	for _, target := range sortedKeys(mapping) {
+		field := mapping[target]
+		fieldLocation := fmt.Sprintf("%s.%s", location, target)
+		if strings.TrimSpace(field.Source) == "" {
+			errs = append(errs, ValidationError{"mapping source is required", fieldLocation + ".source"})
+		} else if _, err := gojq.Parse(field.Source); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid mapping source: %v", err), fieldLocation + ".source"})
+		}
+		if _, ok := mappingCoercions[field.Type]; field.Type != "" && !ok {
+			errs = append(errs, ValidationError{"mapping type must be one of [string, int, float, bool]", fieldLocation + ".type"})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMemoryCheckIntervalMs = 500
+	// without heapLimitMb, the heap limit is this share of GOMEMLIMIT
+	defaultMemoryLimitRatio = 0.8
+	// the pressure is over once the heap went back under this share of the limit
+	memoryRecoveryRatio = 0.9
+
+	// MEMORY_PRESSURE is the name of the profiler event of a relief, see MemoryPressureConfig
+	MEMORY_PRESSURE = "Memory Pressure"
+)
+
+// MemoryPressureConfig lets a large run degrade gracefully instead of being killed for its
+// memory: while the heap is above heapLimitMb, the parallel forEach steps run at their
+// minimum concurrency, and before the next iteration the streamed entities accumulated so far
+// are emitted and a garbage collection is forced.
+type MemoryPressureConfig struct {
+	HeapLimitMb     int `yaml:"heapLimitMb,omitempty" json:"heapLimitMb,omitempty"`         // default 80% of GOMEMLIMIT
+	CheckIntervalMs int `yaml:"checkIntervalMs,omitempty" json:"checkIntervalMs,omitempty"` // default 500
+}
+
+// memoryMonitor samples the heap of a run periodically.
+type memoryMonitor struct {
+	limit    uint64
+	pressure atomic.Bool // the heap crossed the limit and did not recover yet
+	relief   atomic.Bool // a relief is due before the next iteration
+}
+
+// heapObjectsBytes returns the bytes of the heap occupied by objects, live or not swept yet.
+func heapObjectsBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// memoryLimit returns the heap limit in bytes, 0 when neither heapLimitMb nor GOMEMLIMIT is set.
+func (cfg *MemoryPressureConfig) memoryLimit() uint64 {
+	if cfg.HeapLimitMb > 0 {
+		return uint64(cfg.HeapLimitMb) << 20
+	}
+	// a negative limit reads the current one without changing it
+	if limit := debug.SetMemoryLimit(-1); limit > 0 && limit < math.MaxInt64 {
+		return uint64(float64(limit) * defaultMemoryLimitRatio)
+	}
+	return 0
+}
+
+// startMemoryMonitor samples the heap every checkIntervalMs until the returned function is
+// called. Nothing is monitored without memoryPressure.
+func (c *ApiCrawler) startMemoryMonitor() func() {
+	c.memory = nil
+	cfg := c.Config.MemoryPressure
+	if cfg == nil {
+		return func() {}
+	}
+	limit := cfg.memoryLimit()
+	if limit == 0 {
+		c.logger.Warning("[Memory] memoryPressure ignored, set heapLimitMb or GOMEMLIMIT")
+		return func() {}
+	}
+	interval := cfg.CheckIntervalMs
+	if interval <= 0 {
+		interval = defaultMemoryCheckIntervalMs
+	}
+	monitor := &memoryMonitor{limit: limit}
+	c.memory = monitor
+
+	check := func() {
+		heap := c.heapInUse()
+		switch {
+		case heap >= monitor.limit && !monitor.pressure.Load():
+			c.logger.Warning("[Memory] heap of %d MB above the limit of %d MB, throttling", heap>>20, monitor.limit>>20)
+			monitor.pressure.Store(true)
+		case float64(heap) < float64(monitor.limit)*memoryRecoveryRatio && monitor.pressure.Load():
+			c.logger.Info("[Memory] heap back to %d MB, throttling ends", heap>>20)
+			monitor.pressure.Store(false)
+		}
+		if monitor.pressure.Load() {
+			monitor.relief.Store(true)
+		}
+	}
+	check()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// underMemoryPressure reports whether the heap of the run is above its limit.
+func (c *ApiCrawler) underMemoryPressure() bool {
+	return c.memory != nil && c.memory.pressure.Load()
+}
+
+// relieveMemoryPressure runs before the iterations of forEach steps: once per heap check
+// finding the heap above its limit, it emits the streamed entities accumulated so far and
+// forces a garbage collection.
+func (c *ApiCrawler) relieveMemoryPressure(ctx context.Context, exec *stepExecution) error {
+	if c.memory == nil || !c.memory.relief.CompareAndSwap(true, false) {
+		return nil
+	}
+	before := c.heapInUse()
+	flushed, err := c.flushStream(ctx, exec)
+	if err != nil {
+		return err
+	}
+	debug.FreeOSMemory()
+	after := c.heapInUse()
+	c.logger.Info("[Memory] %s: %d entities flushed, heap from %d MB to %d MB", exec.path, flushed, before>>20, after>>20)
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, MEMORY_PRESSURE, exec, nil, nil,
+		"heapBefore", before, "heapAfter", after, "limit", c.memory.limit, "flushed", flushed)
+	return nil
+}
+
+// flushStream emits the entities accumulated in the root context in stream mode, unless an
+// iteration step exec runs in patches them in the root context once done.
+func (c *ApiCrawler) flushStream(ctx context.Context, exec *stepExecution) (int, error) {
+	if !c.Config.Stream {
+		return 0, nil
+	}
+	for e := exec; e != nil; e = e.parent {
+		if isIterationStep(e.step.Type) && e.currentContext.depth == 0 && patchesStream(e.step.Path, c.Config.StreamKey) {
+			return 0, nil
+		}
+	}
+	root := c.ContextMap["root"]
+	c.mergeMu.Lock()
+	entities := c.takeStreamEntities(root)
+	c.mergeMu.Unlock()
+	for i, d := range entities {
+		if err := c.emitEntity(ctx, exec, d); err != nil {
+			return i, err
+		}
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Stream result #%d", i), exec, d, nil)
+	}
+	return len(entities), nil
+}
+
+// patchesStream reports whether the path of an iteration step on the root context covers
+// the streamed entities.
+func patchesStream(path string, streamKey string) bool {
+	path = strings.TrimSpace(path)
+	if path == "." || streamKey == "" {
+		return true
+	}
+	return path == "."+streamKey || strings.HasPrefix(path, "."+streamKey+".") || strings.HasPrefix(path, "."+streamKey+"[")
+}
@@ -0,0 +1,458 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	OPENAPI_MODE_WARN  = "warn"
+	OPENAPI_MODE_ERROR = "error"
+
+	openAPIMaxMismatches = 20
+)
+
+type OpenAPIConfig struct {
+	Spec      string `yaml:"spec" json:"spec"`                               // file path or http(s) url of the OpenAPI 3 / Swagger 2 document
+	Operation string `yaml:"operation,omitempty" json:"operation,omitempty"` // operationId, default: matched by method and url path
+	Mode      string `yaml:"mode,omitempty" json:"mode,omitempty"`           // warn (default) | error
+}
+
+// openAPIDoc is a parsed OpenAPI document, kept generic to resolve $refs with JSON pointers.
+type openAPIDoc struct {
+	root map[string]any
+}
+
+// loadOpenAPISpec reads (and caches) an OpenAPI document; JSON documents are parsed as YAML.
+func (c *ApiCrawler) loadOpenAPISpec(ctx context.Context, spec string) (*openAPIDoc, error) {
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	if doc, ok := c.openAPICache[spec]; ok {
+		return doc, nil
+	}
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		data, err = c.fetchOpenAPISpec(ctx, spec)
+	} else {
+		data, err = os.ReadFile(spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading openapi spec %s: %w", spec, err)
+	}
+
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing openapi spec %s: %w", spec, err)
+	}
+	root, ok := normalizeYAML(raw).(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("openapi spec %s is not an object", spec)
+	}
+
+	doc := &openAPIDoc{root: root}
+	c.openAPICache[spec] = doc
+	return doc, nil
+}
+
+func (c *ApiCrawler) fetchOpenAPISpec(ctx context.Context, spec string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, spec, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// normalizeYAML turns the map[interface{}]interface{} produced for non string keys
+// (e.g. unquoted status codes) into map[string]any.
+func normalizeYAML(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			t[k] = normalizeYAML(val)
+		}
+		return t
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = normalizeYAML(val)
+		}
+		return m
+	case []any:
+		for i, val := range t {
+			t[i] = normalizeYAML(val)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// checkOpenAPI validates a decoded response against the schema the step's OpenAPI document
+// declares for it. Mismatches are logged, or returned as a ContractError in error mode.
+func (c *ApiCrawler) checkOpenAPI(ctx context.Context, exec *stepExecution, method string, u *url.URL, status int, raw any) error {
+	cfg := exec.step.Request.OpenAPI
+	doc, err := c.loadOpenAPISpec(ctx, cfg.Spec)
+	if err != nil {
+		return &ConfigError{Err: err}
+	}
+	schema, err := doc.responseSchema(cfg.Operation, method, u.Path, status)
+	if err != nil {
+		return &ConfigError{Err: fmt.Errorf("%s.request.openapi: %w", exec.path, err)}
+	}
+	if schema == nil {
+		c.logger.Debug("[OpenAPI] no schema declared for %s %s (status %d)", method, u.Path, status)
+		return nil
+	}
+
+	var mismatches []string
+	doc.validate(schema, raw, "$", &mismatches)
+	if len(mismatches) == 0 {
+		return nil
+	}
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "OpenAPI Validation", exec, mismatches, nil, "url", u.String())
+	if cfg.Mode == OPENAPI_MODE_ERROR {
+		return &ContractError{Step: exec.path, URL: u.String(), Mismatches: mismatches}
+	}
+	for _, m := range mismatches {
+		c.logger.Warning("[OpenAPI] %s: %s", u.String(), m)
+	}
+	return nil
+}
+
+// responseSchema finds the response schema of an operation, by operationId or by method
+// and url path. Spec paths are matched against the end of the url path, so that the base
+// path of the servers does not matter.
+func (d *openAPIDoc) responseSchema(operationID string, method string, urlPath string, status int) (map[string]any, error) {
+	paths, _ := d.root["paths"].(map[string]any)
+
+	var operation map[string]any
+	bestScore, bestTemplate := -1, ""
+	for template, item := range paths {
+		methods, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if operationID != "" {
+			for _, op := range methods {
+				if op, ok := op.(map[string]any); ok && op["operationId"] == operationID {
+					operation = op
+				}
+			}
+			continue
+		}
+		op, ok := methods[strings.ToLower(method)].(map[string]any)
+		if !ok {
+			continue
+		}
+		score, ok := matchPathTemplate(template, urlPath)
+		if ok && (score > bestScore || score == bestScore && template < bestTemplate) {
+			operation, bestScore, bestTemplate = op, score, template
+		}
+	}
+	if operation == nil {
+		if operationID != "" {
+			return nil, fmt.Errorf("operation '%s' not found", operationID)
+		}
+		return nil, fmt.Errorf("no operation for %s %s", method, urlPath)
+	}
+
+	responses, _ := operation["responses"].(map[string]any)
+	code := strconv.Itoa(status)
+	response, ok := responses[code]
+	if !ok {
+		response, ok = responses[code[:1]+"XX"]
+	}
+	if !ok {
+		response, ok = responses[code[:1]+"xx"]
+	}
+	if !ok {
+		response = responses["default"]
+	}
+	responseMap, _ := d.resolve(response).(map[string]any)
+	if responseMap == nil {
+		return nil, nil
+	}
+
+	// OpenAPI 3: content by media type
+	if content, ok := responseMap["content"].(map[string]any); ok {
+		for _, mediaType := range sortedKeys(content) {
+			if strings.Contains(mediaType, "json") {
+				media, _ := content[mediaType].(map[string]any)
+				schema, _ := d.resolve(media["schema"]).(map[string]any)
+				return schema, nil
+			}
+		}
+		return nil, nil
+	}
+	// Swagger 2
+	schema, _ := d.resolve(responseMap["schema"]).(map[string]any)
+	return schema, nil
+}
+
+// matchPathTemplate matches a spec path such as /facilities/{id} against the end of
+// urlPath, scoring literal segments higher than parameters.
+func matchPathTemplate(template string, urlPath string) (int, bool) {
+	tSegs := strings.Split(strings.Trim(template, "/"), "/")
+	uSegs := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if len(tSegs) > len(uSegs) {
+		return 0, false
+	}
+	uSegs = uSegs[len(uSegs)-len(tSegs):]
+
+	score := 0
+	for i, seg := range tSegs {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			score++
+			continue
+		}
+		if seg != uSegs[i] {
+			return 0, false
+		}
+		score += 2
+	}
+	return score, true
+}
+
+// resolve follows local $refs (#/components/schemas/X, #/definitions/X).
+func (d *openAPIDoc) resolve(v any) any {
+	for range 32 {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return v
+		}
+		var target any = d.root
+		for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			obj, _ := target.(map[string]any)
+			target = obj[token]
+		}
+		v = target
+	}
+	return v
+}
+
+// validate checks value against the subset of JSON schema used in OpenAPI documents:
+// type, nullable, enum, properties, required, additionalProperties, items, allOf, anyOf,
+// oneOf and the length, range and pattern constraints.
+func (d *openAPIDoc) validate(schemaValue any, value any, path string, out *[]string) {
+	if len(*out) >= openAPIMaxMismatches {
+		return
+	}
+	schema, ok := d.resolve(schemaValue).(map[string]any)
+	if !ok {
+		return
+	}
+	fail := func(format string, args ...any) {
+		if len(*out) < openAPIMaxMismatches {
+			*out = append(*out, path+": "+fmt.Sprintf(format, args...))
+		}
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		d.validate(sub, value, path, out)
+	}
+	if subs := schemaList(schema["anyOf"]); len(subs) > 0 && d.countMatching(subs, value) == 0 {
+		fail("does not match any schema of anyOf")
+	}
+	if subs := schemaList(schema["oneOf"]); len(subs) > 0 {
+		if n := d.countMatching(subs, value); n != 1 {
+			fail("matches %d schemas of oneOf, expected 1", n)
+		}
+	}
+
+	if value == nil {
+		if schema["nullable"] == true || slices.Contains(schemaTypes(schema), "null") || len(schemaTypes(schema)) == 0 {
+			return
+		}
+		fail("expected %s, got null", strings.Join(schemaTypes(schema), " or "))
+		return
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return jsonTypeMatches(t, value) }) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return jsonEqual(e, value) }) {
+		fail("value %v not in enum %v", value, enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				fail("missing required property '%s'", name)
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			if propSchema, ok := properties[name]; ok {
+				d.validate(propSchema, v[name], path+"."+name, out)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property '%s'", name)
+				}
+			case map[string]any:
+				d.validate(additional, v[name], path+"."+name, out)
+			}
+		}
+	case []any:
+		if n, ok := schemaNumber(schema["minItems"]); ok && float64(len(v)) < n {
+			fail("expected at least %v items, got %d", n, len(v))
+		}
+		if n, ok := schemaNumber(schema["maxItems"]); ok && float64(len(v)) > n {
+			fail("expected at most %v items, got %d", n, len(v))
+		}
+		if items, ok := schema["items"]; ok {
+			for i, item := range v {
+				d.validate(items, item, fmt.Sprintf("%s[%d]", path, i), out)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := schemaNumber(schema["minLength"]); ok && length < n {
+			fail("expected at least %v characters", n)
+		}
+		if n, ok := schemaNumber(schema["maxLength"]); ok && length > n {
+			fail("expected at most %v characters", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("'%s' does not match pattern %s", v, pattern)
+			}
+		}
+	default:
+		number, ok := jsonNumberValue(v)
+		if !ok {
+			break
+		}
+		if n, ok := schemaNumber(schema["minimum"]); ok && number < n {
+			fail("%v is below minimum %v", v, n)
+		}
+		if n, ok := schemaNumber(schema["maximum"]); ok && number > n {
+			fail("%v is above maximum %v", v, n)
+		}
+	}
+}
+
+func (d *openAPIDoc) countMatching(schemas []any, value any) int {
+	n := 0
+	for _, sub := range schemas {
+		var mismatches []string
+		d.validate(sub, value, "$", &mismatches)
+		if len(mismatches) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func schemaList(v any) []any {
+	list, _ := v.([]any)
+	return list
+}
+
+func schemaStrings(v any) []string {
+	var out []string
+	for _, s := range schemaList(v) {
+		if s, ok := s.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// schemaTypes returns the type keyword, a string in OpenAPI 3.0 and possibly a list in 3.1.
+func schemaTypes(schema map[string]any) []string {
+	if t, ok := schema["type"].(string); ok {
+		return []string{t}
+	}
+	return schemaStrings(schema["type"])
+}
+
+func schemaNumber(v any) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	n, err := toFloat64(v)
+	return n, err == nil
+}
+
+func jsonTypeMatches(schemaType string, value any) bool {
+	switch schemaType {
+	case "integer":
+		f, ok := jsonNumberValue(value)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := jsonNumberValue(value)
+		return ok
+	default:
+		return jsonTypeName(value) == schemaType
+	}
+}
+
+// jsonEqual compares a schema value (decoded from YAML) with a JSON value.
+func jsonEqual(schemaValue any, value any) bool {
+	switch v := value.(type) {
+	case string, bool:
+		return schemaValue == v
+	default:
+		number, isNumber := jsonNumberValue(v)
+		n, ok := schemaNumber(schemaValue)
+		return isNumber && ok && n == number
+	}
+}
+
+// jsonNumberValue returns the value of a JSON number: float64 as decoded by default,
+// json.Number with preciseNumbers, int and *big.Int once gone through jq.
+func jsonNumberValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	OUTPUT_NUMBERS_DEFAULT = "default"
+	OUTPUT_NUMBERS_PLAIN   = "plain"
+)
+
+// OutputConfig controls how the output is marshaled to JSON, for downstream change detection
+// diffing the files. Object keys are always sorted.
+type OutputConfig struct {
+	// Numbers is default (exponent notation below 1e-6 and from 1e21 on) or plain, which never
+	// uses the exponent notation: integral numbers are written as integers
+	Numbers string `yaml:"numbers,omitempty" json:"numbers,omitempty"`
+	Indent  int    `yaml:"indent,omitempty" json:"indent,omitempty"` // spaces, 0 writes compact JSON
+}
+
+// marshal encodes v as configured; ndjson lines are never indented.
+func (o OutputConfig) marshal(v any, line bool) ([]byte, error) {
+	if o.Numbers == OUTPUT_NUMBERS_PLAIN {
+		v = plainNumbers(v)
+	}
+	if o.Indent > 0 && !line {
+		return json.MarshalIndent(v, "", strings.Repeat(" ", o.Indent))
+	}
+	return json.Marshal(v)
+}
+
+// MarshalOutput encodes v, e.g. the result of GetData, following the output section of the
+// configuration, as the sinks do.
+func (a *ApiCrawler) MarshalOutput(v any) ([]byte, error) {
+	return a.outputConfig().marshal(v, false)
+}
+
+// MarshalOutputLine encodes a streamed entity as a ndjson line, never indented.
+func (a *ApiCrawler) MarshalOutputLine(v any) ([]byte, error) {
+	return a.outputConfig().marshal(v, true)
+}
+
+func (c *ApiCrawler) outputConfig() OutputConfig {
+	if c.Config.Output == nil {
+		return OutputConfig{}
+	}
+	return *c.Config.Output
+}
+
+// plainNumbers returns a copy of v with the floats replaced by their plain decimal notation.
+func plainNumbers(v any) any {
+	switch t := v.(type) {
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return t
+		}
+		return json.Number(strconv.FormatFloat(t, 'f', -1, 64))
+	case float32:
+		return plainNumbers(float64(t))
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, item := range t {
+			m[k] = plainNumbers(item)
+		}
+		return m
+	case []any:
+		s := make([]any, len(t))
+		for i, item := range t {
+			s[i] = plainNumbers(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
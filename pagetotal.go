@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/itchyny/gojq"
+)
+
+// TotalCount derives the number of pages from a total count read in the first response:
+// the pagination stops after ceil(total / pageSize) pages. With concurrency > 1 the
+// remaining pages are requested in parallel once the first one is in, their params being
+// computed ahead by the increments.
+type TotalCount struct {
+	Source      string `yaml:"source" json:"source"`     // "body:selector", "header:name" or a bare jq selector on the body
+	PageSize    int    `yaml:"pageSize" json:"pageSize"` // items per page
+	Concurrency int    `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+}
+
+// extractTotal reads the total count of the first response, fixing the number of pages.
+func (p *Paginator) extractTotal(body any, headers map[string][]string) error {
+	total := p.config.Pagination.Total
+	if total == nil || p.totalPages >= 0 {
+		return nil
+	}
+	var val any
+	sourceType, sourcePath := totalSource(total.Source)
+	switch sourceType {
+	case "body":
+		v, err := evalJQ(sourcePath, body)
+		if err != nil {
+			return fmt.Errorf("jq error for the total count: %w", err)
+		}
+		val = v
+	case "header":
+		if v, ok := headerValue(headers, sourcePath); ok {
+			val = strings.TrimSpace(v)
+		}
+	}
+	if val == nil {
+		return fmt.Errorf("total count not found at %s", total.Source)
+	}
+	count, err := toFloat64(val)
+	if err != nil || count < 0 {
+		return fmt.Errorf("invalid total count %v", val)
+	}
+	p.totalPages = int(math.Ceil(count / float64(total.PageSize)))
+	return nil
+}
+
+// totalSource splits the source of a total count into its type and selector.
+func totalSource(source string) (string, string) {
+	if path, ok := strings.CutPrefix(source, "header:"); ok {
+		return "header", path
+	}
+	return "body", strings.TrimPrefix(source, "body:")
+}
+
+// TotalPages returns the number of pages derived from the total count, -1 until known.
+func (p *Paginator) TotalPages() int {
+	return p.totalPages
+}
+
+// remainingPages returns the params of the pages left after the ones fetched so far,
+// computed ahead by the increments.
+func (p *Paginator) remainingPages() ([]*RequestParts, error) {
+	if p.stopped || p.totalPages < 0 {
+		return nil, nil
+	}
+	ctx, pageNum := maps.Clone(p.ctx), p.pageNum
+	defer func() { p.ctx, p.pageNum = ctx, pageNum }()
+
+	parts := []*RequestParts{p.NextFromCtx()}
+	for p.pageNum+1 < p.totalPages {
+		if err := p.applyIncrements(); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p.NextFromCtx())
+	}
+	return parts, nil
+}
+
+// prefetchedPage is a page requested ahead, its body read.
+type prefetchedPage struct {
+	req  *http.Request
+	url  *url.URL
+	resp *http.Response
+	err  error
+}
+
+// fetchPage requests a page ahead, reading its body so that the connection is released
+// until the page is processed.
+func fetchPage(ctx context.Context, send pageSender, pageNum int, next *RequestParts) prefetchedPage {
+	var page prefetchedPage
+	page.req, page.url, page.resp, page.err = send(ctx, pageNum, next)
+	if page.err == nil {
+		var body []byte
+		body, page.err = io.ReadAll(page.resp.Body)
+		page.resp.Body.Close()
+		page.resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return page
+}
+
+// prefetchPages requests the pages of a total count left after the first one with up to
+// total.concurrency requests at the same time. The pages are returned in order, the first
+// failure cancels the ones not sent yet.
+func (c *ApiCrawler) prefetchPages(ctx context.Context, exec *stepExecution, send pageSender, parts []*RequestParts, firstPage int) []prefetchedPage {
+	pages := make([]prefetchedPage, len(parts))
+	slots := make(chan struct{}, exec.step.Request.Pagination.Total.Concurrency)
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, next := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-workCtx.Done():
+				pages[i].err = workCtx.Err()
+				return
+			}
+			page := &pages[i]
+			*page = fetchPage(workCtx, send, firstPage+i, next)
+			if page.err != nil {
+				once.Do(func() {
+					firstErr = page.err
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the pages canceled by a failure report it
+	if firstErr != nil && ctx.Err() == nil {
+		for i := range pages {
+			if errors.Is(pages[i].err, context.Canceled) {
+				pages[i].err = firstErr
+			}
+		}
+	}
+	return pages
+}
+
+func validateTotalCount(p Pagination, location string) []ValidationError {
+	var errs []ValidationError
+	total := p.Total
+	if sourceType, sourcePath := totalSource(total.Source); sourcePath == "" {
+		errs = append(errs, ValidationError{"pagination.total.source is required", location + ".source"})
+	} else if sourceType == "body" {
+		if _, err := gojq.Parse(sourcePath); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid pagination.total.source: %v", err), location + ".source"})
+		}
+	}
+	if total.PageSize <= 0 {
+		errs = append(errs, ValidationError{"pagination.total.pageSize must be positive", location + ".pageSize"})
+	}
+	if total.Concurrency < 0 {
+		errs = append(errs, ValidationError{"pagination.total.concurrency must not be negative", location + ".concurrency"})
+	}
+	if total.Concurrency > 1 {
+		// the params of the remaining pages must be known ahead
+		for i, param := range p.Params {
+			if t := strings.ToLower(param.Type); t == "dynamic" || t == "cursor" {
+				errs = append(errs, ValidationError{fmt.Sprintf("pagination.total.concurrency can not compute %s params ahead", t), fmt.Sprintf("%s.params[%d]", strings.TrimSuffix(location, ".total"), i)})
+			}
+		}
+		if p.NextPageUrlSelector != "" {
+			errs = append(errs, ValidationError{"pagination.total.concurrency can not be combined with nextPageUrlSelector", location + ".concurrency"})
+		}
+		if p.SessionAffinity {
+			errs = append(errs, ValidationError{"pagination.total.concurrency can not be combined with sessionAffinity", location + ".concurrency"})
+		}
+	}
+	return errs
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -23,26 +24,45 @@ import (
 type Param struct {
 	Name      string `yaml:"name" json:"name"`
 	Location  string `yaml:"location" json:"location"` // "query", "body", "header"
-	Type      string `yaml:"type" json:"type"`         // "int", "float", "datetime", "dynamic"`
+	Type      string `yaml:"type" json:"type"`         // "int", "float", "datetime", "string", "uuid", "dynamic", "cursor"`
 	Format    string `yaml:"format,omitempty" json:"format,omitempty"`
+	Pad       int    `yaml:"pad,omitempty" json:"pad,omitempty"`           // int and string: minimum number of digits, zero padded
+	Decimals  *int   `yaml:"decimals,omitempty" json:"decimals,omitempty"` // float: fixed number of decimals
 	Default   string `yaml:"default" json:"default"`
 	Increment string `yaml:"increment,omitempty" json:"increment,omitempty"`
-	Source    string `yaml:"source,omitempty" json:"source,omitempty"` // "body:selector" or "header:selector"
+	Source    string `yaml:"source,omitempty" json:"source,omitempty"` // "body:selector" or "header:selector", a cursor also takes a bare jq selector
 }
 
 type StopCondition struct {
-	Type       string `yaml:"type" json:"type"`             // "responseBody", "requestParam", "pageNum"
+	Type       string `yaml:"type" json:"type"`             // "responseBody", "requestParam", "pageNum", "timeBudget"
 	Expression string `yaml:"expression" json:"expression"` // used by jq
 
 	Param   string `yaml:"param,omitempty" json:"param,omitempty"`     // for requestParam
 	Compare string `yaml:"compare,omitempty" json:"compare,omitempty"` // "lt", "lte", "eq", "gt", "gte"
-	Value   any    `yaml:"value,omitempty" json:"value,omitempty"`     // value to compare against
+	Value   any    `yaml:"value,omitempty" json:"value,omitempty"`     // value to compare against, seconds for timeBudget
 }
 
 type Pagination struct {
 	NextPageUrlSelector string          `yaml:"nextPageUrlSelector,omitempty" json:"nextPageUrlSelector,omitempty"` // jq selector to get nextPage url
 	Params              []Param         `yaml:"params,omitempty" json:"params,omitempty"`
 	StopOn              []StopCondition `yaml:"stopOn,omitempty" json:"stopOn,omitempty"`
+	NextRequest         *NextRequest    `yaml:"nextRequest,omitempty" json:"nextRequest,omitempty"`
+	// SessionAffinity sends the cookies set by the responses with the next pages, for upstreams
+	// pinning a pagination session to a backend
+	SessionAffinity bool `yaml:"sessionAffinity,omitempty" json:"sessionAffinity,omitempty"`
+	// Total stops after the pages of a total count read in the first response
+	Total *TotalCount `yaml:"total,omitempty" json:"total,omitempty"`
+	// Pipeline requests the next page while the nested steps run on the current one
+	Pipeline bool `yaml:"pipeline,omitempty" json:"pipeline,omitempty"`
+}
+
+// NextRequest replaces the url, method and body of the request from the second page on, e.g.
+// for scroll APIs where the first request opens a cursor that is continued at another endpoint.
+// Empty fields keep the ones of the request; the pagination params apply to every page.
+type NextRequest struct {
+	URL    string `yaml:"url,omitempty" json:"url,omitempty"` // go template, like request.url
+	Method string `yaml:"method,omitempty" json:"method,omitempty"`
+	Body   string `yaml:"body,omitempty" json:"body,omitempty"` // go template, like request.body
 }
 
 type ConfigP struct {
@@ -57,10 +77,18 @@ type Paginator struct {
 	stopped     bool
 	pageNum     int
 	nextPageUrl string
+	totalPages  int // -1 until known, see TotalCount
+
+	now               func() time.Time // resolves "now" in datetime params
+	decode            func(data []byte) (any, error)
+	clock             func() time.Time // measures the timeBudget
+	started           time.Time
+	timeBudgetReached bool
 }
 
 type RequestParts struct {
 	QueryParams map[string]string      `yaml:"queryParams"`
+	QueryArrays map[string][]string    `yaml:"queryArrays,omitempty"` // query params holding arrays, e.g. dynamic ones
 	BodyParams  map[string]interface{} `yaml:"bodyParams"`
 	Headers     map[string]string      `yaml:"headers"`
 	NextPageUrl string                 `yaml:"nextPageUrl"`
@@ -68,11 +96,20 @@ type RequestParts struct {
 
 // NewPaginator creates a new paginator from YAML config
 func NewPaginator(cfg ConfigP) (*Paginator, error) {
+	return newPaginator(cfg, func() time.Time { return nowFunc() })
+}
+
+// newPaginator creates a paginator resolving "now" in datetime params with now.
+func newPaginator(cfg ConfigP, now func() time.Time) (*Paginator, error) {
 	p := &Paginator{
-		config:  cfg,
-		ctx:     make(PaginationContext),
-		stopped: len(cfg.Pagination.Params) == 0 && len(cfg.Pagination.NextPageUrlSelector) == 0,
+		config:     cfg,
+		ctx:        make(PaginationContext),
+		stopped:    len(cfg.Pagination.Params) == 0 && len(cfg.Pagination.NextPageUrlSelector) == 0,
+		totalPages: -1,
+		now:        now,
+		clock:      time.Now,
 	}
+	p.started = p.clock()
 
 	// initialize context
 	return p, p.initializeContext()
@@ -95,6 +132,29 @@ func (p *Paginator) PageNum() int {
 	return p.pageNum
 }
 
+// TimeBudgetReached reports whether the pagination stopped on a timeBudget condition.
+func (p *Paginator) TimeBudgetReached() bool {
+	return p.timeBudgetReached
+}
+
+// setDecoder makes the paginator decode the response bodies like the step does,
+// e.g. with the tolerantJson and preciseNumbers options.
+func (p *Paginator) setDecoder(decode func(data []byte) (any, error)) {
+	p.decode = decode
+}
+
+// setClock makes timeBudget conditions use now, counting from now on.
+// The clock is only read when there is such a condition.
+func (p *Paginator) setClock(clock func() time.Time) {
+	p.clock = clock
+	for _, cond := range p.config.Pagination.StopOn {
+		if cond.Type == "timeBudget" {
+			p.started = clock()
+			return
+		}
+	}
+}
+
 func evalSimpleExpr(expression string, val interface{}) (interface{}, error) {
 	prog, err := expr.Compile(fmt.Sprintf("x %s", expression))
 	if err != nil {
@@ -129,6 +189,13 @@ func (p *Paginator) initializeContext() error {
 		if param.Type == "dynamic" {
 			continue
 		}
+		if param.Type == "cursor" {
+			// the first page goes without a cursor, unless the API wants a start value
+			if param.Default != "" {
+				p.ctx[param.Name] = param.Default
+			}
+			continue
+		}
 
 		// Parse default into actual typed value
 		var parsed any
@@ -140,7 +207,9 @@ func (p *Paginator) initializeContext() error {
 		case "float":
 			parsed, err = strconv.ParseFloat(param.Default, 64)
 		case "datetime":
-			parsed, err = toTime(param.Default, param.Format)
+			parsed, err = toTimeAt(param.Default, param.Format, p.now)
+		case "uuid":
+			parsed, err = parseUUID(param.Default)
 		default:
 			parsed = param.Default
 		}
@@ -156,7 +225,7 @@ func (p *Paginator) applyIncrements() error {
 	p.pageNum += 1
 
 	for _, param := range p.config.Pagination.Params {
-		if param.Type == "dynamic" {
+		if param.Type == "dynamic" || param.Type == "cursor" {
 			continue
 		}
 
@@ -166,7 +235,7 @@ func (p *Paginator) applyIncrements() error {
 			// Apply increment if defined
 			switch param.Type {
 			case "datetime":
-				tval, err := toTime(val, param.Format)
+				tval, err := toTimeAt(val, param.Format, p.now)
 				if err != nil {
 					return fmt.Errorf("failed to parse datetime param '%s': %w", param.Name, err)
 				}
@@ -184,6 +253,19 @@ func (p *Paginator) applyIncrements() error {
 				if err != nil {
 					return fmt.Errorf("jq eval error on increment for '%s': %w", param.Name, err)
 				}
+				if param.Decimals != nil {
+					res, err = roundDecimals(res, *param.Decimals)
+					if err != nil {
+						return fmt.Errorf("failed to round param '%s': %w", param.Name, err)
+					}
+				}
+				p.ctx[param.Name] = res
+
+			case "string":
+				res, err := incrementCode(val.(string), param.Increment)
+				if err != nil {
+					return fmt.Errorf("failed to increment param '%s': %w", param.Name, err)
+				}
 				p.ctx[param.Name] = res
 
 			default:
@@ -196,15 +278,14 @@ func (p *Paginator) applyIncrements() error {
 
 func (p *Paginator) extractDynamicParams(body interface{}, headers map[string][]string) error {
 	for _, param := range p.config.Pagination.Params {
-		if param.Type != "dynamic" {
+		if param.Type != "dynamic" && param.Type != "cursor" {
 			continue
 		}
 
-		sourceParts := strings.SplitN(param.Source, ":", 2)
-		sourceType := sourceParts[0]
-		sourcePath := ""
-		if len(sourceParts) > 1 {
-			sourcePath = sourceParts[1]
+		sourceType, sourcePath := paramSource(param)
+		if param.Type == "cursor" {
+			// a cursor missing in the response ends the pagination, see shouldStop
+			p.ctx[param.Name] = nil
 		}
 
 		switch sourceType {
@@ -222,8 +303,8 @@ func (p *Paginator) extractDynamicParams(body interface{}, headers map[string][]
 			if sourcePath == "" {
 				return fmt.Errorf("missing header key for param '%s'", param.Name)
 			}
-			if val, ok := headers[sourcePath]; ok && len(val) > 0 {
-				p.ctx[param.Name] = val[0]
+			if val, ok := headerValue(headers, sourcePath); ok {
+				p.ctx[param.Name] = val
 			}
 
 		default:
@@ -233,7 +314,22 @@ func (p *Paginator) extractDynamicParams(body interface{}, headers map[string][]
 	return nil
 }
 
-func (p *Paginator) extractNextUrl(body interface{}, headers map[string][]string) error {
+// paramSource splits the source of a dynamic or cursor param into its type and selector.
+// A cursor source without a type is a jq selector on the body, e.g. .meta.next_cursor.
+func paramSource(param Param) (string, string) {
+	if param.Type == "cursor" && !strings.HasPrefix(param.Source, "body:") && !strings.HasPrefix(param.Source, "header:") {
+		return "body", param.Source
+	}
+	sourceParts := strings.SplitN(param.Source, ":", 2)
+	if len(sourceParts) > 1 {
+		return sourceParts[0], sourceParts[1]
+	}
+	return sourceParts[0], ""
+}
+
+// extractNextUrl reads the next page url, a relative one from a Link header being resolved
+// against base, the url of the request, when known.
+func (p *Paginator) extractNextUrl(body interface{}, headers map[string][]string, base *url.URL) error {
 	if len(p.config.Pagination.NextPageUrlSelector) == 0 {
 		return nil
 	}
@@ -263,19 +359,93 @@ func (p *Paginator) extractNextUrl(body interface{}, headers map[string][]string
 		if sourcePath == "" {
 			return fmt.Errorf("missing header key for next url")
 		}
-		if val, ok := headers[sourcePath]; ok && len(val) > 0 {
-			p.nextPageUrl = val[0]
+		if val, ok := headerValue(headers, sourcePath); ok {
+			p.nextPageUrl = val
 		} else {
 			p.nextPageUrl = ""
 		}
 
+	case "link":
+		rel := sourcePath
+		if rel == "" {
+			rel = "next"
+		}
+		p.nextPageUrl = ""
+		link, ok := linkHeaderTarget(headers, rel)
+		if !ok {
+			break
+		}
+		if base != nil {
+			ref, err := url.Parse(link)
+			if err != nil {
+				return fmt.Errorf("invalid Link header url %s: %w", link, err)
+			}
+			link = base.ResolveReference(ref).String()
+		}
+		p.nextPageUrl = link
+
 	default:
 		return fmt.Errorf("unsupported source type '%s' for next url", sourceType)
 	}
 	return nil
 }
 
-func compareValues(param Param, a, b any, op string) (bool, error) {
+// linkHeaderTarget returns the target of the first link with the relation rel in the Link
+// headers (RFC 8288), e.g. <https://api.example.com/items?page=2>; rel="next".
+func linkHeaderTarget(headers map[string][]string, rel string) (string, bool) {
+	values, ok := headers["Link"]
+	if !ok {
+		values = headers["link"]
+	}
+	for _, value := range values {
+		for value != "" {
+			start := strings.IndexByte(value, '<')
+			end := strings.IndexByte(value, '>')
+			if start < 0 || end < start {
+				break
+			}
+			target := strings.TrimSpace(value[start+1 : end])
+			value = value[end+1:]
+			// the params run up to the next link, commas only appearing quoted within them
+			params := value
+			if next := nextLinkStart(value); next >= 0 {
+				params, value = value[:next], value[next:]
+			} else {
+				value = ""
+			}
+			params = strings.TrimRight(params, ", \t")
+			for _, param := range strings.Split(params, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				for _, r := range strings.Fields(strings.Trim(strings.TrimSpace(val), `"`)) {
+					if strings.EqualFold(r, rel) {
+						return target, true
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// nextLinkStart returns the index of the '<' opening the next link of a Link header, skipping
+// quoted strings, or -1.
+func nextLinkStart(value string) int {
+	quoted := false
+	for i, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '<' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+func compareValues(param Param, a, b any, op string, now func() time.Time) (bool, error) {
 	switch param.Type {
 	case "int":
 		af, err := toFloat64(a)
@@ -300,14 +470,14 @@ func compareValues(param Param, a, b any, op string) (bool, error) {
 		return floatCompare(af, bf, op)
 
 	case "datetime":
-		ta, err := toTime(a, param.Format)
+		ta, err := toTimeAt(a, param.Format, now)
 		if err != nil {
 			return false, err
 		}
 		var tb time.Time
 		switch vb := b.(type) {
 		case string:
-			tb, err = toTime(vb, param.Format)
+			tb, err = toTimeAt(vb, param.Format, now)
 			if err != nil {
 				return false, fmt.Errorf("invalid stop condition datetime: %w", err)
 			}
@@ -322,6 +492,12 @@ func compareValues(param Param, a, b any, op string) (bool, error) {
 		// string compare fallback
 		as := fmt.Sprintf("%v", a)
 		bs := fmt.Sprintf("%v", b)
+		if param.Type == "string" && digitsPattern.MatchString(as) && digitsPattern.MatchString(bs) {
+			// zero padded codes compare by value, whatever their width
+			af, _ := strconv.ParseFloat(as, 64)
+			bf, _ := strconv.ParseFloat(bs, 64)
+			return floatCompare(af, bf, op)
+		}
 		switch op {
 		case "eq":
 			return as == bs, nil
@@ -373,6 +549,15 @@ func timeCompare(a, b time.Time, op string) (bool, error) {
 	}
 }
 
+// timeBudgetDuration converts the value of a timeBudget condition, in seconds.
+func timeBudgetDuration(value any) (time.Duration, error) {
+	seconds, err := toFloat64(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time budget: %w", err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
 func parseParamPath(path string) (location, name string, err error) {
 	if !strings.HasPrefix(path, ".") {
 		return "", "", fmt.Errorf("invalid param path: %s", path)
@@ -392,6 +577,8 @@ func toFloat64(v any) (float64, error) {
 		return float64(t), nil
 	case float64:
 		return t, nil
+	case json.Number:
+		return t.Float64()
 	case string:
 		return strconv.ParseFloat(t, 64)
 	default:
@@ -428,6 +615,11 @@ func addSmartDuration(t time.Time, expr string) (time.Time, error) {
 }
 
 func toTime(value any, format string) (time.Time, error) {
+	return toTimeAt(value, format, nowFunc)
+}
+
+// toTimeAt converts a datetime param value, resolving "now" (e.g. "now - 1d") with now.
+func toTimeAt(value any, format string, now func() time.Time) (time.Time, error) {
 	switch t := value.(type) {
 	case time.Time:
 		return t, nil
@@ -435,10 +627,10 @@ func toTime(value any, format string) (time.Time, error) {
 		value = strings.TrimSpace(t)
 		if strings.HasPrefix(t, "now") {
 			offset := strings.TrimSpace(strings.TrimPrefix(t, "now"))
-			now := nowFunc()
+			current := now()
 
 			if offset == "" {
-				return now, nil
+				return current, nil
 			}
 
 			// Matches things like "+1d", "- 2h", etc.
@@ -451,7 +643,7 @@ func toTime(value any, format string) (time.Time, error) {
 			// sign := matches[1]
 			// durStr := matches[2]
 			// dur, err := str2duration.ParseDuration(durStr)
-			now, err := addSmartDuration(now, offset)
+			current, err := addSmartDuration(current, offset)
 			if err != nil {
 				return time.Time{}, fmt.Errorf("invalid duration: %w", err)
 			}
@@ -461,7 +653,7 @@ func toTime(value any, format string) (time.Time, error) {
 			// } else {
 			// 	now = now.Add(dur)
 			// }
-			return now, nil
+			return current, nil
 		}
 
 		// Regular timestamp
@@ -476,11 +668,34 @@ func (p *Paginator) shouldStop(body interface{}) (bool, error) {
 	if p.config.Pagination.NextPageUrlSelector != "" && p.nextPageUrl == "" {
 		return true, nil
 	}
+	// stop after the pages of the total count
+	if p.totalPages >= 0 && p.pageNum >= p.totalPages {
+		return true, nil
+	}
+	// stop as soon as a cursor param was not found in the response, null or empty
+	for _, param := range p.config.Pagination.Params {
+		if param.Type == "cursor" {
+			if val := p.ctx[param.Name]; val == nil || val == "" {
+				return true, nil
+			}
+		}
+	}
 
 	for _, cond := range p.config.Pagination.StopOn {
 		switch cond.Type {
 		case "pageNum":
-			return p.pageNum >= cond.Value.(int), nil
+			if p.pageNum >= cond.Value.(int) {
+				return true, nil
+			}
+		case "timeBudget":
+			budget, err := timeBudgetDuration(cond.Value)
+			if err != nil {
+				return false, err
+			}
+			if p.clock().Sub(p.started) >= budget {
+				p.timeBudgetReached = true
+				return true, nil
+			}
 		case "responseBody":
 			res, err := evalJQ(cond.Expression, body)
 			if err != nil {
@@ -512,7 +727,7 @@ func (p *Paginator) shouldStop(body interface{}) (bool, error) {
 				continue
 			}
 
-			ok, err := compareValues(*paramDef, val, cond.Value, cond.Compare)
+			ok, err := compareValues(*paramDef, val, cond.Value, cond.Compare, p.now)
 			if err != nil {
 				return false, err
 			}
@@ -526,29 +741,147 @@ func (p *Paginator) shouldStop(body interface{}) (bool, error) {
 
 func (p *Paginator) NextFromCtx() *RequestParts {
 	q := make(map[string]string)
+	var qa map[string][]string // only set for array values
 	h := make(map[string]string)
 	b := make(map[string]interface{})
 
 	for _, param := range p.config.Pagination.Params {
 		val := p.ctx[param.Name]
+		// a dynamic param has no value until its source is found in a response,
+		// rather than sending an empty value the request goes without it
+		if val == nil {
+			continue
+		}
 		switch param.Location {
 		case "query":
-			q[param.Name] = fmt.Sprintf("%v", val)
+			if items, ok := val.([]any); ok {
+				if qa == nil {
+					qa = make(map[string][]string)
+				}
+				qa[param.Name] = make([]string, len(items))
+				for i, item := range items {
+					qa[param.Name][i] = queryValue(item)
+				}
+			} else {
+				q[param.Name] = formatParamValue(param, val)
+			}
 		case "header":
-			h[param.Name] = fmt.Sprintf("%v", val)
+			h[param.Name] = formatParamValue(param, val)
 		case "body":
-			b[param.Name] = val
+			b[param.Name] = bodyParamValue(param, val)
 		}
 	}
 
 	return &RequestParts{
 		QueryParams: q,
+		QueryArrays: qa,
 		BodyParams:  b,
 		Headers:     h,
 		NextPageUrl: p.nextPageUrl,
 	}
 }
 
+// formatParamValue renders a param value for the url or the headers of a request.
+// Datetime params hold a time.Time until their first increment.
+func formatParamValue(param Param, val any) string {
+	if t, ok := val.(time.Time); ok && param.Format != "" {
+		return t.Format(param.Format)
+	}
+	if param.Decimals != nil {
+		if f, err := toFloat64(val); err == nil {
+			return strconv.FormatFloat(f, 'f', *param.Decimals, 64)
+		}
+	}
+	s := fmt.Sprintf("%v", val)
+	if param.Pad > 0 {
+		s = padDigits(s, param.Pad)
+	}
+	return s
+}
+
+// bodyParamValue renders a param value for a JSON body: padded numbers become strings and
+// fixed decimals numbers keep their trailing zeros.
+func bodyParamValue(param Param, val any) any {
+	switch {
+	case param.Type == "int" && param.Pad > 0:
+		return formatParamValue(param, val)
+	case param.Type == "float" && param.Decimals != nil:
+		return json.Number(formatParamValue(param, val))
+	case param.Type == "string" && param.Pad > 0:
+		return formatParamValue(param, val)
+	}
+	return val
+}
+
+var digitsPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// padDigits zero pads a non negative integer to width digits, other values are left alone.
+func padDigits(s string, width int) string {
+	if !digitsPattern.MatchString(s) || len(s) >= width {
+		return s
+	}
+	return strings.Repeat("0", width-len(s)) + s
+}
+
+// incrementCode increments a numeric string code like "00042", keeping its width: the
+// code is never turned into a number, which would drop the leading zeros.
+func incrementCode(code, increment string) (string, error) {
+	if !digitsPattern.MatchString(code) {
+		return "", fmt.Errorf("'%s' is not a numeric code", code)
+	}
+	n, err := strconv.ParseInt(code, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	res, err := evalSimpleExpr(increment, n)
+	if err != nil {
+		return "", err
+	}
+	next, ok := res.(int64)
+	if !ok {
+		f, err := toFloat64(res)
+		if err != nil || f != float64(int64(f)) {
+			return "", fmt.Errorf("increment of a numeric code must yield an integer, got %v", res)
+		}
+		next = int64(f)
+	}
+	if next < 0 {
+		return "", fmt.Errorf("increment of a numeric code yields the negative %d", next)
+	}
+	return padDigits(strconv.FormatInt(next, 10), len(code)), nil
+}
+
+// roundDecimals rounds an incremented float, so that e.g. 0.1 + 0.2 stays 0.3 page after page.
+func roundDecimals(val any, decimals int) (float64, error) {
+	f, err := toFloat64(val)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strconv.FormatFloat(f, 'f', decimals, 64), 64)
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// parseUUID checks a uuid param value, returned in lower case.
+func parseUUID(value string) (string, error) {
+	if !uuidPattern.MatchString(value) {
+		return "", fmt.Errorf("'%s' is not a UUID", value)
+	}
+	return strings.ToLower(value), nil
+}
+
+// headerValue looks up a response header, falling back to the canonical form of key.
+func headerValue(headers map[string][]string, key string) (string, bool) {
+	val, ok := headers[key]
+	if !ok {
+		val, ok = headers[http.CanonicalHeaderKey(key)]
+	}
+	if !ok || len(val) == 0 {
+		return "", false
+	}
+	return val[0], true
+}
+
 // Next advances the paginator and returns query/body/header params for the next request
 func (p *Paginator) Next(resp *http.Response) (*RequestParts, bool, error) {
 	if p.stopped {
@@ -557,7 +890,8 @@ func (p *Paginator) Next(resp *http.Response) (*RequestParts, bool, error) {
 
 	// Step 1: Read body into buffer
 	var buf bytes.Buffer
-	if _, err := buf.ReadFrom(resp.Body); err != nil {
+	_, err := buf.ReadFrom(resp.Body)
+	if err != nil {
 		return nil, false, fmt.Errorf("failed to read body: %w", err)
 	}
 
@@ -566,7 +900,12 @@ func (p *Paginator) Next(resp *http.Response) (*RequestParts, bool, error) {
 
 	// Step 3: Decode into JSON
 	var bodyJSON interface{}
-	if err := json.Unmarshal(buf.Bytes(), &bodyJSON); err != nil {
+	if p.decode != nil {
+		bodyJSON, err = p.decode(buf.Bytes())
+	} else {
+		err = json.Unmarshal(buf.Bytes(), &bodyJSON)
+	}
+	if err != nil {
 		return nil, false, fmt.Errorf("failed to decode body: %w", err)
 	}
 
@@ -576,7 +915,15 @@ func (p *Paginator) Next(resp *http.Response) (*RequestParts, bool, error) {
 		return nil, false, err
 	}
 
-	if err := p.extractNextUrl(bodyJSON, headers); err != nil {
+	var base *url.URL
+	if resp.Request != nil {
+		base = resp.Request.URL
+	}
+	if err := p.extractNextUrl(bodyJSON, headers, base); err != nil {
+		return nil, false, err
+	}
+
+	if err := p.extractTotal(bodyJSON, headers); err != nil {
 		return nil, false, err
 	}
 
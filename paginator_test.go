@@ -5,6 +5,7 @@
 package apigorowler
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -198,3 +199,127 @@ func TestNextUrlSelector(t *testing.T) {
 func TestStopOnPageNum(t *testing.T) {
 	runPaginatorTest(t, "testdata/paginator/test9_stop_on_iteration.yaml", 3)
 }
+
+func TestHeaderParams(t *testing.T) {
+	runPaginatorTest(t, "testdata/paginator/test10_header_params.yaml", 3)
+
+	p, _, _, err := LoadPaginatorTestFile("testdata/paginator/test10_header_params.yaml")
+	require.NoError(t, err)
+	// the first request goes without the dynamic param, datetimes use their format
+	assert.Equal(t, map[string]string{"X-Page": "1", "If-Modified-Since": "2024-01-01"}, p.NextFromCtx().Headers)
+}
+
+func TestFormattedParams(t *testing.T) {
+	runPaginatorTest(t, "testdata/paginator/test11_formatted_params.yaml", 2)
+
+	p, _, _, err := LoadPaginatorTestFile("testdata/paginator/test11_formatted_params.yaml")
+	require.NoError(t, err)
+	// the code keeps its leading zeros, the price its trailing ones
+	next := p.NextFromCtx()
+	assert.Equal(t, map[string]string{"code": "0098", "page": "001"}, next.QueryParams)
+	assert.Equal(t, "0.10", next.Headers["X-Min-Price"])
+	decimals := 2
+	assert.Equal(t, json.Number("0.10"), bodyParamValue(Param{Type: "float", Decimals: &decimals}, 0.1))
+	assert.Equal(t, "007", bodyParamValue(Param{Type: "int", Pad: 3}, 7))
+
+	code, err := incrementCode("0999", "+ 1")
+	require.NoError(t, err)
+	assert.Equal(t, "1000", code)
+	_, err = incrementCode("A12", "+ 1")
+	assert.ErrorContains(t, err, "not a numeric code")
+
+	assert.Equal(t, []ValidationError{
+		{"pagination param pad must be a positive number of digits, for int and string params", "p.pad"},
+		{"pagination param decimals must not be negative, for float params", "p.decimals"},
+		{"pagination param default of a uuid param: 'abc' is not a UUID", "p.default"},
+		{"pagination param increment is not supported for uuid params", "p.increment"},
+	}, validatePaginationParam(Param{Name: "id", Location: "query", Type: "uuid", Default: "abc", Increment: "+ 1", Pad: 2, Decimals: &decimals}, "p"))
+}
+
+func TestCursorParams(t *testing.T) {
+	runPaginatorTest(t, "testdata/paginator/test12_cursor.yaml", 3)
+
+	p, _, _, err := LoadPaginatorTestFile("testdata/paginator/test12_cursor.yaml")
+	require.NoError(t, err)
+	// the first page goes without the cursor, or with its start value
+	next := p.NextFromCtx()
+	assert.Empty(t, next.QueryParams)
+	assert.Equal(t, map[string]string{"X-Resume": "*"}, next.Headers)
+
+	// a cursor missing in the response stops the pagination
+	_, done, err := p.Next(&http.Response{Body: io.NopCloser(strings.NewReader(`{"meta": {}}`)), Header: http.Header{"X-Resume-Token": {"r1"}}})
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	assert.Equal(t, []ValidationError{
+		{"pagination param source is required when type is cursor", "p.source"},
+		{"pagination param increment is not supported for cursor params", "p.increment"},
+	}, validatePaginationParam(Param{Name: "cursor", Location: "query", Type: "cursor", Increment: "+ 1"}, "p"))
+	assert.Empty(t, validatePagination(Pagination{Params: []Param{{Name: "cursor", Location: "query", Type: "cursor", Source: ".next"}}}, "p"), "a cursor needs no stopOn")
+}
+
+func TestLinkHeaderPagination(t *testing.T) {
+	runPaginatorTest(t, "testdata/paginator/test13_link_header.yaml", 3)
+
+	// relative links resolve against the url of the request, other relations are picked by name
+	p, err := NewPaginator(ConfigP{Pagination: Pagination{NextPageUrlSelector: "link:alternate"}})
+	require.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v1/items?page=1", nil)
+	next, done, err := p.Next(&http.Response{
+		Body:    io.NopCloser(strings.NewReader("[]")),
+		Header:  http.Header{"Link": {`<https://api.example.com/v1/items?page=2>; rel="next"`, `</v1/items?page=2&view=compact>; REL=alternate`}},
+		Request: req,
+	})
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "https://api.example.com/v1/items?page=2&view=compact", next.NextPageUrl)
+
+	assert.Equal(t, []ValidationError{
+		{"nextPageUrlSelector source 'links' must be one of body, header, link", "p.nextPageUrlSelector"},
+	}, validatePagination(Pagination{NextPageUrlSelector: "links"}, "p"))
+}
+
+func TestTotalCountPagination(t *testing.T) {
+	runPaginatorTest(t, "testdata/paginator/test14_total_count.yaml", 3)
+
+	p, err := NewPaginator(ConfigP{Pagination: Pagination{
+		Params: []Param{{Name: "page", Location: "query", Type: "int", Default: "1", Increment: "+ 1"}},
+		Total:  &TotalCount{Source: ".meta.total", PageSize: 50, Concurrency: 4},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, -1, p.TotalPages())
+	_, done, err := p.Next(&http.Response{Body: io.NopCloser(strings.NewReader(`{"meta": {"total": 160}}`))})
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 4, p.TotalPages())
+
+	// the remaining pages are computed ahead, leaving the paginator as it was
+	parts, err := p.remainingPages()
+	require.NoError(t, err)
+	pages := []string{}
+	for _, part := range parts {
+		pages = append(pages, part.QueryParams["page"])
+	}
+	assert.Equal(t, []string{"2", "3", "4"}, pages)
+	assert.Equal(t, "2", p.NextFromCtx().QueryParams["page"])
+	assert.Equal(t, 1, p.PageNum())
+
+	_, _, err = p.Next(&http.Response{Body: io.NopCloser(strings.NewReader(`{}`))})
+	require.NoError(t, err, "the total is read from the first response only")
+
+	p, err = NewPaginator(ConfigP{Pagination: Pagination{
+		Params: []Param{{Name: "page", Location: "query", Type: "int", Default: "1", Increment: "+ 1"}},
+		Total:  &TotalCount{Source: "body:.total", PageSize: 50},
+	}})
+	require.NoError(t, err)
+	_, _, err = p.Next(&http.Response{Body: io.NopCloser(strings.NewReader(`{}`))})
+	assert.EqualError(t, err, "total count not found at body:.total")
+
+	assert.Equal(t, []ValidationError{
+		{"pagination.total.pageSize must be positive", "p.total.pageSize"},
+		{"pagination.total.concurrency can not compute cursor params ahead", "p.params[0]"},
+	}, validatePagination(Pagination{
+		Params: []Param{{Name: "cursor", Location: "query", Type: "cursor", Source: ".next"}},
+		Total:  &TotalCount{Source: ".total", Concurrency: 2},
+	}, "p"))
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// templateParamsPrefix declares $params in every go-template of the configuration.
+const templateParamsPrefix = "{{ $params := params }}"
+
+// RunWithParams runs the crawler with caller supplied parameters, available as $params
+// in go-templates ({{ $params.tenant }}) and jq expressions ($params.tenant) for this run
+// only. One configuration can so be run per tenant or region without rewriting rootContext.
+// Params must be JSON encodable.
+func (c *ApiCrawler) RunWithParams(ctx context.Context, params map[string]any) error {
+	normalized, err := normalizeParams(params)
+	if err != nil {
+		return err
+	}
+	return c.runLocked(ctx, normalized)
+}
+
+// normalizeParams turns params into plain JSON values, the only ones jq accepts.
+func normalizeParams(params map[string]any) (map[string]any, error) {
+	normalized := map[string]any{}
+	if len(params) == 0 {
+		return normalized, nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid run params: %w", err)
+	}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, fmt.Errorf("invalid run params: %w", err)
+	}
+	return normalized, nil
+}
+
+// runParams is the params template function, returning the $params of the current run.
+func (c *ApiCrawler) runParams() map[string]any {
+	return c.params
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// StepFailure is a step (or forEach item) that made the run fail.
+type StepFailure struct {
+	Step  string `json:"step,omitempty"` // step location, e.g. steps[0].steps[1]; empty for failures after the steps (sinks, schema drift)
+	Name  string `json:"name,omitempty"`
+	Items []int  `json:"items,omitempty"` // iteration indexes of the enclosing forEach steps, outermost first
+	URL   string `json:"url,omitempty"`
+	Error error  `json:"-"`
+}
+
+// PartialData is the outcome of the last run, also when it failed.
+type PartialData struct {
+	// Data is the root context. Steps merge their result only once their nested steps
+	// completed, so after a failure it holds the results of the top level steps that
+	// completed before the failing one.
+	Data     any
+	Complete bool
+	Failures []StepFailure
+}
+
+type runFailures struct {
+	mu       sync.Mutex
+	failures []StepFailure
+}
+
+func newRunFailures() *runFailures {
+	return &runFailures{}
+}
+
+// GetPartialData returns the data of the last run together with the failed steps,
+// so that loaders can do partial updates after a failed run.
+func (a *ApiCrawler) GetPartialData() PartialData {
+	a.failures.mu.Lock()
+	defer a.failures.mu.Unlock()
+
+	var data any
+	if root, ok := a.ContextMap["root"]; ok {
+		data = root.Data
+	}
+	return PartialData{
+		Data:     data,
+		Complete: a.complete,
+		Failures: append([]StepFailure{}, a.failures.failures...),
+	}
+}
+
+// recordFailure records the failure of a step. Errors are propagated unchanged by the
+// parent steps, only the innermost step returning err is recorded; it reports whether
+// the failure was recorded.
+func (c *ApiCrawler) recordFailure(exec *stepExecution, err error) bool {
+	c.failures.mu.Lock()
+	defer c.failures.mu.Unlock()
+
+	for _, f := range c.failures.failures {
+		if errors.Is(err, f.Error) {
+			return false
+		}
+	}
+	// parallel forEach iterations cancelled because of another failure
+	if len(c.failures.failures) > 0 && errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	failure := StepFailure{Error: err}
+	if exec != nil {
+		failure.Step = exec.path
+		failure.Name = exec.step.Name
+	}
+	var httpErr *HTTPError
+	var probeErr *ProbeError
+	if errors.As(err, &httpErr) {
+		failure.URL = httpErr.URL
+	} else if errors.As(err, &probeErr) {
+		failure.URL = probeErr.URL
+	}
+	c.failures.failures = append(c.failures.failures, failure)
+	return true
+}
+
+// recordFailedItem marks the failure caused by err as happened in iteration i of a forEach step.
+func (c *ApiCrawler) recordFailedItem(err error, i int) {
+	c.failures.mu.Lock()
+	defer c.failures.mu.Unlock()
+
+	for j := range c.failures.failures {
+		if errors.Is(err, c.failures.failures[j].Error) {
+			c.failures.failures[j].Items = append([]int{i}, c.failures.failures[j].Items...)
+			return
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+)
+
+// fetchAhead requests the next page of a pipelined pagination while the current one is
+// transformed, runs its nested steps and is merged. The page is delivered on the returned
+// channel once its body is read.
+func fetchAhead(ctx context.Context, send pageSender, pageNum int, next *RequestParts) <-chan prefetchedPage {
+	ahead := make(chan prefetchedPage, 1)
+	go func() {
+		ahead <- fetchPage(ctx, send, pageNum, next)
+	}()
+	return ahead
+}
+
+func validatePipeline(p Pagination, location string) []ValidationError {
+	if p.Total != nil && p.Total.Concurrency > 1 {
+		return []ValidationError{{"pagination.pipeline can not be combined with total.concurrency, the pages are already requested ahead", location}}
+	}
+	return nil
+}
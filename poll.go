@@ -0,0 +1,344 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	POLL_PROTOCOL_MQTT  = "mqtt"
+	POLL_PROTOCOL_OPCUA = "opcua"
+
+	mqttDefaultWait = time.Second
+)
+
+type PollConfig struct {
+	Protocol string   `yaml:"protocol,omitempty" json:"protocol,omitempty"` // mqtt | opcua, defaults from the url scheme
+	Topics   []string `yaml:"topics,omitempty" json:"topics,omitempty"`     // mqtt topic filters
+	NodeIDs  []string `yaml:"nodeIds,omitempty" json:"nodeIds,omitempty"`   // opcua node ids, e.g. ns=2;s=Temperature
+	Username string   `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string   `yaml:"password,omitempty" json:"password,omitempty"`
+	ClientID string   `yaml:"clientId,omitempty" json:"clientId,omitempty"` // mqtt, random by default
+	WaitMs   int      `yaml:"waitMs,omitempty" json:"waitMs,omitempty"`     // mqtt, how long to wait for further retained messages
+}
+
+// protocol returns the configured protocol, or the one of the url scheme.
+// It is empty for other schemes.
+func (p *PollConfig) protocol(rawURL string) string {
+	if p.Protocol != "" {
+		return p.Protocol
+	}
+	scheme, _, _ := strings.Cut(rawURL, "://")
+	switch scheme {
+	case "mqtt", "mqtts":
+		return POLL_PROTOCOL_MQTT
+	case "opc.tcp":
+		return POLL_PROTOCOL_OPCUA
+	}
+	return ""
+}
+
+// OPCUAReader reads the current value of OPC-UA nodes, keyed by node id.
+// The module does not ship an OPC-UA stack; embedders provide one
+// (e.g. built on github.com/gopcua/opcua) with SetOPCUAReader.
+// Runs with opcua poll steps and no reader fail before the first step.
+type OPCUAReader interface {
+	Read(ctx context.Context, endpoint string, nodeIDs []string, auth PollConfig) (map[string]any, error)
+}
+
+func (a *ApiCrawler) SetOPCUAReader(reader OPCUAReader) {
+	a.opcuaReader = reader
+}
+
+// handlePoll reads a snapshot of sensor values, the retained messages of MQTT topics or
+// OPC-UA node values, as one JSON object handled like an HTTP response.
+func (c *ApiCrawler) handlePoll(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Poll] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	_url, err := c.renderURL(exec.step.Request.URL, c.templateData(exec, templateCtx))
+	if err != nil {
+		return err
+	}
+	urlObj, err := url.Parse(_url)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %w", _url, err)
+	}
+
+	cfg := *exec.step.Poll
+	if cfg.Username == "" && urlObj.User != nil {
+		cfg.Username = urlObj.User.Username()
+		cfg.Password, _ = urlObj.User.Password()
+	}
+	logURL := *urlObj
+	logURL.User = nil
+
+	c.logger.Info("[Poll] %s", logURL.String())
+
+	if err := c.chargeRequest(exec); err != nil {
+		return err
+	}
+
+	var values map[string]any
+	switch cfg.protocol(_url) {
+	case POLL_PROTOCOL_OPCUA:
+		if c.opcuaReader == nil {
+			return fmt.Errorf("step '%s' requires an OPC-UA reader, set one with SetOPCUAReader", exec.step.Name)
+		}
+		values, err = c.opcuaReader.Read(ctx, _url, cfg.NodeIDs, cfg)
+	case POLL_PROTOCOL_MQTT:
+		values, err = mqttSnapshot(ctx, urlObj, cfg)
+	default:
+		return fmt.Errorf("no poll protocol for %s", logURL.String())
+	}
+	if err != nil {
+		return fmt.Errorf("error polling %s: %w", logURL.String(), err)
+	}
+
+	// normalize to JSON types, as for decoded responses
+	var raw interface{}
+	if err := roundTripJSON(values, &raw); err != nil {
+		return err
+	}
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Poll '%s'", exec.step.Name), exec, raw, nil, "url", logURL.String())
+
+	transformed, err := c.transformResult(exec, raw, templateCtx, nil)
+	if err != nil {
+		return err
+	}
+	c.pushProfilerDiff("Response Transformation", exec, transformed, raw, "url", logURL.String())
+
+	return c.mergeStepResult(ctx, exec, transformed, nil, "url", logURL.String())
+}
+
+func roundTripJSON(in any, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("error encoding values: %w", err)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// mqttSnapshot connects to a broker (mqtt:// or mqtts://) and collects the retained
+// messages of the configured topics.
+func mqttSnapshot(ctx context.Context, u *url.URL, cfg PollConfig) (map[string]any, error) {
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "mqtts" {
+			host += ":8883"
+		} else {
+			host += ":1883"
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "mqtts" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mqtt dial error: %w", err)
+	}
+	defer conn.Close()
+
+	return mqttRetained(ctx, conn, cfg)
+}
+
+// mqttRetained runs an MQTT 3.1.1 session on conn: connect, subscribe with QoS 0 and
+// collect retained messages until every plain topic is received or no message arrives
+// within the wait window. Live (non retained) messages are ignored.
+func mqttRetained(ctx context.Context, conn net.Conn, cfg PollConfig) (map[string]any, error) {
+	wait := mqttDefaultWait
+	if cfg.WaitMs > 0 {
+		wait = time.Duration(cfg.WaitMs) * time.Millisecond
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	clientID := cfg.ClientID
+	if clientID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		clientID = "apigorowler-" + hex.EncodeToString(b)
+	}
+
+	// CONNECT
+	var flags byte = 0x02 // clean session
+	payload := mqttString(clientID)
+	if cfg.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(cfg.Username)...)
+		if cfg.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(cfg.Password)...)
+		}
+	}
+	variable := append(mqttString("MQTT"), 4, flags, 0, 60)
+	if err := mqttWrite(conn, 0x10, append(variable, payload...)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	packetType, body, err := mqttRead(reader)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt connect: %w", err)
+	}
+	if packetType>>4 != 2 || len(body) < 2 {
+		return nil, fmt.Errorf("mqtt connect: unexpected packet type %d", packetType>>4)
+	}
+	if body[1] != 0 {
+		return nil, fmt.Errorf("mqtt connect refused with code %d", body[1])
+	}
+
+	// SUBSCRIBE, packet id 1
+	subscribe := []byte{0, 1}
+	pending := map[string]bool{}
+	wildcards := false
+	for _, topic := range cfg.Topics {
+		subscribe = append(subscribe, mqttString(topic)...)
+		subscribe = append(subscribe, 0)
+		if strings.ContainsAny(topic, "+#") {
+			wildcards = true
+		} else {
+			pending[topic] = true
+		}
+	}
+	if err := mqttWrite(conn, 0x82, subscribe); err != nil {
+		return nil, err
+	}
+
+	values := map[string]any{}
+	for {
+		if !wildcards && len(pending) == 0 {
+			break
+		}
+		readDeadline := time.Now().Add(wait)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+
+		packetType, body, err := mqttRead(reader)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			break // quiet for the whole wait window, the snapshot is complete
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mqtt read: %w", err)
+		}
+
+		switch packetType >> 4 {
+		case 9: // SUBACK
+			if len(body) < 2 {
+				return nil, fmt.Errorf("mqtt subscribe: malformed packet")
+			}
+			for _, code := range body[2:] {
+				if code == 0x80 {
+					return nil, fmt.Errorf("mqtt subscription refused")
+				}
+			}
+		case 3: // PUBLISH
+			if packetType&0x01 == 0 {
+				continue
+			}
+			if len(body) < 2 {
+				return nil, fmt.Errorf("mqtt publish: malformed packet")
+			}
+			topicLen := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+topicLen {
+				return nil, fmt.Errorf("mqtt publish: malformed packet")
+			}
+			topic := string(body[2 : 2+topicLen])
+			message := body[2+topicLen:]
+			if (packetType>>1)&0x03 > 0 && len(message) >= 2 {
+				message = message[2:] // packet id, QoS 0 was requested but some brokers keep the original
+			}
+
+			var value any
+			if err := json.Unmarshal(message, &value); err != nil {
+				value = string(message)
+			}
+			values[topic] = value
+			delete(pending, topic)
+		}
+	}
+
+	mqttWrite(conn, 0xE0, nil) // DISCONNECT
+	return values, nil
+}
+
+func mqttString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func mqttWrite(w io.Writer, header byte, body []byte) error {
+	packet := []byte{header}
+	// remaining length, variable byte integer
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(packet, body...))
+	return err
+}
+
+func mqttRead(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mqttPublish(topic, payload string, retained bool) []byte {
+	var header byte = 0x30
+	if retained {
+		header |= 0x01
+	}
+	packet := []byte{header, byte(2 + len(topic) + len(payload))}
+	packet = append(packet, mqttString(topic)...)
+	return append(packet, payload...)
+}
+
+func TestMQTTRetainedSnapshot(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	connect := make(chan []byte, 1)
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		_, body, err := mqttRead(reader)
+		if err != nil {
+			return
+		}
+		connect <- body
+		server.Write([]byte{0x20, 0x02, 0x00, 0x00})
+
+		if _, _, err := mqttRead(reader); err != nil {
+			return
+		}
+		server.Write([]byte{0x90, 0x04, 0x00, 0x01, 0x00, 0x00})
+		server.Write(mqttPublish("sensors/bz/temp", `{"value": 21.5}`, true))
+		server.Write(mqttPublish("sensors/bz/temp", `{"value": 99}`, false)) // live update, ignored
+		server.Write(mqttPublish("sensors/bz/state", `online`, true))
+		mqttRead(reader) // DISCONNECT
+	}()
+
+	values, err := mqttRetained(context.TODO(), client, PollConfig{
+		Topics:   []string{"sensors/bz/temp", "sensors/bz/state"},
+		Username: "noi",
+		Password: "secret",
+		ClientID: "test",
+	})
+	require.NoError(t, err)
+
+	body := <-connect
+	assert.Equal(t, byte(0xC2), body[7], "username, password and clean session flags")
+	assert.Equal(t, map[string]any{
+		"sensors/bz/temp":  map[string]any{"value": 21.5},
+		"sensors/bz/state": "online",
+	}, values)
+}
+
+func TestPollProtocolFromScheme(t *testing.T) {
+	cfg := PollConfig{}
+	assert.Equal(t, POLL_PROTOCOL_MQTT, cfg.protocol("mqtts://broker.example.com"))
+	assert.Equal(t, POLL_PROTOCOL_OPCUA, cfg.protocol("opc.tcp://plc.example.com:4840"))
+	assert.Equal(t, "", cfg.protocol("opc.https://plc.example.com"), "no fallback to mqtt")
+
+	errs := validateStep(Step{Type: "poll", Request: &RequestConfig{URL: "opc.https://plc.example.com"}, Poll: &PollConfig{NodeIDs: []string{"ns=2;s=Temperature"}}}, "steps[0]")
+	require.Len(t, errs, 1)
+	assert.Equal(t, "steps[0].request.url", errs[0].Location)
+}
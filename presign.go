@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+const (
+	URL_SIGNER_S3  = "s3"
+	URL_SIGNER_GCS = "gcs"
+)
+
+const (
+	defaultPresignExpiresSeconds = 900
+	maxPresignExpiresSeconds     = 7 * 24 * 3600 // limit of S3 and GCS
+	gcsDefaultEndpoint           = "https://storage.googleapis.com"
+)
+
+// URLSignerConfig holds the credentials of a bucket whose objects are fetched with pre-signed
+// GET URLs, generated by the presign helpers: {{ presign "archive" .file.key }} in templates,
+// .key | presign("archive") in jq. GCS buckets are signed with HMAC keys.
+type URLSignerConfig struct {
+	Provider     string `yaml:"provider" json:"provider"`                     // s3 | gcs
+	Endpoint     string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"` // required for s3, e.g. https://s3.eu-west-1.amazonaws.com
+	Region       string `yaml:"region,omitempty" json:"region,omitempty"`     // gcs default auto
+	Bucket       string `yaml:"bucket" json:"bucket"`
+	AccessKey    string `yaml:"accessKey,omitempty" json:"accessKey,omitempty"` // s3 defaults to AWS_ACCESS_KEY_ID
+	SecretKey    string `yaml:"secretKey,omitempty" json:"secretKey,omitempty"`
+	SessionToken string `yaml:"sessionToken,omitempty" json:"sessionToken,omitempty"` // s3 only
+	PathStyle    bool   `yaml:"pathStyle,omitempty" json:"pathStyle,omitempty"`
+	// ExpiresSeconds is the validity of the URLs, default 900, at most 7 days
+	ExpiresSeconds int `yaml:"expiresSeconds,omitempty" json:"expiresSeconds,omitempty"`
+}
+
+// sigV4Query is the flavour of query string signing of a provider.
+type sigV4Query struct {
+	algorithm  string
+	keyPrefix  string
+	terminator string
+	service    string
+	param      string // prefix of the signing query parameters
+}
+
+var presignFlavours = map[string]sigV4Query{
+	URL_SIGNER_S3:  {algorithm: sigV4Algorithm, keyPrefix: "AWS4", terminator: "aws4_request", service: "s3", param: "X-Amz-"},
+	URL_SIGNER_GCS: {algorithm: "GOOG4-HMAC-SHA256", keyPrefix: "GOOG4", terminator: "goog4_request", service: "storage", param: "X-Goog-"},
+}
+
+// presign returns the pre-signed GET URL of the object key, valid from now.
+func (s URLSignerConfig) presign(key string, now time.Time) (string, error) {
+	flavour, ok := presignFlavours[s.Provider]
+	if !ok {
+		return "", fmt.Errorf("unknown url signer provider: %s", s.Provider)
+	}
+
+	endpoint, region := s.Endpoint, s.Region
+	creds := awsCredentials{AccessKey: s.AccessKey, SecretKey: s.SecretKey}
+	switch s.Provider {
+	case URL_SIGNER_S3:
+		creds.SessionToken = s.SessionToken
+		if creds.AccessKey == "" {
+			creds = awsCredentials{
+				AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			}
+		}
+	case URL_SIGNER_GCS:
+		if endpoint == "" {
+			endpoint = gcsDefaultEndpoint
+		}
+		if region == "" {
+			region = "auto"
+		}
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid url signer endpoint: %w", err)
+	}
+	key = strings.TrimPrefix(key, "/")
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	// the URL must carry the path as it was signed
+	u.RawPath = canonicalURI(u.Path)
+
+	expires := s.ExpiresSeconds
+	if expires <= 0 {
+		expires = defaultPresignExpiresSeconds
+	}
+	now = now.UTC()
+	date := now.Format(sigV4TimeFormat)
+	shortDate := now.Format(sigV4DateFormat)
+	scope := fmt.Sprintf("%s/%s/%s/%s", shortDate, region, flavour.service, flavour.terminator)
+
+	query := url.Values{}
+	query.Set(flavour.param+"Algorithm", flavour.algorithm)
+	query.Set(flavour.param+"Credential", creds.AccessKey+"/"+scope)
+	query.Set(flavour.param+"Date", date)
+	query.Set(flavour.param+"Expires", strconv.Itoa(expires))
+	query.Set(flavour.param+"SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set(flavour.param+"Security-Token", creds.SessionToken)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		u.RawPath,
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		flavour.algorithm,
+		date,
+		scope,
+		hashPayload([]byte(canonicalRequest)),
+	}, "\n")
+	signingKey := sigV4SigningKey(flavour.keyPrefix, flavour.terminator, creds.SecretKey, shortDate, region, flavour.service)
+	query.Set(flavour.param+"Signature", hex.EncodeToString(hmacSHA256(signingKey, stringToSign)))
+
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// presignURL is the presign template function: the pre-signed GET URL of key with the
+// credentials of the urlSigners entry name, signed at the server time. The URL is marked
+// safe, html/template would otherwise escape the ampersands of its query.
+func (c *ApiCrawler) presignURL(name string, key string) (template.HTML, error) {
+	signed, err := c.signURL(name, key)
+	return template.HTML(signed), err
+}
+
+// signURL signs key with the urlSigners entry name.
+func (c *ApiCrawler) signURL(name string, key string) (string, error) {
+	signer, ok := c.Config.URLSigners[name]
+	if !ok {
+		return "", fmt.Errorf("presign: unknown url signer '%s'", name)
+	}
+	return signer.presign(key, c.serverNow())
+}
+
+// jqPresign is the presign jq function: .key | presign("archive")
+func (c *ApiCrawler) jqPresign() gojq.CompilerOption {
+	return gojq.WithFunction("presign", 1, 1, func(v any, args []any) any {
+		key, okKey := v.(string)
+		name, okName := args[0].(string)
+		if !okKey || !okName {
+			return fmt.Errorf("presign: key and signer name must be strings, got %v, %v", v, args[0])
+		}
+		u, err := c.signURL(name, key)
+		if err != nil {
+			return err
+		}
+		return u
+	})
+}
+
+// templateNoPresign stands in for presignURL where templates are only parsed.
+func templateNoPresign(name string, key string) (template.HTML, error) {
+	return "", fmt.Errorf("presign: unknown url signer '%s'", name)
+}
+
+func validateURLSigner(signer URLSignerConfig, location string) []ValidationError {
+	var errs []ValidationError
+	if _, ok := presignFlavours[signer.Provider]; !ok {
+		errs = append(errs, ValidationError{fmt.Sprintf("provider must be one of %s, %s", URL_SIGNER_S3, URL_SIGNER_GCS), location + ".provider"})
+	}
+	if signer.Bucket == "" {
+		errs = append(errs, ValidationError{"bucket is required", location + ".bucket"})
+	}
+	if signer.Provider == URL_SIGNER_S3 && signer.Endpoint == "" {
+		errs = append(errs, ValidationError{"endpoint is required for s3", location + ".endpoint"})
+	}
+	if signer.Endpoint != "" {
+		if u, err := url.Parse(signer.Endpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, ValidationError{"endpoint must be an http or https url", location + ".endpoint"})
+		}
+	}
+	if signer.Provider == URL_SIGNER_GCS {
+		if signer.AccessKey == "" || signer.SecretKey == "" {
+			errs = append(errs, ValidationError{"gcs requires accessKey and secretKey (HMAC keys)", location})
+		}
+		if signer.SessionToken != "" {
+			errs = append(errs, ValidationError{"sessionToken is only supported by s3", location + ".sessionToken"})
+		}
+	}
+	if signer.ExpiresSeconds < 0 || signer.ExpiresSeconds > maxPresignExpiresSeconds {
+		errs = append(errs, ValidationError{fmt.Sprintf("expiresSeconds must be between 1 and %d", maxPresignExpiresSeconds), location + ".expiresSeconds"})
+	}
+	return errs
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestPreview is the first request a step would send, see RenderURLPreview.
+type RequestPreview struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// RenderURLPreview renders the request of the step at stepPath (e.g. steps[0].steps[1])
+// without sending it, with the caller supplied sample values as template data: context
+// names such as the forEach "as" items, next to the keys of a map rootContext.
+// The first page pagination params are applied; authentication is not.
+func (a *ApiCrawler) RenderURLPreview(stepPath string, sampleContext map[string]any) (RequestPreview, error) {
+	step, err := a.Config.stepAt(stepPath)
+	if err != nil {
+		return RequestPreview{}, err
+	}
+	if step.Request == nil {
+		return RequestPreview{}, fmt.Errorf("step '%s' has no request", stepPath)
+	}
+	if sampleContext == nil {
+		sampleContext = map[string]any{}
+	}
+
+	rendered, err := a.renderURL(step.Request.URL, sampleContext)
+	if err != nil {
+		return RequestPreview{}, err
+	}
+	u, err := url.Parse(rendered)
+	if err != nil {
+		return RequestPreview{}, fmt.Errorf("invalid URL %s: %w", rendered, err)
+	}
+
+	paginator, err := newPaginator(ConfigP{step.Request.pagination()}, a.serverNow)
+	if err != nil {
+		return RequestPreview{}, &PaginationError{Step: stepPath, Err: err}
+	}
+	next := paginator.NextFromCtx()
+	if len(next.QueryParams) > 0 || len(next.QueryArrays) > 0 || len(step.Request.Query) > 0 {
+		if err := a.applyQuery(u, step.Request, sampleContext, next); err != nil {
+			return RequestPreview{}, err
+		}
+	}
+
+	body, err := a.buildRequestBody(step.Request, sampleContext, next)
+	if err != nil {
+		return RequestPreview{}, err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), strings.ToUpper(step.Request.Method), u.String(), body)
+	if err != nil {
+		return RequestPreview{}, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	if err := a.applyHeaders(req, step.Request, sampleContext, next.Headers); err != nil {
+		return RequestPreview{}, err
+	}
+
+	preview := RequestPreview{Method: req.Method, URL: req.URL.String()}
+	if len(req.Header) > 0 {
+		preview.Headers = make(map[string]string, len(req.Header))
+		for k := range req.Header {
+			preview.Headers[k] = strings.Join(req.Header.Values(k), ", ")
+		}
+	}
+	if body != nil {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return RequestPreview{}, err
+		}
+		preview.Body = string(data)
+	}
+	return preview, nil
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type ProbeConfig struct {
+	ExpectStatus []int  `yaml:"expectStatus,omitempty" json:"expectStatus,omitempty"` // default: any 2xx
+	MaxLatencyMs int    `yaml:"maxLatencyMs,omitempty" json:"maxLatencyMs,omitempty"`
+	Assert       string `yaml:"assert,omitempty" json:"assert,omitempty"` // jq predicate on the body, $response and $ctx are available
+}
+
+// ProbeError is returned when a probe step fails, e.g. because the upstream is in maintenance.
+// Embedders can tell it apart from crawl failures with errors.As.
+type ProbeError struct {
+	Step    string
+	URL     string
+	Reason  string
+	Status  int
+	Latency time.Duration
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("probe '%s' failed: %s (url %s, status %d, latency %s)", e.Step, e.Reason, e.URL, e.Status, e.Latency)
+}
+
+// handleProbe performs a request and asserts on its status, latency and body.
+// Nothing is merged into the context, a failed probe aborts the run with a ProbeError.
+func (c *ApiCrawler) handleProbe(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Probe] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	data := c.templateData(exec, templateCtx)
+	_url, err := c.renderURL(exec.step.Request.URL, data)
+	if err != nil {
+		return err
+	}
+
+	method := http.MethodGet
+	if exec.step.Request.Method != "" {
+		method = strings.ToUpper(exec.step.Request.Method)
+	}
+	reqBody, err := c.buildRequestBody(exec.step.Request, data, &RequestParts{})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, _url, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	if err := c.applyHeaders(req, exec.step.Request, data, nil); err != nil {
+		return err
+	}
+	if err := c.authenticate(exec, c.requestAuthenticator(exec.step.Request), req); err != nil {
+		return err
+	}
+
+	c.logger.Info("[Probe] %s", _url)
+
+	probeErr := &ProbeError{Step: exec.step.Name, URL: _url}
+	if probeErr.Step == "" {
+		probeErr.Step = exec.path
+	}
+
+	start := time.Now()
+	resp, err := c.doRequest(exec, req)
+	if err != nil {
+		probeErr.Reason = err.Error()
+		return probeErr
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(resp.Body)
+	probeErr.Latency = time.Since(start)
+	probeErr.Status = resp.StatusCode
+	if err != nil {
+		probeErr.Reason = fmt.Sprintf("error reading response: %s", err.Error())
+		return probeErr
+	}
+
+	// health endpoints often answer with plain text
+	var raw interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		raw = string(payload)
+	}
+	responseInfo := responseToJQ(resp)
+
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Probe '%s'", exec.step.Name), exec, raw, nil,
+		"url", _url, "status", resp.StatusCode, "latencyMs", probeErr.Latency.Milliseconds())
+
+	cfg := ProbeConfig{}
+	if exec.step.Probe != nil {
+		cfg = *exec.step.Probe
+	}
+
+	if len(cfg.ExpectStatus) > 0 {
+		if !slices.Contains(cfg.ExpectStatus, resp.StatusCode) {
+			probeErr.Reason = fmt.Sprintf("unexpected status %d, expected %v", resp.StatusCode, cfg.ExpectStatus)
+			return probeErr
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		probeErr.Reason = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return probeErr
+	}
+
+	if cfg.MaxLatencyMs > 0 && probeErr.Latency > time.Duration(cfg.MaxLatencyMs)*time.Millisecond {
+		probeErr.Reason = fmt.Sprintf("latency above %dms", cfg.MaxLatencyMs)
+		return probeErr
+	}
+
+	if cfg.Assert != "" {
+		code, err := c.getOrCompileJQRule(cfg.Assert, "$ctx", "$response")
+		if err != nil {
+			return fmt.Errorf("failed to get/compile probe assertion: %w", err)
+		}
+		v, ok := c.runJQ(exec, code, raw, templateCtx, responseInfo).Next()
+		if err, isErr := v.(error); isErr {
+			return fmt.Errorf("probe assertion error: %w", err)
+		}
+		if !ok || v == nil || v == false {
+			probeErr.Reason = fmt.Sprintf("assertion '%s' not satisfied", cfg.Assert)
+			return probeErr
+		}
+	}
+
+	c.logger.Info("[Probe] %s healthy (status %d, %s)", _url, resp.StatusCode, probeErr.Latency)
+	return nil
+}
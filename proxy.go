@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ProxyConfig routes the requests through an HTTP, HTTPS or SOCKS5 proxy. The proxy of a
+// request wins over the one of its hosts entry, which wins over the configuration one; the
+// hosts in NoProxy are reached directly. Without any, the HTTP client decides, the default one
+// following the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+type ProxyConfig struct {
+	URL      string   `yaml:"url" json:"url"`                               // e.g. http://proxy.local:3128 or socks5://proxy.local:1080
+	Username string   `yaml:"username,omitempty" json:"username,omitempty"` // may be a secret:// reference
+	Password string   `yaml:"password,omitempty" json:"password,omitempty"` // may be a secret:// reference
+	NoProxy  []string `yaml:"noProxy,omitempty" json:"noProxy,omitempty"`   // hosts, patterns like *.example.com, IPs and CIDRs
+}
+
+// directConnection is the proxy of the requests to the NoProxy hosts.
+var directConnection = &ProxyConfig{}
+
+// proxyFor returns the proxy of a request of reqConfig to u, directConnection for the NoProxy
+// hosts and nil when the client decides.
+func (c *ApiCrawler) proxyFor(reqConfig *RequestConfig, policy *hostPolicy, u *url.URL) *ProxyConfig {
+	var cfg *ProxyConfig
+	switch {
+	case reqConfig != nil && reqConfig.Proxy != nil:
+		cfg = reqConfig.Proxy
+	case policy != nil && policy.cfg.Proxy != "":
+		return &ProxyConfig{URL: policy.cfg.Proxy}
+	default:
+		cfg = c.Config.Proxy
+	}
+	if cfg == nil {
+		return nil
+	}
+	if cfg.bypasses(u.Hostname()) {
+		return directConnection
+	}
+	return cfg
+}
+
+// bypasses tells whether the requests to host skip the proxy.
+func (cfg *ProxyConfig) bypasses(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range cfg.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(entry, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKey identifies the proxy in the cache of the clients.
+func (cfg *ProxyConfig) clientKey() string {
+	if cfg == nil {
+		return ""
+	}
+	return fmt.Sprintf("proxy:%s|%s|%s", cfg.URL, cfg.Username, cfg.Password)
+}
+
+// proxyURL returns the url of the proxy with its credentials resolved, nil for a direct connection.
+func (c *ApiCrawler) proxyURL(cfg *ProxyConfig) (*url.URL, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	if cfg.Username != "" {
+		username, err := c.secretValue(cfg.Username)
+		if err != nil {
+			return nil, fmt.Errorf("proxy username: %w", err)
+		}
+		password, err := c.secretValue(cfg.Password)
+		if err != nil {
+			return nil, fmt.Errorf("proxy password: %w", err)
+		}
+		u.User = url.UserPassword(username, password)
+	}
+	return u, nil
+}
+
+func validateProxyURL(raw string, location string) []ValidationError {
+	if u, err := url.Parse(raw); err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks5"}, u.Scheme) {
+		return []ValidationError{{"proxy must be an http, https or socks5 url", location}}
+	}
+	return nil
+}
+
+func validateProxy(cfg *ProxyConfig, location string) []ValidationError {
+	errs := validateProxyURL(cfg.URL, location+".url")
+	if cfg.Password != "" && cfg.Username == "" {
+		errs = append(errs, ValidationError{"proxy.password requires username", location + ".username"})
+	}
+	errs = append(errs, validateSecretRef(cfg.Username, location+".username")...)
+	errs = append(errs, validateSecretRef(cfg.Password, location+".password")...)
+	for i, entry := range cfg.NoProxy {
+		entryLocation := fmt.Sprintf("%s.noProxy[%d]", location, i)
+		if strings.TrimSpace(entry) == "" {
+			errs = append(errs, ValidationError{"proxy.noProxy entries must not be empty", entryLocation})
+		} else if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(entry)); err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid proxy.noProxy CIDR: %v", err), entryLocation})
+			}
+		} else if _, err := path.Match(entry, ""); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid proxy.noProxy pattern: %v", err), entryLocation})
+		}
+	}
+	return errs
+}
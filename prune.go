@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// contextNameToken matches the words of a step configuration that can name a context.
+var contextNameToken = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// pruneContexts drops from the context map of the nested steps of exec the contexts none of
+// them references, so that deep trees do not keep every intermediate payload alive until the
+// end of the iteration. key is the context the nested steps work on: it, its parent (target of
+// mergeWithParentOn) and root are always kept. References are found by name in the
+// configuration of the nested steps, templates and jq rules looking contexts up with computed
+// names are not seen, hence pruning is enabled with pruneContexts.
+func (c *ApiCrawler) pruneContexts(exec *stepExecution, contextMap map[string]*Context, key string) {
+	if !c.Config.PruneContexts || len(exec.step.Steps) == 0 {
+		return
+	}
+	refs, ok := c.contextRefs(exec)
+	if !ok {
+		return
+	}
+	keep := map[string]bool{"root": true, key: true}
+	if current, ok := contextMap[key]; ok {
+		keep[current.ParentContext] = true
+	}
+	for name := range contextMap {
+		if !keep[name] && !refs[name] {
+			delete(contextMap, name)
+		}
+	}
+}
+
+// contextRefs returns the words of the configuration of the nested steps of exec, cached by
+// step location, false when the configuration can not be scanned.
+func (c *ApiCrawler) contextRefs(exec *stepExecution) (map[string]bool, bool) {
+	if refs, ok := c.contextRefCache.Load(exec.path); ok {
+		return refs.(map[string]bool), true
+	}
+	data, err := json.Marshal(exec.step.Steps)
+	if err != nil {
+		c.logger.Warning("[Prune] can not scan the nested steps of %s, keeping all contexts: %s", exec.path, err.Error())
+		return nil, false
+	}
+	refs := map[string]bool{}
+	for _, word := range contextNameToken.FindAllString(string(data), -1) {
+		refs[word] = true
+	}
+	c.contextRefCache.Store(exec.path, refs)
+	return refs, true
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type EntitySchemaConfig struct {
+	// Schema is the file path or http(s) url of a JSON schema, or the schema itself inline
+	Schema     any    `yaml:"schema" json:"schema"`
+	Quarantine string `yaml:"quarantine,omitempty" json:"quarantine,omitempty"` // NDJSON file the invalid entities are appended to
+}
+
+// QuarantinedEntity is an emitted entity that did not match the entitySchema.
+type QuarantinedEntity struct {
+	RunID  string   `json:"runId"`
+	Entity any      `json:"entity"`
+	Errors []string `json:"errors"`
+}
+
+// entityQuarantine diverts the entities failing the entitySchema of a run.
+type entityQuarantine struct {
+	schema *openAPIDoc
+	mu     sync.Mutex
+	file   *os.File
+	count  int
+}
+
+// EnableQuarantineStream returns a channel receiving the entities failing the entitySchema,
+// it must be consumed while the crawler runs.
+func (a *ApiCrawler) EnableQuarantineStream() chan QuarantinedEntity {
+	a.quarantineStream = make(chan QuarantinedEntity)
+	return a.quarantineStream
+}
+
+// QuarantinedCount returns the number of entities quarantined in the last run.
+func (a *ApiCrawler) QuarantinedCount() int {
+	if a.quarantine == nil {
+		return 0
+	}
+	a.quarantine.mu.Lock()
+	defer a.quarantine.mu.Unlock()
+	return a.quarantine.count
+}
+
+// openQuarantine loads the entitySchema and opens the quarantine file of a run.
+func (c *ApiCrawler) openQuarantine(ctx context.Context) (*entityQuarantine, error) {
+	cfg := c.Config.EntitySchema
+	var doc *openAPIDoc
+	if spec, ok := cfg.Schema.(string); ok {
+		var err error
+		if doc, err = c.loadOpenAPISpec(ctx, spec); err != nil {
+			return nil, &ConfigError{Err: fmt.Errorf("entitySchema: %w", err)}
+		}
+	} else {
+		root, ok := normalizeYAML(cfg.Schema).(map[string]any)
+		if !ok {
+			return nil, &ConfigError{Err: fmt.Errorf("entitySchema.schema must be a path, an url or an object")}
+		}
+		doc = &openAPIDoc{root: root}
+	}
+
+	q := &entityQuarantine{schema: doc}
+	if cfg.Quarantine != "" {
+		f, err := os.OpenFile(cfg.Quarantine, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening quarantine file: %w", err)
+		}
+		q.file = f
+	}
+	return q, nil
+}
+
+// closeQuarantine closes the quarantine file of the run, if any.
+func (c *ApiCrawler) closeQuarantine() error {
+	if c.quarantine == nil || c.quarantine.file == nil {
+		return nil
+	}
+	err := c.quarantine.file.Close()
+	c.quarantine.file = nil
+	return err
+}
+
+// quarantineEntity validates an entity against the entitySchema, diverting it to the
+// quarantine outputs when it does not match. It reports whether the entity was quarantined.
+func (c *ApiCrawler) quarantineEntity(entity any) (bool, error) {
+	if c.quarantine == nil {
+		return false, nil
+	}
+	var mismatches []string
+	c.quarantine.schema.validate(c.quarantine.schema.root, entity, "$", &mismatches)
+	if len(mismatches) == 0 {
+		return false, nil
+	}
+
+	c.logger.Warning("[Quarantine] entity does not match the entitySchema: %v", mismatches)
+	q := QuarantinedEntity{RunID: c.runID, Entity: entity, Errors: mismatches}
+
+	c.quarantine.mu.Lock()
+	c.quarantine.count++
+	file := c.quarantine.file
+	c.quarantine.mu.Unlock()
+
+	if file != nil {
+		line, err := json.Marshal(q)
+		if err != nil {
+			return true, fmt.Errorf("error encoding quarantined entity: %w", err)
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return true, fmt.Errorf("error writing quarantine file: %w", err)
+		}
+	}
+	if c.quarantineStream != nil {
+		c.quarantineStream <- q
+	}
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Entity Quarantine", nil, entity, nil, "errors", mismatches)
+	return true, nil
+}
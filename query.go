@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	ARRAY_FORMAT_REPEAT   = "repeat"   // ids=1&ids=2
+	ARRAY_FORMAT_COMMA    = "comma"    // ids=1,2
+	ARRAY_FORMAT_BRACKETS = "brackets" // ids[]=1&ids[]=2
+)
+
+// applyQuery sets the query of a page request on u: the query params of the url, the query
+// templates of the request and the pagination query params, in increasing priority. Arrays,
+// i.e. repeated params of the url, query templates rendering a JSON array and pagination
+// params holding arrays, are encoded following arrayFormat.
+func (c *ApiCrawler) applyQuery(u *url.URL, reqConfig *RequestConfig, templateData map[string]any, next *RequestParts) error {
+	values := u.Query()
+	for _, name := range sortedKeys(reqConfig.Query) {
+		tmpl, err := c.getOrCompileTextTemplate(reqConfig.Query[name])
+		if err != nil {
+			return fmt.Errorf("error getting/compiling query template '%s': %w", name, err)
+		}
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, templateData); err != nil {
+			return fmt.Errorf("error executing query template '%s': %w", name, err)
+		}
+		values[name] = queryValues(buf.String())
+	}
+	for k, v := range next.QueryParams {
+		values.Set(k, v)
+	}
+	for k, v := range next.QueryArrays {
+		values[k] = v
+	}
+	u.RawQuery = encodeQuery(values, reqConfig.ArrayFormat)
+	return nil
+}
+
+// queryValues returns the elements of a rendered query template holding a JSON array, e.g.
+// {{ toJson .ids }}, otherwise the value itself.
+func queryValues(rendered string) []string {
+	trimmed := strings.TrimSpace(rendered)
+	if !strings.HasPrefix(trimmed, "[") {
+		return []string{rendered}
+	}
+	var items []any
+	if err := json.Unmarshal([]byte(trimmed), &items); err != nil {
+		return []string{rendered}
+	}
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = queryValue(item)
+	}
+	return values
+}
+
+// queryValue renders an array element or a pagination param for a query string.
+func queryValue(v any) string {
+	switch v.(type) {
+	case map[string]any, []any:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// encodeQuery encodes values like url.Values.Encode, sorted by key, with the params having
+// several values encoded following format. The separators of comma and brackets arrays are
+// not escaped, as most upstreams expect them.
+func encodeQuery(values url.Values, format string) string {
+	var buf strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		key := url.QueryEscape(k)
+		vs := make([]string, len(values[k]))
+		for i, v := range values[k] {
+			vs[i] = url.QueryEscape(v)
+		}
+		if len(vs) > 1 {
+			switch format {
+			case ARRAY_FORMAT_COMMA:
+				vs = []string{strings.Join(vs, ",")}
+			case ARRAY_FORMAT_BRACKETS:
+				key += "[]"
+			}
+		}
+		for _, v := range vs {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(key)
+			buf.WriteByte('=')
+			buf.WriteString(v)
+		}
+	}
+	return buf.String()
+}
+
+// templateToJson renders a value as JSON, e.g. arrays of query templates.
+func templateToJson(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
@@ -0,0 +1,245 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	STATE_STORE_FILE  = "file"
+	STATE_STORE_REDIS = "redis"
+
+	redisDefaultTimeout = 30 * time.Second
+	// redisUnlockScript deletes the lock only while it is held with the token of the run
+	redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// StateStoreConfig selects where the state kept between runs (e.g. the dedup hashes) lives.
+type StateStoreConfig struct {
+	Type      string `yaml:"type" json:"type"`                               // file | redis
+	BaseDir   string `yaml:"baseDir,omitempty" json:"baseDir,omitempty"`     // file
+	URL       string `yaml:"url,omitempty" json:"url,omitempty"`             // redis, redis://[user:password@]host:port[/db] or rediss://
+	KeyPrefix string `yaml:"keyPrefix,omitempty" json:"keyPrefix,omitempty"` // redis, prepended to every key
+}
+
+// newStateStore creates the state store of the configuration.
+func newStateStore(cfg StateStoreConfig) (StateStore, error) {
+	if cfg.Type == STATE_STORE_REDIS {
+		return NewRedisStore(cfg.URL, cfg.KeyPrefix)
+	}
+	return FileStateStore{BaseDir: cfg.BaseDir}, nil
+}
+
+// RedisStore keeps the state of the crawler in Redis, so that the replicas of a crawler
+// (e.g. one per region) share it instead of keeping local files. It is a StateStore and
+// a RunLock. Every operation opens its own connection.
+type RedisStore struct {
+	Addr      string // host:port
+	Username  string
+	Password  string
+	DB        int
+	TLS       bool
+	KeyPrefix string
+	LockTTL   time.Duration // expiry of the locks, e.g. of killed processes; 0 never expires
+}
+
+// NewRedisStore returns a RedisStore for a redis:// or rediss:// url.
+func NewRedisStore(rawURL string, keyPrefix string) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid redis url: scheme must be redis or rediss")
+	}
+	s := &RedisStore{Addr: u.Host, TLS: u.Scheme == "rediss", KeyPrefix: keyPrefix}
+	if u.Port() == "" {
+		s.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.Username = u.User.Username()
+		s.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis url: database must be a number")
+		}
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Load(ctx context.Context, key string) ([]byte, error) {
+	v, err := s.do(ctx, "GET", s.KeyPrefix+key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis GET %s: unexpected reply %v", key, v)
+	}
+	return data, nil
+}
+
+func (s *RedisStore) Save(ctx context.Context, key string, value []byte) error {
+	_, err := s.do(ctx, "SET", s.KeyPrefix+key, string(value))
+	return err
+}
+
+// Lock holds the key lock/<name> with a token of the run, released only by the holder.
+func (s *RedisStore) Lock(ctx context.Context, name string) (func() error, error) {
+	key := s.KeyPrefix + "lock/" + name
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	args := []string{"SET", key, token, "NX"}
+	if s.LockTTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(s.LockTTL.Milliseconds(), 10))
+	}
+	v, err := s.do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("%w: redis key %s", ErrRunLocked, key)
+	}
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), redisDefaultTimeout)
+		defer cancel()
+		_, err := s.do(ctx, "EVAL", redisUnlockScript, "1", key, token)
+		return err
+	}, nil
+}
+
+// do runs a command on a new connection, after authenticating and selecting the database.
+func (s *RedisStore) do(ctx context.Context, args ...string) (any, error) {
+	dialer := &net.Dialer{Timeout: redisDefaultTimeout}
+	var conn net.Conn
+	var err error
+	if s.TLS {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis dial error: %w", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisDefaultTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	var commands [][]string
+	if s.Password != "" {
+		if s.Username != "" {
+			commands = append(commands, []string{"AUTH", s.Username, s.Password})
+		} else {
+			commands = append(commands, []string{"AUTH", s.Password})
+		}
+	}
+	if s.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.DB)})
+	}
+	commands = append(commands, args)
+
+	var reply any
+	for _, command := range commands {
+		if err := redisWrite(conn, command); err != nil {
+			return nil, fmt.Errorf("redis %s: %w", command[0], err)
+		}
+		if reply, err = redisRead(reader); err != nil {
+			return nil, fmt.Errorf("redis %s: %w", command[0], err)
+		}
+	}
+	return reply, nil
+}
+
+// redisWrite sends a command as a RESP array of bulk strings.
+func redisWrite(w io.Writer, args []string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// redisRead reads a RESP reply: string for simple strings, []byte for bulk strings, int64,
+// []any for arrays, nil for null replies. Error replies are returned as errors.
+// Limits of the replies, a corrupt or hostile server must not make the crawler allocate
+// arbitrary amounts of memory. The bulk limit is the default proto-max-bulk-len of Redis.
+const (
+	redisMaxBulkLength  = 512 << 20
+	redisMaxArrayLength = 1 << 20
+)
+
+// redisLength parses the length of a bulk string or array reply, -1 being the null reply.
+func redisLength(line string, max int) (int, error) {
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > max {
+		return 0, fmt.Errorf("malformed reply %q", line)
+	}
+	return n, nil
+}
+
+func redisRead(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("malformed reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := redisLength(line, redisMaxBulkLength)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := redisLength(line, redisMaxArrayLength)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = redisRead(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("malformed reply %q", line)
+}
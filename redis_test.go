@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis serves the commands used by RedisStore from memory.
+type fakeRedis struct {
+	mu       sync.Mutex
+	values   map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) (*fakeRedis, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	r := &fakeRedis{values: map[string]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r, listener.Addr().String()
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		v, err := redisRead(reader)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range v.([]any) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		r.mu.Lock()
+		r.commands = append(r.commands, strings.Join(args, " "))
+		reply := "+OK\r\n"
+		switch args[0] {
+		case "AUTH":
+			if args[len(args)-1] != "secret" {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case "GET":
+			if value, ok := r.values[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case "SET":
+			if _, ok := r.values[args[1]]; ok && len(args) > 3 && args[3] == "NX" {
+				reply = "$-1\r\n"
+			} else {
+				r.values[args[1]] = args[2]
+			}
+		case "EVAL":
+			reply = ":0\r\n"
+			if r.values[args[3]] == args[4] {
+				delete(r.values, args[3])
+				reply = ":1\r\n"
+			}
+		}
+		r.mu.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+// log returns the commands received so far.
+func (r *fakeRedis) log() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.commands...)
+}
+
+func TestRedisStore(t *testing.T) {
+	redis, addr := newFakeRedis(t)
+
+	store, err := NewRedisStore("redis://crawler:secret@"+addr+"/2", "harvest:")
+	require.NoError(t, err)
+	assert.Equal(t, &RedisStore{Addr: addr, Username: "crawler", Password: "secret", DB: 2, KeyPrefix: "harvest:"}, store)
+
+	ctx := context.Background()
+	data, err := store.Load(ctx, "dedup/stations.json")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+	require.NoError(t, store.Save(ctx, "dedup/stations.json", []byte(`{"1": "abc"}`)))
+	data, err = store.Load(ctx, "dedup/stations.json")
+	require.NoError(t, err)
+	assert.Equal(t, `{"1": "abc"}`, string(data))
+	assert.Equal(t, []string{"AUTH crawler secret", "SELECT 2", "GET harvest:dedup/stations.json"}, redis.log()[:3])
+
+	store.LockTTL = time.Minute
+	unlock, err := store.Lock(ctx, "stations")
+	require.NoError(t, err)
+	commands := redis.log()
+	assert.Contains(t, commands[len(commands)-1], "NX PX 60000")
+	_, err = store.Lock(ctx, "stations")
+	assert.ErrorIs(t, err, ErrRunLocked)
+	require.NoError(t, unlock())
+	unlock, err = store.Lock(ctx, "stations")
+	require.NoError(t, err)
+	require.NoError(t, unlock())
+
+	store.Password = "wrong"
+	_, err = store.Load(ctx, "dedup/stations.json")
+	assert.ErrorContains(t, err, "WRONGPASS")
+}
+
+func TestRedisStateStoreConfig(t *testing.T) {
+	redis, addr := newFakeRedis(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 1}, {"id": 2}]`)
+	}))
+	defer server.Close()
+
+	config := fmt.Sprintf(`
+rootContext: []
+stateStore:
+  type: redis
+  url: redis://%s
+  keyPrefix: "replicas:"
+lock:
+  staleAfterSeconds: 600
+dedup:
+  identity: .id
+  onlyChanged: true
+steps:
+  - type: request
+    request:
+      url: %s/stations
+      method: GET
+`, addr, server.URL)
+	configPath := filepath.Join(t.TempDir(), "stations.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(config), 0644))
+	craw, verr, err := NewApiCrawler(configPath)
+	require.Nil(t, err)
+	require.Empty(t, verr)
+	require.NoError(t, craw.Run(context.TODO()))
+
+	redis.mu.Lock()
+	defer redis.mu.Unlock()
+	assert.Contains(t, redis.values, "replicas:dedup/stations.json")
+	assert.NotContains(t, redis.values, "replicas:lock/stations")
+	assert.Regexp(t, `^SET replicas:lock/stations \w+ NX PX 600000$`, redis.commands[0])
+
+	cfg, err := ParseConfig([]byte(strings.Replace(config, "redis://", "http://", 1)))
+	require.NoError(t, err)
+	assert.Equal(t, []ValidationError{{"invalid redis url: scheme must be redis or rediss", "stateStore.url"}}, ValidateConfig(cfg))
+}
+
+func TestRedisReplyLengths(t *testing.T) {
+	read := func(reply string) (any, error) {
+		return redisRead(bufio.NewReader(strings.NewReader(reply)))
+	}
+	v, err := read("$3\r\nabc\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), v)
+	v, err = read("$-1\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+	v, err = read("*-1\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	for _, reply := range []string{"$-2\r\n", "$99999999999\r\n", "$536870913\r\n", "*-7\r\n", "*2000000\r\n", "$abc\r\n"} {
+		_, err := read(reply)
+		assert.ErrorContains(t, err, "malformed reply", reply)
+	}
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+const OAUTH_METHOD_REFRESH_TOKEN = "refresh_token"
+
+// refreshTokenSession holds the tokens of the refresh_token authenticators of a crawler,
+// keyed like the device tokens, and persists their rotated refresh tokens in the state store:
+// the servers rotating them invalidate the previous one, the configured token is only good
+// for the first exchange.
+type refreshTokenSession struct {
+	mu     sync.Mutex // one exchange at a time, a concurrent one would use a rotated token
+	tokens map[string]*oauth2.Token
+	store  func() StateStore // nil keeps the rotated tokens for the session only
+}
+
+func newRefreshTokenSession(store func() StateStore) *refreshTokenSession {
+	return &refreshTokenSession{tokens: map[string]*oauth2.Token{}, store: store}
+}
+
+// persistedRefreshToken is the state of a refresh_token authenticator, the hash of the
+// configured token telling whether a new one was configured since.
+type persistedRefreshToken struct {
+	Configured   string `json:"configured"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshTokenStateKey is the key of the rotated refresh token of cfg in the state store.
+func refreshTokenStateKey(cfg OAuthConfig) string {
+	sum := sha256.Sum256([]byte(deviceTokenKey(cfg)))
+	return "oauth/refresh-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+func configuredRefreshTokenHash(cfg OAuthConfig) string {
+	sum := sha256.Sum256([]byte(cfg.RefreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// token returns a valid access token of cfg, exchanging the latest refresh token when the
+// access token expired.
+func (s *refreshTokenSession) token(ctx context.Context, conf *oauth2.Config, cfg OAuthConfig) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deviceTokenKey(cfg)
+	current := s.tokens[key]
+	if current != nil && current.Valid() {
+		return current, nil
+	}
+	refresh := cfg.RefreshToken
+	if current != nil && current.RefreshToken != "" {
+		refresh = current.RefreshToken
+	} else if persisted, err := s.load(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("could not load the refresh token: %w", err)
+	} else if persisted != "" {
+		refresh = persisted
+	}
+
+	// the refresh token is kept when the server does not rotate it
+	token, err := conf.TokenSource(ctx, &oauth2.Token{RefreshToken: refresh}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("could not refresh the token: %w", err)
+	}
+	s.tokens[key] = token
+	if token.RefreshToken != refresh {
+		if err := s.save(ctx, cfg, token.RefreshToken); err != nil {
+			return nil, fmt.Errorf("could not persist the rotated refresh token: %w", err)
+		}
+	}
+	return token, nil
+}
+
+// load returns the refresh token persisted for cfg, empty when there is none or a new one
+// was configured since.
+func (s *refreshTokenSession) load(ctx context.Context, cfg OAuthConfig) (string, error) {
+	if s.store == nil {
+		return "", nil
+	}
+	data, err := s.store().Load(ctx, refreshTokenStateKey(cfg))
+	if err != nil || data == nil {
+		return "", err
+	}
+	var persisted persistedRefreshToken
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return "", err
+	}
+	if persisted.Configured != configuredRefreshTokenHash(cfg) {
+		return "", nil
+	}
+	return persisted.RefreshToken, nil
+}
+
+func (s *refreshTokenSession) save(ctx context.Context, cfg OAuthConfig, refreshToken string) error {
+	if s.store == nil {
+		return nil
+	}
+	data, err := json.Marshal(persistedRefreshToken{Configured: configuredRefreshTokenHash(cfg), RefreshToken: refreshToken})
+	if err != nil {
+		return err
+	}
+	return s.store().Save(ctx, refreshTokenStateKey(cfg), data)
+}
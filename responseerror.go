@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/itchyny/gojq"
+)
+
+// checkResponseError fails with a ResponseError on a response matching the errorWhen
+// predicate of the request, e.g. the {"status": "error"} bodies some upstreams answer with
+// status 200. The response is checked before its transformation, with $response available.
+func (c *ApiCrawler) checkResponseError(exec *stepExecution, url string, resp *http.Response, raw any) error {
+	req := exec.step.Request
+	if req.ErrorWhen == "" {
+		return nil
+	}
+	responseInfo := responseToJQ(resp)
+	matched, err := c.evalResponseRule(exec, req.ErrorWhen, exec.path+".request.errorWhen", raw, responseInfo)
+	if err != nil {
+		return err
+	}
+	if matched == nil || matched == false {
+		return nil
+	}
+
+	message := "errorWhen matched the response"
+	if req.ErrorMessage != "" {
+		v, err := c.evalResponseRule(exec, req.ErrorMessage, exec.path+".request.errorMessage", raw, responseInfo)
+		if err != nil {
+			return err
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			message = v
+		default:
+			data, _ := json.Marshal(v)
+			message = string(data)
+		}
+	}
+	return &ResponseError{Step: exec.path, URL: url, Status: resp.StatusCode, Message: message}
+}
+
+// evalResponseRule returns the first value of a jq rule on a response, nil when it yields none.
+func (c *ApiCrawler) evalResponseRule(exec *stepExecution, rule string, location string, raw any, responseInfo map[string]any) (any, error) {
+	code, err := c.getOrCompileJQRule(rule, "$response")
+	if err != nil {
+		return nil, &TransformError{Location: location, Rule: rule, Err: err}
+	}
+	v, ok := c.runJQ(exec, code, raw, responseInfo).Next()
+	if !ok {
+		return nil, nil
+	}
+	if err, isErr := v.(error); isErr {
+		return nil, &TransformError{Location: location, Rule: rule, Err: fmt.Errorf("jq error: %w", err)}
+	}
+	return v, nil
+}
+
+func validateErrorWhen(req RequestConfig, location string) []ValidationError {
+	var errs []ValidationError
+	if req.ErrorWhen != "" {
+		if _, err := gojq.Parse(req.ErrorWhen); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid request.errorWhen: %v", err), location + ".errorWhen"})
+		}
+	}
+	if req.ErrorMessage != "" {
+		if req.ErrorWhen == "" {
+			errs = append(errs, ValidationError{"request.errorMessage requires request.errorWhen", location + ".errorMessage"})
+		} else if _, err := gojq.Parse(req.ErrorMessage); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid request.errorMessage: %v", err), location + ".errorMessage"})
+		}
+	}
+	return errs
+}
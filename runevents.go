@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	RUN_STATUS_STARTED = "started"
+	RUN_STATUS_SUCCESS = "success"
+	RUN_STATUS_FAILURE = "failure"
+
+	runEventTimeout = 10 * time.Second
+)
+
+// RunEventsConfig declares the webhooks receiving the RunReport as a JSON POST.
+type RunEventsConfig struct {
+	OnRunStart   string            `yaml:"onRunStart,omitempty" json:"onRunStart,omitempty"`
+	OnRunSuccess string            `yaml:"onRunSuccess,omitempty" json:"onRunSuccess,omitempty"`
+	OnRunFailure string            `yaml:"onRunFailure,omitempty" json:"onRunFailure,omitempty"`
+	Headers      map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"` // e.g. Authorization of the orchestrator
+}
+
+// RunHooks are the Go counterpart of the runEvents webhooks, nil hooks are skipped.
+// They are called synchronously from Run.
+type RunHooks struct {
+	OnRunStart   func(RunReport)
+	OnRunSuccess func(RunReport)
+	OnRunFailure func(RunReport)
+}
+
+// RunReport describes a run to external orchestration and alerting.
+type RunReport struct {
+	RunID       string        `json:"runId"`
+	ConfigName  string        `json:"configName"`
+	Status      string        `json:"status"` // started | success | failure
+	Start       time.Time     `json:"start"`
+	Duration    time.Duration `json:"duration"`
+	Requests    int           `json:"requests"`
+	Bytes       int64         `json:"bytes"`
+	Quarantined int           `json:"quarantined,omitempty"`
+	Suppressed  int           `json:"suppressed,omitempty"`
+	SchemaDrift int           `json:"schemaDrift,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Failures    []StepFailure `json:"failures,omitempty"`
+}
+
+// SetRunHooks registers the functions called when a run starts, succeeds or fails.
+func (a *ApiCrawler) SetRunHooks(hooks RunHooks) {
+	a.runHooks = hooks
+}
+
+// LastRunReport returns the report of the last run, err being the error Run returned.
+// Its duration runs until the report is built.
+func (a *ApiCrawler) LastRunReport(err error) RunReport {
+	if err != nil {
+		return a.runReport(RUN_STATUS_FAILURE, err)
+	}
+	return a.runReport(RUN_STATUS_SUCCESS, nil)
+}
+
+// runReport builds the report of the current run.
+func (c *ApiCrawler) runReport(status string, err error) RunReport {
+	c.budget.mu.Lock()
+	requests, bytes := c.budget.requests, c.budget.bytes
+	c.budget.mu.Unlock()
+
+	report := RunReport{
+		RunID:       c.runID,
+		ConfigName:  c.configName,
+		Status:      status,
+		Start:       c.runStart.UTC(),
+		Requests:    requests,
+		Bytes:       bytes,
+		Quarantined: c.QuarantinedCount(),
+		Suppressed:  c.SuppressedCount(),
+		SchemaDrift: len(c.schemaDrift),
+	}
+	if status != RUN_STATUS_STARTED {
+		report.Duration = c.clock.Now().Sub(c.runStart)
+		report.Failures = c.GetPartialData().Failures
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func (c *ApiCrawler) notifyRunStart(ctx context.Context) {
+	report := c.runReport(RUN_STATUS_STARTED, nil)
+	if c.runHooks.OnRunStart != nil {
+		c.runHooks.OnRunStart(report)
+	}
+	if cfg := c.Config.RunEvents; cfg != nil && cfg.OnRunStart != "" {
+		c.postRunEvent(ctx, cfg.OnRunStart, report)
+	}
+}
+
+// notifyRunEnd reports the outcome of a run, err being the error returned by Run.
+func (c *ApiCrawler) notifyRunEnd(ctx context.Context, err error) {
+	status, hook, webhook := RUN_STATUS_SUCCESS, c.runHooks.OnRunSuccess, ""
+	if err != nil {
+		status, hook = RUN_STATUS_FAILURE, c.runHooks.OnRunFailure
+	}
+	if cfg := c.Config.RunEvents; cfg != nil {
+		webhook = cfg.OnRunSuccess
+		if err != nil {
+			webhook = cfg.OnRunFailure
+		}
+	}
+	if hook == nil && webhook == "" {
+		return
+	}
+
+	report := c.runReport(status, err)
+	if hook != nil {
+		hook(report)
+	}
+	if webhook != "" {
+		c.postRunEvent(ctx, webhook, report)
+	}
+}
+
+// postRunEvent posts a run report to a webhook. Failures are logged, they do not change
+// the outcome of the run; the webhook is called also when the run was cancelled.
+func (c *ApiCrawler) postRunEvent(ctx context.Context, webhook string, report RunReport) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), runEventTimeout)
+	defer cancel()
+
+	err := func() error {
+		payload, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range c.Config.RunEvents.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	}()
+	if err != nil {
+		c.logger.Warning("[RunEvents] %s webhook %s failed: %s", report.Status, webhook, err.Error())
+	}
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import "net/http"
+
+// DEFAULT_CORRELATION_HEADER is the usual header carrying the run id upstream, see correlationHeader.
+const DEFAULT_CORRELATION_HEADER = "X-Correlation-ID"
+
+// RunID returns the id of the run in progress, or of the last one. It prefixes the logs, is
+// carried by the profiler events and sent upstream with correlationHeader.
+func (a *ApiCrawler) RunID() string {
+	return a.runID
+}
+
+// runLogger prefixes the messages of a crawler with the id of its run, once started.
+type runLogger struct {
+	Logger
+	crawler *ApiCrawler
+}
+
+func (l *runLogger) args(msg string, args []any) (string, []any) {
+	if id := l.crawler.runID; id != "" {
+		return "[run %s] " + msg, append([]any{id}, args...)
+	}
+	return msg, args
+}
+
+func (l *runLogger) Debug(msg string, args ...any) {
+	msg, args = l.args(msg, args)
+	l.Logger.Debug(msg, args...)
+}
+
+func (l *runLogger) Info(msg string, args ...any) {
+	msg, args = l.args(msg, args)
+	l.Logger.Info(msg, args...)
+}
+
+func (l *runLogger) Warning(msg string, args ...any) {
+	msg, args = l.args(msg, args)
+	l.Logger.Warning(msg, args...)
+}
+
+func (l *runLogger) Error(msg string, args ...any) {
+	msg, args = l.args(msg, args)
+	l.Logger.Error(msg, args...)
+}
+
+// setCorrelationHeader sends the run id with a request of a step, as configured by
+// correlationHeader.
+func (c *ApiCrawler) setCorrelationHeader(req *http.Request) {
+	if header := c.Config.CorrelationHeader; header != "" && c.runID != "" {
+		req.Header.Set(header, c.runID)
+	}
+}
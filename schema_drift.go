@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+const (
+	SCHEMA_DRIFT_ADDED        = "added"
+	SCHEMA_DRIFT_REMOVED      = "removed"
+	SCHEMA_DRIFT_TYPE_CHANGED = "typeChanged"
+)
+
+type SchemaDriftConfig struct {
+	Path   string `yaml:"path" json:"path"`                         // file where the inferred schemas are persisted
+	Update bool   `yaml:"update,omitempty" json:"update,omitempty"` // replace the persisted schemas with the ones of this run
+}
+
+// StructuralSchema maps the json paths of a value to the types seen there,
+// e.g. {"$": ["array"], "$[]": ["object"], "$[].id": ["number"]}.
+type StructuralSchema map[string][]string
+
+// SchemaDrift is a structural difference between the persisted and the current output of a step.
+type SchemaDrift struct {
+	Step   string   `json:"step"` // step location, e.g. steps[0].steps[1]
+	Name   string   `json:"name,omitempty"`
+	Path   string   `json:"path"`
+	Kind   string   `json:"kind"` // added | removed | typeChanged
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+func (d SchemaDrift) String() string {
+	switch d.Kind {
+	case SCHEMA_DRIFT_ADDED:
+		return fmt.Sprintf("%s: new field %s %v", d.Step, d.Path, d.After)
+	case SCHEMA_DRIFT_REMOVED:
+		return fmt.Sprintf("%s: field %s %v disappeared", d.Step, d.Path, d.Before)
+	default:
+		return fmt.Sprintf("%s: field %s changed type %v -> %v", d.Step, d.Path, d.Before, d.After)
+	}
+}
+
+// schemaTracker accumulates the schema of every step output during a run.
+type schemaTracker struct {
+	mu      sync.Mutex
+	schemas map[string]StructuralSchema
+	names   map[string]string
+}
+
+func newSchemaTracker() *schemaTracker {
+	return &schemaTracker{schemas: map[string]StructuralSchema{}, names: map[string]string{}}
+}
+
+func (t *schemaTracker) observe(exec *stepExecution, value any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	schema, ok := t.schemas[exec.path]
+	if !ok {
+		schema = StructuralSchema{}
+		t.schemas[exec.path] = schema
+		t.names[exec.path] = exec.step.Name
+	}
+	schema.infer("$", value)
+}
+
+func (s StructuralSchema) infer(path string, value any) {
+	s.add(path, jsonTypeName(value))
+	switch v := value.(type) {
+	case map[string]any:
+		for k, field := range v {
+			s.infer(path+"."+k, field)
+		}
+	case []any:
+		for _, item := range v {
+			s.infer(path+"[]", item)
+		}
+	}
+}
+
+func (s StructuralSchema) add(path, typ string) {
+	if !slices.Contains(s[path], typ) {
+		s[path] = append(s[path], typ)
+		sort.Strings(s[path])
+	}
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return "number"
+	}
+}
+
+// compareSchemas reports the drift of current against a baseline.
+// null is not considered a type change: optional fields are null in some records only.
+func compareSchemas(step, name string, baseline, current StructuralSchema) []SchemaDrift {
+	var drifts []SchemaDrift
+	for path, after := range current {
+		before, ok := baseline[path]
+		if !ok {
+			drifts = append(drifts, SchemaDrift{Step: step, Name: name, Path: path, Kind: SCHEMA_DRIFT_ADDED, After: after})
+			continue
+		}
+		b, a := withoutNull(before), withoutNull(after)
+		if len(b) > 0 && len(a) > 0 && !slices.Equal(b, a) {
+			drifts = append(drifts, SchemaDrift{Step: step, Name: name, Path: path, Kind: SCHEMA_DRIFT_TYPE_CHANGED, Before: before, After: after})
+		}
+	}
+	for path, before := range baseline {
+		if _, ok := current[path]; !ok {
+			drifts = append(drifts, SchemaDrift{Step: step, Name: name, Path: path, Kind: SCHEMA_DRIFT_REMOVED, Before: before})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Path < drifts[j].Path })
+	return drifts
+}
+
+func withoutNull(types []string) []string {
+	return slices.DeleteFunc(slices.Clone(types), func(t string) bool { return t == "null" })
+}
+
+// SchemaDrift returns the drift detected by the last run, empty unless schemaDrift is configured.
+func (a *ApiCrawler) SchemaDrift() []SchemaDrift {
+	return a.schemaDrift
+}
+
+// checkSchemaDrift compares the schemas inferred during the run with the persisted ones.
+// The first run (or a step seen for the first time) establishes the baseline.
+// Steps that did not produce any output in this run are left untouched.
+func (c *ApiCrawler) checkSchemaDrift() error {
+	cfg := c.Config.SchemaDrift
+	if cfg == nil || c.schemas == nil {
+		return nil
+	}
+
+	baseline := map[string]StructuralSchema{}
+	data, err := os.ReadFile(cfg.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error reading schemas: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &baseline); err != nil {
+			return fmt.Errorf("error decoding schemas %s: %w", cfg.Path, err)
+		}
+	}
+
+	changed := false
+	c.schemaDrift = nil
+	for _, step := range sortedKeys(c.schemas.schemas) {
+		current := c.schemas.schemas[step]
+		persisted, ok := baseline[step]
+		if !ok || cfg.Update {
+			baseline[step] = current
+			changed = true
+		}
+		if !ok {
+			continue
+		}
+		c.schemaDrift = append(c.schemaDrift, compareSchemas(step, c.schemas.names[step], persisted, current)...)
+	}
+
+	for _, d := range c.schemaDrift {
+		c.logger.Warning("[Schema] drift %s", d.String())
+	}
+	if len(c.schemaDrift) > 0 {
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Schema Drift", nil, c.schemaDrift, nil, "drifts", len(c.schemaDrift))
+	}
+
+	if !changed {
+		return nil
+	}
+	out, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return fmt.Errorf("error writing schemas: %w", err)
+	}
+	if err := os.WriteFile(cfg.Path, out, 0644); err != nil {
+		return fmt.Errorf("error writing schemas: %w", err)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SECRET_REF_PREFIX marks a configuration value looked up by the SecretsProvider, e.g.
+// clientSecret: secret://keycloak-client-secret. The value stays a reference in the
+// configuration and the profiler output, it is resolved when the request is built.
+const SECRET_REF_PREFIX = "secret://"
+
+// ErrSecretNotFound is returned by a SecretsProvider without the requested secret.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretsProvider looks up the secrets referenced by the configuration, by key.
+type SecretsProvider interface {
+	Secret(key string) (string, error)
+}
+
+// EnvSecrets looks secrets up in environment variables named Prefix + key.
+// It is the provider of a crawler until SetSecretsProvider.
+type EnvSecrets struct {
+	Prefix string
+}
+
+func (e EnvSecrets) Secret(key string) (string, error) {
+	value, ok := os.LookupEnv(e.Prefix + key)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s", ErrSecretNotFound, e.Prefix+key)
+	}
+	return value, nil
+}
+
+// FileSecrets reads secrets from the files named after their key in Dir, e.g. Kubernetes
+// secrets mounted as a volume. A trailing newline is dropped.
+type FileSecrets struct {
+	Dir string
+}
+
+func (f FileSecrets) Secret(key string) (string, error) {
+	// keys name files of Dir, never a path leaving it
+	if key == "" || key != filepath.Base(key) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid secret key '%s'", key)
+	}
+	data, err := os.ReadFile(filepath.Join(f.Dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: file %s", ErrSecretNotFound, filepath.Join(f.Dir, key))
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// SecretsChain looks secrets up in its providers in order, the first one having the
+// secret wins.
+type SecretsChain []SecretsProvider
+
+func (s SecretsChain) Secret(key string) (string, error) {
+	for _, provider := range s {
+		value, err := provider.Secret(key)
+		if !errors.Is(err, ErrSecretNotFound) {
+			return value, err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrSecretNotFound, key)
+}
+
+// SetSecretsProvider sets the provider of the secret:// references of the configuration,
+// EnvSecrets by default. The global authentication is set up again with it.
+func (a *ApiCrawler) SetSecretsProvider(provider SecretsProvider) {
+	a.secrets = provider
+	if a.Config.Authentication != nil {
+		a.globalAuthenticator = a.newAuthenticator(*a.Config.Authentication)
+	}
+}
+
+// secretValue returns the secret a configuration value references, or the value itself.
+func (c *ApiCrawler) secretValue(value string) (string, error) {
+	key, ok := strings.CutPrefix(value, SECRET_REF_PREFIX)
+	if !ok {
+		return value, nil
+	}
+	if key == "" {
+		return "", fmt.Errorf("empty secret reference")
+	}
+	secret, err := c.secrets.Secret(key)
+	if err != nil {
+		return "", fmt.Errorf("secret '%s': %w", key, err)
+	}
+	return secret, nil
+}
+
+// resolveAuthSecrets returns cfg with its secret references resolved.
+func (c *ApiCrawler) resolveAuthSecrets(cfg AuthenticatorConfig) (AuthenticatorConfig, error) {
+	for _, field := range []*string{&cfg.Token, &cfg.ClientID, &cfg.ClientSecret, &cfg.Username, &cfg.Password, &cfg.RefreshToken, &cfg.AccessKey, &cfg.SecretKey, &cfg.SessionToken} {
+		value, err := c.secretValue(*field)
+		if err != nil {
+			return cfg, err
+		}
+		*field = value
+	}
+	return cfg, nil
+}
+
+// resolveSinkSecrets returns cfg with the secret references of its credentials resolved.
+func (c *ApiCrawler) resolveSinkSecrets(cfg SinkConfig) (SinkConfig, error) {
+	if cfg.S3 == nil {
+		return cfg, nil
+	}
+	s3 := *cfg.S3
+	for _, field := range []*string{&s3.AccessKey, &s3.SecretKey, &s3.SessionToken} {
+		value, err := c.secretValue(*field)
+		if err != nil {
+			return cfg, fmt.Errorf("sink: %w", err)
+		}
+		*field = value
+	}
+	cfg.S3 = &s3
+	return cfg, nil
+}
+
+// failingAuthenticator fails the requests of an authentication whose secrets could not be
+// resolved.
+type failingAuthenticator struct {
+	err error
+}
+
+func (f failingAuthenticator) PrepareRequest(req *http.Request) error {
+	return f.err
+}
+
+// setHeader sets a configured header, resolving a secret reference.
+func (c *ApiCrawler) setHeader(req *http.Request, name string, value string) error {
+	value, err := c.secretValue(value)
+	if err != nil {
+		return fmt.Errorf("header %s: %w", name, err)
+	}
+	req.Header.Set(name, value)
+	return nil
+}
+
+func validateSecretRef(value string, location string) []ValidationError {
+	if value == SECRET_REF_PREFIX {
+		return []ValidationError{{"secret reference requires a key, e.g. secret://api-token", location}}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ServerTimeConfig calibrates the crawler clock against the Date header of the upstream,
+// for APIs rejecting requests whose timestamps are skewed. The calibrated clock is used for
+// request signatures and for "now" in datetime pagination params.
+type ServerTimeConfig struct {
+	Sync bool   `yaml:"sync,omitempty" json:"sync,omitempty"`
+	URL  string `yaml:"url,omitempty" json:"url,omitempty"` // calibration request (HEAD) before the first step, default: the first response of the run
+}
+
+// serverClock holds the offset between the server clock and the local one.
+// The Date header has a one second resolution, smaller offsets are ignored.
+type serverClock struct {
+	offset     atomic.Int64 // nanoseconds
+	calibrated atomic.Bool
+}
+
+// calibrate computes the offset from the Date header of the first response,
+// assuming the server stamped it halfway between sent and received.
+// It reports the offset and whether this call calibrated the clock.
+func (s *serverClock) calibrate(header http.Header, sent, received time.Time) (time.Duration, bool) {
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil || s.calibrated.Swap(true) {
+		return 0, false
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	// the Date header is truncated to the second
+	offset := date.Add(500 * time.Millisecond).Sub(local)
+	if offset.Abs() < time.Second {
+		offset = 0
+	}
+	s.offset.Store(int64(offset))
+	return offset, true
+}
+
+// ServerTimeOffset returns how far the server clock is ahead of the local one,
+// as calibrated by serverTime in the last run.
+func (c *ApiCrawler) ServerTimeOffset() time.Duration {
+	if c.serverClock == nil {
+		return 0
+	}
+	return time.Duration(c.serverClock.offset.Load())
+}
+
+// serverNow returns the current time on the server clock, the local one if
+// serverTime is not configured or not calibrated yet.
+func (c *ApiCrawler) serverNow() time.Time {
+	return nowFunc().Add(c.ServerTimeOffset())
+}
+
+// observeServerTime calibrates the server clock on the first response of a run.
+func (c *ApiCrawler) observeServerTime(req *http.Request, resp *http.Response, sent time.Time) {
+	if c.serverClock == nil || c.serverClock.calibrated.Load() {
+		return
+	}
+	if offset, ok := c.serverClock.calibrate(resp.Header, sent, time.Now()); ok {
+		c.logger.Info("[ServerTime] server clock offset %s, calibrated on %s", offset, req.URL.String())
+	}
+}
+
+// syncServerTime sends the calibration request of serverTime.url.
+// A failed calibration is logged, the run goes on with the local clock until the first response.
+func (c *ApiCrawler) syncServerTime(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.Config.ServerTime.URL, nil)
+	if err != nil {
+		c.logger.Warning("[ServerTime] invalid calibration url: %s", err.Error())
+		return
+	}
+	if err := c.applyHeaders(req, &RequestConfig{}, contextMapToTemplate(c.ContextMap), nil); err != nil {
+		c.logger.Warning("[ServerTime] %s", err.Error())
+		return
+	}
+
+	sent := time.Now()
+	client, err := c.clientFor(c.clientSettings(nil, req.URL))
+	if err != nil {
+		c.logger.Warning("[ServerTime] %s", err.Error())
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		c.logger.Warning("[ServerTime] calibration request failed: %s", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Date") == "" {
+		c.logger.Warning("[ServerTime] no Date header in the response of %s", req.URL.String())
+		return
+	}
+	c.observeServerTime(req, resp, sent)
+}
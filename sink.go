@@ -0,0 +1,441 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	SINK_FORMAT_JSON   = "json"
+	SINK_FORMAT_NDJSON = "ndjson"
+
+	s3MinPartSize = 5 * 1024 * 1024
+
+	sinkAbortTimeout = 30 * time.Second
+)
+
+// OutputSink receives the crawl output.
+// In stream mode Entity is called for every streamed entity; Finish is called once
+// after a successful run with the final data (nil in stream mode).
+type OutputSink interface {
+	Entity(ctx context.Context, entity any) error
+	Finish(ctx context.Context, data any) error
+}
+
+// AbortableSink is an OutputSink holding partial uploads, e.g. S3 multipart uploads:
+// Abort is called instead of Finish when the run fails, to discard them.
+type AbortableSink interface {
+	OutputSink
+	Abort(ctx context.Context) error
+}
+
+type SinkConfig struct {
+	Type string        `yaml:"type" json:"type"` // s3
+	S3   *S3SinkConfig `yaml:"s3,omitempty" json:"s3,omitempty"`
+}
+
+type S3SinkConfig struct {
+	Endpoint     string `yaml:"endpoint" json:"endpoint"` // e.g. https://s3.eu-west-1.amazonaws.com
+	Region       string `yaml:"region" json:"region"`
+	Bucket       string `yaml:"bucket" json:"bucket"`
+	Key          string `yaml:"key" json:"key"`                           // go template: .date .time .configName .runId
+	Format       string `yaml:"format,omitempty" json:"format,omitempty"` // json | ndjson
+	AccessKey    string `yaml:"accessKey,omitempty" json:"accessKey,omitempty"`
+	SecretKey    string `yaml:"secretKey,omitempty" json:"secretKey,omitempty"`
+	SessionToken string `yaml:"sessionToken,omitempty" json:"sessionToken,omitempty"`
+	PathStyle    bool   `yaml:"pathStyle,omitempty" json:"pathStyle,omitempty"`
+	PartSizeMB   int    `yaml:"partSizeMB,omitempty" json:"partSizeMB,omitempty"`
+	MaxRetries   *int   `yaml:"maxRetries,omitempty" json:"maxRetries,omitempty"`
+}
+
+// sinkRunInfo is the data available to sink key templates.
+type sinkRunInfo struct {
+	ConfigName string
+	RunID      string
+	Start      time.Time
+}
+
+func (r sinkRunInfo) templateData() map[string]any {
+	return map[string]any{
+		"configName": r.ConfigName,
+		"runId":      r.RunID,
+		"date":       r.Start.Format("2006-01-02"),
+		"time":       r.Start.Format("150405"),
+		"timestamp":  r.Start.Unix(),
+	}
+}
+
+// AddSink registers an additional output sink, next to the ones declared in the configuration.
+func (a *ApiCrawler) AddSink(sink OutputSink) {
+	a.extraSinks = append(a.extraSinks, sink)
+}
+
+// newSink creates a sink declared in the configuration, now is the clock of request signatures
+// and output the JSON formatting.
+func newSink(cfg SinkConfig, info sinkRunInfo, client HTTPClient, now func() time.Time, output OutputConfig) (OutputSink, error) {
+	switch cfg.Type {
+	case "s3":
+		sink, err := newS3Sink(*cfg.S3, info, client)
+		if err != nil {
+			return nil, err
+		}
+		sink.now = now
+		sink.output = output
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type: %s", cfg.Type)
+	}
+}
+
+// emitEntity pushes a streamed entity of exec to the data stream and to every sink,
+// unless it fails the entitySchema and is quarantined or it is a duplicate.
+func (c *ApiCrawler) emitEntity(ctx context.Context, exec *stepExecution, entity any) error {
+	c.emitMu.Lock()
+	defer c.emitMu.Unlock()
+	if quarantined, err := c.quarantineEntity(entity); quarantined || err != nil {
+		if quarantined {
+			c.updateStatsTree(exec, func(s *StepStats) { s.ErrorsSkipped++ })
+		}
+		return err
+	}
+	if duplicate, err := c.duplicateEntity(entity); duplicate || err != nil {
+		return err
+	}
+	if c.DataStream != nil {
+		c.DataStream <- entity
+	}
+	for _, s := range c.sinks {
+		if err := s.Entity(ctx, entity); err != nil {
+			return fmt.Errorf("sink error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *ApiCrawler) finishSinks(ctx context.Context) error {
+	// sinks having received entities per item finish with them, as in stream mode
+	var data any
+	if !c.Config.Stream && !c.itemsEmitted.Load() {
+		data = c.GetData()
+	}
+	for _, s := range c.sinks {
+		if err := s.Finish(ctx, data); err != nil {
+			return fmt.Errorf("sink error: %w", err)
+		}
+	}
+	return nil
+}
+
+// abortSinks discards the partial uploads of the sinks after a failed run. It runs even when
+// the run was cancelled, failures are only logged.
+func (c *ApiCrawler) abortSinks(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sinkAbortTimeout)
+	defer cancel()
+	for _, s := range c.sinks {
+		if abortable, ok := s.(AbortableSink); ok {
+			if err := abortable.Abort(ctx); err != nil {
+				c.logger.Warning("[Sink] error aborting the upload: %s", err.Error())
+			}
+		}
+	}
+}
+
+// S3Sink uploads the output to S3 compatible object storage.
+// NDJSON output is uploaded with multipart uploads as soon as a part is full,
+// JSON output is uploaded at the end of the run.
+type S3Sink struct {
+	cfg      S3SinkConfig
+	creds    awsCredentials
+	client   HTTPClient
+	key      string
+	partSize int
+	retries  int
+	now      func() time.Time // signing time
+	output   OutputConfig
+
+	entities []any
+	buffer   bytes.Buffer
+	uploadID string
+	parts    []s3CompletedPart
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func newS3Sink(cfg S3SinkConfig, info sinkRunInfo, client HTTPClient) (*S3Sink, error) {
+	tmpl, err := texttemplate.New("key").Parse(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 key template: %w", err)
+	}
+	var keyBuf bytes.Buffer
+	if err := tmpl.Execute(&keyBuf, info.templateData()); err != nil {
+		return nil, fmt.Errorf("error executing s3 key template: %w", err)
+	}
+
+	creds := awsCredentials{AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, SessionToken: cfg.SessionToken}
+	if creds.AccessKey == "" {
+		creds = awsCredentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+
+	partSize := cfg.PartSizeMB * 1024 * 1024
+	if partSize < s3MinPartSize {
+		partSize = s3MinPartSize
+	}
+	retries := 3
+	if cfg.MaxRetries != nil {
+		retries = *cfg.MaxRetries
+	}
+	if cfg.Format == "" {
+		cfg.Format = SINK_FORMAT_JSON
+	}
+
+	return &S3Sink{
+		cfg:      cfg,
+		creds:    creds,
+		client:   client,
+		key:      strings.TrimPrefix(keyBuf.String(), "/"),
+		partSize: partSize,
+		retries:  retries,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *S3Sink) Key() string {
+	return s.key
+}
+
+func (s *S3Sink) Entity(ctx context.Context, entity any) error {
+	if s.cfg.Format != SINK_FORMAT_NDJSON {
+		s.entities = append(s.entities, entity)
+		return nil
+	}
+
+	line, err := s.output.marshal(entity, true)
+	if err != nil {
+		return err
+	}
+	s.buffer.Write(line)
+	s.buffer.WriteByte('\n')
+
+	if s.buffer.Len() >= s.partSize {
+		return s.flushPart(ctx)
+	}
+	return nil
+}
+
+func (s *S3Sink) Finish(ctx context.Context, data any) error {
+	if data == nil && s.cfg.Format != SINK_FORMAT_NDJSON {
+		data = s.entities
+	}
+
+	if data != nil {
+		if s.cfg.Format == SINK_FORMAT_NDJSON {
+			items, ok := data.([]any)
+			if !ok {
+				items = []any{data}
+			}
+			for _, item := range items {
+				if err := s.Entity(ctx, item); err != nil {
+					return err
+				}
+			}
+		} else {
+			payload, err := s.output.marshal(data, false)
+			if err != nil {
+				return err
+			}
+			s.buffer.Write(payload)
+		}
+	}
+
+	// small payloads go with a single PUT
+	if s.uploadID == "" && s.buffer.Len() < s.partSize {
+		_, err := s.do(ctx, http.MethodPut, nil, s.buffer.Bytes())
+		return err
+	}
+
+	for s.buffer.Len() > 0 {
+		if err := s.flushPart(ctx); err != nil {
+			return err
+		}
+	}
+	return s.completeMultipart(ctx)
+}
+
+func (s *S3Sink) contentType() string {
+	if s.cfg.Format == SINK_FORMAT_NDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// flushPart uploads up to partSize bytes of the buffer as the next multipart part.
+func (s *S3Sink) flushPart(ctx context.Context) error {
+	if s.uploadID == "" {
+		resp, err := s.do(ctx, http.MethodPost, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.Unmarshal(resp, &result); err != nil {
+			return fmt.Errorf("invalid CreateMultipartUpload response: %w", err)
+		}
+		s.uploadID = result.UploadID
+	}
+
+	size := s.partSize
+	if s.buffer.Len() < size {
+		size = s.buffer.Len()
+	}
+	part := make([]byte, size)
+	copy(part, s.buffer.Next(size))
+
+	partNumber := len(s.parts) + 1
+	query := url.Values{"partNumber": {fmt.Sprint(partNumber)}, "uploadId": {s.uploadID}}
+
+	var etag string
+	_, err := s.doWithHeaders(ctx, http.MethodPut, query, part, func(h http.Header) {
+		etag = h.Get("ETag")
+	})
+	if err != nil {
+		return err
+	}
+	s.parts = append(s.parts, s3CompletedPart{PartNumber: partNumber, ETag: etag})
+	return nil
+}
+
+// completeMultipart assembles the uploaded parts. S3 may answer 200 and report the failure
+// in the body, the upload is then still pending and aborted with the failed run.
+func (s *S3Sink) completeMultipart(ctx context.Context) error {
+	payload, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: s.parts})
+	if err != nil {
+		return err
+	}
+	body, err := s.do(ctx, http.MethodPost, url.Values{"uploadId": {s.uploadID}}, payload)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &result) == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 CompleteMultipartUpload %s: %s %s", s.key, result.Code, result.Message)
+	}
+	s.uploadID = ""
+	return nil
+}
+
+// Abort discards a multipart upload not completed, nothing else is uploaded before Finish.
+func (s *S3Sink) Abort(ctx context.Context) error {
+	if s.uploadID == "" {
+		return nil
+	}
+	_, err := s.do(ctx, http.MethodDelete, url.Values{"uploadId": {s.uploadID}}, nil)
+	if err == nil {
+		s.uploadID = ""
+	}
+	return err
+}
+
+func (s *S3Sink) objectURL(query url.Values) (*url.URL, error) {
+	base, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if s.cfg.PathStyle {
+		base.Path = "/" + s.cfg.Bucket + "/" + s.key
+	} else {
+		base.Host = s.cfg.Bucket + "." + base.Host
+		base.Path = "/" + s.key
+	}
+	base.RawQuery = query.Encode()
+	return base, nil
+}
+
+func (s *S3Sink) do(ctx context.Context, method string, query url.Values, payload []byte) ([]byte, error) {
+	return s.doWithHeaders(ctx, method, query, payload, nil)
+}
+
+// doWithHeaders performs a signed request, retrying with exponential backoff on
+// transport errors, 5xx and 429 responses.
+func (s *S3Sink) doWithHeaders(ctx context.Context, method string, query url.Values, payload []byte, onHeaders func(http.Header)) ([]byte, error) {
+	u, err := s.objectURL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := 500 * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(payload))
+		if method == http.MethodPut && query.Get("partNumber") == "" {
+			req.Header.Set("Content-Type", s.contentType())
+		}
+		signV4(req, hashPayload(payload), s.creds, s.cfg.Region, "s3", s.now())
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			lastErr = fmt.Errorf("s3 %s %s: %s", method, u.Path, resp.Status)
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("s3 %s %s: %s %s", method, u.Path, resp.Status, string(body))
+		}
+
+		if onHeaders != nil {
+			onHeaders(resp.Header)
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("s3 request failed after %d retries: %w", s.retries, lastErr)
+}
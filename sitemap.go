@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+const sitemapDefaultMaxDepth = 3
+
+type SitemapConfig struct {
+	Include  string `yaml:"include,omitempty" json:"include,omitempty"`   // regex, only matching page urls are kept
+	MaxDepth int    `yaml:"maxDepth,omitempty" json:"maxDepth,omitempty"` // nesting of sitemap indexes, default 3
+	MaxURLs  int    `yaml:"maxUrls,omitempty" json:"maxUrls,omitempty"`   // stop after this many page urls
+}
+
+// sitemapDocument covers both <urlset> and <sitemapindex> documents.
+type sitemapDocument struct {
+	XMLName  xml.Name       `xml:""`
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+func (e sitemapEntry) toMap() map[string]interface{} {
+	m := map[string]interface{}{"loc": e.Loc}
+	if e.LastMod != "" {
+		m["lastmod"] = e.LastMod
+	}
+	if e.ChangeFreq != "" {
+		m["changefreq"] = e.ChangeFreq
+	}
+	if e.Priority != "" {
+		m["priority"] = e.Priority
+	}
+	return m
+}
+
+// handleSitemap fetches a sitemap, following sitemap indexes, and returns the page
+// entries as an array of {loc, lastmod, changefreq, priority} objects.
+func (c *ApiCrawler) handleSitemap(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Sitemap] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	data := c.templateData(exec, templateCtx)
+	_url, err := c.renderURL(exec.step.Request.URL, data)
+	if err != nil {
+		return err
+	}
+
+	cfg := SitemapConfig{}
+	if exec.step.Sitemap != nil {
+		cfg = *exec.step.Sitemap
+	}
+	if cfg.MaxDepth == 0 {
+		cfg.MaxDepth = sitemapDefaultMaxDepth
+	}
+	var include *regexp.Regexp
+	if cfg.Include != "" {
+		include, err = regexp.Compile(cfg.Include)
+		if err != nil {
+			return fmt.Errorf("invalid sitemap include pattern: %w", err)
+		}
+	}
+
+	pages := []interface{}{}
+	visited := map[string]bool{}
+
+	var walk func(sitemapURL string, depth int) error
+	walk = func(sitemapURL string, depth int) error {
+		if visited[sitemapURL] {
+			return nil
+		}
+		visited[sitemapURL] = true
+
+		doc, err := c.fetchSitemap(ctx, exec, sitemapURL, data)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range doc.URLs {
+			if cfg.MaxURLs > 0 && len(pages) >= cfg.MaxURLs {
+				return nil
+			}
+			if include != nil && !include.MatchString(entry.Loc) {
+				continue
+			}
+			pages = append(pages, entry.toMap())
+		}
+
+		for _, nested := range doc.Sitemaps {
+			if depth >= cfg.MaxDepth {
+				c.logger.Warning("[Sitemap] max depth reached, skipping %s", nested.Loc)
+				c.updateStatsTree(exec, func(s *StepStats) { s.ErrorsSkipped++ })
+				continue
+			}
+			if err := walk(nested.Loc, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(_url, 0); err != nil {
+		return err
+	}
+	c.logger.Debug("[Sitemap] %d sitemaps, %d urls", len(visited), len(pages))
+
+	var raw interface{} = pages
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Sitemap '%s'", exec.step.Name), exec, raw, nil, "url", _url, "sitemaps", len(visited))
+
+	transformed, err := c.transformResult(exec, raw, templateCtx, nil)
+	if err != nil {
+		return err
+	}
+	c.pushProfilerDiff("Response Transformation", exec, transformed, raw, "url", _url)
+
+	return c.mergeStepResult(ctx, exec, transformed, nil, "url", _url)
+}
+
+func (c *ApiCrawler) fetchSitemap(ctx context.Context, exec *stepExecution, sitemapURL string, data map[string]any) (*sitemapDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	if err := c.applyHeaders(req, exec.step.Request, data, nil); err != nil {
+		return nil, err
+	}
+	if err := c.authenticate(exec, c.requestAuthenticator(exec.step.Request), req); err != nil {
+		return nil, err
+	}
+
+	c.logger.Info("[Sitemap] %s", sitemapURL)
+
+	resp, err := c.doRequest(exec, req)
+	if err != nil {
+		return nil, fmt.Errorf("error performing HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &HTTPError{Step: exec.path, URL: sitemapURL, Status: resp.StatusCode}
+	}
+
+	// .xml.gz sitemaps are served as plain gzip files, not with Content-Encoding
+	var body io.Reader = bufio.NewReader(resp.Body)
+	if magic, _ := body.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing sitemap %s: %w", sitemapURL, err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var doc sitemapDocument
+	if err := xml.NewDecoder(body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding sitemap %s: %w", sitemapURL, err)
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("%s is not a sitemap, root element is <%s>", sitemapURL, doc.XMLName.Local)
+	}
+	return &doc, nil
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ContextSnapshot is the context map a step was executed with. It allows re-running
+// that step locally without re-running everything before it, see RunFromSnapshot.
+type ContextSnapshot struct {
+	Step       string                     `json:"step"`       // step location, e.g. steps[1].steps[0]
+	ContextKey string                     `json:"contextKey"` // context the step operates on
+	Contexts   map[string]SnapshotContext `json:"contexts"`
+	Error      string                     `json:"error,omitempty"` // set on snapshots of failed steps
+}
+
+type SnapshotContext struct {
+	Data   any    `json:"data"`
+	Parent string `json:"parent,omitempty"`
+	Depth  int    `json:"depth"`
+}
+
+// DumpContextAt writes a snapshot of the context map to path when the step at location
+// (e.g. steps[1].steps[0]) is executed for the first time.
+func (a *ApiCrawler) DumpContextAt(location string, path string) {
+	a.contextDumpStep = location
+	a.contextDumpPath = path
+}
+
+// DumpContextOnFailure writes a snapshot of the context map of the failing step to path
+// when a run fails.
+func (a *ApiCrawler) DumpContextOnFailure(path string) {
+	a.contextDumpStep = ""
+	a.contextDumpPath = path
+}
+
+func LoadContextSnapshot(path string) (*ContextSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot ContextSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid context snapshot %s: %w", path, err)
+	}
+	if _, ok := snapshot.Contexts["root"]; !ok {
+		return nil, fmt.Errorf("invalid context snapshot %s: missing root context", path)
+	}
+	if _, ok := snapshot.Contexts[snapshot.ContextKey]; !ok {
+		return nil, fmt.Errorf("invalid context snapshot %s: missing context '%s'", path, snapshot.ContextKey)
+	}
+	return &snapshot, nil
+}
+
+// RunFromSnapshot executes a single step (with its nested steps) on the context map of
+// a snapshot. location defaults to the step of the snapshot; other steps must be at the
+// same nesting level to find the contexts they refer to.
+// Sinks and schema drift detection are not run, the root context is available with GetData.
+func (c *ApiCrawler) RunFromSnapshot(ctx context.Context, snapshot *ContextSnapshot, location string) error {
+	if location == "" {
+		location = snapshot.Step
+	}
+	step, err := c.Config.stepAt(location)
+	if err != nil {
+		return err
+	}
+
+	contextMap := make(map[string]*Context, len(snapshot.Contexts))
+	for key, sc := range snapshot.Contexts {
+		contextMap[key] = &Context{Data: sc.Data, ParentContext: sc.Parent, key: key, depth: sc.Depth}
+	}
+	c.ContextMap["root"] = contextMap["root"]
+
+	c.runID = c.idGenerator.NewID()
+	c.budget = newRunBudget()
+	c.failures = newRunFailures()
+	c.collections = newOutputCollections()
+	c.complete = false
+	c.sinks = nil
+
+	c.logger.Info("[Snapshot] Running %s from snapshot of %s", location, snapshot.Step)
+	exec := newStepExecution(step, location, snapshot.ContextKey, contextMap)
+	if err := c.ExecuteStep(ctx, exec); err != nil {
+		return err
+	}
+
+	c.complete = true
+	c.pushProfilerData(STEP_PROFILER_TYPE_NONE, "Result", nil, c.GetData(), snapshot.Contexts["root"].Data)
+	return nil
+}
+
+// stepAt resolves a step location such as steps[1].steps[0].
+func (cfg Config) stepAt(location string) (Step, error) {
+	steps := cfg.Steps
+	var step Step
+	for _, part := range strings.Split(location, ".") {
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(part, "steps["), "]"))
+		if err != nil || !strings.HasPrefix(part, "steps[") || index < 0 || index >= len(steps) {
+			return Step{}, fmt.Errorf("no step at '%s'", location)
+		}
+		step = steps[index]
+		steps = step.Steps
+	}
+	return step, nil
+}
+
+// dumpContext writes the snapshot of the context map of exec, if configured for it.
+func (c *ApiCrawler) dumpContext(exec *stepExecution, stepErr error) {
+	if c.contextDumpPath == "" {
+		return
+	}
+	if stepErr == nil && c.contextDumpStep != exec.path {
+		return
+	}
+	if stepErr != nil && c.contextDumpStep != "" {
+		return
+	}
+	if c.contextDumped.Swap(true) && stepErr == nil {
+		return
+	}
+
+	snapshot := ContextSnapshot{
+		Step:       exec.path,
+		ContextKey: exec.currentContextKey,
+		Contexts:   make(map[string]SnapshotContext, len(exec.contextMap)),
+	}
+	for key, ctx := range exec.contextMap {
+		snapshot.Contexts[key] = SnapshotContext{Data: ctx.Data, Parent: ctx.ParentContext, Depth: ctx.depth}
+	}
+	if stepErr != nil {
+		snapshot.Error = stepErr.Error()
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err == nil {
+		err = os.WriteFile(c.contextDumpPath, data, 0o644)
+	}
+	if err != nil {
+		c.logger.Warning("[Snapshot] could not write context snapshot: %s", err.Error())
+		return
+	}
+	c.logger.Info("[Snapshot] context of %s written to %s", exec.path, c.contextDumpPath)
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+)
+
+// handleSplit runs a split step, the stream-first counterpart of forEach: every element selected
+// by path runs through the nested steps as an iteration of its own and is emitted as an entity
+// as soon as it is enriched, instead of once the whole tree is retrieved. The elements emitted
+// leave the context. Every entity goes through the profiler events "Entity Split #i",
+// "Entity Enriched #i" and "Entity Emitted #i", or "Entity Failed #i".
+// Without a stream and sinks the entities stay in the context, as the ones of a forEach step.
+func (c *ApiCrawler) handleSplit(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Split] Preparing %s", exec.step.Name)
+
+	items, err := c.forEachItems(exec)
+	if err != nil {
+		return err
+	}
+
+	emit := c.Config.Stream || len(c.sinks) > 0
+	if !emit {
+		c.logger.Warning("[Split] %s has neither a stream nor a sink, the entities stay in the context", exec.path)
+	}
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Split '%s'", exec.step.Name), exec, items, nil, "entities", len(items))
+
+	kept := []interface{}{}
+	for i, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.updateStats(exec, func(s *StepStats) { s.Items++ })
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Entity Split #%d", i), exec, item, nil)
+
+		childContextMap := childMapWith(exec.contextMap, exec.currentContext, exec.step.As, item)
+		addIterationMetadata(childContextMap, exec.step.As, i, len(items))
+		entity, err := c.runNestedSteps(ctx, exec, i, childContextMap)
+		if err != nil {
+			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Entity Failed #%d", i), exec, item, nil, "error", err.Error())
+			return err
+		}
+		c.pushProfilerDiff(fmt.Sprintf("Entity Enriched #%d", i), exec, entity, item)
+
+		if !emit {
+			kept = append(kept, entity)
+			continue
+		}
+		if err := c.emitEntity(ctx, exec, entity); err != nil {
+			c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Entity Failed #%d", i), exec, entity, nil, "error", err.Error())
+			return err
+		}
+		c.itemsEmitted.Store(true)
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Entity Emitted #%d", i), exec, entity, nil)
+	}
+
+	v, err := c.patchPath(exec, kept)
+	if err != nil {
+		return err
+	}
+	c.pushProfilerData(STEP_PROFILER_TYPE_END, fmt.Sprintf("Split Merge '%s'", exec.step.Name), exec, v, exec.currentContext.Data, "stats", c.statsSnapshot(exec))
+	exec.currentContext.Data = v
+	return nil
+}
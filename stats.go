@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"time"
+)
+
+// StepStats aggregates the execution of a step. They are attached as Extra["stats"] to the
+// events closing a step (STEP_PROFILER_TYPE_END, STEP_PROFILER_TYPE_END_SILENT), so that a run
+// can be summarized from those events alone.
+// Requests, Bytes and ErrorsSkipped include the nested steps, Pages and Items only count the
+// step itself.
+type StepStats struct {
+	Pages         int           `json:"pages"` // request pages fetched
+	Items         int           `json:"items"` // forEach iterations
+	Requests      int           `json:"requests"`
+	Bytes         int64         `json:"bytes"`         // response bytes read
+	Retries       int           `json:"retries"`       // throttled requests retried, see AdaptiveConcurrencyConfig
+	ErrorsSkipped int           `json:"errorsSkipped"` // errors tolerated without failing the step: quarantined entities, sitemaps beyond maxDepth
+	Duration      time.Duration `json:"duration"`
+}
+
+// updateStats applies update to the stats of exec.
+func (c *ApiCrawler) updateStats(exec *stepExecution, update func(s *StepStats)) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	update(exec.stats)
+}
+
+// updateStatsTree applies update to the stats of exec and of all its parent steps.
+func (c *ApiCrawler) updateStatsTree(exec *stepExecution, update func(s *StepStats)) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	for e := exec; e != nil; e = e.parent {
+		update(e.stats)
+	}
+}
+
+// statsSnapshot returns a copy of the stats of exec, with the duration up to now.
+func (c *ApiCrawler) statsSnapshot(exec *stepExecution) StepStats {
+	now := c.clock.Now()
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	s := *exec.stats
+	s.Duration = now.Sub(exec.start)
+	return s
+}
+
+// statsTemplateKey holds $stats in the data of the request templates, see templateData.
+const statsTemplateKey = "$stats"
+
+// templateStatsPrefix declares $stats in every go-template of the configuration.
+const templateStatsPrefix = "{{ $stats := stats . }}"
+
+// runStats is the $stats value of a step: the pages it fetched so far, the iterations
+// started so far by the enclosing forEach or split step (the 1-based number of the current item),
+// the errors it skipped so far and the requests and bytes of the run so far.
+// Outside steps only the run counters are set.
+func (c *ApiCrawler) runStats(exec *stepExecution) map[string]any {
+	c.budget.mu.Lock()
+	stats := map[string]any{"pages": 0, "items": 0, "errorsSkipped": 0, "requests": c.budget.requests, "bytes": int(c.budget.bytes)}
+	c.budget.mu.Unlock()
+
+	if exec == nil {
+		return stats
+	}
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	stats["pages"] = exec.stats.Pages
+	stats["errorsSkipped"] = exec.stats.ErrorsSkipped
+	for e := exec; e != nil; e = e.parent {
+		if isIterationStep(e.step.Type) {
+			stats["items"] = e.stats.Items
+			break
+		}
+	}
+	return stats
+}
+
+// templateData returns the data of the request templates of exec: the context names, $stats
+// and $locals.
+func (c *ApiCrawler) templateData(exec *stepExecution, templateCtx map[string]any) map[string]any {
+	data := make(map[string]any, len(templateCtx)+2)
+	for k, v := range templateCtx {
+		data[k] = v
+	}
+	data[statsTemplateKey] = c.runStats(exec)
+	data[localsTemplateKey] = stepLocals(exec)
+	return data
+}
+
+// templateStats is the stats template function, returning the $stats of the template data.
+func templateStats(data any) any {
+	if m, ok := data.(map[string]any); ok {
+		return m[statsTemplateKey]
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SUBSCRIBE_PROTOCOL_SSE       = "sse"
+	SUBSCRIBE_PROTOCOL_WEBSOCKET = "websocket"
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	defaultMaxMessageBytes = 16 * 1024 * 1024
+)
+
+type SubscribeConfig struct {
+	Protocol        string `yaml:"protocol,omitempty" json:"protocol,omitempty"` // sse | websocket, defaults from the url scheme
+	MaxMessages     int    `yaml:"maxMessages,omitempty" json:"maxMessages,omitempty"`
+	DurationSeconds int    `yaml:"durationSeconds,omitempty" json:"durationSeconds,omitempty"`
+	Message         string `yaml:"message,omitempty" json:"message,omitempty"`                 // websocket only, go template sent after connecting
+	MaxMessageBytes int    `yaml:"maxMessageBytes,omitempty" json:"maxMessageBytes,omitempty"` // 16 MiB by default
+}
+
+func (s *SubscribeConfig) maxMessageBytes() int {
+	if s.MaxMessageBytes > 0 {
+		return s.MaxMessageBytes
+	}
+	return defaultMaxMessageBytes
+}
+
+func (s *SubscribeConfig) protocol(rawURL string) string {
+	if s.Protocol != "" {
+		return s.Protocol
+	}
+	if strings.HasPrefix(rawURL, "ws://") || strings.HasPrefix(rawURL, "wss://") {
+		return SUBSCRIBE_PROTOCOL_WEBSOCKET
+	}
+	return SUBSCRIBE_PROTOCOL_SSE
+}
+
+// errSubscriptionDone stops a subscription once the message bound is reached.
+var errSubscriptionDone = errors.New("subscription done")
+
+// handleSubscribe connects to a push endpoint (SSE or WebSocket) and processes each message
+// like a page of a paginated request: transformed, passed to nested steps, merged and streamed.
+// The subscription ends after maxMessages, durationSeconds or when the server closes it.
+func (c *ApiCrawler) handleSubscribe(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Subscribe] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	data := c.templateData(exec, templateCtx)
+	_url, err := c.renderURL(exec.step.Request.URL, data)
+	if err != nil {
+		return err
+	}
+
+	cfg := exec.step.Subscribe
+	if cfg.DurationSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.DurationSeconds)*time.Second)
+		defer cancel()
+	}
+
+	received := 0
+	onMessage := func(payload []byte) error {
+		received++
+		var raw interface{}
+		if err := json.Unmarshal(payload, &raw); err != nil {
+			raw = string(payload)
+		}
+
+		c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Subscribe '%s' | message#%d", exec.step.Name, received), exec, raw, nil, "url", _url)
+
+		transformed, err := c.transformResult(exec, raw, templateCtx, nil)
+		if err != nil {
+			return err
+		}
+		c.pushProfilerDiff("Message Transformation", exec, transformed, raw, "url", _url)
+
+		if err := c.mergeStepResult(ctx, exec, transformed, nil, "url", _url); err != nil {
+			return err
+		}
+
+		if cfg.MaxMessages > 0 && received >= cfg.MaxMessages {
+			return errSubscriptionDone
+		}
+		return nil
+	}
+
+	c.logger.Info("[Subscribe] %s", _url)
+
+	switch cfg.protocol(_url) {
+	case SUBSCRIBE_PROTOCOL_WEBSOCKET:
+		err = c.subscribeWebSocket(ctx, exec, _url, data, onMessage)
+	default:
+		err = c.subscribeSSE(ctx, exec, _url, data, onMessage)
+	}
+
+	// reaching one of the bounds is the normal end of a subscription
+	if errors.Is(err, errSubscriptionDone) || (cfg.DurationSeconds > 0 && errors.Is(err, context.DeadlineExceeded)) {
+		err = nil
+	}
+	c.logger.Debug("[Subscribe] received %d messages", received)
+	return err
+}
+
+func (c *ApiCrawler) subscribeSSE(ctx context.Context, exec *stepExecution, _url string, data map[string]any, onMessage func([]byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if err := c.applyHeaders(req, exec.step.Request, data, nil); err != nil {
+		return err
+	}
+	if err := c.authenticate(exec, c.requestAuthenticator(exec.step.Request), req); err != nil {
+		return err
+	}
+
+	resp, err := c.doRequest(exec, req)
+	if err != nil {
+		return fmt.Errorf("error performing HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPError{Step: exec.path, URL: _url, Status: resp.StatusCode}
+	}
+
+	return readSSE(resp.Body, exec.step.Subscribe.maxMessageBytes(), onMessage)
+}
+
+// readSSE dispatches the data of every server-sent event to onMessage.
+// Lines longer than maxBytes fail the subscription.
+func readSSE(body io.Reader, maxBytes int, onMessage func([]byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, min(64*1024, maxBytes)), maxBytes)
+
+	var data [][]byte
+	dispatch := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		payload := bytes.Join(data, []byte("\n"))
+		data = nil
+		return onMessage(payload)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0:
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case line[0] == ':':
+			// comment / keep-alive
+		case bytes.HasPrefix(line, []byte("data:")):
+			value := bytes.TrimPrefix(line[5:], []byte(" "))
+			data = append(data, append([]byte(nil), value...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("server-sent event line exceeds maxMessageBytes %d", maxBytes)
+		}
+		return err
+	}
+	// flush an event not terminated by a blank line
+	return dispatch()
+}
+
+func (c *ApiCrawler) subscribeWebSocket(ctx context.Context, exec *stepExecution, _url string, data map[string]any, onMessage func([]byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, _url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating websocket request: %w", err)
+	}
+	if err := c.applyHeaders(req, exec.step.Request, data, nil); err != nil {
+		return err
+	}
+	if err := c.authenticate(exec, c.requestAuthenticator(exec.step.Request), req); err != nil {
+		return err
+	}
+
+	conn, err := c.dialWebSocket(exec, req)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// unblock pending reads when the subscription is cancelled or times out
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if exec.step.Subscribe.Message != "" {
+		tmpl, err := c.getOrCompileTextTemplate(exec.step.Subscribe.Message)
+		if err != nil {
+			return fmt.Errorf("error getting/compiling subscribe message template: %w", err)
+		}
+		var msg bytes.Buffer
+		if err := tmpl.Execute(&msg, data); err != nil {
+			return fmt.Errorf("error executing subscribe message template: %w", err)
+		}
+		if err := conn.writeFrame(wsOpText, msg.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	for {
+		payload, err := conn.readMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := onMessage(payload); err != nil {
+			conn.writeFrame(wsOpClose, nil)
+			return err
+		}
+	}
+}
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsConn is a minimal RFC 6455 client connection, enough to consume push feeds.
+type wsConn struct {
+	io.ReadWriteCloser
+	reader     *bufio.Reader
+	maxMessage int
+}
+
+// dialWebSocket performs the opening handshake of a ws(s):// request as an HTTP upgrade, so
+// the configured client and its transport, the hosts proxies and politeness settings and the
+// run budget apply as to any other request. Clients not supporting upgrades fail the step.
+func (c *ApiCrawler) dialWebSocket(exec *stepExecution, req *http.Request) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req.URL.Scheme = strings.Replace(req.URL.Scheme, "ws", "http", 1)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	resp, err := c.doRequest(exec, req)
+	if err != nil {
+		return nil, fmt.Errorf("websocket handshake error: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("websocket handshake failed with status %s", resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("websocket handshake failed: invalid Sec-WebSocket-Accept")
+	}
+
+	// frames are read through the metered body, written to the upgraded connection below it
+	body := resp.Body
+	if metered, ok := body.(*meteredBody); ok {
+		body = metered.ReadCloser
+	}
+	rwc, ok := body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("websocket handshake failed: the HTTP client does not support protocol upgrades")
+	}
+	return &wsConn{
+		ReadWriteCloser: rwc,
+		reader:          bufio.NewReader(resp.Body),
+		maxMessage:      exec.step.Subscribe.maxMessageBytes(),
+	}, nil
+}
+
+// readMessage returns the next text or binary message, answering pings on the way.
+// io.EOF is returned when the server closes the connection.
+func (w *wsConn) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := w.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := w.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			w.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if len(message)+len(payload) > w.maxMessage {
+				return nil, fmt.Errorf("websocket message exceeds maxMessageBytes %d", w.maxMessage)
+			}
+			message = append(message, payload...)
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, fmt.Errorf("unsupported websocket opcode %d", opcode)
+		}
+	}
+}
+
+func (w *wsConn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(w.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(w.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(w.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	// the length is announced by the server, nothing is allocated past the message limit
+	if length > uint64(w.maxMessage) {
+		return false, 0, nil, fmt.Errorf("websocket frame of %d bytes exceeds maxMessageBytes %d", length, w.maxMessage)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(w.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(w.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single final frame; client frames are always masked.
+func (w *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serverFrame builds an unmasked server frame
+func serverFrame(fin bool, opcode byte, payload string) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	return append([]byte{first, byte(len(payload))}, payload...)
+}
+
+// websocketServer accepts the handshake and hands the connection to feed.
+func websocketServer(t *testing.T, feed func(conn net.Conn, reader *bufio.Reader)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "websocket", r.Header.Get("Upgrade"))
+		assert.Equal(t, "k", r.Header.Get("X-Api-Key"))
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n\r\n")
+		feed(conn, rw.Reader)
+	}))
+}
+
+// countingTransport counts the requests going through the configured client.
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newWebSocketCrawler(t *testing.T, serverURL string, maxMessageBytes int) *ApiCrawler {
+	config := fmt.Sprintf(`
+rootContext: []
+steps:
+  - type: subscribe
+    request:
+      url: ws%s/live
+      headers:
+        X-Api-Key: k
+    subscribe:
+      maxMessages: 5
+      maxMessageBytes: %d
+`, strings.TrimPrefix(serverURL, "http"), maxMessageBytes)
+	configPath := filepath.Join(t.TempDir(), "websocket.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(config), 0644))
+	craw, verr, err := NewApiCrawler(configPath)
+	require.Nil(t, err)
+	require.Empty(t, verr)
+	return craw
+}
+
+func TestWebSocketClient(t *testing.T) {
+	server := websocketServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		conn.Write(serverFrame(true, wsOpText, `{"id":1}`))
+		conn.Write(serverFrame(true, wsOpPing, "hb"))
+		// the client answers the ping with a masked pong
+		head := make([]byte, 2+4+2)
+		io.ReadFull(reader, head)
+		conn.Write(serverFrame(false, wsOpText, `{"id":`))
+		conn.Write(serverFrame(true, wsOpContinuation, `2}`))
+		conn.Write(serverFrame(true, wsOpClose, ""))
+		io.Copy(io.Discard, reader)
+	})
+	defer server.Close()
+
+	craw := newWebSocketCrawler(t, server.URL, 0)
+	transport := &countingTransport{}
+	craw.SetClient(&http.Client{Transport: transport})
+	require.NoError(t, craw.Run(context.TODO()))
+
+	assert.Equal(t, []any{map[string]any{"id": 1.0}, map[string]any{"id": 2.0}}, craw.GetData())
+	assert.Equal(t, int32(1), transport.requests.Load(), "the handshake goes through the configured client")
+}
+
+func TestWebSocketMessageLimit(t *testing.T) {
+	server := websocketServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		// a frame announcing a terabyte
+		conn.Write([]byte{0x80 | wsOpText, 127, 0, 0, 1, 0, 0, 0, 0, 0})
+		io.Copy(io.Discard, reader)
+	})
+	defer server.Close()
+
+	craw := newWebSocketCrawler(t, server.URL, 1024)
+	err := craw.Run(context.TODO())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds maxMessageBytes 1024")
+
+	fragmented := websocketServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		for range 3 {
+			conn.Write(serverFrame(false, wsOpText, strings.Repeat("x", 100)))
+		}
+		io.Copy(io.Discard, reader)
+	})
+	defer fragmented.Close()
+
+	craw = newWebSocketCrawler(t, fragmented.URL, 250)
+	err = craw.Run(context.TODO())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "websocket message exceeds maxMessageBytes 250")
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template/parse"
+)
+
+// templateFuncs are available in every go-template of the configuration.
+var templateFuncs = map[string]any{
+	"default": templateDefault,
+	"now":     templateNow,
+	"params":  templateNoParams, // replaced by the run params in the compiled templates
+	"stats":   templateStats,
+	"locals":  templateLocals,
+	"toJson":  templateToJson,
+	"presign": templateNoPresign, // replaced by the url signers in the compiled templates
+}
+
+// templatePrefix declares the variables available in every go-template: $now, $params, $stats,
+// $locals.
+const templatePrefix = templateNowPrefix + templateParamsPrefix + templateStatsPrefix + templateLocalsPrefix
+
+func templateNoParams() map[string]any {
+	return nil
+}
+
+// templateDefault returns fallback when value is missing, nil or empty:
+// {{ .page | default 1 }}. In strict mode optional keys are read with index,
+// which does not fail on missing keys: {{ index . "page" | default 1 }}.
+func templateDefault(fallback any, value any) any {
+	if value == nil {
+		return fallback
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			return fallback
+		}
+	}
+	return value
+}
+
+// isHeaderTemplate reports whether a header value is a go-template; plain values are
+// sent as they are, empty ones included.
+func isHeaderTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// templateMissingKey is the missingkey option of the compiled templates:
+// strict templates fail on missing keys instead of rendering "<no value>".
+func (c *Config) templateMissingKey() string {
+	if c.StrictTemplates {
+		return "missingkey=error"
+	}
+	return "missingkey=default"
+}
+
+// templateRootNames returns the context names a template reads from its root data:
+// the first identifier of .name fields and $.name variables. Fields inside range and
+// with blocks are relative to another value and are not reported.
+func templateRootNames(tmplString string) ([]string, error) {
+	trees, err := parse.Parse("check", templatePrefix+tmplString, "", "", templateFuncs, builtinTemplateFuncs)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var walk func(node parse.Node, dotIsRoot bool)
+	walk = func(node parse.Node, dotIsRoot bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, dotIsRoot)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, dotIsRoot)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, dotIsRoot)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, dotIsRoot)
+			}
+		case *parse.FieldNode:
+			if dotIsRoot && len(n.Ident) > 0 {
+				add(n.Ident[0])
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				add(n.Ident[1])
+			}
+		case *parse.IfNode:
+			walk(n.Pipe, dotIsRoot)
+			walk(n.List, dotIsRoot)
+			walk(n.ElseList, dotIsRoot)
+		case *parse.RangeNode:
+			walk(n.Pipe, dotIsRoot)
+			walk(n.List, false)
+			walk(n.ElseList, dotIsRoot)
+		case *parse.WithNode:
+			walk(n.Pipe, dotIsRoot)
+			walk(n.List, false)
+			walk(n.ElseList, dotIsRoot)
+		}
+	}
+
+	for _, tree := range trees {
+		walk(tree.Root, true)
+	}
+	return names, nil
+}
+
+// builtinTemplateFuncs lets the parser accept the text/template builtins.
+var builtinTemplateFuncs = map[string]any{
+	"and": fmt.Sprint, "call": fmt.Sprint, "html": fmt.Sprint, "index": fmt.Sprint,
+	"slice": fmt.Sprint, "js": fmt.Sprint, "len": fmt.Sprint, "not": fmt.Sprint,
+	"or": fmt.Sprint, "print": fmt.Sprint, "printf": fmt.Sprint, "println": fmt.Sprint,
+	"urlquery": fmt.Sprint, "eq": fmt.Sprint, "ge": fmt.Sprint, "gt": fmt.Sprint,
+	"le": fmt.Sprint, "lt": fmt.Sprint, "ne": fmt.Sprint,
+}
@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler_testing
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Fixture is a recorded run, see Recorder, turned into a Go test by WriteTest: the test replays
+// the recorded responses, compares the data to the golden output and the profiler events to
+// the recorded ones. Paths are relative to the directory of the test.
+type Fixture struct {
+	Package string            // package of the test, default the name of its directory
+	Name    string            // the test is named Test<Name> in camel case, default after the configuration file
+	Config  string            // configuration file
+	Mocks   map[string]string // recorded urls and their response files
+	Output  string            // golden output: the data, or the array of the streamed entities
+	Stream  bool              // the configuration streams its entities
+	Events  map[string]int    // profiler events counted by name, the unnamed ones left out
+}
+
+var fixtureTemplate = template.Must(template.New("fixture").Parse(`// Recorded by crawl -record, edit it to pin what matters once the upstream changes.
+
+package {{ .Package }}
+
+import (
+	"context"
+{{- if .Stream }}
+	"encoding/json"
+{{- end }}
+	"net/http"
+	"os"
+	"testing"
+
+	apigorowler "github.com/noi-techpark/go-apigorowler"
+	crawler_testing "github.com/noi-techpark/go-apigorowler/testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test{{ .Name }}(t *testing.T) {
+	craw, verr, err := apigorowler.NewApiCrawler({{ printf "%q" .Config }})
+	require.NoError(t, err)
+	require.Empty(t, verr)
+	craw.SetClient(&http.Client{Transport: crawler_testing.NewMockRoundTripper(map[string]string{
+{{- range $url, $file := .Mocks }}
+		{{ printf "%q" $url }}: {{ printf "%q" $file }},
+{{- end }}
+	})})
+
+	profiler := craw.EnableProfiler()
+	events := map[string]int{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for d := range profiler {
+			if d.Name != "" {
+				events[d.Name]++
+			}
+		}
+	}()
+{{- if .Stream }}
+
+	stream := craw.GetDataStream()
+	entities := []any{}
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		for entity := range stream {
+			entities = append(entities, entity)
+		}
+	}()
+	require.NoError(t, craw.Run(context.TODO()))
+	close(stream)
+	<-streamed
+	close(profiler)
+	<-done
+
+	output, err := json.Marshal(entities)
+{{- else }}
+
+	require.NoError(t, craw.Run(context.TODO()))
+	close(profiler)
+	<-done
+
+	output, err := craw.MarshalOutput(craw.GetData())
+{{- end }}
+	require.NoError(t, err)
+	golden, err := os.ReadFile({{ printf "%q" .Output }})
+	require.NoError(t, err)
+	assert.JSONEq(t, string(golden), string(output))
+
+	expected := map[string]int{
+{{- range $name, $count := .Events }}
+		{{ printf "%q" $name }}: {{ $count }},
+{{- end }}
+	}
+	assert.Equal(t, expected, events)
+}
+`))
+
+// WriteTest writes the Go test of the fixture to path.
+func (f Fixture) WriteTest(path string) error {
+	if f.Package == "" {
+		f.Package = strings.ToLower(identifier(filepath.Base(filepath.Dir(path))))
+	}
+	if f.Name == "" {
+		f.Name = strings.TrimSuffix(filepath.Base(f.Config), filepath.Ext(f.Config))
+	}
+	f.Name = identifier(f.Name)
+	var buf bytes.Buffer
+	if err := fixtureTemplate.Execute(&buf, f); err != nil {
+		return err
+	}
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("invalid test source: %w", err)
+	}
+	return os.WriteFile(path, source, 0644)
+}
+
+// identifier turns a file name like example-stations.v2 into ExampleStationsV2.
+func identifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || !unicode.IsLetter([]rune(b.String())[0]) {
+		return "Fixture" + b.String()
+	}
+	return b.String()
+}
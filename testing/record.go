@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler_testing
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Recorder is an http.RoundTripper saving the bodies of the responses of a run into
+// Dir/responses, so that the run can be replayed with a MockRoundTripper over MockMap.
+// A url requested more than once replays its last response.
+type Recorder struct {
+	Transport http.RoundTripper // default http.DefaultTransport
+	Dir       string
+
+	mu    sync.Mutex
+	count int
+	mocks map[string]string // normalized url => file relative to Dir
+}
+
+func NewRecorder(dir string, transport http.RoundTripper) *Recorder {
+	return &Recorder{Transport: transport, Dir: dir, mocks: make(map[string]string)}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := normalizeURL(req.URL)
+	file, ok := r.mocks[key]
+	if !ok {
+		r.count++
+		file = filepath.ToSlash(filepath.Join("responses", fmt.Sprintf("%03d.json", r.count)))
+	}
+	if err := os.MkdirAll(filepath.Join(r.Dir, "responses"), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.Dir, file), body, 0644); err != nil {
+		return nil, err
+	}
+	r.mocks[key] = file
+	return resp, nil
+}
+
+// MockMap returns the recorded urls and their response files, relative to Dir.
+func (r *Recorder) MockMap() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maps.Clone(r.mocks)
+}
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// templateNowPrefix declares $now in every go-template of the configuration.
+const templateNowPrefix = "{{ $now := now }}"
+
+// TimeHelper is the $now value of go-templates: the current time with date math helpers,
+// e.g. {{ ($now.AddDays -1).StartOfDay.Date }} or {{ $now.In "Europe/Rome" }}.
+type TimeHelper struct {
+	time.Time
+}
+
+func templateNow() TimeHelper {
+	return TimeHelper{nowFunc()}
+}
+
+func (t TimeHelper) String() string {
+	return t.ISO()
+}
+
+// ISO formats the time as RFC 3339.
+func (t TimeHelper) ISO() string {
+	return t.Time.Format(time.RFC3339)
+}
+
+// Date formats the time as YYYY-MM-DD.
+func (t TimeHelper) Date() string {
+	return t.Time.Format("2006-01-02")
+}
+
+// Clock formats the time as hh:mm:ss.
+func (t TimeHelper) Clock() string {
+	return t.Time.Format("15:04:05")
+}
+
+func (t TimeHelper) UnixMillis() int64 {
+	return t.Time.UnixMilli()
+}
+
+func (t TimeHelper) AddDays(days int) TimeHelper {
+	return TimeHelper{t.Time.AddDate(0, 0, days)}
+}
+
+func (t TimeHelper) AddHours(hours int) TimeHelper {
+	return TimeHelper{t.Time.Add(time.Duration(hours) * time.Hour)}
+}
+
+func (t TimeHelper) AddMinutes(minutes int) TimeHelper {
+	return TimeHelper{t.Time.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func (t TimeHelper) StartOfDay() TimeHelper {
+	return TimeHelper{startOfDay(t.Time)}
+}
+
+func (t TimeHelper) EndOfDay() TimeHelper {
+	return TimeHelper{startOfDay(t.Time).AddDate(0, 0, 1).Add(-time.Nanosecond)}
+}
+
+func (t TimeHelper) StartOfMonth() TimeHelper {
+	y, m, _ := t.Time.Date()
+	return TimeHelper{time.Date(y, m, 1, 0, 0, 0, 0, t.Time.Location())}
+}
+
+func (t TimeHelper) UTC() TimeHelper {
+	return TimeHelper{t.Time.UTC()}
+}
+
+// In converts the time to an IANA time zone, day boundaries follow the zone.
+func (t TimeHelper) In(zone string) (TimeHelper, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return t, err
+	}
+	return TimeHelper{t.Time.In(loc)}, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// jqNow is the $now value of jq expressions.
+func jqNow() map[string]any {
+	now := nowFunc()
+	sod := startOfDay(now)
+	return map[string]any{
+		"iso":            now.Format(time.RFC3339),
+		"date":           now.Format("2006-01-02"),
+		"time":           now.Format("15:04:05"),
+		"unix":           now.Unix(),
+		"unixMillis":     now.UnixMilli(),
+		"year":           now.Year(),
+		"month":          int(now.Month()),
+		"day":            now.Day(),
+		"weekday":        int(now.Weekday()),
+		"startOfDay":     sod.Format(time.RFC3339),
+		"startOfDayUnix": sod.Unix(),
+	}
+}
+
+// jqTimeFunctions are the date math helpers of jq expressions. They accept an RFC 3339
+// string or unix seconds and return the same representation:
+// $now.iso | addDays(-7) | startOfDay
+var jqTimeFunctions = []gojq.CompilerOption{
+	gojq.WithFunction("addDays", 1, 1, func(v any, args []any) any {
+		days, ok := args[0].(int)
+		if !ok {
+			f, isFloat := args[0].(float64)
+			if !isFloat {
+				return fmt.Errorf("addDays: days must be a number, got %v", args[0])
+			}
+			days = int(f)
+		}
+		return shiftTime(v, func(t time.Time) time.Time { return t.AddDate(0, 0, days) })
+	}),
+	gojq.WithFunction("startOfDay", 0, 0, func(v any, _ []any) any {
+		return shiftTime(v, startOfDay)
+	}),
+}
+
+func shiftTime(v any, fn func(time.Time) time.Time) any {
+	switch x := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339, x)
+		if err != nil {
+			return fmt.Errorf("expected an RFC 3339 time: %w", err)
+		}
+		return fn(t).Format(time.RFC3339)
+	case int:
+		return int(fn(time.Unix(int64(x), 0).UTC()).Unix())
+	case float64:
+		return int(fn(time.Unix(int64(x), 0).UTC()).Unix())
+	default:
+		return fmt.Errorf("expected an RFC 3339 time or unix seconds, got %v", v)
+	}
+}
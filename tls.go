@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSConfig sets up the TLS connections of the requests: the client certificate presented to
+// APIs protected by mutual TLS, the CAs trusted besides the system ones and the server name
+// sent as SNI. The tls of a request overrides the fields set in the configuration one.
+type TLSConfig struct {
+	CertFile   string `yaml:"certFile,omitempty" json:"certFile,omitempty"`     // PEM client certificate
+	KeyFile    string `yaml:"keyFile,omitempty" json:"keyFile,omitempty"`       // PEM key of certFile
+	CAFile     string `yaml:"caFile,omitempty" json:"caFile,omitempty"`         // PEM bundle of CAs trusted besides the system ones
+	ServerName string `yaml:"serverName,omitempty" json:"serverName,omitempty"` // SNI and verified name, default the host of the url; request tls only
+	MinVersion string `yaml:"minVersion,omitempty" json:"minVersion,omitempty"` // 1.2 or 1.3, default the one of the HTTP client
+}
+
+// tlsVersions are the TLS versions minVersion accepts.
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// tlsConfig returns the TLS settings of the requests of reqConfig, nil without any.
+func (c *ApiCrawler) tlsConfig(reqConfig *RequestConfig) *TLSConfig {
+	var cfg TLSConfig
+	if c.Config.TLS != nil {
+		cfg = *c.Config.TLS
+	}
+	if reqConfig != nil && reqConfig.TLS != nil {
+		override := reqConfig.TLS
+		if override.CertFile != "" {
+			cfg.CertFile, cfg.KeyFile = override.CertFile, override.KeyFile
+		}
+		if override.CAFile != "" {
+			cfg.CAFile = override.CAFile
+		}
+		if override.ServerName != "" {
+			cfg.ServerName = override.ServerName
+		}
+		if override.MinVersion != "" {
+			cfg.MinVersion = override.MinVersion
+		}
+	}
+	if cfg == (TLSConfig{}) {
+		return nil
+	}
+	return &cfg
+}
+
+// clientKey identifies the TLS settings in the cache of the clients.
+func (cfg *TLSConfig) clientKey() string {
+	if cfg == nil {
+		return ""
+	}
+	return fmt.Sprintf("tls:%s|%s|%s|%s|%s", cfg.CertFile, cfg.KeyFile, cfg.CAFile, cfg.ServerName, cfg.MinVersion)
+}
+
+// apply returns base with the certificate, the CAs and the server name of cfg.
+func (cfg *TLSConfig) apply(base *tls.Config) (*tls.Config, error) {
+	conf := &tls.Config{}
+	if base != nil {
+		conf = base.Clone()
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca bundle: no PEM certificate in %s", cfg.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.ServerName != "" {
+		conf.ServerName = cfg.ServerName
+	}
+	if version, ok := tlsVersions[cfg.MinVersion]; ok {
+		conf.MinVersion = version
+	}
+	return conf, nil
+}
+
+// tlsSetupError is returned when the TLS settings of a request can not be loaded.
+type tlsSetupError struct {
+	err error
+}
+
+func (e *tlsSetupError) Error() string { return "tls: " + e.err.Error() }
+func (e *tlsSetupError) Unwrap() error { return e.err }
+
+func validateTLS(cfg *TLSConfig, location string) []ValidationError {
+	var errs []ValidationError
+	if *cfg == (TLSConfig{}) {
+		errs = append(errs, ValidationError{"tls requires one of certFile, caFile, serverName, minVersion", location})
+	}
+	if cfg.CertFile != "" && cfg.KeyFile == "" {
+		errs = append(errs, ValidationError{"tls.keyFile is required with certFile", location + ".keyFile"})
+	}
+	if cfg.KeyFile != "" && cfg.CertFile == "" {
+		errs = append(errs, ValidationError{"tls.certFile is required with keyFile", location + ".certFile"})
+	}
+	if _, ok := tlsVersions[cfg.MinVersion]; cfg.MinVersion != "" && !ok {
+		errs = append(errs, ValidationError{"tls.minVersion must be one of [1.2, 1.3]", location + ".minVersion"})
+	}
+	return errs
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+)
+
+// handleTransform runs a transform step: its jq rule reshapes the current context, the root
+// context for top level steps, with $ctx available, and the single result goes through the
+// nested steps and the merge rules like the result of a request. No request is sent.
+func (c *ApiCrawler) handleTransform(ctx context.Context, exec *stepExecution) error {
+	c.logger.Info("[Transform] Preparing %s", exec.step.Name)
+
+	templateCtx := c.templateContext(exec)
+	data := exec.currentContext.Data
+	c.pushProfilerData(STEP_PROFILER_TYPE_START, fmt.Sprintf("Transform '%s'", exec.step.Name), exec, data, nil)
+
+	location := exec.path + ".transform"
+	code, err := c.getOrCompileJQRule(exec.step.Transform, "$ctx")
+	if err != nil {
+		return &TransformError{Location: location, Rule: exec.step.Transform, Err: err}
+	}
+	iter := c.runJQ(exec, code, data, templateCtx)
+	var result any
+	count := 0
+	for {
+		v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, isErr := v.(error); isErr {
+			return &TransformError{Location: location, Rule: exec.step.Transform, Err: fmt.Errorf("jq error: %w", err)}
+		}
+		count++
+		if count > 1 {
+			return &TransformError{Location: location, Rule: exec.step.Transform, Err: fmt.Errorf("transform yielded more than one value")}
+		}
+		result = v
+	}
+
+	transformed, err := c.transformResult(exec, result, templateCtx, nil)
+	if err != nil {
+		return err
+	}
+	c.pushProfilerDiff("Context Transformation", exec, transformed, data)
+
+	return c.mergeStepResult(ctx, exec, transformed, nil)
+}
@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportConfig tunes the connections of the requests. The transport of a request overrides
+// the fields set in the configuration one; the fields left out keep the ones of the HTTP
+// client of the crawler.
+type TransportConfig struct {
+	MaxIdleConns                 int     `yaml:"maxIdleConns,omitempty" json:"maxIdleConns,omitempty"`               // idle connections kept over all hosts
+	MaxIdleConnsPerHost          int     `yaml:"maxIdleConnsPerHost,omitempty" json:"maxIdleConnsPerHost,omitempty"` // idle connections kept per host
+	MaxConnsPerHost              int     `yaml:"maxConnsPerHost,omitempty" json:"maxConnsPerHost,omitempty"`         // connections per host, the requests beyond wait
+	IdleConnTimeoutSeconds       float64 `yaml:"idleConnTimeoutSeconds,omitempty" json:"idleConnTimeoutSeconds,omitempty"`
+	DisableKeepAlives            bool    `yaml:"disableKeepAlives,omitempty" json:"disableKeepAlives,omitempty"` // one connection per request
+	KeepAliveSeconds             float64 `yaml:"keepAliveSeconds,omitempty" json:"keepAliveSeconds,omitempty"`   // interval of the TCP keep-alive probes
+	DialTimeoutSeconds           float64 `yaml:"dialTimeoutSeconds,omitempty" json:"dialTimeoutSeconds,omitempty"`
+	TLSHandshakeTimeoutSeconds   float64 `yaml:"tlsHandshakeTimeoutSeconds,omitempty" json:"tlsHandshakeTimeoutSeconds,omitempty"`
+	ResponseHeaderTimeoutSeconds float64 `yaml:"responseHeaderTimeoutSeconds,omitempty" json:"responseHeaderTimeoutSeconds,omitempty"`
+}
+
+// transportConfig returns the transport settings of the requests of reqConfig, nil without any.
+func (c *ApiCrawler) transportConfig(reqConfig *RequestConfig) *TransportConfig {
+	var cfg TransportConfig
+	if c.Config.Transport != nil {
+		cfg = *c.Config.Transport
+	}
+	if reqConfig != nil && reqConfig.Transport != nil {
+		override := reqConfig.Transport
+		for _, field := range []struct{ dst, src *int }{
+			{&cfg.MaxIdleConns, &override.MaxIdleConns},
+			{&cfg.MaxIdleConnsPerHost, &override.MaxIdleConnsPerHost},
+			{&cfg.MaxConnsPerHost, &override.MaxConnsPerHost},
+		} {
+			if *field.src != 0 {
+				*field.dst = *field.src
+			}
+		}
+		for _, field := range []struct{ dst, src *float64 }{
+			{&cfg.IdleConnTimeoutSeconds, &override.IdleConnTimeoutSeconds},
+			{&cfg.KeepAliveSeconds, &override.KeepAliveSeconds},
+			{&cfg.DialTimeoutSeconds, &override.DialTimeoutSeconds},
+			{&cfg.TLSHandshakeTimeoutSeconds, &override.TLSHandshakeTimeoutSeconds},
+			{&cfg.ResponseHeaderTimeoutSeconds, &override.ResponseHeaderTimeoutSeconds},
+		} {
+			if *field.src != 0 {
+				*field.dst = *field.src
+			}
+		}
+		cfg.DisableKeepAlives = cfg.DisableKeepAlives || override.DisableKeepAlives
+	}
+	if cfg == (TransportConfig{}) {
+		return nil
+	}
+	return &cfg
+}
+
+// apply sets the settings of cfg on transport.
+func (cfg *TransportConfig) apply(transport *http.Transport) {
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	}
+	if cfg.IdleConnTimeoutSeconds > 0 {
+		transport.IdleConnTimeout = seconds(cfg.IdleConnTimeoutSeconds)
+	}
+	if cfg.DisableKeepAlives {
+		transport.DisableKeepAlives = true
+	}
+	if cfg.TLSHandshakeTimeoutSeconds > 0 {
+		transport.TLSHandshakeTimeout = seconds(cfg.TLSHandshakeTimeoutSeconds)
+	}
+	if cfg.ResponseHeaderTimeoutSeconds > 0 {
+		transport.ResponseHeaderTimeout = seconds(cfg.ResponseHeaderTimeoutSeconds)
+	}
+	if cfg.DialTimeoutSeconds > 0 || cfg.KeepAliveSeconds > 0 {
+		// the defaults of http.DefaultTransport
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		if cfg.DialTimeoutSeconds > 0 {
+			dialer.Timeout = seconds(cfg.DialTimeoutSeconds)
+		}
+		if cfg.KeepAliveSeconds > 0 {
+			dialer.KeepAlive = seconds(cfg.KeepAliveSeconds)
+		}
+		transport.DialContext = dialer.DialContext
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// requestTimeout returns the time limit of the requests of reqConfig, 0 without any.
+func (c *ApiCrawler) requestTimeout(reqConfig *RequestConfig) time.Duration {
+	if reqConfig != nil && reqConfig.TimeoutSeconds > 0 {
+		return seconds(reqConfig.TimeoutSeconds)
+	}
+	return seconds(c.Config.TimeoutSeconds)
+}
+
+// bodyTimeout returns the time limit of the requests of exec, response body included, 0 without
+// any. The bodies of the subscribe and download steps stream for as long as they need, their
+// timeout only limits the wait for the response headers, see clientSettings.
+func (c *ApiCrawler) bodyTimeout(exec *stepExecution) time.Duration {
+	if exec.step.Subscribe != nil || exec.step.Download != nil {
+		return 0
+	}
+	return c.requestTimeout(exec.step.Request)
+}
+
+// withTimeout returns req limited to timeout, and the cancel to call once its response body is
+// read, see timedBody.
+func withTimeout(req *http.Request, timeout time.Duration) (*http.Request, context.CancelFunc) {
+	if timeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	return req.WithContext(ctx), cancel
+}
+
+// timedBody releases the deadline of its request when closed.
+type timedBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *timedBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// clientSettings are what the client of a request is derived from the configured one with.
+type clientSettings struct {
+	proxy     *ProxyConfig
+	tls       *TLSConfig
+	transport *TransportConfig
+}
+
+// clientSettings returns the settings of the requests of reqConfig to u, see proxyFor. The
+// timeout of the requests limits the wait for the response headers, unless the transport sets
+// its own responseHeaderTimeoutSeconds; the body is limited per request, see bodyTimeout.
+func (c *ApiCrawler) clientSettings(reqConfig *RequestConfig, u *url.URL) clientSettings {
+	settings := clientSettings{
+		proxy:     c.proxyFor(reqConfig, c.hostPolicy(u), u),
+		tls:       c.tlsConfig(reqConfig),
+		transport: c.transportConfig(reqConfig),
+	}
+	if timeout := c.requestTimeout(reqConfig); timeout > 0 {
+		if settings.transport == nil {
+			settings.transport = &TransportConfig{}
+		}
+		if settings.transport.ResponseHeaderTimeoutSeconds == 0 {
+			settings.transport.ResponseHeaderTimeoutSeconds = timeout.Seconds()
+		}
+	}
+	return settings
+}
+
+// key identifies the settings in the cache of the clients.
+func (s clientSettings) key() string {
+	transport := ""
+	if s.transport != nil {
+		transport = fmt.Sprintf("%+v", *s.transport)
+	}
+	return fmt.Sprintf("%s\x00%s\x00%s", s.proxy.clientKey(), s.tls.clientKey(), transport)
+}
+
+// clientFor returns the client to use with settings: routing through their proxy, with their
+// TLS and transport settings. They need the configured client to be an
+// *http.Client with an *http.Transport (or the default one); other clients are used as they are.
+func (c *ApiCrawler) clientFor(settings clientSettings) (HTTPClient, error) {
+	if settings == (clientSettings{}) {
+		return c.httpClient, nil
+	}
+	key := settings.key()
+	c.proxyMu.Lock()
+	defer c.proxyMu.Unlock()
+
+	if client, ok := c.proxyClients[key]; ok {
+		return client, nil
+	}
+
+	base, ok := c.httpClient.(*http.Client)
+	if !ok {
+		c.logger.Warning("[Hosts] proxy, tls, transport and timeout settings ignored, the HTTP client is not an *http.Client")
+		c.proxyClients[key] = c.httpClient
+		return c.httpClient, nil
+	}
+	client := *base
+	transport, ok := base.Transport.(*http.Transport)
+	if base.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		c.logger.Warning("[Hosts] proxy, tls, transport and timeout settings ignored, the HTTP client transport can not be configured")
+		c.proxyClients[key] = &client
+		return &client, nil
+	}
+
+	transport = transport.Clone()
+	if settings.proxy != nil {
+		proxyURL, err := c.proxyURL(settings.proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = nil
+		if proxyURL != nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	if settings.tls != nil {
+		conf, err := settings.tls.apply(transport.TLSClientConfig)
+		if err != nil {
+			return nil, &tlsSetupError{err}
+		}
+		transport.TLSClientConfig = conf
+	}
+	if settings.transport != nil {
+		settings.transport.apply(transport)
+	}
+	client.Transport = transport
+	c.proxyClients[key] = &client
+	return &client, nil
+}
+
+func validateTransport(cfg *TransportConfig, location string) []ValidationError {
+	var errs []ValidationError
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"maxIdleConns", float64(cfg.MaxIdleConns)},
+		{"maxIdleConnsPerHost", float64(cfg.MaxIdleConnsPerHost)},
+		{"maxConnsPerHost", float64(cfg.MaxConnsPerHost)},
+		{"idleConnTimeoutSeconds", cfg.IdleConnTimeoutSeconds},
+		{"keepAliveSeconds", cfg.KeepAliveSeconds},
+		{"dialTimeoutSeconds", cfg.DialTimeoutSeconds},
+		{"tlsHandshakeTimeoutSeconds", cfg.TLSHandshakeTimeoutSeconds},
+		{"responseHeaderTimeoutSeconds", cfg.ResponseHeaderTimeoutSeconds},
+	} {
+		if field.value < 0 {
+			errs = append(errs, ValidationError{fmt.Sprintf("transport.%s must not be negative", field.name), location + "." + field.name})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	USER_AGENT_NAME    = "go-apigorowler"
+	USER_AGENT_CONTACT = "https://github.com/noi-techpark/go-apigorowler"
+	modulePath         = "github.com/noi-techpark/go-apigorowler"
+)
+
+// UserAgentConfig identifies the crawler to the APIs, as "name/version (+contact)".
+type UserAgentConfig struct {
+	Value   string `yaml:"value,omitempty" json:"value,omitempty"` // full User-Agent, overrides the other fields
+	Name    string `yaml:"name,omitempty" json:"name,omitempty"`
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+	Contact string `yaml:"contact,omitempty" json:"contact,omitempty"` // url or email address of the maintainers
+}
+
+// moduleVersion is the version of this module in the running binary, "dev" when unknown,
+// e.g. in tests and local builds.
+var moduleVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath && dep.Version != "" {
+			return dep.Version
+		}
+	}
+	return "dev"
+})
+
+// DefaultUserAgent is the User-Agent sent when the configuration sets none, e.g.
+// "go-apigorowler/v1.2.0 (+https://github.com/noi-techpark/go-apigorowler)".
+func DefaultUserAgent() string {
+	return UserAgentConfig{}.String()
+}
+
+// String renders the User-Agent, the fields not set default to the ones of the crawler.
+func (u UserAgentConfig) String() string {
+	if u.Value != "" {
+		return u.Value
+	}
+	name, version, contact := u.Name, u.Version, u.Contact
+	if name == "" {
+		name = USER_AGENT_NAME
+		if version == "" {
+			version = moduleVersion()
+		}
+	}
+	if contact == "" {
+		contact = USER_AGENT_CONTACT
+	}
+	if version != "" {
+		name += "/" + version
+	}
+	return fmt.Sprintf("%s (+%s)", name, contact)
+}
+
+// setUserAgent sets the configured User-Agent on req, the default one without configuration.
+// Headers named User-Agent override it.
+func (c *ApiCrawler) setUserAgent(req *http.Request) {
+	ua := DefaultUserAgent()
+	if c.Config.UserAgent != nil {
+		ua = c.Config.UserAgent.String()
+	}
+	req.Header.Set("User-Agent", ua)
+}
@@ -5,8 +5,15 @@
 package apigorowler
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
 	"strings"
+
+	"github.com/itchyny/gojq"
 )
 
 type ValidationError struct {
@@ -36,8 +43,16 @@ func ValidateConfig(cfg Config) []ValidationError {
 		}
 	}
 
-	// stream requires rootContext to be []interface{}
-	if cfg.Stream {
+	// stream requires rootContext to be []interface{}, or an object holding the streamKey array
+	switch {
+	case cfg.StreamKey != "" && !cfg.Stream:
+		errs = append(errs, ValidationError{"streamKey requires stream=true", "streamKey"})
+	case cfg.StreamKey != "":
+		root, _ := cfg.RootContext.(map[string]interface{})
+		if _, ok := root[cfg.StreamKey].([]interface{}); !ok {
+			errs = append(errs, ValidationError{fmt.Sprintf("streamKey requires rootContext to be an object with an array '%s'", cfg.StreamKey), "streamKey"})
+		}
+	case cfg.Stream:
 		if _, ok := cfg.RootContext.([]interface{}); !ok {
 			errs = append(errs, ValidationError{"stream=true requires rootContext to be an array", "stream"})
 		}
@@ -48,7 +63,119 @@ func ValidateConfig(cfg Config) []ValidationError {
 		errs = append(errs, validateAuth(*cfg.Authentication, "auth")...)
 	}
 
-	// headers optional, but if present must be map[string]string (assumed unmarshalled correctly)
+	// headers optional, values may be go-templates
+	errs = append(errs, validateHeaderTemplates(cfg.Headers, "headers")...)
+
+	if cfg.SchemaDrift != nil && cfg.SchemaDrift.Path == "" {
+		errs = append(errs, ValidationError{"schemaDrift.path is required", "schemaDrift.path"})
+	}
+
+	if cfg.EntitySchema != nil && cfg.EntitySchema.Schema == nil {
+		errs = append(errs, ValidationError{"entitySchema.schema is required", "entitySchema.schema"})
+	}
+
+	if cfg.Dedup != nil {
+		if cfg.Dedup.Identity == "" {
+			errs = append(errs, ValidationError{"dedup.identity is required", "dedup.identity"})
+		} else if _, err := gojq.Parse(cfg.Dedup.Identity); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid dedup identity: %v", err), "dedup.identity"})
+		}
+	}
+
+	for i, interceptor := range cfg.Interceptors {
+		errs = append(errs, validateInterceptor(interceptor, fmt.Sprintf("interceptors[%d]", i))...)
+	}
+
+	if store := cfg.StateStore; store != nil {
+		switch store.Type {
+		case STATE_STORE_FILE:
+		case STATE_STORE_REDIS:
+			if store.URL == "" {
+				errs = append(errs, ValidationError{"stateStore.url is required for redis", "stateStore.url"})
+			} else if _, err := NewRedisStore(store.URL, store.KeyPrefix); err != nil {
+				errs = append(errs, ValidationError{err.Error(), "stateStore.url"})
+			}
+		default:
+			errs = append(errs, ValidationError{"stateStore.type must be one of [file, redis]", "stateStore.type"})
+		}
+	}
+
+	if out := cfg.Output; out != nil {
+		switch out.Numbers {
+		case "", OUTPUT_NUMBERS_DEFAULT, OUTPUT_NUMBERS_PLAIN:
+		default:
+			errs = append(errs, ValidationError{"output.numbers must be one of [default, plain]", "output.numbers"})
+		}
+		if out.Indent < 0 {
+			errs = append(errs, ValidationError{"output.indent must not be negative", "output.indent"})
+		}
+	}
+
+	if ua := cfg.UserAgent; ua != nil {
+		if ua.Value != "" && (ua.Name != "" || ua.Version != "" || ua.Contact != "") {
+			errs = append(errs, ValidationError{"userAgent.value can not be combined with name, version or contact", "userAgent.value"})
+		}
+		if ua.Version != "" && ua.Name == "" {
+			errs = append(errs, ValidationError{"userAgent.version requires name", "userAgent.version"})
+		}
+	}
+	if cfg.CorrelationHeader != "" && !isToken(cfg.CorrelationHeader) {
+		errs = append(errs, ValidationError{fmt.Sprintf("correlationHeader '%s' is not a valid header name", cfg.CorrelationHeader), "correlationHeader"})
+	}
+	if mp := cfg.MemoryPressure; mp != nil {
+		if mp.HeapLimitMb < 0 {
+			errs = append(errs, ValidationError{"memoryPressure.heapLimitMb must not be negative", "memoryPressure.heapLimitMb"})
+		}
+		if mp.CheckIntervalMs < 0 {
+			errs = append(errs, ValidationError{"memoryPressure.checkIntervalMs must not be negative", "memoryPressure.checkIntervalMs"})
+		}
+	}
+	for _, name := range sortedKeys(cfg.URLSigners) {
+		errs = append(errs, validateURLSigner(cfg.URLSigners[name], fmt.Sprintf("urlSigners[%s]", name))...)
+	}
+
+	for pattern, host := range cfg.Hosts {
+		errs = append(errs, validateHost(host, fmt.Sprintf("hosts[%s]", pattern))...)
+	}
+
+	if cfg.TLS != nil {
+		errs = append(errs, validateTLS(cfg.TLS, "tls")...)
+		// one name can not be verified for every host
+		if cfg.TLS.ServerName != "" {
+			errs = append(errs, ValidationError{"tls.serverName is only allowed in the tls of a request", "tls.serverName"})
+		}
+	}
+	if cfg.Proxy != nil {
+		errs = append(errs, validateProxy(cfg.Proxy, "proxy")...)
+	}
+	if cfg.TimeoutSeconds < 0 {
+		errs = append(errs, ValidationError{"timeoutSeconds must not be negative", "timeoutSeconds"})
+	}
+	if cfg.Transport != nil {
+		errs = append(errs, validateTransport(cfg.Transport, "transport")...)
+	}
+
+	if st := cfg.ServerTime; st != nil && st.URL != "" {
+		if u, err := url.Parse(st.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, ValidationError{"serverTime.url must be an http or https url", "serverTime.url"})
+		}
+		if !st.Sync {
+			errs = append(errs, ValidationError{"serverTime.url requires sync", "serverTime.sync"})
+		}
+	}
+
+	if events := cfg.RunEvents; events != nil {
+		webhooks := [][2]string{{"onRunStart", events.OnRunStart}, {"onRunSuccess", events.OnRunSuccess}, {"onRunFailure", events.OnRunFailure}}
+		for _, webhook := range webhooks {
+			if u, err := url.Parse(webhook[1]); webhook[1] != "" && (err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https")) {
+				errs = append(errs, ValidationError{"webhook must be an http or https url", "runEvents." + webhook[0]})
+			}
+		}
+	}
+
+	for i, sink := range cfg.Sinks {
+		errs = append(errs, validateSink(sink, fmt.Sprintf("sinks[%d]", i))...)
+	}
 
 	// steps required and non-empty
 	if len(cfg.Steps) == 0 {
@@ -57,6 +184,66 @@ func ValidateConfig(cfg Config) []ValidationError {
 		for i, step := range cfg.Steps {
 			errs = append(errs, validateStep(step, fmt.Sprintf("steps[%d]", i))...)
 		}
+		errs = append(errs, validateDependsOn(cfg.Steps, "steps")...)
+		errs = append(errs, validateTemplateNames(cfg)...)
+	}
+
+	return errs
+}
+
+func validateHost(host HostConfig, location string) []ValidationError {
+	var errs []ValidationError
+
+	if host.RateLimit < 0 {
+		errs = append(errs, ValidationError{"rateLimit must not be negative", location + ".rateLimit"})
+	}
+	if host.DelayMs < 0 {
+		errs = append(errs, ValidationError{"delayMs must not be negative", location + ".delayMs"})
+	}
+	if host.MaxConcurrency < 0 {
+		errs = append(errs, ValidationError{"maxConcurrency must not be negative", location + ".maxConcurrency"})
+	}
+	if host.Proxy != "" {
+		errs = append(errs, validateProxyURL(host.Proxy, location+".proxy")...)
+	}
+	if headers := host.RateLimitHeaders; headers != nil {
+		if !slices.Contains([]string{"", RATE_LIMIT_RESET_AUTO, RATE_LIMIT_RESET_SECONDS, RATE_LIMIT_RESET_UNIX}, headers.ResetFormat) {
+			errs = append(errs, ValidationError{"rateLimitHeaders.resetFormat must be one of [auto, seconds, unix]", location + ".rateLimitHeaders.resetFormat"})
+		}
+		if headers.MinRemaining < 0 {
+			errs = append(errs, ValidationError{"rateLimitHeaders.minRemaining must not be negative", location + ".rateLimitHeaders.minRemaining"})
+		}
+	}
+
+	return errs
+}
+
+func validateSink(sink SinkConfig, location string) []ValidationError {
+	var errs []ValidationError
+
+	if sink.Type != "s3" {
+		errs = append(errs, ValidationError{fmt.Sprintf("sink.type must be one of [s3], got '%s'", sink.Type), location + ".type"})
+		return errs
+	}
+
+	if sink.S3 == nil {
+		errs = append(errs, ValidationError{"s3 sink requires an s3 field", location + ".s3"})
+		return errs
+	}
+	if sink.S3.Endpoint == "" {
+		errs = append(errs, ValidationError{"s3.endpoint is required", location + ".s3.endpoint"})
+	}
+	if sink.S3.Region == "" {
+		errs = append(errs, ValidationError{"s3.region is required", location + ".s3.region"})
+	}
+	if sink.S3.Bucket == "" {
+		errs = append(errs, ValidationError{"s3.bucket is required", location + ".s3.bucket"})
+	}
+	if sink.S3.Key == "" {
+		errs = append(errs, ValidationError{"s3.key is required", location + ".s3.key"})
+	}
+	if sink.S3.Format != "" && sink.S3.Format != SINK_FORMAT_JSON && sink.S3.Format != SINK_FORMAT_NDJSON {
+		errs = append(errs, ValidationError{"s3.format must be one of [json, ndjson]", location + ".s3.format"})
 	}
 
 	return errs
@@ -66,8 +253,28 @@ func validateAuth(auth AuthenticatorConfig, location string) []ValidationError {
 	var errs []ValidationError
 
 	t := strings.ToLower(auth.Type)
-	if t != "basic" && t != "bearer" && t != "oauth" {
-		errs = append(errs, ValidationError{fmt.Sprintf("auth.type must be one of [basic, bearer, oauth], got '%s'", auth.Type), location + ".type"})
+	if t != "basic" && t != "bearer" && t != "oauth" && t != AUTH_TYPE_INJECTED && t != AUTH_TYPE_AWS_SIGV4 {
+		errs = append(errs, ValidationError{fmt.Sprintf("auth.type must be one of [basic, bearer, oauth, injected, aws_sigv4], got '%s'", auth.Type), location + ".type"})
+	}
+
+	for _, field := range [][2]string{{"token", auth.Token}, {"clientId", auth.ClientID}, {"clientSecret", auth.ClientSecret}, {"username", auth.Username}, {"password", auth.Password}, {"refreshToken", auth.RefreshToken}, {"accessKey", auth.AccessKey}, {"secretKey", auth.SecretKey}, {"sessionToken", auth.SessionToken}} {
+		errs = append(errs, validateSecretRef(field[1], location+"."+field[0])...)
+	}
+
+	if t == AUTH_TYPE_INJECTED && auth.Name == "" {
+		errs = append(errs, ValidationError{"auth.name is required when type is injected", location + ".name"})
+	}
+
+	if t == AUTH_TYPE_AWS_SIGV4 {
+		if auth.Region == "" {
+			errs = append(errs, ValidationError{"auth.region is required when type is aws_sigv4", location + ".region"})
+		}
+		if auth.Service == "" {
+			errs = append(errs, ValidationError{"auth.service is required when type is aws_sigv4", location + ".service"})
+		}
+		if (auth.AccessKey == "") != (auth.SecretKey == "") {
+			errs = append(errs, ValidationError{"auth.accessKey and auth.secretKey must be set together", location + ".secretKey"})
+		}
 	}
 
 	if t == "bearer" && auth.Token == "" {
@@ -77,8 +284,8 @@ func validateAuth(auth AuthenticatorConfig, location string) []ValidationError {
 	if t == "oauth" {
 		if auth.Method == "" {
 			errs = append(errs, ValidationError{"auth.method is required when type is oauth", location + ".method"})
-		} else if auth.Method != "password" && auth.Method != "client_credentials" {
-			errs = append(errs, ValidationError{"auth.method must be password or client_credentials", location + ".method"})
+		} else if auth.Method != "password" && auth.Method != "client_credentials" && auth.Method != OAUTH_METHOD_DEVICE_CODE && auth.Method != OAUTH_METHOD_AUTH_CODE && auth.Method != OAUTH_METHOD_REFRESH_TOKEN {
+			errs = append(errs, ValidationError{"auth.method must be password, client_credentials, device_code, authorization_code or refresh_token", location + ".method"})
 		}
 		if auth.TokenURL == "" {
 			errs = append(errs, ValidationError{"auth.tokenUrl is required when type is oauth", location + ".tokenUrl"})
@@ -93,6 +300,29 @@ func validateAuth(auth AuthenticatorConfig, location string) []ValidationError {
 			}
 		}
 
+		if auth.Method == OAUTH_METHOD_DEVICE_CODE {
+			if auth.ClientID == "" {
+				errs = append(errs, ValidationError{"auth.clientId is required when method is device_code", location + ".clientId"})
+			}
+			if auth.DeviceAuthURL == "" {
+				errs = append(errs, ValidationError{"auth.deviceAuthUrl is required when method is device_code", location + ".deviceAuthUrl"})
+			}
+		}
+
+		if auth.Method == OAUTH_METHOD_AUTH_CODE {
+			if auth.ClientID == "" {
+				errs = append(errs, ValidationError{"auth.clientId is required when method is authorization_code", location + ".clientId"})
+			}
+			if auth.AuthURL == "" {
+				errs = append(errs, ValidationError{"auth.authUrl is required when method is authorization_code", location + ".authUrl"})
+			}
+			errs = append(errs, validateRedirectURL(auth.RedirectURL, location+".redirectUrl")...)
+		}
+
+		if auth.Method == OAUTH_METHOD_REFRESH_TOKEN && auth.RefreshToken == "" {
+			errs = append(errs, ValidationError{"auth.refreshToken is required when method is refresh_token", location + ".refreshToken"})
+		}
+
 		if auth.Method == "password" {
 			if auth.Username == "" {
 				errs = append(errs, ValidationError{"auth.username is required when method is password", location + ".username"})
@@ -119,11 +349,44 @@ func validateStep(step Step, location string) []ValidationError {
 	var errs []ValidationError
 
 	t := strings.ToLower(step.Type)
-	if t != "foreach" && t != "request" {
-		errs = append(errs, ValidationError{fmt.Sprintf("step.type must be 'foreach' or 'request', got '%s'", step.Type), location + ".type"})
+	if t != "foreach" && t != "split" && t != "request" && t != "download" && t != "subscribe" && t != "grpc" && t != "fetch" && t != "poll" && t != "sitemap" && t != "probe" && t != "assert" && t != "transform" {
+		errs = append(errs, ValidationError{fmt.Sprintf("step.type must be one of [foreach, split, request, download, subscribe, grpc, fetch, poll, sitemap, probe, assert, transform], got '%s'", step.Type), location + ".type"})
 		return errs
 	}
 
+	if len(step.Mapping) > 0 {
+		errs = append(errs, validateMapping(step.Mapping, location+".mapping")...)
+	}
+
+	errs = append(errs, validateDependsOn(step.Steps, location+".steps")...)
+
+	for _, name := range sortedKeys(step.Locals) {
+		loc := location + ".locals." + name
+		if !localNamePattern.MatchString(name) {
+			errs = append(errs, ValidationError{fmt.Sprintf("local name '%s' must be an identifier", name), loc})
+		}
+		if step.Locals[name] == "" {
+			errs = append(errs, ValidationError{"local rule is required", loc})
+		} else if _, err := gojq.Parse(step.Locals[name]); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid local rule: %v", err), loc})
+		}
+	}
+
+	if step.When != "" {
+		if _, err := gojq.Parse(step.When); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid when rule: %v", err), location + ".when"})
+		}
+	}
+
+	if step.CollectInto != "" {
+		if step.MergeOn != "" || step.MergeWithParentOn != "" || step.MergeWithContext != nil {
+			errs = append(errs, ValidationError{"collectInto can not be combined with mergeOn, mergeWithParentOn or mergeWithContext", location + ".collectInto"})
+		}
+		if step.EmitPerItem {
+			errs = append(errs, ValidationError{"collectInto can not be combined with emitPerItem", location + ".collectInto"})
+		}
+	}
+
 	if t == "foreach" {
 		// foreach rules
 		if step.Path == "" {
@@ -131,6 +394,47 @@ func validateStep(step Step, location string) []ValidationError {
 		}
 		if step.As == "" {
 			errs = append(errs, ValidationError{"foreach step requires as", location + ".as"})
+		} else if step.As == ITERATION_FIRST_KEY || step.As == ITERATION_LAST_KEY {
+			errs = append(errs, ValidationError{fmt.Sprintf("foreach as '%s' is a reserved context name", step.As), location + ".as"})
+		}
+		if step.MaxConcurrency < 0 {
+			errs = append(errs, ValidationError{"maxConcurrency must not be negative", location + ".maxConcurrency"})
+		}
+		if step.ItemShape != "" && step.ItemShape != ITEM_SHAPE_WRAPPED && step.ItemShape != ITEM_SHAPE_RAW {
+			errs = append(errs, ValidationError{"itemShape must be one of [wrapped, raw]", location + ".itemShape"})
+		} else if step.ItemShape != "" && step.Values == nil {
+			errs = append(errs, ValidationError{"itemShape requires values", location + ".itemShape"})
+		}
+		for i, stop := range step.StopOn {
+			stopLocation := fmt.Sprintf("%s.stopOn[%d]", location, i)
+			if stop.Type != "timeBudget" {
+				errs = append(errs, ValidationError{"foreach stop type must be timeBudget", stopLocation + ".type"})
+				continue
+			}
+			errs = append(errs, validatePaginationStop(stop, stopLocation)...)
+		}
+		if step.OrderedMerges && step.MaxConcurrency < 2 {
+			errs = append(errs, ValidationError{"orderedMerges requires maxConcurrency > 1, sequential iterations always merge in item order", location + ".orderedMerges"})
+		}
+		if step.DelayMs < 0 || step.JitterMs < 0 {
+			errs = append(errs, ValidationError{"delayMs and jitterMs must not be negative", location + ".delayMs"})
+		}
+		if (step.DelayMs > 0 || step.JitterMs > 0) && step.MaxConcurrency > 1 {
+			errs = append(errs, ValidationError{"delayMs and jitterMs pace sequential iterations, parallel ones are paced by the hosts settings", location + ".delayMs"})
+		}
+		if a := step.AdaptiveConcurrency; a != nil {
+			if step.MaxConcurrency < 2 {
+				errs = append(errs, ValidationError{"adaptiveConcurrency requires maxConcurrency > 1", location + ".maxConcurrency"})
+			}
+			if a.MinConcurrency < 0 || a.MinConcurrency > step.MaxConcurrency {
+				errs = append(errs, ValidationError{"adaptiveConcurrency.minConcurrency must be between 0 and maxConcurrency", location + ".adaptiveConcurrency.minConcurrency"})
+			}
+			if a.LatencyThresholdMs < 0 {
+				errs = append(errs, ValidationError{"adaptiveConcurrency.latencyThresholdMs must not be negative", location + ".adaptiveConcurrency.latencyThresholdMs"})
+			}
+			if a.MaxRetries < 0 {
+				errs = append(errs, ValidationError{"adaptiveConcurrency.maxRetries must not be negative", location + ".adaptiveConcurrency.maxRetries"})
+			}
 		}
 		// if len(step.Steps) == 0 {
 		// 	errs = append(errs, ValidationError{"foreach step requires nested steps", location + ".steps"})
@@ -150,6 +454,21 @@ func validateStep(step Step, location string) []ValidationError {
 			}
 		}
 
+	} else if t == "split" {
+		if step.Path == "" {
+			errs = append(errs, ValidationError{"split step requires path", location + ".path"})
+		}
+		if step.As == "" {
+			errs = append(errs, ValidationError{"split step requires as", location + ".as"})
+		} else if step.As == ITERATION_FIRST_KEY || step.As == ITERATION_LAST_KEY {
+			errs = append(errs, ValidationError{fmt.Sprintf("split as '%s' is a reserved context name", step.As), location + ".as"})
+		}
+		if step.Values != nil || step.MaxConcurrency > 1 || step.EmitPerItem || step.CollectInto != "" {
+			errs = append(errs, ValidationError{"split step does not support values, maxConcurrency, emitPerItem or collectInto", location})
+		}
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
 	} else if t == "request" {
 		// request step rules
 		if step.Request == nil {
@@ -159,6 +478,188 @@ func validateStep(step Step, location string) []ValidationError {
 		errs = append(errs, validateRequest(*step.Request, location+".request")...)
 
 		// Validate nested steps if any
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	} else if t == "download" {
+		if step.Request == nil {
+			errs = append(errs, ValidationError{"download step requires a request field", location + ".request"})
+		} else {
+			if step.Request.URL == "" {
+				errs = append(errs, ValidationError{"request.url is required", location + ".request.url"})
+			}
+			if step.Request.Method != "" && !isValidMethod(step.Request.Method) {
+				errs = append(errs, ValidationError{fmt.Sprintf("request.method '%s' is not a valid HTTP method token", step.Request.Method), location + ".request.method"})
+			}
+			if step.Request.Authentication != nil {
+				errs = append(errs, validateAuth(*step.Request.Authentication, location+".request.auth")...)
+			}
+			if len(step.Request.Pagination.Params) > 0 || step.Request.Pagination.NextPageUrlSelector != "" {
+				errs = append(errs, ValidationError{"download step does not support pagination", location + ".request.pagination"})
+			}
+		}
+		if step.Download == nil || step.Download.Path == "" {
+			errs = append(errs, ValidationError{"download step requires download.path", location + ".download.path"})
+		}
+
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	}
+
+	if t == "subscribe" {
+		if step.Request == nil || step.Request.URL == "" {
+			errs = append(errs, ValidationError{"subscribe step requires request.url", location + ".request.url"})
+		} else if step.Request.Authentication != nil {
+			errs = append(errs, validateAuth(*step.Request.Authentication, location+".request.auth")...)
+		}
+		if step.Subscribe == nil {
+			errs = append(errs, ValidationError{"subscribe step requires a subscribe field", location + ".subscribe"})
+		} else {
+			p := step.Subscribe.Protocol
+			if p != "" && p != SUBSCRIBE_PROTOCOL_SSE && p != SUBSCRIBE_PROTOCOL_WEBSOCKET {
+				errs = append(errs, ValidationError{"subscribe.protocol must be one of [sse, websocket]", location + ".subscribe.protocol"})
+			}
+			// an unbounded subscription would never end the run
+			if step.Subscribe.MaxMessages <= 0 && step.Subscribe.DurationSeconds <= 0 {
+				errs = append(errs, ValidationError{"subscribe step requires maxMessages or durationSeconds", location + ".subscribe"})
+			}
+			if step.Subscribe.MaxMessageBytes < 0 {
+				errs = append(errs, ValidationError{"subscribe.maxMessageBytes must not be negative", location + ".subscribe.maxMessageBytes"})
+			}
+		}
+
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	}
+
+	if t == "grpc" {
+		if step.GRPC == nil {
+			errs = append(errs, ValidationError{"grpc step requires a grpc field", location + ".grpc"})
+		} else {
+			if step.GRPC.Target == "" {
+				errs = append(errs, ValidationError{"grpc.target is required", location + ".grpc.target"})
+			}
+			if step.GRPC.Service == "" {
+				errs = append(errs, ValidationError{"grpc.service is required", location + ".grpc.service"})
+			}
+			if step.GRPC.Method == "" {
+				errs = append(errs, ValidationError{"grpc.method is required", location + ".grpc.method"})
+			}
+		}
+
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	}
+
+	if t == "fetch" {
+		if step.Request == nil || step.Request.URL == "" {
+			errs = append(errs, ValidationError{"fetch step requires request.url", location + ".request.url"})
+		} else {
+			scheme, _, _ := strings.Cut(step.Request.URL, "://")
+			if scheme != "ftp" && scheme != "sftp" {
+				errs = append(errs, ValidationError{"fetch step request.url must be an ftp:// or sftp:// url", location + ".request.url"})
+			}
+			if step.Request.ResponseFormat != "" && !slices.Contains(responseFormats, step.Request.ResponseFormat) {
+				errs = append(errs, ValidationError{fmt.Sprintf("request.responseFormat must be one of %v", responseFormats), location + ".request.responseFormat"})
+			}
+			if step.Request.CSV != nil {
+				errs = append(errs, validateCSV(step.Request.CSV, location+".request.csv")...)
+			}
+			errs = append(errs, validateHTMLFormat(step.Request, location+".request")...)
+		}
+
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	}
+
+	if t == "poll" {
+		if step.Request == nil || step.Request.URL == "" {
+			errs = append(errs, ValidationError{"poll step requires request.url", location + ".request.url"})
+		}
+		if step.Poll == nil {
+			errs = append(errs, ValidationError{"poll step requires a poll field", location + ".poll"})
+		} else if step.Request != nil {
+			switch step.Poll.protocol(step.Request.URL) {
+			case POLL_PROTOCOL_MQTT:
+				if len(step.Poll.Topics) == 0 {
+					errs = append(errs, ValidationError{"poll.topics is required for mqtt", location + ".poll.topics"})
+				}
+			case POLL_PROTOCOL_OPCUA:
+				if len(step.Poll.NodeIDs) == 0 {
+					errs = append(errs, ValidationError{"poll.nodeIds is required for opcua", location + ".poll.nodeIds"})
+				}
+			case "":
+				errs = append(errs, ValidationError{"poll step request.url must be an mqtt://, mqtts:// or opc.tcp:// url, or poll.protocol set", location + ".request.url"})
+			default:
+				errs = append(errs, ValidationError{"poll.protocol must be one of [mqtt, opcua]", location + ".poll.protocol"})
+			}
+		}
+
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	}
+
+	if t == "sitemap" {
+		if step.Request == nil || step.Request.URL == "" {
+			errs = append(errs, ValidationError{"sitemap step requires request.url", location + ".request.url"})
+		}
+		if step.Sitemap != nil && step.Sitemap.Include != "" {
+			if _, err := regexp.Compile(step.Sitemap.Include); err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid sitemap.include pattern: %v", err), location + ".sitemap.include"})
+			}
+		}
+
+		for i, nested := range step.Steps {
+			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
+		}
+	}
+
+	if t == "probe" {
+		if step.Request == nil || step.Request.URL == "" {
+			errs = append(errs, ValidationError{"probe step requires request.url", location + ".request.url"})
+		} else if step.Request.Method != "" && !isValidMethod(step.Request.Method) {
+			errs = append(errs, ValidationError{fmt.Sprintf("request.method '%s' is not a valid HTTP method token", step.Request.Method), location + ".request.method"})
+		}
+		if step.Probe != nil && step.Probe.MaxLatencyMs < 0 {
+			errs = append(errs, ValidationError{"probe.maxLatencyMs must be positive", location + ".probe.maxLatencyMs"})
+		}
+		if len(step.Steps) > 0 || step.MergeOn != "" || step.MergeWithParentOn != "" || step.MergeWithContext != nil {
+			errs = append(errs, ValidationError{"probe step does not support nested steps or merge rules", location})
+		}
+	}
+
+	if t == "assert" {
+		if len(step.Assertions) == 0 {
+			errs = append(errs, ValidationError{"assert step requires assertions", location + ".assertions"})
+		}
+		for i, assertion := range step.Assertions {
+			loc := fmt.Sprintf("%s.assertions[%d].rule", location, i)
+			if assertion.Rule == "" {
+				errs = append(errs, ValidationError{"assertion rule is required", loc})
+			} else if _, err := gojq.Parse(assertion.Rule); err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid assertion rule: %v", err), loc})
+			}
+		}
+		if step.Request != nil || len(step.Steps) > 0 || step.MergeOn != "" || step.MergeWithParentOn != "" || step.MergeWithContext != nil || step.CollectInto != "" {
+			errs = append(errs, ValidationError{"assert step does not support request, nested steps or merge rules", location})
+		}
+	}
+
+	if t == "transform" {
+		if step.Transform == "" {
+			errs = append(errs, ValidationError{"transform step requires transform", location + ".transform"})
+		} else if _, err := gojq.Parse(step.Transform); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid transform rule: %v", err), location + ".transform"})
+		}
+		if step.Request != nil {
+			errs = append(errs, ValidationError{"transform step does not send a request", location + ".request"})
+		}
+
 		for i, nested := range step.Steps {
 			errs = append(errs, validateStep(nested, fmt.Sprintf("%s.steps[%d]", location, i))...)
 		}
@@ -184,17 +685,132 @@ func validateRequest(req RequestConfig, location string) []ValidationError {
 	if req.Method == "" {
 		errs = append(errs, ValidationError{"request.method is required", location + ".method"})
 	} else {
-		m := strings.ToUpper(req.Method)
-		if m != "GET" && m != "POST" {
-			errs = append(errs, ValidationError{"request.method must be GET or POST", location + ".method"})
+		if !isValidMethod(req.Method) {
+			errs = append(errs, ValidationError{fmt.Sprintf("request.method '%s' is not a valid HTTP method token", req.Method), location + ".method"})
+		}
+	}
+
+	if req.ResponseFrom != "" && req.ResponseFrom != "body" && req.ResponseFrom != "headers" {
+		errs = append(errs, ValidationError{"request.responseFrom must be one of [body, headers]", location + ".responseFrom"})
+	}
+
+	if req.ResponseFormat != "" && !slices.Contains(responseFormats, req.ResponseFormat) {
+		errs = append(errs, ValidationError{fmt.Sprintf("request.responseFormat must be one of %v", responseFormats), location + ".responseFormat"})
+	}
+	if req.CSV != nil {
+		errs = append(errs, validateCSV(req.CSV, location+".csv")...)
+	}
+	errs = append(errs, validateHTMLFormat(&req, location)...)
+
+	if req.EmptyBody != "" && req.EmptyBody != EMPTY_BODY_NULL && req.EmptyBody != EMPTY_BODY_ERROR {
+		errs = append(errs, ValidationError{"request.emptyBody must be one of [null, error]", location + ".emptyBody"})
+	}
+
+	errs = append(errs, validateHeaderValues(req.Headers, location+".headers")...)
+	if req.TLS != nil {
+		errs = append(errs, validateTLS(req.TLS, location+".tls")...)
+	}
+	if req.Proxy != nil {
+		errs = append(errs, validateProxy(req.Proxy, location+".proxy")...)
+	}
+	if req.TimeoutSeconds < 0 {
+		errs = append(errs, ValidationError{"request.timeoutSeconds must not be negative", location + ".timeoutSeconds"})
+	}
+	if req.Transport != nil {
+		errs = append(errs, validateTransport(req.Transport, location+".transport")...)
+	}
+	if req.GraphQL != nil {
+		errs = append(errs, validateGraphQL(req, location+".graphql")...)
+	}
+
+	bodies := 0
+	for _, body := range []string{req.Body, req.BodyBase64, req.BodyFile} {
+		if body != "" {
+			bodies++
 		}
 	}
+	if bodies > 1 {
+		errs = append(errs, ValidationError{"request.body, bodyBase64 and bodyFile are mutually exclusive", location + ".body"})
+	}
+	if req.BodyBase64 != "" && !isHeaderTemplate(req.BodyBase64) {
+		if _, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(req.BodyBase64), "")); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("request.bodyBase64 is not valid base64: %v", err), location + ".bodyBase64"})
+		}
+	}
+	if req.binaryBody() {
+		for i, param := range req.Pagination.Params {
+			if param.Location == "body" {
+				errs = append(errs, ValidationError{"body pagination params require a JSON body, not bodyBase64 or bodyFile", fmt.Sprintf("%s.pagination.params[%d].location", location, i)})
+			}
+		}
+	}
+
+	if !isSupportedCharset(req.ResponseCharset) {
+		errs = append(errs, ValidationError{fmt.Sprintf("request.responseCharset '%s' is not supported", req.ResponseCharset), location + ".responseCharset"})
+	}
 
 	if req.Authentication != nil {
 		errs = append(errs, validateAuth(*req.Authentication, location+".auth")...)
 	}
 
-	if len(req.Pagination.Params) > 0 || len(req.Pagination.StopOn) > 0 {
+	if req.OpenAPI != nil {
+		if req.OpenAPI.Spec == "" {
+			errs = append(errs, ValidationError{"request.openapi.spec is required", location + ".openapi.spec"})
+		}
+		if req.OpenAPI.Mode != "" && req.OpenAPI.Mode != OPENAPI_MODE_WARN && req.OpenAPI.Mode != OPENAPI_MODE_ERROR {
+			errs = append(errs, ValidationError{"request.openapi.mode must be one of [warn, error]", location + ".openapi.mode"})
+		}
+	}
+
+	errs = append(errs, validateErrorWhen(req, location)...)
+
+	if p := req.AsyncPoll; p != nil {
+		if p.IntervalMs < 0 {
+			errs = append(errs, ValidationError{"request.asyncPoll.intervalMs must not be negative", location + ".asyncPoll.intervalMs"})
+		}
+		if p.TimeoutMs < 0 {
+			errs = append(errs, ValidationError{"request.asyncPoll.timeoutMs must not be negative", location + ".asyncPoll.timeoutMs"})
+		}
+		if p.Until != "" {
+			if _, err := gojq.Parse(p.Until); err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid request.asyncPoll.until: %v", err), location + ".asyncPoll.until"})
+			}
+		}
+	}
+
+	if req.IdempotencyKey != nil {
+		switch strings.ToUpper(req.Method) {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			errs = append(errs, ValidationError{fmt.Sprintf("request.idempotencyKey is not supported on %s requests", strings.ToUpper(req.Method)), location + ".idempotencyKey"})
+		}
+		if strings.ContainsAny(req.IdempotencyKey.Header, " :\r\n") {
+			errs = append(errs, ValidationError{"request.idempotencyKey.header must be a header name", location + ".idempotencyKey.header"})
+		}
+	}
+
+	switch req.ArrayFormat {
+	case "", ARRAY_FORMAT_REPEAT, ARRAY_FORMAT_COMMA, ARRAY_FORMAT_BRACKETS:
+	default:
+		errs = append(errs, ValidationError{"request.arrayFormat must be one of [repeat, comma, brackets]", location + ".arrayFormat"})
+	}
+	for name := range req.Query {
+		if name == "" {
+			errs = append(errs, ValidationError{"request.query names must not be empty", location + ".query"})
+		}
+	}
+
+	for i, lang := range req.Languages {
+		if lang == "" {
+			errs = append(errs, ValidationError{"request.languages must not contain empty languages", fmt.Sprintf("%s.languages[%d]", location, i)})
+		} else if slices.Contains(req.Languages[:i], lang) {
+			errs = append(errs, ValidationError{fmt.Sprintf("request.languages contains '%s' twice", lang), fmt.Sprintf("%s.languages[%d]", location, i)})
+		}
+	}
+	if req.LanguageParam != "" && len(req.Languages) == 0 {
+		errs = append(errs, ValidationError{"request.languageParam requires languages", location + ".languageParam"})
+	}
+
+	if len(req.Pagination.Params) > 0 || len(req.Pagination.StopOn) > 0 || req.Pagination.NextRequest != nil {
 		errs = append(errs, validatePagination(req.Pagination, location+".pagination")...)
 	}
 
@@ -211,19 +827,43 @@ func validatePagination(p Pagination, location string) []ValidationError {
 		errs = append(errs, ValidationError{"pagination must have either params or nextPageUrlSelector", location})
 	}
 
+	if p.NextPageUrlSelector != "" {
+		switch sourceType, _, _ := strings.Cut(p.NextPageUrlSelector, ":"); sourceType {
+		case "body", "header", "link":
+		default:
+			errs = append(errs, ValidationError{fmt.Sprintf("nextPageUrlSelector source '%s' must be one of body, header, link", sourceType), location + ".nextPageUrlSelector"})
+		}
+	}
+
 	// If Params is provided, validate each
 	for i, param := range p.Params {
 		errs = append(errs, validatePaginationParam(param, fmt.Sprintf("%s.params[%d]", location, i))...)
 	}
 
-	// StopOn must always be non-empty
-	if len(p.StopOn) == 0 && p.NextPageUrlSelector == "" {
-		errs = append(errs, ValidationError{"pagination.stopOn must be a non-empty array if not using 'nextPageUrlSelector'", location + ".stopOn"})
+	// StopOn must be non-empty, unless a missing next page url or cursor stops the pagination
+	cursor := slices.ContainsFunc(p.Params, func(param Param) bool { return strings.ToLower(param.Type) == "cursor" })
+	if len(p.StopOn) == 0 && p.NextPageUrlSelector == "" && !cursor && p.Total == nil {
+		errs = append(errs, ValidationError{"pagination.stopOn must be a non-empty array if not using 'nextPageUrlSelector', a cursor param or total", location + ".stopOn"})
+	}
+	if p.Total != nil {
+		errs = append(errs, validateTotalCount(p, location+".total")...)
+	}
+	if p.Pipeline {
+		errs = append(errs, validatePipeline(p, location+".pipeline")...)
 	}
 	for i, stop := range p.StopOn {
 		errs = append(errs, validatePaginationStop(stop, fmt.Sprintf("%s.stopOn[%d]", location, i))...)
 	}
 
+	if next := p.NextRequest; next != nil {
+		if next.URL == "" && next.Method == "" && next.Body == "" {
+			errs = append(errs, ValidationError{"pagination.nextRequest requires one of url, method, body", location + ".nextRequest"})
+		}
+		if next.Method != "" && !isValidMethod(next.Method) {
+			errs = append(errs, ValidationError{fmt.Sprintf("pagination.nextRequest.method '%s' is not a valid HTTP method token", next.Method), location + ".nextRequest.method"})
+		}
+	}
+
 	return errs
 }
 
@@ -236,9 +876,29 @@ func validatePaginationParam(param Param, location string) []ValidationError {
 	if param.Location != "query" && param.Location != "body" && param.Location != "header" {
 		errs = append(errs, ValidationError{"pagination param location must be one of [query, body, header]", location + ".location"})
 	}
+	if param.Location == "header" && param.Name != "" && !isToken(param.Name) {
+		errs = append(errs, ValidationError{fmt.Sprintf("pagination param name '%s' is not a valid header name", param.Name), location + ".name"})
+	}
 	typ := strings.ToLower(param.Type)
-	if typ != "int" && typ != "float" && typ != "datetime" && typ != "dynamic" {
-		errs = append(errs, ValidationError{"pagination param type must be one of [int, float, datetime, dynamic]", location + ".type"})
+	if typ != "int" && typ != "float" && typ != "datetime" && typ != "string" && typ != "uuid" && typ != "dynamic" && typ != "cursor" {
+		errs = append(errs, ValidationError{"pagination param type must be one of [int, float, datetime, string, uuid, dynamic, cursor]", location + ".type"})
+	}
+	if param.Pad < 0 || (param.Pad > 0 && typ != "int" && typ != "string") {
+		errs = append(errs, ValidationError{"pagination param pad must be a positive number of digits, for int and string params", location + ".pad"})
+	}
+	if param.Decimals != nil && (*param.Decimals < 0 || typ != "float") {
+		errs = append(errs, ValidationError{"pagination param decimals must not be negative, for float params", location + ".decimals"})
+	}
+	if typ == "uuid" {
+		if _, err := parseUUID(param.Default); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("pagination param default of a uuid param: %v", err), location + ".default"})
+		}
+		if param.Increment != "" {
+			errs = append(errs, ValidationError{"pagination param increment is not supported for uuid params", location + ".increment"})
+		}
+	}
+	if typ == "string" && param.Increment != "" && !digitsPattern.MatchString(param.Default) {
+		errs = append(errs, ValidationError{"pagination param default must be a numeric code, e.g. 0001, when a string param has an increment", location + ".default"})
 	}
 	if typ == "datetime" && param.Format == "" {
 		errs = append(errs, ValidationError{"pagination param format is required when type is datetime", location + ".format"})
@@ -246,6 +906,18 @@ func validatePaginationParam(param Param, location string) []ValidationError {
 	if typ == "dynamic" && param.Source == "" {
 		errs = append(errs, ValidationError{"pagination param source is required when type is dynamic", location + ".source"})
 	}
+	if typ == "cursor" {
+		if param.Source == "" {
+			errs = append(errs, ValidationError{"pagination param source is required when type is cursor", location + ".source"})
+		} else if sourceType, selector := paramSource(param); sourceType == "body" {
+			if _, err := gojq.Parse(selector); err != nil {
+				errs = append(errs, ValidationError{fmt.Sprintf("invalid cursor selector: %v", err), location + ".source"})
+			}
+		}
+		if param.Increment != "" {
+			errs = append(errs, ValidationError{"pagination param increment is not supported for cursor params", location + ".increment"})
+		}
+	}
 	// Default can be anything, skipping type check here
 
 	return errs
@@ -255,9 +927,9 @@ func validatePaginationStop(stop StopCondition, location string) []ValidationErr
 	var errs []ValidationError
 
 	t := strings.ToLower(stop.Type)
-	validTypes := map[string]bool{"responsebody": true, "requestparam": true, "pagenum": true}
+	validTypes := map[string]bool{"responsebody": true, "requestparam": true, "pagenum": true, "timebudget": true}
 	if !validTypes[t] {
-		errs = append(errs, ValidationError{"pagination stop type must be one of [responseBody, requestParam, pageNum]", location + ".type"})
+		errs = append(errs, ValidationError{"pagination stop type must be one of [responseBody, requestParam, pageNum, timeBudget]", location + ".type"})
 	}
 
 	if t == "responsebody" {
@@ -284,6 +956,12 @@ func validatePaginationStop(stop StopCondition, location string) []ValidationErr
 		}
 	}
 
+	if t == "timebudget" {
+		if seconds, err := toFloat64(stop.Value); err != nil || seconds <= 0 {
+			errs = append(errs, ValidationError{"pagination stop value is required and must be a positive number of seconds when type is timeBudget", location + ".value"})
+		}
+	}
+
 	if t == "pagenum" {
 		// For pageNum type, value is required
 		_, ok := stop.Value.(int)
@@ -295,3 +973,128 @@ func validatePaginationStop(stop StopCondition, location string) []ValidationErr
 
 	return errs
 }
+
+// isValidMethod accepts standard and custom methods (e.g. PROPFIND), as long as they are RFC 7230 tokens.
+func isValidMethod(method string) bool {
+	return isToken(method)
+}
+
+// isToken reports whether s is an RFC 7230 token, as methods and header names must be.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > 127 || r <= ' ' || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateHeaderTemplates checks the syntax of templated header values.
+func validateHeaderTemplates(headers map[string]string, location string) []ValidationError {
+	var errs []ValidationError
+	for _, name := range sortedKeys(headers) {
+		if !isHeaderTemplate(headers[name]) {
+			continue
+		}
+		if _, err := templateRootNames(headers[name]); err != nil {
+			errs = append(errs, ValidationError{fmt.Sprintf("invalid header template: %v", err), location + "." + name})
+		}
+	}
+	return errs
+}
+
+// validateTemplateNames flags URL templates reading context names that are never defined:
+// the keys of a map rootContext and the `as` names of the enclosing steps.
+// The root keys are only known at runtime once a step may have merged its result into
+// a map root context, or inside nested steps receiving the result as root context;
+// unknown names are not reported there.
+func validateTemplateNames(cfg Config) []ValidationError {
+	rootMap, isMap := cfg.RootContext.(map[string]interface{})
+	if !isMap {
+		rootMap = map[string]interface{}{}
+	}
+	rootMerged := false
+
+	var errs []ValidationError
+	var walk func(steps []Step, scope map[string]bool, current string, rootDynamic bool, location string, depth int)
+	walk = func(steps []Step, scope map[string]bool, current string, rootDynamic bool, location string, depth int) {
+		for i, step := range steps {
+			stepLocation := fmt.Sprintf("%s[%d]", location, i)
+
+			if step.Request != nil && step.Request.URL != "" && !rootMerged && !rootDynamic {
+				names, err := templateRootNames(step.Request.URL)
+				if err != nil {
+					errs = append(errs, ValidationError{fmt.Sprintf("invalid url template: %v", err), stepLocation + ".request.url"})
+				}
+				for _, name := range names {
+					if _, ok := rootMap[name]; !ok && !scope[name] {
+						errs = append(errs, ValidationError{fmt.Sprintf("url template references undefined context name '%s'", name), stepLocation + ".request.url"})
+					}
+				}
+			}
+
+			nestedScope, nestedCurrent, nestedDynamic := scope, current, rootDynamic
+			if step.As != "" {
+				nestedScope = make(map[string]bool, len(scope)+1)
+				for k := range scope {
+					nestedScope[k] = true
+				}
+				nestedScope[step.As] = true
+				if isIterationStep(step.Type) {
+					for _, name := range []string{step.As + ITERATION_INDEX_SUFFIX, step.As + ITERATION_TOTAL_SUFFIX, ITERATION_FIRST_KEY, ITERATION_LAST_KEY} {
+						nestedScope[name] = true
+					}
+				}
+				nestedCurrent = step.As
+			} else if !isIterationStep(step.Type) && current == "root" {
+				// nested steps see the step result as root context
+				nestedDynamic = true
+			}
+			walk(step.Steps, nestedScope, nestedCurrent, nestedDynamic, stepLocation+".steps", depth+1)
+
+			if isMap && (depth == 0 || (depth == 1 && step.MergeWithParentOn != "") ||
+				(step.MergeWithContext != nil && step.MergeWithContext.Name == "root")) {
+				rootMerged = true
+			}
+		}
+	}
+	walk(cfg.Steps, map[string]bool{}, "root", false, "steps", 0)
+	return errs
+}
+
+// validateTransports checks the steps relying on transports the module does not ship against
+// the ones registered on the crawler. It runs at the start of every run, the transports being
+// set after the configuration was validated.
+func (a *ApiCrawler) validateTransports() []ValidationError {
+	var errs []ValidationError
+	var walk func(steps []Step, location string)
+	walk = func(steps []Step, location string) {
+		for i, step := range steps {
+			stepLocation := fmt.Sprintf("%s[%d]", location, i)
+			switch strings.ToLower(step.Type) {
+			case "fetch":
+				if step.Request == nil {
+					break
+				}
+				scheme, _, _ := strings.Cut(step.Request.URL, "://")
+				if _, ok := a.fileFetchers[scheme]; !ok {
+					errs = append(errs, ValidationError{fmt.Sprintf("no fetcher for scheme '%s', set one with SetFileFetcher", scheme), stepLocation + ".request.url"})
+				}
+			case "grpc":
+				if a.grpcInvoker == nil {
+					errs = append(errs, ValidationError{"grpc step requires a gRPC invoker, set one with SetGRPCInvoker", stepLocation})
+				}
+			case "poll":
+				if step.Poll != nil && step.Request != nil && step.Poll.protocol(step.Request.URL) == POLL_PROTOCOL_OPCUA && a.opcuaReader == nil {
+					errs = append(errs, ValidationError{"opcua poll step requires an OPC-UA reader, set one with SetOPCUAReader", stepLocation})
+				}
+			}
+			walk(step.Steps, stepLocation+".steps")
+		}
+	}
+	walk(a.Config.Steps, "steps")
+	return errs
+}
@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2024 NOI Techpark <digital@noi.bz.it>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package apigorowler
+
+import (
+	"fmt"
+)
+
+// evalWhen evaluates the when rule of the step against the current context with $ctx,
+// reporting whether the step runs: the first output must be neither false nor null.
+func (c *ApiCrawler) evalWhen(exec *stepExecution) (bool, error) {
+	rule := exec.step.When
+	if rule == "" {
+		return true, nil
+	}
+	location := exec.path + ".when"
+	code, err := c.getOrCompileJQRule(rule, "$ctx")
+	if err != nil {
+		return false, &TransformError{Location: location, Rule: rule, Err: err}
+	}
+	v, ok := c.runJQ(exec, code, exec.currentContext.Data, c.templateContext(exec)).Next()
+	if err, isErr := v.(error); isErr {
+		return false, &TransformError{Location: location, Rule: rule, Err: fmt.Errorf("jq error: %w", err)}
+	}
+	run := ok && v != nil && v != false
+	if !run {
+		c.logger.Info("[When] %s skipped, '%s' yields %v", exec.path, rule, v)
+		c.pushProfilerData(STEP_PROFILER_TYPE_NONE, fmt.Sprintf("Skipped '%s'", exec.step.Name), exec, v, nil, "when", rule)
+	}
+	return run, nil
+}